@@ -24,23 +24,51 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/events"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
 	"github.com/tdmalone/aws-ssm/pkg/controller"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		os.Exit(runPreview(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prune" {
+		os.Exit(runPrune(os.Args[2:]))
+	}
+
 	cfg := config.DefaultConfig()
 	if err := cfg.ParseFlags(); err != nil {
 		log.Fatalf("Error parsing flags: %v", err)
 	}
 	log.Infof("Using config: %s", cfg)
 
+	if cfg.RunOnce {
+		os.Exit(runOnce(cfg))
+	}
+
 	stopChan := make(chan struct{}, 1)
 
-	go doMetrics(cfg.MetricsListenAddress)
+	ctrl := controller.NewController(cfg)
+
+	go doMetrics(cfg.MetricsListenAddress, ctrl)
 	go handleSigterm(stopChan)
 
-	ctrl := controller.NewController(cfg)
+	if cfg.SQSQueueURL != "" {
+		consumer, err := events.NewConsumer(cfg)
+		if err != nil {
+			log.Fatalf("Error creating SQS consumer: %s", err)
+		}
+		go consumer.Run(stopChan, ctrl.SyncParameters)
+	}
 
+	if cfg.NoWatch {
+		ctrl.RunNoWatch(stopChan)
+		return
+	}
 	ctrl.Run(stopChan)
 }
 
@@ -52,10 +80,12 @@ func handleSigterm(stopChan chan struct{}) {
 	close(stopChan)
 }
 
-func doMetrics(address string) {
+func doMetrics(address string, ctrl *controller.Controller) {
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	http.Handle("/readyz", ctrl.ReadyHandler())
+	http.Handle("/metrics", metrics.Handler())
 	log.Fatal(http.ListenAndServe(address, nil))
 }
@@ -15,6 +15,16 @@
  */
 package annotations
 
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
 const (
 	K8SSecretName = "alpha.ssm.cmattoon.com/k8s-secret-name"
 	K8SSecretType = "alpha.ssm.cmattoon.com/k8s-secret-type"
@@ -25,4 +35,305 @@ const (
 	V1ParamName = "aws-ssm/aws-param-name"
 	V1ParamType = "aws-ssm/aws-param-type"
 	V1ParamKey  = "aws-ssm/aws-param-key"
+	// Reads the parameter name from a ConfigMap key ("configmap/key") in the
+	// object's namespace at each sync, instead of from aws-param-name, which
+	// is set to the name read
+	V1ParamNameFrom = "aws-ssm/param-name-from"
+
+	// The AWS region and IAM role to read the parameter with. Otherwise, the
+	// defaults of the object's namespace (as Namespace annotations) apply,
+	// then the flags.
+	V1Region         = "aws-ssm/region"
+	V1RoleARN        = "aws-ssm/role-arn"
+	V1DefaultRegion  = "aws-ssm/default-region"
+	V1DefaultRoleARN = "aws-ssm/default-role-arn"
+
+	// Namespace default: parameter names that don't start with "/" are read
+	// under this path, instead of -base-path
+	V1DefaultBasePath = "aws-ssm/default-base-path"
+
+	// An IAM role to decrypt a SecureString as, with KMS, when aws-ssm/role-arn
+	// (or the controller's role) may read the parameter but not use its key
+	V1DecryptRoleARN = "aws-ssm/decrypt-role-arn"
+
+	// The AWS account to read the parameter from, with the role of
+	// -assume-role-template for that account. aws-ssm/role-arn takes precedence.
+	V1AccountID = "aws-ssm/account-id"
+
+	// "false" stops the controller reading or writing the object, e.g. during an
+	// incident, without removing its other annotations
+	V1Enabled = "aws-ssm/enabled"
+
+	// "ConfigMap" or "Secret"; must match the object's kind
+	V1TargetKind = "aws-ssm/target-kind"
+
+	// Pins String/SecureString/StringList params to a specific version
+	V1PinVersion = "aws-ssm/pin-version"
+	// Don't sync until String/SecureString/StringList params reach this version
+	V1MinVersion = "aws-ssm/min-version"
+	// "true" converts Windows line endings (\r\n) in fetched values to \n,
+	// before they're parsed (e.g., as JSON or a StringList)
+	V1NormalizeNewlines = "aws-ssm/normalize-newlines"
+	// Adds a "tag_<key>" key for each tag of the parameter
+	V1ImportTags = "aws-ssm/import-tags"
+
+	// Copies the parameter's description to the description annotation
+	V1ImportDescription = "aws-ssm/import-description"
+	// Stores a SHA-256 of the imported keys and values in the checksum annotation
+	V1ComputeChecksum = "aws-ssm/compute-checksum"
+	// Set by the controller (with compute-checksum); changes only when the data does
+	V1Checksum = "aws-ssm/checksum"
+
+	// Set by the controller (with import-description)
+	V1Description = "aws-ssm/description"
+
+	// Records the ID of the KMS key that encrypted a SecureString in the kms-key-id annotation
+	V1ImportKMSKeyID = "aws-ssm/import-kms-key-id"
+	// Set by the controller (with import-kms-key-id); unset for unencrypted params
+	V1KMSKeyID = "aws-ssm/kms-key-id"
+
+	// Records the ARN(s) of the imported parameter(s) in the arn annotation
+	V1ImportARN = "aws-ssm/import-arn"
+	// Set by the controller (with import-arn); comma-separated for Directory imports
+	V1ARN = "aws-ssm/arn"
+
+	// Patches only the Secret keys whose values changed, instead of
+	// replacing the whole object (e.g., for SecretsManager JSON secrets)
+	V1PatchChangedKeys = "aws-ssm/patch-changed-keys"
+
+	// Secrets of type kubernetes.io/dockerconfigjson only: builds the
+	// .dockerconfigjson key for this registry from the imported username and
+	// password (and email) keys
+	V1DockerRegistry = "aws-ssm/docker-registry"
+
+	// "true" forces the server-side applies of -update-strategy=apply, taking
+	// over the keys and annotations another field manager set. By default, an
+	// apply that would change them fails with a conflict instead
+	V1ForceApply = "aws-ssm/force-apply"
+
+	// Creates the object if it was deleted before it could be updated
+	V1CreateIfMissing = "aws-ssm/create-if-missing"
+
+	// "aws-ssm/template-<key>" renders a text/template into <key>, using the
+	// parameters listed in template-params ("name=/ssm/param,...")
+	V1TemplatePrefix = "aws-ssm/template-"
+	V1TemplateParams = "aws-ssm/template-params"
+
+	// "aws-ssm/previous-value-<key>: N" stores the value of the Nth version
+	// before the current one of a String/SecureString/StringList param in <key>
+	V1PreviousValuePrefix = "aws-ssm/previous-value-"
+
+	// JSON object of Directory/DirectoryArchive parameters (full names) and the
+	// keys to store them under instead ({"/path/source": "key", ...})
+	V1KeyMap = "aws-ssm/key-map"
+
+	// JSON object of static keys to add ({"key": "value", ...})
+	V1ExtraData = "aws-ssm/extra-data"
+
+	// KMS grant token(s), comma-separated, for decrypting SecureString params
+	V1KMSGrantToken = "aws-ssm/kms-grant-token"
+
+	// Stores the still-encrypted value when decrypting is denied, instead of failing
+	V1AllowEncryptedFallback = "aws-ssm/allow-encrypted-fallback"
+	// Set by the controller ("true", with allow-encrypted-fallback) while the stored value is encrypted
+	V1EncryptedFallback = "aws-ssm/encrypted-fallback"
+
+	// "false" syncs the object without the parameter's keys when decrypting it
+	// fails, recording the failure in decrypt-error, instead of failing the sync.
+	// With directory-fetch: each, only the Directory parameters that fail are left out.
+	V1DecryptFailureFatal = "aws-ssm/decrypt-failure-fatal"
+	// Set by the controller (with decrypt-failure-fatal: "false") to the type and
+	// the error of the failed decrypt; removed once it succeeds
+	V1DecryptError = "aws-ssm/decrypt-error"
+
+	// Overrides -max-directory-keys for the object ("0" is unlimited)
+	V1MaxDirectoryKeys = "aws-ssm/max-directory-keys"
+	// "true" fails a Directory/DirectoryArchive import of a path without
+	// parameters (e.g., a typo), instead of syncing no keys for it
+	V1FailOnEmptyDirectory = "aws-ssm/fail-on-empty-directory"
+	// How Directory/DirectoryArchive parameters are read: DirectoryFetchPath
+	// (default) or DirectoryFetchEach
+	V1DirectoryFetch = "aws-ssm/directory-fetch"
+
+	// Trimmed from the start of each Directory/DirectoryArchive key
+	V1StripPrefix = "aws-ssm/strip-prefix"
+	// Only imports the Directory/DirectoryArchive parameters of this tier ("Standard" or "Advanced")
+	V1TierFilter = "aws-ssm/tier-filter"
+	// Directory only: also stores a key about the directory itself:
+	// DirectoryMetaNone (default), DirectoryMetaTrue or DirectoryMetaPath
+	V1DirectoryMeta = "aws-ssm/directory-meta"
+	// Stores the aws-ssm/directory-meta key under this key instead of "Directory"
+	V1DirectoryMetaKey = "aws-ssm/directory-meta-key"
+
+	// Stores the raw StringList value under this key instead of "StringList"
+	V1ListRawKey = "aws-ssm/list-raw-key"
+	// Omits the raw StringList value, keeping only the entries
+	V1ListOmitRaw = "aws-ssm/list-omit-raw"
+
+	// Separates StringList entries (default: "," or, if the value has newlines but no commas, "\n")
+	V1ListSeparator = "aws-ssm/list-separator"
+	// Characters that split a StringList entry into its key and value, at the first
+	// one found (default: "=")
+	V1ListPairDelimiters = "aws-ssm/list-pair-delimiters"
+
+	// How StringList entries are stored: ListOutputKeys (default) or ListOutputJoined
+	V1ListOutput = "aws-ssm/list-output"
+
+	// Secrets only: where StringList entries are stored: ListTargetStringData
+	// (default), ListTargetData or ListTargetDataBase64
+	V1ListTarget = "aws-ssm/list-target"
+
+	// Reads the version of a SecretsManager secret with this stage (default AWSCURRENT)
+	V1VersionStage = "aws-ssm/version-stage"
+
+	// Selects a single (optionally nested: "a.b.c") field of a JSON SecretsManager secret
+	V1SecretFieldPath = "aws-ssm/secret-field-path"
+
+	// Comma-separated top-level fields of a JSON SecretsManager secret to import; others are ignored
+	V1SecretFields = "aws-ssm/secret-fields"
+
+	// Label set by the controller on the objects it syncs ("true"), for
+	// kubectl get -l aws-ssm/managed=true
+	V1ManagedLabel = "aws-ssm/managed"
+
+	// Set by the controller (with -managed-by-policy=merge) to the keys it manages
+	V1ManagedKeys = "aws-ssm/managed-keys"
+
+	// Comma-separated namespaces the object's data is also written to, in objects
+	// of the same kind and name, which are created if missing
+	V1MirrorNamespaces = "aws-ssm/mirror-namespaces"
+	// Set by the controller on those objects: the namespace/name they mirror
+	V1MirrorOf = "aws-ssm/mirror-of"
+	// Namespace annotation: the comma-separated namespaces (or "*") whose
+	// objects may be mirrored into it. Namespaces without it get no mirrors.
+	V1AcceptMirrorsFrom = "aws-ssm/accept-mirrors-from"
+
+	// Set by the controller (-max-value-policy=truncate) to the comma-separated
+	// keys whose values were truncated to -max-value-bytes
+	V1TruncatedKeys = "aws-ssm/truncated-keys"
+
+	// Set by the controller to the number of keys in a DirectoryArchive value
+	V1ArchiveKeyCount = "aws-ssm/archive-key-count"
+
+	// Set by the controller to the smallest SSM tier ("Standard" or "Advanced")
+	// that fits a String/SecureString/StringList value
+	V1ParamTier = "aws-ssm/param-tier"
+
+	// Set by the controller when a sync fails; removed by the next successful sync
+	V1LastError     = "aws-ssm/last-error"
+	V1LastErrorTime = "aws-ssm/last-error-time"
 )
+
+// Values of aws-ssm/list-output
+const (
+	// A key per "key=value" entry, besides the raw value
+	ListOutputKeys = "keys"
+	// Only the entries, one per line, under the raw value's key
+	ListOutputJoined = "joined"
+)
+
+// Values of aws-ssm/list-target
+const (
+	// Each entry in the Secret's stringData
+	ListTargetStringData = "stringData"
+	// Each entry in the Secret's data, as-is
+	ListTargetData = "data"
+	// Each entry base64-decoded into the Secret's data
+	ListTargetDataBase64 = "data-base64"
+)
+
+// Values of aws-ssm/directory-fetch
+const (
+	// GetParametersByPath, a page at a time
+	DirectoryFetchPath = "path"
+	// DescribeParameters, then each parameter on its own, so one that can't be
+	// decrypted doesn't fail the rest (with aws-ssm/decrypt-failure-fatal: "false")
+	DirectoryFetchEach = "each"
+)
+
+// Values of aws-ssm/directory-meta
+const (
+	// No key
+	DirectoryMetaNone = "none"
+	// "true"
+	DirectoryMetaTrue = "true"
+	// The directory's path, comma-separated if it has several
+	DirectoryMetaPath = "path"
+)
+
+// Bool returns the boolean value of annotation key, or def if it's unset or invalid
+func Bool(annotations map[string]string, key string, def bool) bool {
+	v, ok := annotations[key]
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warnf("Invalid value '%s' for annotation %s", v, key)
+		return def
+	}
+	return b
+}
+
+// PreviousValues returns how many versions before the current one to read into
+// each key of a previous-value-<key> annotation. Each must be a positive integer.
+func PreviousValues(annotations map[string]string) (map[string]int, error) {
+	previous := make(map[string]int)
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, V1PreviousValuePrefix) {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid %s '%s': must be a positive number of versions", k, v)
+		}
+		previous[strings.TrimPrefix(k, V1PreviousValuePrefix)] = n
+	}
+	return previous, nil
+}
+
+var validKey = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// KeyMap returns the keys of the key-map annotation by parameter name, or nil
+// if it's unset. Each key must be a valid data key.
+func KeyMap(annotations map[string]string) (map[string]string, error) {
+	value, ok := annotations[V1KeyMap]
+	if !ok {
+		return nil, nil
+	}
+	keys := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation: %s", V1KeyMap, err)
+	}
+	for name, key := range keys {
+		if !validKey.MatchString(key) {
+			return nil, fmt.Errorf("Invalid %s key '%s' for %s", V1KeyMap, key, name)
+		}
+	}
+	return keys, nil
+}
+
+// ParamNameFrom returns the ConfigMap and key of the param-name-from annotation,
+// or "" if it's unset
+func ParamNameFrom(annotations map[string]string) (string, string, error) {
+	value, ok := annotations[V1ParamNameFrom]
+	if !ok {
+		return "", "", nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || parts[0] == "" || !validKey.MatchString(parts[1]) {
+		return "", "", fmt.Errorf("Invalid %s '%s' (configmap/key)", V1ParamNameFrom, value)
+	}
+	return parts[0], parts[1], nil
+}
+
+// List returns the comma-separated values of annotation key, or nil if it's unset
+func List(annotations map[string]string, key string) []string {
+	var values []string
+	for _, v := range strings.Split(annotations[key], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
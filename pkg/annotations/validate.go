@@ -0,0 +1,240 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package annotations
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParamTypes are the values accepted for aws-ssm/aws-param-type
+var ParamTypes = []string{"String", "SecureString", "StringList", "Directory", "DirectoryArchive", "SecretsManager"}
+
+// Types that can be pinned to (or wait for) a parameter version
+var versionedTypes = []string{"String", "SecureString", "StringList"}
+
+var roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$`)
+
+// Annotations that only apply to some parameter types
+var typeAnnotations = []struct {
+	key   string
+	types []string
+}{
+	{V1VersionStage, []string{"SecretsManager"}},
+	{V1SecretFieldPath, []string{"SecretsManager"}},
+	{V1SecretFields, []string{"SecretsManager"}},
+	{V1StripPrefix, []string{"Directory", "DirectoryArchive"}},
+	{V1TierFilter, []string{"Directory", "DirectoryArchive"}},
+	{V1DirectoryMeta, []string{"Directory"}},
+	{V1DirectoryMetaKey, []string{"Directory"}},
+	{V1KeyMap, []string{"Directory", "DirectoryArchive"}},
+	{V1MaxDirectoryKeys, []string{"Directory", "DirectoryArchive"}},
+	{V1FailOnEmptyDirectory, []string{"Directory", "DirectoryArchive"}},
+	{V1DirectoryFetch, []string{"Directory", "DirectoryArchive"}},
+	{V1ListRawKey, []string{"StringList"}},
+	{V1ListOmitRaw, []string{"StringList"}},
+	{V1ListSeparator, []string{"StringList"}},
+	{V1ListPairDelimiters, []string{"StringList"}},
+	{V1ListOutput, []string{"StringList"}},
+	{V1ListTarget, []string{"StringList"}},
+	{V1KMSGrantToken, versionedTypes},
+	{V1DecryptRoleARN, versionedTypes},
+	{V1AllowEncryptedFallback, versionedTypes},
+	{V1DecryptFailureFatal, []string{"String", "SecureString", "StringList", "Directory", "DirectoryArchive"}},
+	{V1ImportKMSKeyID, versionedTypes},
+	{V1ImportARN, []string{"String", "SecureString", "StringList", "Directory", "DirectoryArchive"}},
+	// ListTagsForResource takes the name of an SSM parameter
+	{V1ImportTags, versionedTypes},
+}
+
+// AppliesTo is whether the annotation key applies to parameters of paramType;
+// elsewhere it's ignored (with a warning from Validate)
+func AppliesTo(key string, paramType string) bool {
+	for _, a := range typeAnnotations {
+		if a.key == key {
+			return contains(a.types, paramType)
+		}
+	}
+	return true
+}
+
+// InvalidError lists every problem with an object's annotations
+type InvalidError struct {
+	Problems []string
+}
+
+func (e *InvalidError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validate cross-checks the parameter annotations of an object of kind
+// ("ConfigMap" or "Secret"). Combinations that can't work are returned together
+// as an *InvalidError; ones that work but probably aren't what was meant (e.g.,
+// a SecureString stored in a ConfigMap, in plaintext) are returned as warnings.
+func Validate(kind string, annotations map[string]string) (warnings []string, err error) {
+	var problems []string
+	paramType := first(annotations, V1ParamType, AWSParamType)
+
+	if !contains(ParamTypes, paramType) {
+		problems = append(problems, fmt.Sprintf("Unknown parameter type '%s'", paramType))
+	}
+
+	if targetKind, ok := annotations[V1TargetKind]; ok && targetKind != kind {
+		if targetKind != "ConfigMap" && targetKind != "Secret" {
+			problems = append(problems, fmt.Sprintf("Invalid target kind '%s' (ConfigMap|Secret)", targetKind))
+		} else {
+			problems = append(problems, fmt.Sprintf("Target kind is %s, but this is a %s", targetKind, kind))
+		}
+	}
+
+	if annotations[V1PinVersion] != "" && !contains(versionedTypes, paramType) {
+		problems = append(problems, fmt.Sprintf("%s parameters cannot be pinned to a version", paramType))
+	}
+	if annotations[V1MinVersion] != "" && !contains(versionedTypes, paramType) {
+		problems = append(problems, fmt.Sprintf("%s parameters don't support a minimum version", paramType))
+	}
+
+	if previous, err := PreviousValues(annotations); err != nil {
+		problems = append(problems, err.Error())
+	} else if len(previous) > 0 && contains(ParamTypes, paramType) && !contains(versionedTypes, paramType) {
+		warnings = append(warnings, fmt.Sprintf("%s<key> only applies to %s parameters, and is ignored", V1PreviousValuePrefix, strings.Join(versionedTypes, "/")))
+	}
+
+	if v, ok := annotations[V1MaxDirectoryKeys]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("Invalid %s '%s' (a number of keys; 0 is unlimited)", V1MaxDirectoryKeys, v))
+		}
+	}
+
+	if v, ok := annotations[V1DecryptRoleARN]; ok && !roleARNPattern.MatchString(v) {
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (an IAM role ARN, arn:aws:iam::<account>:role/<name>)", V1DecryptRoleARN, v))
+	}
+
+	if _, err := KeyMap(annotations); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, _, err := ParamNameFrom(annotations); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch annotations[V1ListOutput] {
+	case "", ListOutputKeys:
+	case ListOutputJoined:
+		if Bool(annotations, V1ListOmitRaw, false) {
+			problems = append(problems, fmt.Sprintf("%s can't be used with %s: %s, which stores the list as the raw value", V1ListOmitRaw, V1ListOutput, ListOutputJoined))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (%s|%s)", V1ListOutput, annotations[V1ListOutput], ListOutputKeys, ListOutputJoined))
+	}
+
+	switch annotations[V1TierFilter] {
+	case "", "Standard", "Advanced":
+	case "Intelligent-Tiering":
+		problems = append(problems, fmt.Sprintf("Invalid %s 'Intelligent-Tiering': it only chooses the tier a parameter is written with, which is Standard or Advanced", V1TierFilter))
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (Standard|Advanced)", V1TierFilter, annotations[V1TierFilter]))
+	}
+
+	switch annotations[V1DirectoryFetch] {
+	case "", DirectoryFetchPath:
+		if !Bool(annotations, V1DecryptFailureFatal, true) && strings.HasPrefix(paramType, "Directory") {
+			problems = append(problems, fmt.Sprintf("%s needs %s: %s for a %s, since a page of GetParametersByPath fails as a whole", V1DecryptFailureFatal, V1DirectoryFetch, DirectoryFetchEach, paramType))
+		}
+	case DirectoryFetchEach:
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (%s|%s)", V1DirectoryFetch, annotations[V1DirectoryFetch], DirectoryFetchPath, DirectoryFetchEach))
+	}
+
+	switch annotations[V1DirectoryMeta] {
+	case "", DirectoryMetaNone, DirectoryMetaTrue, DirectoryMetaPath:
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (%s|%s|%s)", V1DirectoryMeta, annotations[V1DirectoryMeta], DirectoryMetaNone, DirectoryMetaTrue, DirectoryMetaPath))
+	}
+
+	switch annotations[V1ListTarget] {
+	case "", ListTargetStringData, ListTargetData, ListTargetDataBase64:
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid %s '%s' (%s|%s|%s)", V1ListTarget, annotations[V1ListTarget], ListTargetStringData, ListTargetData, ListTargetDataBase64))
+	}
+
+	if kind == "ConfigMap" {
+		switch paramType {
+		case "SecureString":
+			warnings = append(warnings, "SecureString parameter is stored in plaintext in a ConfigMap; use a Secret")
+		case "SecretsManager":
+			warnings = append(warnings, "SecretsManager secret is stored in plaintext in a ConfigMap; use a Secret")
+		case "Directory", "DirectoryArchive":
+			if Decrypted(annotations) {
+				warnings = append(warnings, fmt.Sprintf("%s is decrypted with a KMS key, but stored in plaintext in a ConfigMap; use a Secret", paramType))
+			}
+		}
+		for _, k := range []string{V1PatchChangedKeys, V1ListTarget, V1DockerRegistry} {
+			if _, ok := annotations[k]; ok {
+				warnings = append(warnings, fmt.Sprintf("%s only applies to Secrets, and is ignored", k))
+			}
+		}
+	}
+
+	if contains(ParamTypes, paramType) {
+		for _, a := range typeAnnotations {
+			if _, ok := annotations[a.key]; ok && !contains(a.types, paramType) {
+				warnings = append(warnings, fmt.Sprintf("%s only applies to %s parameters, and is ignored", a.key, strings.Join(a.types, "/")))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, &InvalidError{Problems: problems}
+	}
+	return warnings, nil
+}
+
+// Decrypted is whether any read of the object of annotations decrypts: its
+// parameter is a SecureString, or is read with a KMS key (which, for a Directory,
+// decrypts the SecureStrings under it), or it has templates, whose parameters are
+// always read decrypted
+func Decrypted(annotations map[string]string) bool {
+	if first(annotations, V1ParamType, AWSParamType) == "SecureString" || first(annotations, V1ParamKey, AWSParamKey) != "" {
+		return true
+	}
+	for k := range annotations {
+		if strings.HasPrefix(k, V1TemplatePrefix) && k != V1TemplateParams {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the value of the first of keys that's set
+func first(annotations map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := annotations[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
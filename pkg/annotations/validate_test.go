@@ -0,0 +1,277 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package annotations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTypeKindMatrix(t *testing.T) {
+	tests := []struct {
+		paramType string
+		kind      string
+		warns     bool
+		invalid   bool
+	}{
+		{"String", "Secret", false, false},
+		{"String", "ConfigMap", false, false},
+		{"SecureString", "Secret", false, false},
+		{"SecureString", "ConfigMap", true, false},
+		{"StringList", "Secret", false, false},
+		{"StringList", "ConfigMap", false, false},
+		{"Directory", "Secret", false, false},
+		{"Directory", "ConfigMap", false, false},
+		{"DirectoryArchive", "Secret", false, false},
+		{"DirectoryArchive", "ConfigMap", false, false},
+		{"SecretsManager", "Secret", false, false},
+		{"SecretsManager", "ConfigMap", true, false},
+		{"Strnig", "Secret", false, true},
+		{"Strnig", "ConfigMap", false, true},
+	}
+
+	for _, tt := range tests {
+		for _, key := range []string{V1ParamType, AWSParamType} {
+			warnings, err := Validate(tt.kind, map[string]string{V1ParamName: "/p", key: tt.paramType})
+			assert.Equal(t, tt.warns, len(warnings) > 0, "%s in a %s: %v", tt.paramType, tt.kind, warnings)
+			assert.Equal(t, tt.invalid, err != nil, "%s in a %s: %v", tt.paramType, tt.kind, err)
+		}
+	}
+}
+
+func TestValidateDirectoryWithKeyInConfigMap(t *testing.T) {
+	for _, paramType := range []string{"Directory", "DirectoryArchive"} {
+		a := map[string]string{V1ParamType: paramType, V1ParamKey: "alias/k"}
+		warnings, err := Validate("ConfigMap", a)
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "plaintext")
+
+		warnings, err = Validate("Secret", a)
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+	}
+}
+
+func TestValidateTargetKind(t *testing.T) {
+	_, err := Validate("ConfigMap", map[string]string{V1ParamType: "String", V1TargetKind: "ConfigMap"})
+	assert.NoError(t, err)
+
+	_, err = Validate("ConfigMap", map[string]string{V1ParamType: "String", V1TargetKind: "Secret"})
+	assert.EqualError(t, err, "Target kind is Secret, but this is a ConfigMap")
+
+	_, err = Validate("Secret", map[string]string{V1ParamType: "String", V1TargetKind: "Deployment"})
+	assert.EqualError(t, err, "Invalid target kind 'Deployment' (ConfigMap|Secret)")
+}
+
+func TestValidateVersions(t *testing.T) {
+	for _, paramType := range ParamTypes {
+		a := map[string]string{V1ParamType: paramType, V1PinVersion: "3", V1MinVersion: "2"}
+		_, err := Validate("Secret", a)
+		if paramType == "String" || paramType == "SecureString" || paramType == "StringList" {
+			assert.NoError(t, err, paramType)
+		} else {
+			assert.Error(t, err, paramType)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	_, err := Validate("ConfigMap", map[string]string{
+		V1ParamType:  "Directory",
+		V1TargetKind: "Secret",
+		V1PinVersion: "3",
+		V1MinVersion: "2",
+	})
+	require.Error(t, err)
+	require.IsType(t, &InvalidError{}, err)
+	assert.Equal(t, []string{
+		"Target kind is Secret, but this is a ConfigMap",
+		"Directory parameters cannot be pinned to a version",
+		"Directory parameters don't support a minimum version",
+	}, err.(*InvalidError).Problems)
+	assert.Equal(t, "Target kind is Secret, but this is a ConfigMap; "+
+		"Directory parameters cannot be pinned to a version; "+
+		"Directory parameters don't support a minimum version", err.Error())
+}
+
+func TestValidateWarnsOfIgnoredAnnotations(t *testing.T) {
+	warnings, err := Validate("ConfigMap", map[string]string{
+		V1ParamType:        "String",
+		V1StripPrefix:      "app_",
+		V1VersionStage:     "AWSPENDING",
+		V1PatchChangedKeys: "true",
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"aws-ssm/patch-changed-keys only applies to Secrets, and is ignored",
+		"aws-ssm/version-stage only applies to SecretsManager parameters, and is ignored",
+		"aws-ssm/strip-prefix only applies to Directory/DirectoryArchive parameters, and is ignored",
+	}, warnings)
+
+	warnings, err = Validate("Secret", map[string]string{V1ParamType: "StringList", V1ListSeparator: ";"})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestValidateTierFilter(t *testing.T) {
+	a := map[string]string{V1ParamType: "Directory", V1TierFilter: "Advanced"}
+	warnings, err := Validate("ConfigMap", a)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	a[V1TierFilter] = "Intelligent-Tiering"
+	_, err = Validate("ConfigMap", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/tier-filter 'Intelligent-Tiering': it only chooses the tier a parameter is written with, which is Standard or Advanced")
+
+	a[V1TierFilter] = "advanced"
+	_, err = Validate("ConfigMap", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/tier-filter 'advanced' (Standard|Advanced)")
+
+	warnings, err = Validate("ConfigMap", map[string]string{V1ParamType: "String", V1TierFilter: "Standard"})
+	require.NoError(t, err)
+	assert.Len(t, warnings, 1)
+}
+
+func TestValidateDirectoryMeta(t *testing.T) {
+	a := map[string]string{V1ParamType: "Directory", V1DirectoryMeta: DirectoryMetaPath, V1DirectoryMetaKey: "ssm-path"}
+	warnings, err := Validate("Secret", a)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	a[V1DirectoryMeta] = "yes"
+	_, err = Validate("Secret", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/directory-meta 'yes' (none|true|path)")
+
+	warnings, err = Validate("Secret", map[string]string{V1ParamType: "DirectoryArchive", V1DirectoryMeta: DirectoryMetaTrue})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws-ssm/directory-meta only applies to Directory parameters, and is ignored"}, warnings)
+}
+
+func TestValidateDirectoryFetch(t *testing.T) {
+	a := map[string]string{V1ParamType: "Directory", V1DirectoryFetch: DirectoryFetchEach, V1DecryptFailureFatal: "false"}
+	warnings, err := Validate("Secret", a)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	a[V1DirectoryFetch] = "all"
+	_, err = Validate("Secret", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/directory-fetch 'all' (path|each)")
+
+	// A page fails as a whole
+	delete(a, V1DirectoryFetch)
+	_, err = Validate("Secret", a)
+	assert.EqualError(t, err, "aws-ssm/decrypt-failure-fatal needs aws-ssm/directory-fetch: each for a Directory, since a page of GetParametersByPath fails as a whole")
+
+	warnings, err = Validate("Secret", map[string]string{V1ParamType: "SecureString", V1DirectoryFetch: DirectoryFetchEach})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws-ssm/directory-fetch only applies to Directory/DirectoryArchive parameters, and is ignored"}, warnings)
+}
+
+func TestValidateImportTags(t *testing.T) {
+	warnings, err := Validate("Secret", map[string]string{V1ParamType: "String", V1ImportTags: "true"})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	for _, paramType := range []string{"SecretsManager", "Directory", "DirectoryArchive"} {
+		warnings, err = Validate("Secret", map[string]string{V1ParamType: paramType, V1ImportTags: "true"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"aws-ssm/import-tags only applies to String/SecureString/StringList parameters, and is ignored"}, warnings)
+		assert.False(t, AppliesTo(V1ImportTags, paramType))
+	}
+}
+
+func TestValidatePreviousValues(t *testing.T) {
+	a := map[string]string{V1ParamType: "String", V1PreviousValuePrefix + "old": "1"}
+	warnings, err := Validate("Secret", a)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	a[V1PreviousValuePrefix+"older"] = "0"
+	_, err = Validate("Secret", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/previous-value-older '0': must be a positive number of versions")
+
+	warnings, err = Validate("Secret", map[string]string{V1ParamType: "Directory", V1PreviousValuePrefix + "old": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws-ssm/previous-value-<key> only applies to String/SecureString/StringList parameters, and is ignored"}, warnings)
+}
+
+func TestPreviousValues(t *testing.T) {
+	previous, err := PreviousValues(map[string]string{
+		V1ParamType:                 "String",
+		V1PreviousValuePrefix + "a": "1",
+		V1PreviousValuePrefix + "b": "3",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1, "b": 3}, previous)
+
+	_, err = PreviousValues(map[string]string{V1PreviousValuePrefix + "a": "latest"})
+	assert.Error(t, err)
+}
+
+func TestKeyMap(t *testing.T) {
+	keys, err := KeyMap(map[string]string{V1KeyMap: `{"/app/db/x7f3a": "host"}`})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/db/x7f3a": "host"}, keys)
+
+	keys, err = KeyMap(map[string]string{})
+	require.NoError(t, err)
+	assert.Nil(t, keys)
+
+	_, err = KeyMap(map[string]string{V1KeyMap: `["host"]`})
+	assert.Error(t, err)
+	_, err = KeyMap(map[string]string{V1KeyMap: `{"/app/db/x7f3a": "db/host"}`})
+	assert.EqualError(t, err, "Invalid aws-ssm/key-map key 'db/host' for /app/db/x7f3a")
+
+	_, err = Validate("Secret", map[string]string{V1ParamType: "Directory", V1KeyMap: `{`})
+	assert.Error(t, err)
+}
+
+func TestParamNameFrom(t *testing.T) {
+	name, key, err := ParamNameFrom(map[string]string{V1ParamNameFrom: "app-config/db-param"})
+	require.NoError(t, err)
+	assert.Equal(t, "app-config", name)
+	assert.Equal(t, "db-param", key)
+
+	name, key, err = ParamNameFrom(map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, "", name+key)
+
+	for _, value := range []string{"app-config", "/db-param", "app-config/", "app-config/db/param"} {
+		_, _, err = ParamNameFrom(map[string]string{V1ParamNameFrom: value})
+		assert.EqualError(t, err, "Invalid aws-ssm/param-name-from '"+value+"' (configmap/key)")
+	}
+
+	_, err = Validate("Secret", map[string]string{V1ParamType: "String", V1ParamNameFrom: "app-config"})
+	assert.Error(t, err)
+}
+
+func TestValidateDecryptRoleARN(t *testing.T) {
+	a := map[string]string{V1ParamType: "SecureString", V1DecryptRoleARN: "arn:aws:iam::123456789012:role/kms-decrypt"}
+	warnings, err := Validate("Secret", a)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	a[V1DecryptRoleARN] = "kms-decrypt"
+	_, err = Validate("Secret", a)
+	assert.EqualError(t, err, "Invalid aws-ssm/decrypt-role-arn 'kms-decrypt' (an IAM role ARN, arn:aws:iam::<account>:role/<name>)")
+
+	warnings, err = Validate("Secret", map[string]string{V1ParamType: "Directory", V1DecryptRoleARN: "arn:aws:iam::123456789012:role/kms-decrypt"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws-ssm/decrypt-role-arn only applies to String/SecureString/StringList parameters, and is ignored"}, warnings)
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package archive
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	large := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		large[fmt.Sprintf("key%d", i)] = fmt.Sprintf("value-%d", i)
+	}
+
+	for title, data := range map[string]map[string]string{
+		"empty":   {},
+		"simple":  {"user": "root", "host": "10.0.1.10"},
+		"unicode": {"greeting": "héllo wörld", "multi": "line1\nline2"},
+		"large":   large,
+	} {
+		t.Run(title, func(t *testing.T) {
+			value, err := Encode(data)
+			require.NoError(t, err)
+
+			decoded, err := Decode(value)
+			require.NoError(t, err)
+			assert.Equal(t, data, decoded)
+		})
+	}
+}
+
+func TestEncodeIsDeterministic(t *testing.T) {
+	data := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	first, err := Encode(data)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		value, err := Encode(data)
+		require.NoError(t, err)
+		assert.Equal(t, first, value)
+	}
+}
+
+func TestDecodeRejectsInvalidInput(t *testing.T) {
+	for _, value := range []string{"not base64!", "Zm9vYmFy"} {
+		_, err := Decode(value)
+		assert.Error(t, err, value)
+	}
+}
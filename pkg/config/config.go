@@ -17,13 +17,53 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"text/template"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var Version = "undefined"
 
+// Values of -managed-by-policy, for objects managed by another tool (e.g. Helm)
+const (
+	// Update the object as usual
+	ManagedByUpdate = "update"
+	// Leave the object alone
+	ManagedBySkip = "skip"
+	// Don't change keys that were set by the other tool
+	ManagedByMerge = "merge"
+)
+
+// Values of -force-securestring-to-secret, for ConfigMaps with SecureStrings
+const (
+	// Fail the sync of the ConfigMap
+	ForceSecretError = "error"
+	// Sync the parameter into a companion Secret instead of the ConfigMap
+	ForceSecretRedirect = "redirect"
+)
+
+// Values of -max-value-policy, for values larger than -max-value-bytes
+const (
+	// Fail the sync of the object
+	MaxValueError = "error"
+	// Truncate the value to -max-value-bytes
+	MaxValueTruncate = "truncate"
+)
+
+// Values of -update-strategy, for writing synced objects
+const (
+	// Replace the whole object
+	UpdateStrategyUpdate = "update"
+	// JSON merge patch of only the keys and annotations the controller manages
+	UpdateStrategyPatch = "patch"
+	// Server-side apply of the keys and annotations the controller manages,
+	// which removes those it no longer sets
+	UpdateStrategyApply = "apply"
+)
+
 func getenv(key string, default_value string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -34,12 +74,85 @@ func getenv(key string, default_value string) string {
 
 type Config struct {
 	AWSRegion string
+	// IAM role to assume for AWS requests; "" uses the default credentials
+	RoleARN string
+	// "namespace/name" of a Secret with static AWS credentials, used instead of
+	// the default credentials; "" uses the default credentials
+	CredentialsSecret string
+	// Role ARN for objects with an aws-ssm/account-id, e.g. arn:aws:iam::{{.AccountID}}:role/ssm-reader
+	AssumeRoleTemplate string
+	// Path that parameter names that don't start with "/" are read under; ""
+	// reads them as they are
+	BasePath string
+	// Expand ${VAR} references to the InterpolateEnvVars environment variables
+	// in parameter names
+	InterpolateEnv bool
+	// Environment variables parameter names may reference (-interpolate-env)
+	InterpolateEnvVars []string
+	// Regions to read parameters from, fastest first; empty reads from AWSRegion
+	ReadRegions []string
 	// Frequency, in seconds, to poll for changes
 	Interval             int
 	KubeConfig           string
 	KubeMaster           string
 	MetricsListenAddress string
-	Provider             string
+	// "aws" or "gcp" (GCP Secret Manager)
+	Provider string
+	// GCP project of the secrets (-provider=gcp)
+	GCPProject string
+	// Appended to the User-Agent of every AWS request
+	UserAgentSuffix string
+	// Recorded by the apiserver as the manager of the fields the controller writes
+	FieldManager string
+	// Warn when a ConfigMap/Secret's data exceeds this many bytes
+	SizeWarningBytes int
+	// Fail Directory imports of more parameters than this; 0 is unlimited
+	MaxDirectoryKeys int
+	// Largest value of a single key, in bytes; 0 is unlimited
+	MaxValueBytes int
+	// What to do with larger values (MaxValue*)
+	MaxValuePolicy string
+	// Seconds to wait for an object's parameters before retrying it later; 0 waits forever
+	ReconcileTimeout int
+	// Sync once at startup, then only serve healthz/metrics
+	NoWatch bool
+	// Sync once, print a JSON summary and exit
+	RunOnce bool
+	// How to handle objects managed by another tool (ManagedBy*)
+	ManagedByPolicy string
+	// SQS queue of EventBridge Parameter Store change events; "" polls only
+	SQSQueueURL string
+	// Names of the builtin transforms applied to every value, in order
+	Transforms []string
+	// Maximum AWS calls per minute; 0 is unlimited
+	SyncBudget int
+	// Seconds to retry reads of parameters that aren't found (yet); 0 disables retries
+	NotFoundRetryWindow int
+	// AWS error codes to retry, besides throttling
+	RetryErrorCodes []string
+	// Seconds to cache fetched values; 0 disables the cache
+	CacheTTL int
+	// PEM file of CAs to trust for AWS requests, in place of the system roots
+	CABundle string
+	// Overrides the SSM endpoint (e.g., an interface VPC endpoint)
+	SSMEndpoint string
+	// Read every parameter with decryption, whatever its type or annotations
+	AlwaysDecrypt bool
+	// Don't record warning events for SecureStrings decrypted with the default KMS key
+	NoDefaultKeyWarning bool
+	// Directory to write the data of each synced object to, for debugging; "" disables
+	DumpDir string
+	// "namespace/name" of a ConfigMap to write a JSON summary of the controller's
+	// state to after each full sync; "" doesn't
+	StatusConfigMap string
+	// Re-sync ConfigMaps as soon as their synced keys are edited, instead of at the next resync
+	ResyncOnEdit bool
+	// What to do with ConfigMaps with SecureStrings (ForceSecret*); "" syncs them as usual
+	ForceSecureStringToSecret string
+	// How synced objects are written (UpdateStrategy*)
+	UpdateStrategy string
+	// Only read parameter values whose version has changed since they were last read
+	TrackVersions bool
 }
 
 func DefaultConfig() *Config {
@@ -50,6 +163,13 @@ func DefaultConfig() *Config {
 		KubeMaster:           "",
 		MetricsListenAddress: "0.0.0.0:9999",
 		Provider:             "aws",
+		UserAgentSuffix:      "aws-ssm-controller/" + Version,
+		FieldManager:         "aws-ssm-controller",
+		SizeWarningBytes:     900 * 1024,
+		ReconcileTimeout:     30,
+		ManagedByPolicy:      ManagedByUpdate,
+		MaxValuePolicy:       MaxValueError,
+		UpdateStrategy:       UpdateStrategyUpdate,
 	}
 	return cfg
 }
@@ -71,20 +191,196 @@ func (cfg *Config) ParseFlags() error {
 		getenv("AWS_REGION", "us-west-2"),
 		"AWS Region (us-west-2)")
 
+	roleARN := flag.String("role-arn",
+		getenv("ROLE_ARN", ""),
+		"IAM role to assume for AWS requests (default: none)")
+
+	credentialsSecret := flag.String("credentials-secret",
+		getenv("CREDENTIALS_SECRET", ""),
+		"namespace/name of a Secret with the aws_access_key_id, aws_secret_access_key and (optional) aws_session_token to make AWS requests with (default: none)")
+
+	assumeRoleTemplate := flag.String("assume-role-template",
+		getenv("ASSUME_ROLE_TEMPLATE", ""),
+		"Role to assume for objects with an aws-ssm/account-id annotation (arn:aws:iam::{{.AccountID}}:role/ssm-reader)")
+
+	basePath := flag.String("base-path",
+		getenv("BASE_PATH", ""),
+		"Path to read parameter names that don't start with / under, e.g. /app/prod (default: none)")
+
+	interpolateEnv := flag.Bool("interpolate-env", getenv("INTERPOLATE_ENV", "") == "true",
+		"Expand ${VAR} in parameter names with the controller's environment variable VAR, for the variables in -interpolate-env-vars")
+
+	interpolateEnvVars := flag.String("interpolate-env-vars",
+		getenv("INTERPOLATE_ENV_VARS", ""),
+		"Comma-separated environment variables that parameter names may reference with -interpolate-env (CLUSTER_NAME,REGION)")
+
+	readRegions := flag.String("read-regions",
+		getenv("READ_REGIONS", ""),
+		"Comma-separated regions holding the same parameters. Reads use the fastest, failing over to the next (us-east-1,us-west-2)")
+
+	providerName := flag.String("provider",
+		getenv("PROVIDER", "aws"),
+		"Where parameters are read from (aws|gcp)")
+
+	gcpProject := flag.String("gcp-project",
+		getenv("GCP_PROJECT", ""),
+		"GCP project of the Secret Manager secrets (-provider=gcp)")
+
 	logLevelStr := flag.String("log-level",
 		getenv("LOG_LEVEL", "info"),
 		"Logrus log level (info)")
 
+	userAgentSuffix := flag.String("user-agent-suffix",
+		getenv("USER_AGENT_SUFFIX", "aws-ssm-controller/"+Version),
+		"Appended to the User-Agent of AWS requests (aws-ssm-controller/<version>)")
+
+	fieldManager := flag.String("field-manager",
+		getenv("FIELD_MANAGER", "aws-ssm-controller"),
+		"Name recorded as the manager of the fields the controller writes (aws-ssm-controller)")
+
+	sizeWarning := flag.Int("size-warning-bytes", 900*1024,
+		"Warn when a ConfigMap/Secret's data exceeds this many bytes (921600)")
+
+	maxDirectoryKeys := flag.Int("max-directory-keys", 0,
+		"Fail Directory/DirectoryArchive imports of more parameters than this, unless overridden by the aws-ssm/max-directory-keys annotation (0 = unlimited)")
+
+	maxValueBytes := flag.Int("max-value-bytes", 0,
+		"Largest value of a single key, in bytes (0 = unlimited); see -max-value-policy")
+
+	maxValuePolicy := flag.String("max-value-policy",
+		getenv("MAX_VALUE_POLICY", MaxValueError),
+		"What to do with a value larger than -max-value-bytes: fail the object's sync, or truncate the value (error|truncate)")
+
+	reconcileTimeout := flag.Int("reconcile-timeout", 30,
+		"Seconds to wait for an object's parameters before giving up and retrying it later, with backoff (0 = no timeout)")
+
+	noWatch := flag.Bool("no-watch", getenv("NO_WATCH", "") == "true",
+		"Sync once at startup, then only serve healthz/metrics")
+
+	runOnce := flag.Bool("run-once", getenv("RUN_ONCE", "") == "true",
+		"Sync once, print a JSON summary to stdout and exit (1 if any object failed)")
+
+	managedByPolicy := flag.String("managed-by-policy",
+		getenv("MANAGED_BY_POLICY", ManagedByUpdate),
+		"How to handle objects managed by another tool, e.g. Helm (update|skip|merge)")
+
+	transforms := flag.String("transforms",
+		getenv("TRANSFORMS", ""),
+		"Comma-separated transforms applied to every value, in order (trim,base64)")
+
+	sqsQueueURL := flag.String("sqs-queue-url",
+		getenv("SQS_QUEUE_URL", ""),
+		"SQS queue receiving EventBridge Parameter Store change events. Changed parameters are synced immediately")
+
+	notFoundRetryWindow := flag.Int("not-found-retry-window", 0,
+		"Seconds to retry reads of parameters that aren't found, e.g. right after they're created (0 = disabled)")
+
+	retryErrorCodes := flag.String("retry-error-codes",
+		getenv("RETRY_ERROR_CODES", ""),
+		"Comma-separated AWS error codes to retry with backoff, besides throttling (RequestError,InternalServerError)")
+
+	cacheTTL := flag.Int("cache-ttl", 0,
+		"Seconds to cache values fetched from AWS (0 = disabled)")
+
+	syncBudget := flag.Int("sync-budget", 0,
+		"Maximum AWS calls per minute, spread evenly across each resync (0 = unlimited)")
+
+	caBundle := flag.String("ca-bundle",
+		getenv("CA_BUNDLE", ""),
+		"PEM file of CAs to trust for AWS requests")
+
+	ssmEndpoint := flag.String("ssm-endpoint",
+		getenv("SSM_ENDPOINT", ""),
+		"Custom SSM endpoint URL (https://vpce-xxx.ssm.us-west-2.vpce.amazonaws.com)")
+
+	alwaysDecrypt := flag.Bool("always-decrypt", getenv("ALWAYS_DECRYPT", "") == "true",
+		"Read every parameter with decryption (WithDecryption=true), even without an aws-ssm/aws-param-key. SSM ignores it for parameters that aren't SecureStrings")
+
+	noDefaultKeyWarning := flag.Bool("no-default-key-warning", getenv("NO_DEFAULT_KEY_WARNING", "") == "true",
+		"Don't record a Warning event when a SecureString is decrypted with the default KMS key (alias/aws/ssm)")
+
+	dumpDir := flag.String("dump-dir",
+		getenv("DUMP_DIR", ""),
+		"Write the data of each object to <dir>/<kind>_<namespace>_<name>.json before updating it, for debugging. Secret values are redacted")
+
+	statusConfigMap := flag.String("status-configmap",
+		getenv("STATUS_CONFIGMAP", ""),
+		"namespace/name of a ConfigMap to write a JSON summary of the managed objects, their last sync and error counts to after each sync (default: none)")
+
+	resyncOnEdit := flag.Bool("resync-on-edit", getenv("RESYNC_ON_EDIT", "") == "true",
+		"Watch ConfigMaps, and re-sync one as soon as the keys the controller set are edited by someone else")
+
+	forceSecureStringToSecret := flag.String("force-securestring-to-secret",
+		getenv("FORCE_SECURESTRING_TO_SECRET", ""),
+		"Never store SecureStrings in ConfigMaps, whatever their annotations: fail the sync, or sync a companion Secret instead (error|redirect)")
+
+	updateStrategy := flag.String("update-strategy",
+		getenv("UPDATE_STRATEGY", UpdateStrategyUpdate),
+		"How to write synced objects: replace them, patch only the keys and annotations the controller manages, or server-side apply them (update|patch|apply)")
+
+	trackVersions := flag.Bool("track-versions", getenv("TRACK_VERSIONS", "") == "true",
+		"Read the version of each parameter before its value, and only read (and decrypt) the value if the version changed since the last sync")
+
 	interval := flag.Int("interval", 30, "Polling interval")
 	flag.Parse()
 
 	// Override config values from CLI
 	cfg.AWSRegion = *region
+	cfg.RoleARN = *roleARN
+	cfg.CredentialsSecret = *credentialsSecret
+	cfg.AssumeRoleTemplate = *assumeRoleTemplate
+	cfg.BasePath = *basePath
+	cfg.InterpolateEnv = *interpolateEnv
+	for _, v := range strings.Split(*interpolateEnvVars, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			cfg.InterpolateEnvVars = append(cfg.InterpolateEnvVars, v)
+		}
+	}
+	for _, r := range strings.Split(*readRegions, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			cfg.ReadRegions = append(cfg.ReadRegions, r)
+		}
+	}
 	cfg.Interval = *interval
 	cfg.KubeConfig = *kubeConfig
 	cfg.KubeMaster = *kubeMaster
 	cfg.MetricsListenAddress = *metricAddr
-	cfg.Provider = "aws"
+	cfg.Provider = *providerName
+	cfg.GCPProject = *gcpProject
+	cfg.UserAgentSuffix = *userAgentSuffix
+	cfg.FieldManager = *fieldManager
+	cfg.SizeWarningBytes = *sizeWarning
+	cfg.MaxDirectoryKeys = *maxDirectoryKeys
+	cfg.MaxValueBytes = *maxValueBytes
+	cfg.MaxValuePolicy = *maxValuePolicy
+	cfg.ReconcileTimeout = *reconcileTimeout
+	cfg.NoWatch = *noWatch
+	cfg.RunOnce = *runOnce
+	cfg.ManagedByPolicy = *managedByPolicy
+	cfg.SQSQueueURL = *sqsQueueURL
+	for _, t := range strings.Split(*transforms, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			cfg.Transforms = append(cfg.Transforms, t)
+		}
+	}
+	cfg.SyncBudget = *syncBudget
+	cfg.NotFoundRetryWindow = *notFoundRetryWindow
+	for _, c := range strings.Split(*retryErrorCodes, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cfg.RetryErrorCodes = append(cfg.RetryErrorCodes, c)
+		}
+	}
+	cfg.CacheTTL = *cacheTTL
+	cfg.CABundle = *caBundle
+	cfg.SSMEndpoint = *ssmEndpoint
+	cfg.AlwaysDecrypt = *alwaysDecrypt
+	cfg.NoDefaultKeyWarning = *noDefaultKeyWarning
+	cfg.DumpDir = *dumpDir
+	cfg.StatusConfigMap = *statusConfigMap
+	cfg.ResyncOnEdit = *resyncOnEdit
+	cfg.ForceSecureStringToSecret = *forceSecureStringToSecret
+	cfg.UpdateStrategy = *updateStrategy
+	cfg.TrackVersions = *trackVersions
 
 	logLevel, err := log.ParseLevel(*logLevelStr)
 	if err != nil {
@@ -93,5 +389,73 @@ func (cfg *Config) ParseFlags() error {
 	}
 	log.SetLevel(logLevel)
 
+	switch cfg.Provider {
+	case "aws", "gcp":
+	default:
+		return fmt.Errorf("Invalid -provider '%s' (aws|gcp)", cfg.Provider)
+	}
+
+	if _, err := cfg.RoleTemplate(); err != nil {
+		return err
+	}
+
+	if cfg.BasePath != "" && !strings.HasPrefix(cfg.BasePath, "/") {
+		return fmt.Errorf("Invalid -base-path '%s' (must start with /)", cfg.BasePath)
+	}
+
+	if cfg.InterpolateEnv && len(cfg.InterpolateEnvVars) == 0 {
+		return fmt.Errorf("-interpolate-env requires -interpolate-env-vars")
+	}
+	if !cfg.InterpolateEnv && len(cfg.InterpolateEnvVars) > 0 {
+		return fmt.Errorf("-interpolate-env-vars requires -interpolate-env")
+	}
+
+	switch cfg.ManagedByPolicy {
+	case ManagedByUpdate, ManagedBySkip, ManagedByMerge:
+	default:
+		return fmt.Errorf("Invalid -managed-by-policy '%s' (update|skip|merge)", cfg.ManagedByPolicy)
+	}
+
+	switch cfg.ForceSecureStringToSecret {
+	case "", ForceSecretError, ForceSecretRedirect:
+	default:
+		return fmt.Errorf("Invalid -force-securestring-to-secret '%s' (error|redirect)", cfg.ForceSecureStringToSecret)
+	}
+
+	if cfg.CredentialsSecret != "" {
+		if parts := strings.Split(cfg.CredentialsSecret, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid -credentials-secret '%s' (namespace/name)", cfg.CredentialsSecret)
+		}
+	}
+	if cfg.StatusConfigMap != "" {
+		if parts := strings.Split(cfg.StatusConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid -status-configmap '%s' (namespace/name)", cfg.StatusConfigMap)
+		}
+	}
+
+	switch cfg.MaxValuePolicy {
+	case MaxValueError, MaxValueTruncate:
+	default:
+		return fmt.Errorf("Invalid -max-value-policy '%s' (error|truncate)", cfg.MaxValuePolicy)
+	}
+
+	switch cfg.UpdateStrategy {
+	case UpdateStrategyUpdate, UpdateStrategyPatch, UpdateStrategyApply:
+	default:
+		return fmt.Errorf("Invalid -update-strategy '%s' (update|patch|apply)", cfg.UpdateStrategy)
+	}
+
 	return nil
 }
+
+// RoleTemplate parses AssumeRoleTemplate, or returns nil if it isn't set
+func (cfg *Config) RoleTemplate() (*template.Template, error) {
+	if cfg.AssumeRoleTemplate == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("assume-role-template").Parse(cfg.AssumeRoleTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -assume-role-template: %s", err)
+	}
+	return tmpl, nil
+}
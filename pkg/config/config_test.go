@@ -83,3 +83,20 @@ func TestDefaultConfig(t *testing.T) {
 // 	if cfg.MetricsListenAddress != METRICS_ADDR { t.Fail() }
 // 	if cfg.AWSRegion != REGION { t.Fail() }
 // }
+
+func TestRoleTemplate(t *testing.T) {
+	cfg := DefaultConfig()
+	if tmpl, err := cfg.RoleTemplate(); tmpl != nil || err != nil {
+		t.Error("An unset template should be nil")
+	}
+
+	cfg.AssumeRoleTemplate = "arn:aws:iam::{{.AccountID}}:role/ssm-reader"
+	if _, err := cfg.RoleTemplate(); err != nil {
+		t.Error(err)
+	}
+
+	cfg.AssumeRoleTemplate = "arn:aws:iam::{{.AccountID:role/ssm-reader"
+	if _, err := cfg.RoleTemplate(); err == nil {
+		t.Error("An invalid template should be an error")
+	}
+}
@@ -16,16 +16,32 @@
  package configmap
 
  import (
+	 "crypto/sha256"
+	 "encoding/hex"
+	 "encoding/json"
 	 "errors"
 	 "fmt"
+	 "path"
+	 "regexp"
+	 "sort"
+	 "strconv"
 	 "strings"
+	 "sync"
+	 "unicode/utf8"
 
 	 log "github.com/sirupsen/logrus"
 
 	 anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	 "github.com/cmattoon/aws-ssm/pkg/archive"
+	 "github.com/cmattoon/aws-ssm/pkg/jsonfield"
 	 "github.com/cmattoon/aws-ssm/pkg/provider"
+	 "github.com/cmattoon/aws-ssm/pkg/templates"
+	 "github.com/cmattoon/aws-ssm/pkg/transform"
 	 v1 "k8s.io/api/core/v1"
+	 apierrors "k8s.io/apimachinery/pkg/api/errors"
+	 "k8s.io/apimachinery/pkg/types"
 	 "k8s.io/client-go/kubernetes"
+	 "k8s.io/client-go/rest"
  )
 
  type ConfigMap struct {
@@ -40,13 +56,39 @@
 	 ParamType string
 	 // AWS Param Key (Default: "alias/aws/ssm")
 	 ParamKey string
+	 // True if ParamKey is the default key, because no key was annotated
+	 DefaultKey bool
 	 // AWS Param Value
 	 ParamValue string
 	 // The data to add to Kubernetes ConfigMap Data
 	 Data map[string]string
+	 // Keys set during this sync
+	 keys map[string]bool
+	 // Data as it was read, to log which keys changed
+	 original map[string]string
+	 // Keys left alone during this sync, because something else set them
+	 skipped map[string]bool
+	 // Directory/DirectoryArchive: the full name of the parameter of each key
+	 sources map[string]string
+
+	 // Guards the data and keys in Set, which may be called concurrently (e.g.,
+	 // by the workers of a parallel import)
+	 mu sync.Mutex
  }
 
- func NewConfigMap(sec v1.ConfigMap, p provider.Provider, configmap_name string, configmap_namespace string, param_name string, param_type string, param_key string) (*ConfigMap, error) {
+ // NewConfigMap reads the parameter named by an object's annotations into a ConfigMap.
+ // Any error is wrapped with the object and parameter it happened for, for
+ // logs and events; errors.Is and errors.As still see the original.
+ func NewConfigMap(sec v1.ConfigMap, p provider.Provider, configmap_name string, configmap_namespace string, param_name string, param_type string, param_key string) (_ *ConfigMap, err error) {
+	 defer func() {
+		 if err != nil {
+			 err = fmt.Errorf("ConfigMap %s/%s, parameter '%s' (%s): %w", configmap_namespace, configmap_name, param_name, param_type, err)
+		 }
+	 }()
+	 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1NormalizeNewlines, false) {
+		 // Before any value is parsed, after the controller's transforms
+		 p = transform.WithTransformers(p, transform.NormalizeNewlines)
+	 }
 
 	 s := &ConfigMap{
 		 ConfigMap:     sec,
@@ -57,9 +99,13 @@
 		 ParamKey:   param_key,
 		 ParamValue: "",
 		 Data:       map[string]string{},
+		 original:   map[string]string{},
+	 }
+	 for k, v := range sec.Data {
+		 s.original[k] = v
 	 }
 
-	 log.Debugf("Getting value for '%s/%s'", s.Namespace, s.Name)
+	 s.logger().Debug("Getting value")
 
 	 decrypt := false
 	 if s.ParamKey != "" {
@@ -67,51 +113,192 @@
 	 }
 
 	 if s.ParamType == "String" || s.ParamType == "SecureString" {
-		 value, err := p.GetParameterValue(s.ParamName, decrypt)
+		 value, err := s.getParameterValue(p, decrypt)
 		 if err != nil {
+			 if s.decryptFailed(err, decrypt) {
+				 return s, nil
+			 }
 			 return nil, err
 		 }
 		 s.ParamValue = value
+		 if err := s.setParameterTier(value); err != nil {
+			 return nil, err
+		 }
 	 } else if s.ParamType == "StringList" {
-		 value, err := p.GetParameterValue(s.ParamName, decrypt)
+		 value, err := s.getParameterValue(p, decrypt)
 		 if err != nil {
+			 if s.decryptFailed(err, decrypt) {
+				 return s, nil
+			 }
 			 return nil, err
 		 }
 		 s.ParamValue = value
-		 // StringList: Also set each key
-		 values := s.ParseStringList()
-		 for k, v := range values {
-			 s.Set(k, v)
+		 if err := s.setParameterTier(value); err != nil {
+			 return nil, err
+		 }
+		 if sec.ObjectMeta.Annotations[anno.V1ListOutput] == anno.ListOutputJoined {
+			 // StringList: Only the entries, one per line, under the raw key
+			 s.ParamValue = strings.Join(listEntries(sec.ObjectMeta.Annotations, value), "\n")
+		 } else {
+			 // StringList: Also set each key
+			 values := s.ParseStringList()
+			 for k, v := range values {
+				 s.Set(k, v)
+			 }
 		 }
 	 } else if s.ParamType == "Directory" {
 		 // Directory: Set each sub-key
-		 all_params, err := p.GetParameterDataByPath(s.ParamName, decrypt)
+		 ppaths, data, sources, err := s.directoryData(p, sec.ObjectMeta.Annotations, s.ParamName, decrypt)
 		 if err != nil {
 			 return nil, err
 		 }
-
-		 for k, v := range all_params {
-			 s.Set(safeKeyName(k), v)
+		 s.ParamName = ppaths
+		 for k, v := range data {
+			 s.Set(k, v)
+		 }
+		 s.ParamValue = "true"
+		 if err := s.setDirectoryMeta(sec.ObjectMeta.Annotations); err != nil {
+			 return nil, err
+		 }
+		 if err := s.setExtraData(sec.ObjectMeta.Annotations); err != nil {
+			 return nil, err
+		 }
+		 s.sources = sources
+		 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportARN, false) {
+			 s.importARNs(p, sources)
 		 }
-		 s.ParamValue = "true" // Reads "Directory": "true"
 		 return s, nil
+	 } else if s.ParamType == "DirectoryArchive" {
+		 // DirectoryArchive: Store all sub-keys as a single gzipped JSON value
+		 ppaths, data, sources, err := s.directoryData(p, sec.ObjectMeta.Annotations, s.ParamName, decrypt)
+		 if err != nil {
+			 return nil, err
+		 }
+		 s.ParamName = ppaths
+		 value, err := archive.Encode(data)
+		 if err != nil {
+			 return nil, err
+		 }
+		 s.ParamValue = value
+		 s.sources = sources
+
+		 if s.ConfigMap.ObjectMeta.Annotations == nil {
+			 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+		 }
+		 s.ConfigMap.ObjectMeta.Annotations[anno.V1ArchiveKeyCount] = strconv.Itoa(len(data))
+	 } else if s.ParamType == "SecretsManager" {
+		 // SecretsManager: "name#field" (or the secret-field-path annotation) selects a
+		 // single JSON field. Otherwise, also set each top-level field of a JSON secret.
+		 // The secret-fields annotation narrows the secret to its listed fields first.
+		 secret_id, field := jsonfield.Split(s.ParamName)
+		 if v, ok := sec.ObjectMeta.Annotations[anno.V1SecretFieldPath]; ok {
+			 field = v
+		 }
+
+		 secret_value, err := p.GetSecretValue(secret_id, sec.ObjectMeta.Annotations[anno.V1VersionStage])
+		 if err != nil {
+			 return nil, err
+		 }
+		 if secret_value.Binary != nil {
+			 return nil, fmt.Errorf("Secret '%s' is binary, which can only be stored in a Secret", secret_id)
+		 }
+		 value, err := transform.Value(p, secret_id, secret_value.String)
+		 if err != nil {
+			 return nil, err
+		 }
+
+		 if fields := anno.List(sec.ObjectMeta.Annotations, anno.V1SecretFields); len(fields) > 0 && !provider.Validating(p) {
+			 value, err = jsonfield.Subset(value, fields)
+			 if err != nil {
+				 return nil, fmt.Errorf("Secret '%s': %s", secret_id, err)
+			 }
+		 }
+		 if field != "" && !provider.Validating(p) {
+			 value, err = jsonfield.Get(value, field)
+			 if err != nil {
+				 return nil, fmt.Errorf("Secret '%s': %s", secret_id, err)
+			 }
+		 } else if fields, ok := jsonfield.Fields(value); ok {
+			 for k, v := range fields {
+				 s.Set(k, v)
+			 }
+		 }
+		 s.ParamValue = value
+	 }
+
+	 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportTags, false) && anno.AppliesTo(anno.V1ImportTags, s.ParamType) {
+		 tags, err := p.GetParameterTags(s.ParamName)
+		 s.importFailed("tags", err)
+		 for k, v := range tags {
+			 s.Set(tagKeyName(k), v)
+		 }
+	 }
+
+	 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportDescription, false) {
+		 s.importDescription(p)
+	 }
+
+	 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportKMSKeyID, false) {
+		 s.importKMSKeyID(p)
+	 }
+
+	 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportARN, false) && s.ParamType != "SecretsManager" {
+		 if s.sources != nil {
+			 s.importARNs(p, s.sources)
+		 } else {
+			 s.importARNs(p, map[string]string{s.ParamName: s.ParamName})
+		 }
+	 }
+
+	 if err := s.setPreviousValues(p, sec.ObjectMeta.Annotations, decrypt); err != nil {
+		 return nil, err
+	 }
+
+	 rendered, err := templates.Render(sec.ObjectMeta.Annotations, p)
+	 if err != nil {
+		 return nil, err
+	 }
+	 for k, v := range rendered {
+		 s.Set(k, v)
+	 }
+
+	 if err := s.setExtraData(sec.ObjectMeta.Annotations); err != nil {
+		 return nil, err
 	 }
 
 	 // Always set the "$ParamType" key:
 	 //   String: Value
 	 //   SecureString: Value
-	 //   StringList: Value
-	 //   Directory: <ssm-path>
-	 s.Set(s.ParamType, s.ParamValue)
+	 //   StringList: Value (unless renamed or omitted)
+	 // (Directory only sets a key with aws-ssm/directory-meta)
+	 key := s.ParamType
+	 if s.ParamType == "StringList" {
+		 if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ListOmitRaw, false) {
+			 return s, nil
+		 }
+		 if k := sec.ObjectMeta.Annotations[anno.V1ListRawKey]; k != "" {
+			 key = k
+		 }
+	 }
+	 s.Set(key, s.ParamValue)
 
 	 return s, nil
  }
 
  // FromKubernetesConfigMap returns an internal ConfigMap struct, if the v1.ConfigMap is properly annotated.
  func FromKubernetesConfigMap(p provider.Provider, configmap v1.ConfigMap) (*ConfigMap, error) {
+	 return FromKubernetesConfigMapWithBasePath(p, configmap, "")
+ }
+
+ // FromKubernetesConfigMapWithBasePath is FromKubernetesConfigMap, reading relative
+ // parameter names (that don't start with "/") under basePath (-base-path)
+ func FromKubernetesConfigMapWithBasePath(p provider.Provider, configmap v1.ConfigMap, basePath string) (*ConfigMap, error) {
 	 param_name := ""
 	 param_type := ""
+	 default_key := false
 	 param_key := ""
+	 param_version := ""
+	 min_version := ""
 
 	 for k, v := range configmap.ObjectMeta.Annotations {
 		 switch k {
@@ -121,6 +308,10 @@
 			 param_type = v
 		 case anno.AWSParamKey, anno.V1ParamKey:
 			 param_key = v
+		 case anno.V1PinVersion:
+			 param_version = v
+		 case anno.V1MinVersion:
+			 min_version = v
 		 }
 	 }
 
@@ -128,10 +319,53 @@
 		 return nil, errors.New("Irrelevant ConfigMap")
 	 }
 
+	 // Reports every problem at once, rather than one per sync
+	 warnings, err := anno.Validate("ConfigMap", configmap.ObjectMeta.Annotations)
+	 for _, w := range warnings {
+		 log.WithFields(log.Fields{
+			 "namespace": configmap.Namespace,
+			 "name":      configmap.Name,
+			 "paramName": param_name,
+			 "paramType": param_type,
+		 }).Warn(w)
+	 }
+	 if err != nil {
+		 return nil, err
+	 }
+
 	 if param_name != "" && param_type != "" {
 		 if param_type == "SecureString" && param_key == "" {
-			 log.Info("No KMS key defined. Using default key 'alias/aws/ssm'")
+			 // Logged for every sync, so only at debug level
+			 log.WithFields(log.Fields{
+				 "namespace": configmap.Namespace,
+				 "name":      configmap.Name,
+				 "paramName": param_name,
+				 "paramType": param_type,
+			 }).Debug("No KMS key defined. Using default key 'alias/aws/ssm'")
 			 param_key = "alias/aws/ssm"
+			 default_key = true
+		 }
+	 }
+
+	 // Secrets Manager names aren't paths
+	 if param_type != "SecretsManager" {
+		 param_name = provider.WithBasePath(param_name, basePath)
+	 }
+
+	 // An explicit pin-version annotation takes precedence over any
+	 // inline "name:version" selector in the param name
+	 if param_version != "" {
+		 versioned, err := provider.WithVersion(param_name, param_version)
+		 if err != nil {
+			 return nil, err
+		 }
+		 param_name = versioned
+	 }
+
+	 // Don't write a stale value while an external update is propagating
+	 if min_version != "" {
+		 if err := provider.CheckMinVersion(p, param_name, min_version); err != nil {
+			 return nil, err
 		 }
 	 }
 
@@ -147,25 +381,23 @@
 	 if err != nil {
 		 return nil, err
 	 }
+	 s.DefaultKey = default_key
 	 return s, nil
  }
 
  func (s *ConfigMap) ParseStringList() (values map[string]string) {
 	 values = make(map[string]string)
 
-	 for _, pair := range strings.Split(strings.TrimSpace(s.ParamValue), ",") {
-		 pair = strings.TrimSpace(pair)
+	 delimiters := listPairDelimiters(s.ConfigMap.ObjectMeta.Annotations)
+	 for _, pair := range listEntries(s.ConfigMap.ObjectMeta.Annotations, s.ParamValue) {
 		 key := pair
 		 val := ""
 
-		 if strings.Contains(pair, "=") {
-			 kv := strings.SplitN(pair, "=", 2)
-			 if len(kv) == 2 {
-				 if kv[0] != "" {
-					 key = kv[0]
-					 val = kv[1]
-				 }
-			 }
+		 // Split at the first delimiter only; the value may contain more
+		 if i := strings.IndexAny(pair, delimiters); i > 0 {
+			 _, n := utf8.DecodeRuneInString(pair[i:])
+			 key = pair[:i]
+			 val = pair[i+n:]
 		 }
 		 if key != "" {
 			 values[key] = val
@@ -175,8 +407,83 @@
 	 return
  }
 
+ // logger returns a logger with fields identifying the ConfigMap and its parameter.
+ // Values must never be logged.
+ func (s *ConfigMap) logger() *log.Entry {
+	 return log.WithFields(log.Fields{
+		 "namespace": s.Namespace,
+		 "name":      s.Name,
+		 "paramName": s.ParamName,
+		 "paramType": s.ParamType,
+	 })
+ }
+
+ // getParameterValue reads the value of the parameter, and transforms it (see
+ // transform.Value). With allow-encrypted-fallback, a decrypt that's denied stores
+ // the still-encrypted value instead, untransformed, which is recorded in the
+ // encrypted-fallback annotation.
+ func (s *ConfigMap) getParameterValue(p provider.Provider, decrypt bool) (string, error) {
+	 annotations := s.ConfigMap.ObjectMeta.Annotations
+	 value, err := getParameterValue(p, annotations, s.ParamName, decrypt)
+	 delete(annotations, anno.V1DecryptError)
+	 if err == nil {
+		 delete(annotations, anno.V1EncryptedFallback)
+		 return transform.Value(p, s.ParamName, value)
+	 }
+	 if !decrypt || !provider.IsAccessDenied(err) || !anno.Bool(annotations, anno.V1AllowEncryptedFallback, false) {
+		 delete(annotations, anno.V1EncryptedFallback)
+		 return "", err
+	 }
+
+	 encrypted, fallbackErr := p.GetParameterValue(s.ParamName, false)
+	 if fallbackErr != nil {
+		 s.logger().Debugf("Failed to read the encrypted value: %s", fallbackErr)
+		 return "", err
+	 }
+	 s.logger().Warnf("Storing the encrypted value, since decrypting was denied: %s", err)
+	 annotations[anno.V1EncryptedFallback] = "true"
+	 return encrypted, nil
+ }
+
+ // decryptFailed records err in the decrypt-error annotation, if it's a failure to
+ // decrypt and decrypt-failure-fatal is "false". The ConfigMap is then synced without
+ // any keys of the parameter, so nothing (not even the ciphertext) is written,
+ // and keys from previous syncs are left as they were.
+ func (s *ConfigMap) decryptFailed(err error, decrypt bool) bool {
+	 annotations := s.ConfigMap.ObjectMeta.Annotations
+	 if !decrypt || !provider.IsDecryptError(err) || anno.Bool(annotations, anno.V1DecryptFailureFatal, true) {
+		 return false
+	 }
+	 s.logger().Warnf("Failed to decrypt the parameter; syncing without its keys: %s", err)
+	 annotations[anno.V1DecryptError] = fmt.Sprintf("%s: %s", s.ParamType, err)
+	 return true
+ }
+
+ // setParameterTier records the tier of a single parameter's value in the
+ // param-tier annotation
+ func (s *ConfigMap) setParameterTier(value string) error {
+	 if s.ConfigMap.ObjectMeta.Annotations[anno.V1EncryptedFallback] == "true" {
+		 // The size of the ciphertext says nothing of the tier
+		 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1ParamTier)
+		 return nil
+	 }
+	 tier, err := provider.ParameterTier(s.ParamName, value)
+	 if err != nil {
+		 return err
+	 }
+	 if s.ConfigMap.ObjectMeta.Annotations == nil {
+		 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+	 }
+	 s.ConfigMap.ObjectMeta.Annotations[anno.V1ParamTier] = tier
+	 return nil
+ }
+
+ // Set sets key to val, refusing to overwrite a key of s.Data. It's safe for
+ // concurrent use.
  func (s *ConfigMap) Set(key string, val string) (err error) {
-	 log.Debugf("Setting key=%s", key)
+	 s.logger().Debugf("Setting key=%s", key)
+	 s.mu.Lock()
+	 defer s.mu.Unlock()
 	 if s.ConfigMap.Data == nil {
 		 s.ConfigMap.Data = make(map[string]string)
 	 }
@@ -186,12 +493,728 @@
 		 return errors.New(fmt.Sprintf("Key '%s' already exists for ConfigMap %s/%s", key, s.Namespace, s.Name))
 	 }
 	 s.ConfigMap.Data[key] = val
+	 s.track(key)
 	 return
  }
 
+ func (s *ConfigMap) track(key string) {
+	 if s.keys == nil {
+		 s.keys = make(map[string]bool)
+	 }
+	 s.keys[key] = true
+ }
+
+ // ManagedKeys returns the keys set during this sync, sorted
+ func (s *ConfigMap) ManagedKeys() []string {
+	 keys := []string{}
+	 for k := range s.keys {
+		 keys = append(keys, k)
+	 }
+	 sort.Strings(keys)
+	 return keys
+ }
+
+ // PreserveKeys restores the original value of each key that was already in the
+ // ConfigMap but not set by a previous sync (i.e., owned by another tool), and records
+ // the remaining keys in the managed-keys annotation. Returns the restored keys.
+ func (s *ConfigMap) PreserveKeys(original map[string]string) []string {
+	 previous := make(map[string]bool)
+	 for _, k := range anno.List(s.ConfigMap.ObjectMeta.Annotations, anno.V1ManagedKeys) {
+		 previous[k] = true
+	 }
+
+	 preserved := []string{}
+	 for _, k := range s.ManagedKeys() {
+		 v, ok := original[k]
+		 if !ok || previous[k] {
+			 continue
+		 }
+		 s.ConfigMap.Data[k] = v
+		 delete(s.keys, k)
+		 s.skip(k)
+		 preserved = append(preserved, k)
+	 }
+
+	 if s.ConfigMap.ObjectMeta.Annotations == nil {
+		 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+	 }
+	 s.ConfigMap.ObjectMeta.Annotations[anno.V1ManagedKeys] = strings.Join(s.ManagedKeys(), ",")
+	 return preserved
+ }
+
+ // Size returns the number of bytes the apiserver counts against its size limit
+ // (v1.MaxSecretSize applies to ConfigMaps too)
+ func (s *ConfigMap) Size() int {
+	 size := 0
+	 for _, v := range s.ConfigMap.Data {
+		 size += len(v)
+	 }
+	 for _, v := range s.ConfigMap.BinaryData {
+		 size += len(v)
+	 }
+	 return size
+ }
+
  func (s *ConfigMap) UpdateObject(cli kubernetes.Interface) (result *v1.ConfigMap, err error) {
-	 log.Info("Updating Kubernetes ConfigMap...")
-	 return cli.CoreV1().ConfigMaps(s.Namespace).Update(&s.ConfigMap)
+	 if err := s.prepareWrite(); err != nil {
+		 return nil, err
+	 }
+
+	 s.logUpdate("Updating")
+	 result, err = cli.CoreV1().ConfigMaps(s.Namespace).Update(&s.ConfigMap)
+	 return s.createIfMissing(cli, result, err)
+ }
+
+ // PatchObject writes the ConfigMap like UpdateObject, but with a JSON merge
+ // patch of only the keys set during this sync, the controller's annotations and
+ // the managed label (-update-strategy=patch), so it doesn't conflict with other
+ // writers, whose keys and annotations are left alone
+ func (s *ConfigMap) PatchObject(cli kubernetes.Interface) (result *v1.ConfigMap, err error) {
+	 if err := s.prepareWrite(); err != nil {
+		 return nil, err
+	 }
+
+	 s.logUpdate("Patching")
+	 result, err = s.mergePatch(cli)
+	 return s.createIfMissing(cli, result, err)
+ }
+
+ // types.ApplyPatchType, which this apimachinery predates
+ const applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+ // The metav1.CauseType of the conflicts of an apply with another field manager
+ const fieldManagerConflict = "FieldManagerConflict"
+
+ // ApplyObject writes the ConfigMap with a server-side apply of the keys set during
+ // this sync, the controller's annotations and the managed label
+ // (-update-strategy=apply). The apiserver records them as owned by fieldManager
+ // (-field-manager), and removes those its previous apply set that this one doesn't,
+ // e.g. the key of a parameter deleted from a Directory. Keys and annotations
+ // owned by other writers are left alone. Kubernetes < 1.16 doesn't support
+ // server-side apply, so there (and with a client that can't send one, e.g. a
+ // fake) it falls back to PatchObject, which leaves removed keys in place.
+ func (s *ConfigMap) ApplyObject(cli kubernetes.Interface, fieldManager string) (*v1.ConfigMap, error) {
+	 rc := cli.CoreV1().RESTClient()
+	 if r, ok := rc.(*rest.RESTClient); ok && r == nil {
+		 s.logger().Warn("Client can't send a server-side apply; patching instead")
+		 return s.PatchObject(cli)
+	 }
+	 if err := s.prepareWrite(); err != nil {
+		 return nil, err
+	 }
+
+	 body, err := s.applyConfiguration()
+	 if err != nil {
+		 return nil, err
+	 }
+	 s.logUpdate("Applying")
+	 req := rc.Patch(applyPatchType).
+		 Namespace(s.Namespace).
+		 Resource("configmaps").
+		 Name(s.Name).
+		 Param("fieldManager", fieldManager)
+	 if anno.Bool(s.ConfigMap.ObjectMeta.Annotations, anno.V1ForceApply, false) {
+		 req = req.Param("force", "true")
+	 }
+	 result := &v1.ConfigMap{}
+	 err = req.Body(body).Do().Into(result)
+	 if isApplyConflict(err) {
+		 return nil, fmt.Errorf("Keys or annotations are owned by another field manager; set aws-ssm/force-apply to take them over: %w", err)
+	 }
+	 if apierrors.IsUnsupportedMediaType(err) {
+		 s.logger().Warn("The apiserver doesn't support server-side apply (Kubernetes < 1.16); patching instead")
+		 return s.PatchObject(cli)
+	 }
+	 if err != nil {
+		 return nil, err
+	 }
+	 return s.clearLastError(cli, result)
+ }
+
+ // isApplyConflict returns true if err is the conflict of an apply (that isn't
+ // forced) with the fields of another field manager
+ func isApplyConflict(err error) bool {
+	 status, ok := err.(apierrors.APIStatus)
+	 if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		 return false
+	 }
+	 for _, cause := range status.Status().Details.Causes {
+		 if cause.Type == fieldManagerConflict {
+			 return true
+		 }
+	 }
+	 return false
+ }
+
+ // applyConfiguration returns the server-side apply of ApplyObject. Unless
+ // aws-ssm/create-if-missing is set, it has the resourceVersion the ConfigMap was
+ // read at, so an object deleted since then isn't recreated.
+ func (s *ConfigMap) applyConfiguration() ([]byte, error) {
+	 data := make(map[string]string)
+	 for _, k := range s.ManagedKeys() {
+		 data[k] = s.ConfigMap.Data[k]
+	 }
+	 metadata := map[string]interface{}{
+		 "name":      s.Name,
+		 "namespace": s.Namespace,
+		 "labels":    map[string]string{anno.V1ManagedLabel: "true"},
+	 }
+	 if !anno.Bool(s.ConfigMap.ObjectMeta.Annotations, anno.V1CreateIfMissing, false) {
+		 metadata["resourceVersion"] = s.ConfigMap.ObjectMeta.ResourceVersion
+	 }
+	 annotations := make(map[string]string)
+	 for _, k := range controllerAnnotations {
+		 if v, ok := s.ConfigMap.ObjectMeta.Annotations[k]; ok {
+			 annotations[k] = v
+		 }
+	 }
+	 if len(annotations) > 0 {
+		 metadata["annotations"] = annotations
+	 }
+
+	 return json.Marshal(map[string]interface{}{
+		 "apiVersion": "v1",
+		 "kind":       "ConfigMap",
+		 "metadata":   metadata,
+		 "data":       data,
+	 })
+ }
+
+ // clearLastError removes the last-error annotations from result, the ConfigMap as
+ // applied. They're written by another kind of request when a sync fails, so
+ // they aren't owned by the controller's applies, which can't remove them.
+ func (s *ConfigMap) clearLastError(cli kubernetes.Interface, result *v1.ConfigMap) (*v1.ConfigMap, error) {
+	 _, hasError := result.ObjectMeta.Annotations[anno.V1LastError]
+	 _, hasTime := result.ObjectMeta.Annotations[anno.V1LastErrorTime]
+	 if !hasError && !hasTime {
+		 return result, nil
+	 }
+	 patch, err := json.Marshal(map[string]interface{}{
+		 "metadata": map[string]interface{}{
+			 "annotations": map[string]interface{}{anno.V1LastError: nil, anno.V1LastErrorTime: nil},
+		 },
+	 })
+	 if err != nil {
+		 return nil, err
+	 }
+	 return cli.CoreV1().ConfigMaps(s.Namespace).Patch(s.Name, types.MergePatchType, patch)
+ }
+
+ // prepareWrite checks the size of the ConfigMap and sets what the controller
+ // records on every successful sync
+ func (s *ConfigMap) prepareWrite() error {
+	 if size := s.Size(); size > v1.MaxSecretSize {
+		 return fmt.Errorf("ConfigMap %s/%s is too large: %d bytes exceeds the limit of %d bytes", s.Namespace, s.Name, size, v1.MaxSecretSize)
+	 }
+	 // A successful sync clears any previous error
+	 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1LastError)
+	 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1LastErrorTime)
+	 s.label()
+	 if anno.Bool(s.ConfigMap.ObjectMeta.Annotations, anno.V1ComputeChecksum, false) {
+		 s.ConfigMap.ObjectMeta.Annotations[anno.V1Checksum] = s.Checksum()
+	 }
+	 return nil
+ }
+
+ // createIfMissing creates the ConfigMap if writing it failed because it doesn't
+ // exist and it has aws-ssm/create-if-missing. Otherwise, it returns result and err.
+ func (s *ConfigMap) createIfMissing(cli kubernetes.Interface, result *v1.ConfigMap, err error) (*v1.ConfigMap, error) {
+	 if apierrors.IsNotFound(err) && anno.Bool(s.ConfigMap.ObjectMeta.Annotations, anno.V1CreateIfMissing, false) {
+		 s.logger().Info("ConfigMap not found; creating it")
+		 s.ConfigMap.ObjectMeta.ResourceVersion = ""
+		 return cli.CoreV1().ConfigMaps(s.Namespace).Create(&s.ConfigMap)
+	 }
+	 return result, err
+ }
+
+ // Annotations written by the controller, which are patched along with the keys
+ var controllerAnnotations = []string{
+	 anno.V1ManagedKeys,
+	 anno.V1ArchiveKeyCount,
+	 anno.V1ParamTier,
+	 anno.V1EncryptedFallback,
+	 anno.V1DecryptError,
+	 anno.V1Description,
+	 anno.V1KMSKeyID,
+	 anno.V1ARN,
+	 anno.V1TruncatedKeys,
+	 anno.V1Checksum,
+	 anno.V1LastError,
+	 anno.V1LastErrorTime,
+ }
+
+ // mergePatch patches the keys set during this sync, whether or not they
+ // changed, and the controller's annotations (removing those it didn't set),
+ // with a JSON merge patch
+ func (s *ConfigMap) mergePatch(cli kubernetes.Interface) (*v1.ConfigMap, error) {
+	 data := make(map[string]string)
+	 for _, k := range s.ManagedKeys() {
+		 data[k] = s.ConfigMap.Data[k]
+	 }
+	 annotations := make(map[string]*string)
+	 for _, k := range controllerAnnotations {
+		 if v, ok := s.ConfigMap.ObjectMeta.Annotations[k]; ok {
+			 annotations[k] = &v
+		 } else {
+			 annotations[k] = nil
+		 }
+	 }
+
+	 patch, err := json.Marshal(map[string]interface{}{
+		 "metadata": map[string]interface{}{
+			 "annotations": annotations,
+			 "labels":      map[string]string{anno.V1ManagedLabel: "true"},
+		 },
+		 "data": data,
+	 })
+	 if err != nil {
+		 return nil, err
+	 }
+	 s.logger().Debugf("Patching %d keys", len(data))
+	 return cli.CoreV1().ConfigMaps(s.Namespace).Patch(s.Name, types.MergePatchType, patch)
+ }
+
+ // changedKeys returns the keys set during this sync whose values differ from
+ // the ConfigMap as it was read, sorted
+ func (s *ConfigMap) changedKeys() []string {
+	 added, changed := s.KeyChanges()
+	 keys := append(added, changed...)
+	 sort.Strings(keys)
+	 return keys
+ }
+
+ // KeyChanges returns the keys set during this sync that weren't in the ConfigMap
+ // as it was read, and the ones whose values differ from it, sorted
+ func (s *ConfigMap) KeyChanges() (added []string, changed []string) {
+	 added, changed = []string{}, []string{}
+	 for _, k := range s.ManagedKeys() {
+		 v := s.ConfigMap.Data[k]
+		 if original, ok := s.original[k]; !ok {
+			 added = append(added, k)
+		 } else if original != v {
+			 changed = append(changed, k)
+		 }
+	 }
+	 return added, changed
+ }
+
+ func (s *ConfigMap) skip(key string) {
+	 if s.skipped == nil {
+		 s.skipped = make(map[string]bool)
+	 }
+	 s.skipped[key] = true
+ }
+
+ // SkippedKeys returns the keys left alone during this sync, because something
+ // else set them (see PreserveKeys), sorted
+ func (s *ConfigMap) SkippedKeys() []string {
+	 keys := []string{}
+	 for k := range s.skipped {
+		 keys = append(keys, k)
+	 }
+	 sort.Strings(keys)
+	 return keys
+ }
+
+ // SourceParams returns the full names of the parameters read, sorted: each
+ // parameter of a Directory/DirectoryArchive, or else the parameter itself
+ func (s *ConfigMap) SourceParams() []string {
+	 if s.sources == nil {
+		 return []string{provider.Unversioned(s.ParamName)}
+	 }
+	 names := []string{}
+	 for _, name := range s.sources {
+		 names = append(names, name)
+	 }
+	 sort.Strings(names)
+	 return names
+ }
+
+ // logUpdate logs an update at info level when keys changed. Most resyncs change
+ // nothing, so those are only logged at debug level.
+ func (s *ConfigMap) logUpdate(action string) {
+	 changed := s.changedKeys()
+	 entry := s.logger().WithFields(log.Fields{
+		 "kind":        "ConfigMap",
+		 "changed":     len(changed) > 0,
+		 "changedKeys": strings.Join(changed, ","),
+	 })
+	 if len(changed) > 0 {
+		 entry.Infof("%s Kubernetes ConfigMap...", action)
+	 } else {
+		 entry.Debugf("%s Kubernetes ConfigMap (no keys changed)...", action)
+	 }
+ }
+
+ // Checksum returns a hex SHA-256 of the keys set by the controller and their
+ // values, in key order. Keys and values are length-prefixed, so moving bytes
+ // between them changes the checksum.
+ func (s *ConfigMap) Checksum() string {
+	 return DataChecksum(s.ConfigMap.Data, s.ManagedKeys())
+ }
+
+ // DataChecksum returns the Checksum of data, for the (sorted) keys set by the
+ // controller. An object whose data no longer matches was edited since.
+ func DataChecksum(data map[string]string, keys []string) string {
+	 h := sha256.New()
+	 for _, k := range keys {
+		 v := data[k]
+		 fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(v), v)
+	 }
+	 return hex.EncodeToString(h.Sum(nil))
+ }
+
+ // label sets the managed label, so synced objects can be listed with a selector.
+ // It's set on every sync, which doesn't change an object that already has it,
+ // and the controller doesn't watch labels, so syncs don't trigger each other.
+ func (s *ConfigMap) label() {
+	 if s.ConfigMap.ObjectMeta.Labels == nil {
+		 s.ConfigMap.ObjectMeta.Labels = make(map[string]string)
+	 }
+	 s.ConfigMap.ObjectMeta.Labels[anno.V1ManagedLabel] = "true"
+ }
+
+ // importARNs records the ARNs of the parameters of each key (sources, as full
+ // names) in the arn annotation, in key order. Only the first ARN is read; the
+ // others are in the same region and account (see importFailed).
+ func (s *ConfigMap) importARNs(p provider.Provider, sources map[string]string) {
+	 if s.ConfigMap.ObjectMeta.Annotations == nil {
+		 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+	 }
+	 if len(sources) == 0 {
+		 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1ARN)
+		 return
+	 }
+
+	 keys := make([]string, 0, len(sources))
+	 for k := range sources {
+		 keys = append(keys, k)
+	 }
+	 sort.Strings(keys)
+
+	 arn, err := p.GetParameterARN(sources[keys[0]])
+	 if s.importFailed("the ARN", err) {
+		 return
+	 }
+	 arns := []string{arn}
+	 for _, k := range keys[1:] {
+		 arns = append(arns, provider.ARNFor(arn, sources[k]))
+	 }
+	 s.ConfigMap.ObjectMeta.Annotations[anno.V1ARN] = strings.Join(arns, ",")
+ }
+
+ // setPreviousValues sets the key of each previous-value-<key> annotation to the
+ // value of the Nth version before the current one, reading only as much of the
+ // parameter's history as needed. A parameter without that many versions (yet)
+ // leaves the key unset.
+ func (s *ConfigMap) setPreviousValues(p provider.Provider, annotations map[string]string, decrypt bool) error {
+	 if s.ParamType != "String" && s.ParamType != "SecureString" && s.ParamType != "StringList" {
+		 return nil
+	 }
+	 previous, err := anno.PreviousValues(annotations)
+	 if err != nil || len(previous) == 0 {
+		 return err
+	 }
+
+	 keys := make([]string, 0, len(previous))
+	 limit := 0
+	 for k, n := range previous {
+		 keys = append(keys, k)
+		 if n+1 > limit {
+			 limit = n + 1
+		 }
+	 }
+	 sort.Strings(keys)
+
+	 name := provider.Unversioned(s.ParamName)
+	 versions, err := provider.GetParameterHistory(p, name, decrypt, limit)
+	 if err != nil {
+		 return err
+	 }
+	 for _, k := range keys {
+		 n := previous[k]
+		 if n >= len(versions) {
+			 s.logger().Warnf("Not setting %s: the parameter has no version %d before the current one", k, n)
+			 continue
+		 }
+		 value, err := transform.Value(p, name, versions[n].Value)
+		 if err != nil {
+			 return err
+		 }
+		 if err := s.Set(k, value); err != nil {
+			 return err
+		 }
+	 }
+	 return nil
+ }
+
+ // importFailed logs err and returns true if importing metadata (e.g., tags or
+ // the description, rather than a value) failed. Metadata isn't what the ConfigMap
+ // is synced for, so it never fails the sync.
+ func (s *ConfigMap) importFailed(what string, err error) bool {
+	 if err == nil {
+		 return false
+	 }
+	 s.logger().Warnf("Failed to import %s: %s", what, err)
+	 return true
+ }
+
+ // importDescription copies the parameter's description to the description
+ // annotation (see importFailed)
+ func (s *ConfigMap) importDescription(p provider.Provider) {
+	 description, err := p.GetParameterDescription(s.ParamName)
+	 if s.importFailed("the description", err) {
+		 return
+	 }
+	 if s.ConfigMap.ObjectMeta.Annotations == nil {
+		 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+	 }
+	 if description == "" {
+		 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1Description)
+		 return
+	 }
+	 s.ConfigMap.ObjectMeta.Annotations[anno.V1Description] = description
+ }
+
+ // importKMSKeyID records the ID of the KMS key that encrypted the parameter,
+ // which may differ from aws-param-key, in the kms-key-id annotation (for
+ // auditing). Parameters that aren't encrypted have none (see importFailed).
+ func (s *ConfigMap) importKMSKeyID(p provider.Provider) {
+	 keyID, err := provider.GetParameterKeyID(p, s.ParamName)
+	 if s.importFailed("the KMS key ID", err) {
+		 return
+	 }
+	 if s.ConfigMap.ObjectMeta.Annotations == nil {
+		 s.ConfigMap.ObjectMeta.Annotations = make(map[string]string)
+	 }
+	 if keyID == "" {
+		 delete(s.ConfigMap.ObjectMeta.Annotations, anno.V1KMSKeyID)
+		 return
+	 }
+	 s.ConfigMap.ObjectMeta.Annotations[anno.V1KMSKeyID] = keyID
+ }
+
+ // setDirectoryMeta sets the key of aws-ssm/directory-meta, if any: "true", or
+ // the directory's path. Fails if a parameter has the same key.
+ func (s *ConfigMap) setDirectoryMeta(annotations map[string]string) error {
+	 switch annotations[anno.V1DirectoryMeta] {
+	 case anno.DirectoryMetaTrue:
+	 case anno.DirectoryMetaPath:
+		 s.ParamValue = s.ParamName
+	 default:
+		 return nil
+	 }
+	 key := s.ParamType
+	 if k := annotations[anno.V1DirectoryMetaKey]; k != "" {
+		 key = k
+	 }
+	 // Only keys set in this sync: Data still has the meta key of the last one
+	 if s.keys[key] {
+		 return fmt.Errorf("The %s key '%s' is also a parameter's key; rename it with %s", anno.V1DirectoryMeta, key, anno.V1DirectoryMetaKey)
+	 }
+	 return s.Set(key, s.ParamValue)
+ }
+
+ // setExtraData sets the static keys of the extra-data annotation. Keys that
+ // were already set from the parameter are kept.
+ func (s *ConfigMap) setExtraData(annotations map[string]string) error {
+	 value, ok := annotations[anno.V1ExtraData]
+	 if !ok {
+		 return nil
+	 }
+	 extra := make(map[string]string)
+	 if err := json.Unmarshal([]byte(value), &extra); err != nil {
+		 return fmt.Errorf("Invalid %s annotation: %s", anno.V1ExtraData, err)
+	 }
+	 for k, v := range extra {
+		 // Data still has the extra keys of the last sync, so only check
+		 // the keys set in this one
+		 if s.keys[k] {
+			 s.logger().Warnf("Ignoring extra-data key '%s': already set", k)
+			 s.skip(k)
+			 continue
+		 }
+		 s.Set(k, v)
+	 }
+	 return nil
+ }
+
+ // getParameterValue reads a parameter, passing any KMS grant tokens to the
+ // decrypt call, which is made as the aws-ssm/decrypt-role-arn if it's set
+ func getParameterValue(p provider.Provider, annotations map[string]string, name string, decrypt bool) (string, error) {
+	 tokens := anno.List(annotations, anno.V1KMSGrantToken)
+	 if role := annotations[anno.V1DecryptRoleARN]; decrypt && role != "" {
+		 return provider.GetParameterValueDecryptedAs(p, name, role, tokens)
+	 }
+	 if decrypt && len(tokens) > 0 {
+		 return p.GetParameterValueWithGrants(name, tokens)
+	 }
+	 return p.GetParameterValue(name, decrypt)
+ }
+
+ // listSeparator returns the separator of StringList entries in value. The
+ // list-separator annotation (which may use "\n" and "\t" escapes) takes precedence.
+ func listSeparator(annotations map[string]string, value string) string {
+	 if sep := annotations[anno.V1ListSeparator]; sep != "" {
+		 return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(sep)
+	 }
+	 if strings.Contains(value, "\n") && !strings.Contains(value, ",") {
+		 return "\n"
+	 }
+	 return ","
+ }
+
+ // listPairDelimiters returns the characters that split a StringList entry into
+ // its key and value: the list-pair-delimiters annotation, or "="
+ func listPairDelimiters(annotations map[string]string) string {
+	 if delimiters := annotations[anno.V1ListPairDelimiters]; delimiters != "" {
+		 return delimiters
+	 }
+	 return "="
+ }
+
+ // listEntries splits a StringList value into its (trimmed, non-empty) entries
+ func listEntries(annotations map[string]string, value string) []string {
+	 entries := []string{}
+	 sep := listSeparator(annotations, value)
+	 for _, entry := range strings.Split(strings.TrimSpace(value), sep) {
+		 if entry = strings.TrimSpace(entry); entry != "" {
+			 entries = append(entries, entry)
+		 }
+	 }
+	 return entries
+ }
+
+ // directoryPath normalizes a Directory path so that equivalent spellings produce
+ // identical keys: surrounding slashes are trimmed, then a single leading slash
+ // is added ("app/db/", "/app/db/" and "//app/db" -> "/app/db")
+ func directoryPath(ppath string) string {
+	 return "/" + strings.Trim(ppath, "/")
+ }
+
+ // directoryData reads the params of each comma-separated Directory path and
+ // maps them to their keys, trimming the strip-prefix annotation (parameters in
+ // the key-map annotation are stored under their mapped keys instead). Every collision,
+ // within a path or across paths, is checked before any key is set, so the error
+ // names both parameters instead of just the key. Returns the normalized paths,
+ // and the full name of the parameter of each key.
+ func (s *ConfigMap) directoryData(p provider.Provider, annotations map[string]string, ppaths string, decrypt bool) (string, map[string]string, map[string]string, error) {
+	 prefix := annotations[anno.V1StripPrefix]
+	 keyMap, err := anno.KeyMap(annotations)
+	 if err != nil {
+		 return "", nil, nil, err
+	 }
+	 data := make(map[string]string)
+	 // key -> the full name of the parameter it was read from
+	 sources := make(map[string]string)
+	 // Parameters left out with decrypt-failure-fatal: "false"
+	 var failures []string
+	 delete(annotations, anno.V1DecryptError)
+
+	 parts := strings.Split(ppaths, ",")
+	 paths := make([]string, 0, len(parts))
+	 for _, ppath := range parts {
+		 ppath = strings.TrimSpace(ppath)
+		 if ppath == "" && len(parts) > 1 {
+			 // A stray comma, not the root
+			 continue
+		 }
+		 ppath = directoryPath(ppath)
+		 paths = append(paths, ppath)
+
+		 params, failed, err := s.directoryParams(p, annotations, ppath, decrypt)
+		 if err != nil {
+			 return "", nil, nil, err
+		 }
+		 failures = append(failures, failed...)
+		 if len(params) == 0 && anno.Bool(annotations, anno.V1FailOnEmptyDirectory, false) && !provider.Validating(p) {
+			 return "", nil, nil, fmt.Errorf("Path '%s' has no parameters (%s)", ppath, anno.V1FailOnEmptyDirectory)
+		 }
+		 tiers, err := directoryTiers(p, annotations, ppath)
+		 if err != nil {
+			 return "", nil, nil, err
+		 }
+		 names := make([]string, 0, len(params))
+		 for name := range params {
+			 if tiers != nil && tiers[name] != annotations[anno.V1TierFilter] {
+				 continue
+			 }
+			 names = append(names, name)
+		 }
+		 sort.Strings(names)
+
+		 for _, name := range names {
+			 key, ok := keyMap[name]
+			 if !ok {
+				 key = strings.TrimPrefix(safeKeyName(path.Base(name)), prefix)
+			 }
+			 if key == "" {
+				 return "", nil, nil, fmt.Errorf("Parameter %s is empty after stripping prefix '%s'", name, prefix)
+			 }
+			 if other, ok := sources[key]; ok {
+				 return "", nil, nil, fmt.Errorf("Parameters %s and %s both produce key '%s'", other, name, key)
+			 }
+			 if _, err := provider.ParameterTier(name, params[name]); err != nil {
+				 return "", nil, nil, err
+			 }
+			 value, err := transform.Value(p, name, params[name])
+			 if err != nil {
+				 return "", nil, nil, err
+			 }
+			 sources[key] = name
+			 data[key] = value
+		 }
+	 }
+	 if len(failures) > 0 {
+		 annotations[anno.V1DecryptError] = strings.Join(failures, "; ")
+	 }
+	 return strings.Join(paths, ","), data, sources, nil
+ }
+
+ // directoryParams reads the params under ppath, by full name. With directory-fetch:
+ // each, they're listed, then read one at a time: a parameter that can't be decrypted
+ // fails the sync, unless decrypt-failure-fatal is "false", in which case it's left
+ // out (as if it weren't under ppath) and the failure is returned.
+ func (s *ConfigMap) directoryParams(p provider.Provider, annotations map[string]string, ppath string, decrypt bool) (map[string]string, []string, error) {
+	 if annotations[anno.V1DirectoryFetch] != anno.DirectoryFetchEach {
+		 params, err := p.GetParameterDataByPath(ppath, decrypt)
+		 return params, nil, err
+	 }
+	 names, err := provider.ListParametersByPath(p, ppath)
+	 if err != nil {
+		 return nil, nil, err
+	 }
+	 sort.Strings(names)
+
+	 params := make(map[string]string, len(names))
+	 var failures []string
+	 for _, name := range names {
+		 value, err := p.GetParameterValue(name, decrypt)
+		 if err != nil {
+			 if !decrypt || !provider.IsDecryptError(err) || anno.Bool(annotations, anno.V1DecryptFailureFatal, true) {
+				 return nil, nil, fmt.Errorf("Failed to read %s: %w", name, err)
+			 }
+			 s.logger().WithField("paramName", name).Warnf("Failed to decrypt; syncing without it: %s", err)
+			 failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+			 continue
+		 }
+		 params[name] = value
+	 }
+	 return params, failures, nil
+ }
+
+ // directoryTiers returns the tiers of the parameters under ppath if they're
+ // filtered by the tier-filter annotation, or nil if they aren't. Parameters
+ // of other tiers are skipped, as if they weren't under ppath.
+ func directoryTiers(p provider.Provider, annotations map[string]string, ppath string) (map[string]string, error) {
+	 if annotations[anno.V1TierFilter] == "" {
+		 return nil, nil
+	 }
+	 tiers, err := provider.GetParameterTiersByPath(p, ppath)
+	 if err != nil {
+		 return nil, fmt.Errorf("Failed to read the tiers of %s (%s): %s", ppath, anno.V1TierFilter, err)
+	 }
+	 return tiers, nil
  }
 
  func safeKeyName(key string) string {
@@ -201,3 +1224,10 @@
 	 }
 	 return strings.Replace(key, "/", "_", -1)
  }
+
+ var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+ // tagKeyName returns the key for a parameter tag: 'Cost Center' -> 'tag_Cost_Center'
+ func tagKeyName(tag string) string {
+	 return "tag_" + invalidKeyChars.ReplaceAllString(tag, "_")
+ }
@@ -17,13 +17,29 @@
 
  import (
 	 //"reflect"
+	 "context"
+	 "encoding/json"
+	 "errors"
+	 "fmt"
+	 "strings"
+	 "sync"
 	 "testing"
 
+	 "github.com/aws/aws-sdk-go/aws/awserr"
+	 anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	 "github.com/cmattoon/aws-ssm/pkg/archive"
 	 "github.com/cmattoon/aws-ssm/pkg/provider"
+	 "github.com/cmattoon/aws-ssm/pkg/testutil"
+	 "github.com/cmattoon/aws-ssm/pkg/transform"
+	 log "github.com/sirupsen/logrus"
+	 logtest "github.com/sirupsen/logrus/hooks/test"
 	 "github.com/stretchr/testify/assert"
 	 "github.com/stretchr/testify/require"
 	 "k8s.io/api/core/v1"
+	 apierrors "k8s.io/apimachinery/pkg/api/errors"
 	 metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	 "k8s.io/apimachinery/pkg/types"
+	 k8stesting "k8s.io/client-go/testing"
  )
 
  func TestParseStringList(t *testing.T) {
@@ -262,3 +278,1581 @@
 		 assert.Equal(t, safeKeyName(path), exp)
 	 }
  }
+
+ func TestFromKubernetesConfigMapPinsVersion(t *testing.T) {
+	 for _, tc := range []struct {
+		 title     string
+		 paramName string
+	 }{
+		 {title: "plain name", paramName: "foo-param"},
+		 {title: "inline version is overridden", paramName: "foo-param:1"},
+		 {title: "inline label is overridden", paramName: "foo-param:prod"},
+	 } {
+		 t.Run(tc.title, func(t *testing.T) {
+			 p := &testutil.Provider{Values: map[string]string{"foo-param:3": "pinned"}}
+			 s := v1.ConfigMap{
+				 ObjectMeta: metav1.ObjectMeta{
+					 Annotations: map[string]string{
+						 "aws-ssm/aws-param-name": tc.paramName,
+						 "aws-ssm/aws-param-type": "String",
+						 "aws-ssm/pin-version":    "3",
+					 },
+				 },
+			 }
+
+			 obj, err := FromKubernetesConfigMap(p, s)
+			 require.NoError(t, err)
+			 assert.Equal(t, []string{"foo-param:3"}, p.Requested)
+			 assert.Equal(t, "pinned", obj.ParamValue)
+		 })
+	 }
+ }
+
+ func TestFromKubernetesConfigMapRejectsInvalidPinVersion(t *testing.T) {
+	 for _, tc := range []struct {
+		 title     string
+		 paramType string
+		 version   string
+	 }{
+		 {title: "not a number", paramType: "String", version: "latest"},
+		 {title: "zero", paramType: "String", version: "0"},
+		 {title: "directory", paramType: "Directory", version: "3"},
+	 } {
+		 t.Run(tc.title, func(t *testing.T) {
+			 p := &testutil.Provider{}
+			 s := v1.ConfigMap{
+				 ObjectMeta: metav1.ObjectMeta{
+					 Annotations: map[string]string{
+						 "aws-ssm/aws-param-name": "foo-param",
+						 "aws-ssm/aws-param-type": tc.paramType,
+						 "aws-ssm/pin-version":    tc.version,
+					 },
+				 },
+			 }
+
+			 _, err := FromKubernetesConfigMap(p, s)
+			 assert.Error(t, err)
+			 assert.Empty(t, p.Requested)
+		 })
+	 }
+ }
+
+ func TestNewConfigMapImportsTags(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"foo-param": "FooBar123"},
+		 Tags: map[string]map[string]string{
+			 "foo-param": {"environment": "prod", "Cost Center": "1234"},
+		 },
+	 }
+	 s := v1.ConfigMap{
+		 ObjectMeta: metav1.ObjectMeta{
+			 Annotations: map[string]string{"aws-ssm/import-tags": "true"},
+		 },
+	 }
+
+	 obj, err := NewConfigMap(s, p, "foo", "namespace", "foo-param", "String", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{
+		 "String":          "FooBar123",
+		 "tag_environment": "prod",
+		 "tag_Cost_Center": "1234",
+	 }, obj.ConfigMap.Data)
+ }
+
+ func TestNewConfigMapIgnoresTagErrors(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "FooBar123"}}
+	 s := v1.ConfigMap{
+		 ObjectMeta: metav1.ObjectMeta{
+			 Annotations: map[string]string{"aws-ssm/import-tags": "true"},
+		 },
+	 }
+
+	 obj, err := NewConfigMap(s, p, "foo", "namespace", "foo-param", "String", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "FooBar123"}, obj.ConfigMap.Data)
+ }
+
+ // SecretsManager ids aren't SSM parameters, so have no tags to import
+ func TestNewConfigMapDoesNotImportTagsOfSecretsManager(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"my-secret": "FooBar123"},
+		 Tags:   map[string]map[string]string{"my-secret": {"environment": "prod"}},
+	 }
+	 s := v1.ConfigMap{
+		 ObjectMeta: metav1.ObjectMeta{
+			 Annotations: map[string]string{"aws-ssm/import-tags": "true"},
+		 },
+	 }
+
+	 obj, err := NewConfigMap(s, p, "foo", "namespace", "my-secret", "SecretsManager", "")
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.Data, "tag_environment")
+ }
+
+ func TestNewConfigMapDoesNotImportTagsByDefault(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"foo-param": "FooBar123"},
+		 Tags:   map[string]map[string]string{"foo-param": {"environment": "prod"}},
+	 }
+
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "foo-param", "String", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "FooBar123"}, obj.ConfigMap.Data)
+ }
+
+ func TestNewConfigMapHandlesDirectoryArchive(t *testing.T) {
+	 contents := map[string]string{
+		 "/dev/db/user": "root",
+		 "/dev/db/host": "10.0.1.10",
+		 "/dev/db/pass": "password123",
+	 }
+	 p := provider.MockProvider{"", "", contents}
+
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/dev/db", "DirectoryArchive", "")
+	 require.NoError(t, err)
+	 require.Len(t, obj.ConfigMap.Data, 1)
+	 assert.Equal(t, "3", obj.ConfigMap.ObjectMeta.Annotations["aws-ssm/archive-key-count"])
+
+	 data, err := archive.Decode(obj.ConfigMap.Data["DirectoryArchive"])
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{
+		 "user": "root",
+		 "host": "10.0.1.10",
+		 "pass": "password123",
+	 }, data)
+ }
+
+ func TestUpdateObjectRejectsOversizedConfigMap(t *testing.T) {
+	 s := &ConfigMap{
+		 Name:      "foo",
+		 Namespace: "namespace",
+		 Data:      map[string]string{},
+	 }
+	 require.NoError(t, s.Set("big", strings.Repeat("x", v1.MaxSecretSize)))
+	 assert.Equal(t, v1.MaxSecretSize, s.Size())
+	 require.NoError(t, s.Set("one-more", "x"))
+
+	 // The size check happens before the client is used
+	 _, err := s.UpdateObject(nil)
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "too large")
+ }
+
+ func TestNewConfigMapHandlesSecretsManager(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{
+		 "db-creds": `{"username": "admin", "password": "hunter2", "db": {"host": "10.0.0.1"}}`,
+		 "api-key":  "plaintext",
+	 }}
+
+	 for _, tc := range []struct {
+		 title       string
+		 paramName   string
+		 annotations map[string]string
+		 expected    map[string]string
+	 }{
+		 {
+			 title:     "all fields",
+			 paramName: "db-creds",
+			 expected: map[string]string{
+				 "SecretsManager": `{"username": "admin", "password": "hunter2", "db": {"host": "10.0.0.1"}}`,
+				 "username":       "admin",
+				 "password":       "hunter2",
+				 "db":             `{"host":"10.0.0.1"}`,
+			 },
+		 },
+		 {
+			 title:     "not JSON",
+			 paramName: "api-key",
+			 expected:  map[string]string{"SecretsManager": "plaintext"},
+		 },
+		 {
+			 title:     "inline field",
+			 paramName: "db-creds#password",
+			 expected:  map[string]string{"SecretsManager": "hunter2"},
+		 },
+		 {
+			 title:     "inline nested field",
+			 paramName: "db-creds#db.host",
+			 expected:  map[string]string{"SecretsManager": "10.0.0.1"},
+		 },
+		 {
+			 title:       "annotation field overrides inline",
+			 paramName:   "db-creds#password",
+			 annotations: map[string]string{"aws-ssm/secret-field-path": "username"},
+			 expected:    map[string]string{"SecretsManager": "admin"},
+		 },
+		 {
+			 title:       "subset of fields",
+			 paramName:   "db-creds",
+			 annotations: map[string]string{"aws-ssm/secret-fields": "username, db"},
+			 expected: map[string]string{
+				 "SecretsManager": `{"db":{"host":"10.0.0.1"},"username":"admin"}`,
+				 "username":       "admin",
+				 "db":             `{"host":"10.0.0.1"}`,
+			 },
+		 },
+		 {
+			 title:       "field within subset",
+			 paramName:   "db-creds#db.host",
+			 annotations: map[string]string{"aws-ssm/secret-fields": "username,db"},
+			 expected:    map[string]string{"SecretsManager": "10.0.0.1"},
+		 },
+	 } {
+		 t.Run(tc.title, func(t *testing.T) {
+			 s := v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			 obj, err := NewConfigMap(s, p, "foo", "namespace", tc.paramName, "SecretsManager", "")
+			 require.NoError(t, err)
+			 assert.Equal(t, tc.expected, obj.ConfigMap.Data)
+		 })
+	 }
+ }
+
+ func TestNewConfigMapFailsOnMissingSecretField(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"db-creds": `{"username": "admin"}`}}
+
+	 _, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "db-creds#password", "SecretsManager", "")
+	 require.Error(t, err)
+	 assert.Equal(t, "ConfigMap namespace/foo, parameter 'db-creds#password' (SecretsManager): Secret 'db-creds': Field 'password' not found", err.Error())
+ }
+
+ func TestNewConfigMapWrapsErrors(t *testing.T) {
+	 denied := awserr.New("AccessDeniedException", "User is not authorized to perform: kms:Decrypt", nil)
+	 p := &testutil.Provider{Encrypted: map[string]string{"foo-param": "AQICAHh..."}, DecryptError: denied}
+
+	 _, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "foo-param", "SecureString", "alias/aws/ssm")
+	 assert.EqualError(t, err, "ConfigMap namespace/foo, parameter 'foo-param' (SecureString): "+
+		 "AccessDeniedException: User is not authorized to perform: kms:Decrypt")
+	 assert.True(t, errors.Is(err, denied))
+	 assert.True(t, provider.IsAccessDenied(err))
+
+	 var aerr awserr.Error
+	 require.True(t, errors.As(err, &aerr))
+	 assert.Equal(t, "AccessDeniedException", aerr.Code())
+	 assert.Equal(t, denied, errors.Unwrap(err))
+ }
+
+ func TestNewConfigMapFailsOnMissingSecretFields(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"db-creds": `{"username": "admin", "password": "hunter2"}`}}
+	 s := v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		 "aws-ssm/secret-fields": "username,token",
+	 }}}
+
+	 _, err := NewConfigMap(s, p, "foo", "namespace", "db-creds", "SecretsManager", "")
+	 require.Error(t, err)
+	 assert.Equal(t, "ConfigMap namespace/foo, parameter 'db-creds' (SecretsManager): Secret 'db-creds': Field 'token' not found", err.Error())
+
+	 // A single field outside the subset isn't there either
+	 s.ObjectMeta.Annotations["aws-ssm/secret-fields"] = "username"
+	 _, err = NewConfigMap(s, p, "foo", "namespace", "db-creds#password", "SecretsManager", "")
+	 require.Error(t, err)
+	 assert.Equal(t, "ConfigMap namespace/foo, parameter 'db-creds#password' (SecretsManager): Secret 'db-creds': Field 'password' not found", err.Error())
+ }
+
+ func TestNewConfigMapRejectsBinarySecretsManager(t *testing.T) {
+	 p := &testutil.Provider{Binaries: map[string][]byte{"keystore": {0x00, 0xff}}}
+
+	 _, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "keystore", "SecretsManager", "")
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "binary")
+ }
+
+ func TestNewConfigMapNormalizesDirectoryPath(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/db": {"user": "root", "host": "10.0.1.10"},
+	 }}
+	 expected := map[string]string{
+		 "user": "root",
+		 "host": "10.0.1.10",
+	 }
+
+	 for _, path := range []string{"/app/db", "/app/db/", "app/db", "//app/db//"} {
+		 t.Run(path, func(t *testing.T) {
+			 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", path, "Directory", "")
+			 require.NoError(t, err)
+			 assert.Equal(t, "/app/db", obj.ParamName)
+			 assert.Equal(t, expected, obj.ConfigMap.Data)
+		 })
+	 }
+ }
+
+ func TestDirectoryPath(t *testing.T) {
+	 for path, exp := range map[string]string{
+		 "/":        "/",
+		 "":         "/",
+		 "/app/db":  "/app/db",
+		 "/app/db/": "/app/db",
+		 "app/db":   "/app/db",
+	 } {
+		 assert.Equal(t, exp, directoryPath(path))
+	 }
+ }
+
+ // Exercises the full flow against a fake apiserver:
+ // FromKubernetesConfigMap -> UpdateObject -> read back
+ func TestUpdateObjectWritesData(t *testing.T) {
+	 cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("/app/db", "Directory")))
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/db": {"user": "root", "host": "10.0.1.10"},
+	 }}
+
+	 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+
+	 obj, err := FromKubernetesConfigMap(p, *current)
+	 require.NoError(t, err)
+	 _, err = obj.UpdateObject(cli)
+	 require.NoError(t, err)
+
+	 updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{
+		 "user": "root",
+		 "host": "10.0.1.10",
+	 }, updated.Data)
+	 assert.Equal(t, current.ObjectMeta.Annotations, updated.ObjectMeta.Annotations)
+ }
+
+ func TestUpdateObjectCreateIfMissing(t *testing.T) {
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1CreateIfMissing] = "true"
+	 cli := testutil.NewKubeClient()
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 _, err = obj.UpdateObject(cli)
+	 require.NoError(t, err)
+
+	 created, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+	 assert.Equal(t, "bar", created.Data["String"])
+ }
+
+ func TestUpdateObjectMissingWithoutCreate(t *testing.T) {
+	 cli := testutil.NewKubeClient()
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	 require.NoError(t, err)
+	 _, err = obj.UpdateObject(cli)
+	 assert.True(t, apierrors.IsNotFound(err))
+
+	 _, err = cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 assert.True(t, apierrors.IsNotFound(err))
+ }
+
+ func TestUpdateObjectExistingWithCreateIfMissing(t *testing.T) {
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1CreateIfMissing] = "true"
+	 cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", annotations))
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+	 obj, err := FromKubernetesConfigMap(p, *current)
+	 require.NoError(t, err)
+	 _, err = obj.UpdateObject(cli)
+	 require.NoError(t, err)
+
+	 updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+	 assert.Equal(t, "bar", updated.Data["String"])
+ }
+
+ func TestTemplateKeys(t *testing.T) {
+	 annotations := testutil.Annotations("/db/host", "String")
+	 annotations[anno.V1TemplateParams] = "host=/db/host,port=/db/port"
+	 annotations[anno.V1TemplatePrefix+"url"] = "postgres://{{.host}}:{{.port}}/app"
+	 p := &testutil.Provider{Values: map[string]string{
+		 "/db/host": "db.internal",
+		 "/db/port": "5432",
+	 }}
+
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "postgres://db.internal:5432/app", obj.ConfigMap.Data["url"])
+	 assert.Equal(t, "db.internal", obj.ConfigMap.Data["String"])
+ }
+
+ func TestTemplateRenderError(t *testing.T) {
+	 annotations := testutil.Annotations("/db/host", "String")
+	 annotations[anno.V1TemplateParams] = "host=/db/host"
+	 annotations[anno.V1TemplatePrefix+"url"] = "{{.hots}}"
+	 p := &testutil.Provider{Values: map[string]string{"/db/host": "db.internal"}}
+
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "url")
+	 assert.NotContains(t, err.Error(), "db.internal")
+ }
+
+ func TestKMSGrantTokens(t *testing.T) {
+	 annotations := testutil.Annotations("foo-param", "SecureString")
+	 annotations[anno.V1KMSGrantToken] = "token-a, token-b"
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "bar", obj.ParamValue)
+	 assert.Equal(t, map[string][]string{"foo-param": {"token-a", "token-b"}}, p.GrantTokens)
+ }
+
+ func TestDecryptRoleARN(t *testing.T) {
+	 annotations := testutil.Annotations("foo-param", "SecureString")
+	 annotations[anno.V1RoleARN] = "arn:aws:iam::123456789012:role/reader"
+	 annotations[anno.V1DecryptRoleARN] = "arn:aws:iam::123456789012:role/kms-decrypt"
+	 annotations[anno.V1KMSGrantToken] = "token-a"
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "bar", obj.ParamValue)
+	 assert.Equal(t, map[string]string{"foo-param": "arn:aws:iam::123456789012:role/kms-decrypt"}, p.DecryptRoles)
+	 assert.Equal(t, map[string][]string{"foo-param": {"token-a"}}, p.GrantTokens)
+
+	 // Nothing to decrypt
+	 p = &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", map[string]string{
+		 anno.V1ParamName:      "foo-param",
+		 anno.V1ParamType:      "String",
+		 anno.V1DecryptRoleARN: "arn:aws:iam::123456789012:role/kms-decrypt",
+	 }))
+	 require.NoError(t, err)
+	 assert.Empty(t, p.DecryptRoles)
+ }
+
+ func TestKMSGrantTokensIgnoredWithoutDecryption(t *testing.T) {
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1KMSGrantToken] = "token-a"
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Empty(t, p.GrantTokens)
+ }
+
+ func TestParseStringListSeparators(t *testing.T) {
+	 tests := []struct {
+		 title     string
+		 separator string
+		 value     string
+		 expected  map[string]string
+	 }{
+		 {"comma-separated", "", "foo=bar,baz=bat", map[string]string{"foo": "bar", "baz": "bat"}},
+		 {"newline-separated", "", "foo=bar\nbaz=bat\n", map[string]string{"foo": "bar", "baz": "bat"}},
+		 {"CRLF-separated", "", "foo=bar\r\nbaz=bat", map[string]string{"foo": "bar", "baz": "bat"}},
+		 {"newlines with commas", "", "foo=bar,\nbaz=bat", map[string]string{"foo": "bar", "baz": "bat"}},
+		 {"commas in newline-separated values", "\\n", "foo=a,b\nbaz=bat", map[string]string{"foo": "a,b", "baz": "bat"}},
+		 {"override", ";", "foo=bar;baz=bat,bar", map[string]string{"foo": "bar", "baz": "bat,bar"}},
+	 }
+
+	 for _, test := range tests {
+		 t.Run(test.title, func(t *testing.T) {
+			 annotations := map[string]string{}
+			 if test.separator != "" {
+				 annotations[anno.V1ListSeparator] = test.separator
+			 }
+			 s := &ConfigMap{ParamType: "StringList", ParamValue: test.value}
+			 s.ConfigMap.ObjectMeta.Annotations = annotations
+			 assert.Equal(t, test.expected, s.ParseStringList())
+		 })
+	 }
+ }
+
+ func TestParseStringListPairDelimiters(t *testing.T) {
+	 tests := []struct {
+		 title      string
+		 delimiters string
+		 value      string
+		 expected   map[string]string
+	 }{
+		 {"default", "", "foo=bar,baz:bat", map[string]string{"foo": "bar", "baz:bat": ""}},
+		 {"colon", ":", "foo:bar,baz:bat", map[string]string{"foo": "bar", "baz": "bat"}},
+		 {"delimiter in value", ":", "url:https://example.com:8443,empty:", map[string]string{"url": "https://example.com:8443", "empty": ""}},
+		 {"either", ":=", "foo:a=b,baz=c:d,bat", map[string]string{"foo": "a=b", "baz": "c:d", "bat": ""}},
+		 {"empty key", ":", ":bar", map[string]string{":bar": ""}},
+		 {"multibyte", "→", "foo→bar", map[string]string{"foo": "bar"}},
+	 }
+
+	 for _, test := range tests {
+		 t.Run(test.title, func(t *testing.T) {
+			 annotations := map[string]string{}
+			 if test.delimiters != "" {
+				 annotations[anno.V1ListPairDelimiters] = test.delimiters
+			 }
+			 s := &ConfigMap{ParamType: "StringList", ParamValue: test.value}
+			 s.ConfigMap.ObjectMeta.Annotations = annotations
+			 assert.Equal(t, test.expected, s.ParseStringList())
+		 })
+	 }
+ }
+
+ func TestMinVersion(t *testing.T) {
+	 tests := []struct {
+		 title   string
+		 version int64
+		 ready   bool
+	 }{
+		 {"below", 2, false},
+		 {"equal", 3, true},
+		 {"above", 4, true},
+	 }
+
+	 for _, test := range tests {
+		 t.Run(test.title, func(t *testing.T) {
+			 annotations := testutil.Annotations("foo-param", "String")
+			 annotations[anno.V1MinVersion] = "3"
+			 p := &testutil.Provider{
+				 Values:   map[string]string{"foo-param": "bar"},
+				 Versions: map[string]int64{"foo-param": test.version},
+			 }
+
+			 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+			 if test.ready {
+				 require.NoError(t, err)
+				 assert.Equal(t, "bar", obj.ParamValue)
+				 return
+			 }
+			 require.IsType(t, &provider.VersionNotReadyError{}, err)
+			 // The value isn't read until the version is ready
+			 assert.Equal(t, []string{"foo-param"}, p.Requested)
+		 })
+	 }
+ }
+
+ func TestMinVersionInvalid(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1MinVersion] = "latest"
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+
+	 annotations = testutil.Annotations("/foo", "Directory")
+	 annotations[anno.V1MinVersion] = "3"
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+ }
+
+ func TestTargetKind(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	 tests := []struct {
+		 kind  string
+		 valid bool
+	 }{
+		 {"ConfigMap", true},
+		 {"Secret", false},
+		 {"Deployment", false},
+	 }
+
+	 for _, test := range tests {
+		 annotations := testutil.Annotations("foo-param", "String")
+		 annotations[anno.V1TargetKind] = test.kind
+		 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+		 if test.valid {
+			 assert.NoError(t, err, test.kind)
+		 } else {
+			 assert.Error(t, err, test.kind)
+		 }
+	 }
+ }
+
+ func TestStripPrefix(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app": {"app_user": "root", "app_host": "10.0.1.10", "port": "5432"},
+	 }}
+	 annotations := map[string]string{anno.V1StripPrefix: "app_"}
+
+	 for _, paramType := range []string{"Directory", "DirectoryArchive"} {
+		 obj, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app", paramType, "")
+		 require.NoError(t, err, paramType)
+		 if paramType == "Directory" {
+			 assert.Equal(t, map[string]string{
+				 "user": "root",
+				 "host": "10.0.1.10",
+				 "port": "5432",
+			 }, obj.ConfigMap.Data)
+		 }
+	 }
+ }
+
+ func TestStripPrefixCollision(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app": {"app_host": "10.0.1.10", "host": "10.0.1.11"},
+	 }}
+	 annotations := map[string]string{anno.V1StripPrefix: "app_"}
+
+	 for _, paramType := range []string{"Directory", "DirectoryArchive"} {
+		 _, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app", paramType, "")
+		 require.Error(t, err, paramType)
+		 assert.Contains(t, err.Error(), "/app/app_host")
+		 assert.Contains(t, err.Error(), "/app/host")
+	 }
+
+	 // Stripping the whole key
+	 p.Directories["/app"] = map[string]string{"app_": "foo"}
+	 _, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app", "Directory", "")
+	 assert.Error(t, err)
+ }
+
+ func TestExtraData(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"foo-param": "bar"},
+		 Directories: map[string]map[string]string{
+			 "/app": {"user": "root", "env": "dev"},
+		 },
+	 }
+
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1ExtraData] = `{"env": "prod", "region": "us-east-1"}`
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{
+		 "String": "bar",
+		 "env":    "prod",
+		 "region": "us-east-1",
+	 }, obj.ConfigMap.Data)
+
+	 // Keys from the parameter take precedence
+	 annotations = testutil.Annotations("/app", "Directory")
+	 annotations[anno.V1ExtraData] = `{"env": "prod", "region": "us-east-1"}`
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{
+		 "user":   "root",
+		 "env":    "dev",
+		 "region": "us-east-1",
+	 }, obj.ConfigMap.Data)
+ }
+
+ func TestExtraDataResync(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1ExtraData] = `{"env": "dev"}`
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+
+	 // The keys from the last sync are replaced, and still managed
+	 obj.ConfigMap.ObjectMeta.Annotations[anno.V1ExtraData] = `{"env": "prod"}`
+	 obj, err = FromKubernetesConfigMap(p, obj.ConfigMap)
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "bar", "env": "prod"}, obj.ConfigMap.Data)
+	 assert.Equal(t, []string{"String", "env"}, obj.ManagedKeys())
+ }
+
+ func TestExtraDataInvalid(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 for _, value := range []string{`{"env": `, `["env"]`, `{"port": 5432}`} {
+		 annotations := testutil.Annotations("foo-param", "String")
+		 annotations[anno.V1ExtraData] = value
+		 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+		 assert.Error(t, err, value)
+	 }
+ }
+
+ func TestImportDescription(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values:       map[string]string{"foo-param": "bar", "bar-param": "baz"},
+		 Descriptions: map[string]string{"foo-param": "Primary DB host (owned by #data)"},
+	 }
+
+	 annotations := testutil.Annotations("foo-param", "String")
+	 annotations[anno.V1ImportDescription] = "true"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "Primary DB host (owned by #data)", obj.ConfigMap.ObjectMeta.Annotations[anno.V1Description])
+	 assert.Equal(t, "bar", obj.ConfigMap.Data["String"])
+
+	 // Best-effort: the sync still succeeds
+	 annotations = testutil.Annotations("bar-param", "String")
+	 annotations[anno.V1ImportDescription] = "true"
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1Description)
+	 assert.Equal(t, "baz", obj.ConfigMap.Data["String"])
+
+	 // Not imported by default
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1Description)
+ }
+
+ func TestDefaultKMSKeyLoggedAtDebug(t *testing.T) {
+	 hook := logtest.NewGlobal()
+	 defer hook.Reset()
+	 level := log.GetLevel()
+	 defer log.SetLevel(level)
+	 log.SetLevel(log.DebugLevel)
+
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "SecureString")))
+	 require.NoError(t, err)
+	 assert.Equal(t, "alias/aws/ssm", obj.ParamKey)
+
+	 found := 0
+	 for _, entry := range hook.AllEntries() {
+		 if strings.Contains(entry.Message, "No KMS key defined") {
+			 found += 1
+			 assert.Equal(t, log.DebugLevel, entry.Level)
+			 assert.Equal(t, "namespace", entry.Data["namespace"])
+			 assert.Equal(t, "foo", entry.Data["name"])
+		 }
+	 }
+	 assert.Equal(t, 1, found)
+ }
+
+ func TestLogFields(t *testing.T) {
+	 hook := logtest.NewGlobal()
+	 defer hook.Reset()
+	 level := log.GetLevel()
+	 defer log.SetLevel(level)
+	 log.SetLevel(log.DebugLevel)
+
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "hunter2"}}
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	 require.NoError(t, err)
+
+	 require.NotEmpty(t, hook.AllEntries())
+	 for _, entry := range hook.AllEntries() {
+		 assert.Equal(t, log.Fields{
+			 "namespace": "namespace",
+			 "name":      "foo",
+			 "paramName": "foo-param",
+			 "paramType": "String",
+		 }, entry.Data, entry.Message)
+		 assert.NotContains(t, entry.Message, "hunter2")
+	 }
+ }
+
+ func TestStringListRawKey(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "a=1,b=2"}}
+
+	 annotations := testutil.Annotations("foo-param", "StringList")
+	 annotations[anno.V1ListRawKey] = "raw"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"a": "1", "b": "2", "raw": "a=1,b=2"}, obj.ConfigMap.Data)
+
+	 annotations = testutil.Annotations("foo-param", "StringList")
+	 annotations[anno.V1ListOmitRaw] = "true"
+	 annotations[anno.V1ListRawKey] = "raw"
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"a": "1", "b": "2"}, obj.ConfigMap.Data)
+
+	 // Only StringLists are affected
+	 p.Values["bar-param"] = "bar"
+	 annotations = testutil.Annotations("bar-param", "String")
+	 annotations[anno.V1ListOmitRaw] = "true"
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "bar"}, obj.ConfigMap.Data)
+ }
+
+ func TestUpdateObjectAddsManagedLabel(t *testing.T) {
+	 existing := testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String"))
+	 existing.ObjectMeta.Labels = map[string]string{"app": "web"}
+	 cli := testutil.NewKubeClient(existing)
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	 for i := 0; i < 2; i++ {
+		 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 obj, err := FromKubernetesConfigMap(p, *current)
+		 require.NoError(t, err)
+		 _, err = obj.UpdateObject(cli)
+		 require.NoError(t, err)
+
+		 updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 assert.Equal(t, map[string]string{"app": "web", anno.V1ManagedLabel: "true"}, updated.ObjectMeta.Labels)
+	 }
+
+	 selected, err := cli.CoreV1().ConfigMaps("namespace").List(metav1.ListOptions{LabelSelector: anno.V1ManagedLabel + "=true"})
+	 require.NoError(t, err)
+	 assert.Len(t, selected.Items, 1)
+ }
+
+ func TestVersionStage(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"db-creds": `{"password": "current"}`},
+		 Stages: map[string]map[string]string{"db-creds": {"AWSPENDING": `{"password": "pending"}`}},
+	 }
+
+	 for stage, password := range map[string]string{"": "current", "AWSCURRENT": "current", "AWSPENDING": "pending"} {
+		 annotations := testutil.Annotations("db-creds", "SecretsManager")
+		 if stage != "" {
+			 annotations[anno.V1VersionStage] = stage
+		 }
+		 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+		 require.NoError(t, err, stage)
+		 assert.Equal(t, password, obj.ConfigMap.Data["password"], stage)
+	 }
+
+	 annotations := testutil.Annotations("db-creds", "SecretsManager")
+	 annotations[anno.V1VersionStage] = "AWSPREVIOUS"
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "AWSPREVIOUS")
+ }
+
+ func TestChecksum(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/db": {"user": "root", "host": "10.0.1.10", "port": "5432"},
+	 }}
+	 annotations := testutil.Annotations("/app/db", "Directory")
+	 annotations[anno.V1ComputeChecksum] = "true"
+	 cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", annotations))
+
+	 checksums := map[string]bool{}
+	 for i := 0; i < 10; i++ {
+		 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 obj, err := FromKubernetesConfigMap(p, *current)
+		 require.NoError(t, err)
+		 _, err = obj.UpdateObject(cli)
+		 require.NoError(t, err)
+
+		 updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 require.Len(t, updated.ObjectMeta.Annotations[anno.V1Checksum], 64)
+		 checksums[updated.ObjectMeta.Annotations[anno.V1Checksum]] = true
+	 }
+	 // Independent of map order
+	 assert.Len(t, checksums, 1)
+
+	 // Changes with the data
+	 p.Directories["/app/db"]["port"] = "5433"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.NotContains(t, checksums, obj.Checksum())
+
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("/app/db", "Directory")))
+	 require.NoError(t, err)
+	 _, err = obj.UpdateObject(testutil.NewKubeClient(&obj.ConfigMap))
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1Checksum)
+ }
+
+ func TestMultipleDirectoryPaths(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/common": {"region": "us-east-1", "env": "prod"},
+		 "/app/db":     {"host": "10.0.1.10", "port": "5432"},
+	 }}
+
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/common, app/db/", "Directory", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, "/app/common,/app/db", obj.ParamName)
+	 assert.Equal(t, map[string]string{
+		 "region": "us-east-1",
+		 "env":    "prod",
+		 "host":   "10.0.1.10",
+		 "port":   "5432",
+	 }, obj.ConfigMap.Data)
+ }
+
+ func TestDirectoryTierFilter(t *testing.T) {
+	 p := &testutil.Provider{
+		 Directories: map[string]map[string]string{
+			 "/app/common": {"region": "us-east-1", "cert": "-----BEGIN CERTIFICATE-----"},
+			 "/app/db":     {"host": "10.0.1.10", "ca": "-----BEGIN CERTIFICATE-----"},
+		 },
+		 Tiers: map[string]string{"/app/common/cert": "Advanced", "/app/db/ca": "Advanced"},
+	 }
+	 tests := []struct {
+		 tier     string
+		 expected map[string]string
+	 }{
+		 {"", map[string]string{"region": "us-east-1", "cert": "-----BEGIN CERTIFICATE-----", "host": "10.0.1.10", "ca": "-----BEGIN CERTIFICATE-----"}},
+		 {"Standard", map[string]string{"region": "us-east-1", "host": "10.0.1.10"}},
+		 {"Advanced", map[string]string{"cert": "-----BEGIN CERTIFICATE-----", "ca": "-----BEGIN CERTIFICATE-----"}},
+	 }
+	 for _, test := range tests {
+		 annotations := map[string]string{}
+		 if test.tier != "" {
+			 annotations["aws-ssm/tier-filter"] = test.tier
+		 }
+		 obj, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/common,/app/db", "Directory", "")
+		 require.NoError(t, err, test.tier)
+		 assert.Equal(t, test.expected, obj.ConfigMap.Data, test.tier)
+	 }
+
+	 _, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"aws-ssm/tier-filter": "Advanced"}}},
+		 struct{ provider.Provider }{p}, "foo", "namespace", "/app/common", "Directory", "")
+	 assert.EqualError(t, err, "ConfigMap namespace/foo, parameter '/app/common' (Directory): Failed to read the tiers of /app/common (aws-ssm/tier-filter): Parameter tiers aren't supported by struct { provider.Provider }")
+ }
+
+ func TestMultipleDirectoryPathsCollision(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/common": {"region": "us-east-1", "host": "10.0.1.1"},
+		 "/app/db":     {"host": "10.0.1.10", "port": "5432"},
+	 }}
+
+	 for _, paramType := range []string{"Directory", "DirectoryArchive"} {
+		 _, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/common,/app/db", paramType, "")
+		 require.Error(t, err, paramType)
+		 assert.Contains(t, err.Error(), "/app/common/host")
+		 assert.Contains(t, err.Error(), "/app/db/host")
+	 }
+ }
+
+ func TestUpdateObjectLogsChangedKeys(t *testing.T) {
+	 hook := logtest.NewGlobal()
+	 defer hook.Reset()
+	 level := log.GetLevel()
+	 defer log.SetLevel(level)
+	 log.SetLevel(log.DebugLevel)
+
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	 updating := func(obj *v1.ConfigMap) *log.Entry {
+		 hook.Reset()
+		 cli := testutil.NewKubeClient(obj)
+		 s, err := FromKubernetesConfigMap(p, *obj)
+		 require.NoError(t, err)
+		 _, err = s.UpdateObject(cli)
+		 require.NoError(t, err)
+		 for _, entry := range hook.AllEntries() {
+			 if strings.HasPrefix(entry.Message, "Updating Kubernetes ConfigMap") {
+				 return entry
+			 }
+		 }
+		 t.Fatal("No update logged")
+		 return nil
+	 }
+
+	 entry := updating(testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	 assert.Equal(t, log.InfoLevel, entry.Level)
+	 assert.Equal(t, "ConfigMap", entry.Data["kind"])
+	 assert.Equal(t, "namespace", entry.Data["namespace"])
+	 assert.Equal(t, "foo", entry.Data["name"])
+	 assert.Equal(t, true, entry.Data["changed"])
+	 assert.Equal(t, "String", entry.Data["changedKeys"])
+
+	 unchanged := testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String"))
+	 unchanged.Data = map[string]string{"String": "bar"}
+	 entry = updating(unchanged)
+	 assert.Equal(t, log.DebugLevel, entry.Level)
+	 assert.Equal(t, false, entry.Data["changed"])
+	 assert.Equal(t, "", entry.Data["changedKeys"])
+ }
+
+ func TestConfigMapRecordsParameterTier(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{
+		 "small": "foo",
+		 "large": strings.Repeat("a", provider.StandardTierMaxBytes+1),
+		 "list":  strings.Repeat("a,", provider.StandardTierMaxBytes),
+	 }}
+	 for name, tier := range map[string]string{"small": "Standard", "large": "Advanced", "list": "Advanced"} {
+		 paramType := "String"
+		 if name == "list" {
+			 paramType = "StringList"
+		 }
+		 obj, err := FromKubernetesConfigMap(p, v1.ConfigMap{
+			 ObjectMeta: metav1.ObjectMeta{
+				 Annotations: map[string]string{
+					 anno.V1ParamName: name,
+					 anno.V1ParamType: paramType,
+				 },
+			 },
+		 })
+		 require.NoError(t, err, name)
+		 assert.Equal(t, tier, obj.ConfigMap.ObjectMeta.Annotations[anno.V1ParamTier], name)
+		 assert.Len(t, obj.ParamValue, len(p.Values[name]), name)
+	 }
+ }
+
+ func TestConfigMapRejectsTruncatedValues(t *testing.T) {
+	 truncated := (strings.Repeat("a", provider.AdvancedTierMaxBytes-2) + "€")[:provider.AdvancedTierMaxBytes]
+	 p := &testutil.Provider{
+		 Values:      map[string]string{"large": truncated},
+		 Directories: map[string]map[string]string{"/dir": {"ok": "foo", "large": truncated}},
+	 }
+	 for _, paramType := range []string{"String", "Directory"} {
+		 name := "large"
+		 if paramType == "Directory" {
+			 name = "/dir"
+		 }
+		 _, err := FromKubernetesConfigMap(p, v1.ConfigMap{
+			 ObjectMeta: metav1.ObjectMeta{
+				 Annotations: map[string]string{
+					 anno.V1ParamName: name,
+					 anno.V1ParamType: paramType,
+				 },
+			 },
+		 })
+		 require.Error(t, err, paramType)
+		 assert.Contains(t, err.Error(), "looks truncated", paramType)
+	 }
+ }
+
+ func TestStringListOutput(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "a=1, b=2,,c"}}
+
+	 for _, output := range []string{"", anno.ListOutputKeys} {
+		 annotations := testutil.Annotations("foo-param", "StringList")
+		 if output != "" {
+			 annotations[anno.V1ListOutput] = output
+		 }
+		 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+		 require.NoError(t, err, output)
+		 assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "", "StringList": "a=1, b=2,,c"}, obj.ConfigMap.Data, output)
+	 }
+
+	 annotations := testutil.Annotations("foo-param", "StringList")
+	 annotations[anno.V1ListOutput] = anno.ListOutputJoined
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"StringList": "a=1\nb=2\nc"}, obj.ConfigMap.Data)
+
+	 annotations[anno.V1ListRawKey] = "hosts.txt"
+	 annotations[anno.V1ListSeparator] = ";"
+	 p.Values["foo-param"] = "10.0.0.1; 10.0.0.2"
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"hosts.txt": "10.0.0.1\n10.0.0.2"}, obj.ConfigMap.Data)
+
+	 annotations[anno.V1ListOutput] = "lines"
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.EqualError(t, err, "Invalid aws-ssm/list-output 'lines' (keys|joined)")
+
+	 annotations[anno.V1ListOutput] = anno.ListOutputJoined
+	 annotations[anno.V1ListOmitRaw] = "true"
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+ }
+
+ func TestEncryptedFallback(t *testing.T) {
+	 denied := awserr.New("AccessDeniedException", "User is not authorized to perform: kms:Decrypt", nil)
+	 p := &testutil.Provider{Encrypted: map[string]string{"foo-param": "AQICAHh..."}, DecryptError: denied}
+	 annotations := testutil.Annotations("foo-param", "SecureString")
+
+	 // Off by default
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.True(t, errors.Is(err, denied))
+
+	 annotations[anno.V1AllowEncryptedFallback] = "true"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecureString": "AQICAHh..."}, obj.ConfigMap.Data)
+	 assert.Equal(t, "true", obj.ConfigMap.ObjectMeta.Annotations[anno.V1EncryptedFallback])
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1ParamTier)
+
+	 // Once decrypting is allowed, the mark is removed
+	 p = &testutil.Provider{Values: map[string]string{"foo-param": "hunter2"}}
+	 obj, err = FromKubernetesConfigMap(p, obj.ConfigMap)
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecureString": "hunter2"}, obj.ConfigMap.Data)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1EncryptedFallback)
+ }
+
+ func TestEncryptedFallbackOnlyOnAccessDenied(t *testing.T) {
+	 invalid := awserr.New("InvalidCiphertextException", "", nil)
+	 p := &testutil.Provider{Encrypted: map[string]string{"foo-param": "AQICAHh..."}, DecryptError: invalid}
+	 annotations := testutil.Annotations("foo-param", "SecureString")
+	 annotations[anno.V1AllowEncryptedFallback] = "true"
+
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.True(t, errors.Is(err, invalid))
+ }
+
+ func TestImportARN(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"/app/db/host": "10.0.1.10"},
+		 Directories: map[string]map[string]string{
+			 "/app/db": {"host": "10.0.1.10", "port": "5432"},
+		 },
+	 }
+
+	 annotations := testutil.Annotations("/app/db/host", "String")
+	 annotations[anno.V1ImportARN] = "true"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/host", obj.ConfigMap.ObjectMeta.Annotations[anno.V1ARN])
+
+	 // Directory: one ARN per key, in key order, with a single ARN request
+	 p.Requested = nil
+	 annotations = testutil.Annotations("/app/db", "Directory")
+	 annotations[anno.V1ImportARN] = "true"
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/host,"+
+		 "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/port", obj.ConfigMap.ObjectMeta.Annotations[anno.V1ARN])
+	 assert.Equal(t, []string{"/app/db", "/app/db/host"}, p.Requested)
+
+	 // Not imported by default
+	 obj, err = NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/db/host", "String", "")
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1ARN)
+ }
+
+ func TestPreviousValues(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values:  map[string]string{"/app/db/password": "v3"},
+		 History: map[string][]string{"/app/db/password": {"v1", "v2", "v3"}},
+	 }
+
+	 annotations := testutil.Annotations("/app/db/password", "String")
+	 annotations[anno.V1PreviousValuePrefix+"previous"] = "1"
+	 annotations[anno.V1PreviousValuePrefix+"first"] = "2"
+	 annotations[anno.V1PreviousValuePrefix+"missing"] = "3"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "v3", "previous": "v2", "first": "v1"}, obj.ConfigMap.Data)
+
+	 annotations[anno.V1PreviousValuePrefix+"missing"] = "none"
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+ }
+
+ func TestDecryptFailureNotFatal(t *testing.T) {
+	 denied := awserr.New("AccessDeniedException", "User is not authorized to perform: kms:Decrypt", nil)
+	 p := &testutil.Provider{Encrypted: map[string]string{"foo-param": "AQICAHh..."}, DecryptError: denied}
+	 annotations := testutil.Annotations("foo-param", "SecureString")
+
+	 // Fatal by default
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.True(t, errors.Is(err, denied))
+
+	 annotations[anno.V1DecryptFailureFatal] = "false"
+	 existing := testutil.ConfigMap("namespace", "foo", annotations)
+	 existing.Data = map[string]string{"SecureString": "old"}
+	 obj, err := FromKubernetesConfigMap(p, *existing)
+	 require.NoError(t, err)
+	 // Neither the value nor the ciphertext is written
+	 assert.Equal(t, map[string]string{"SecureString": "old"}, obj.ConfigMap.Data)
+	 assert.Equal(t, "SecureString: "+denied.Error(), obj.ConfigMap.ObjectMeta.Annotations[anno.V1DecryptError])
+
+	 // Other errors still fail the sync
+	 p.DecryptError = awserr.New("ThrottlingException", "", nil)
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+
+	 // Once decrypting succeeds, the error is removed
+	 p = &testutil.Provider{Values: map[string]string{"foo-param": "hunter2"}}
+	 obj, err = FromKubernetesConfigMap(p, obj.ConfigMap)
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecureString": "hunter2"}, obj.ConfigMap.Data)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1DecryptError)
+ }
+
+ // Run with -race
+ func TestSetConcurrent(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "foo-param", "String", "")
+	 require.NoError(t, err)
+
+	 var wg sync.WaitGroup
+	 for i := 0; i < 50; i++ {
+		 wg.Add(1)
+		 go func(i int) {
+			 defer wg.Done()
+			 assert.NoError(t, obj.Set(fmt.Sprintf("key%d", i), "value"))
+		 }(i)
+	 }
+	 wg.Wait()
+
+	 assert.Len(t, obj.ConfigMap.Data, 51)
+	 assert.Len(t, obj.ManagedKeys(), 51)
+ }
+
+ func TestKeyMap(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/db": {"x7f3a": "db.internal", "port": "5432"},
+	 }}
+	 annotations := testutil.Annotations("/app/db", "Directory")
+	 annotations[anno.V1KeyMap] = `{"/app/db/x7f3a": "host", "/app/db/unused": "unused"}`
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 // Unmapped parameters keep the default key
+	 assert.Equal(t, map[string]string{"host": "db.internal", "port": "5432"}, obj.ConfigMap.Data)
+
+	 // A mapped key collides with an unmapped one
+	 annotations[anno.V1KeyMap] = `{"/app/db/x7f3a": "port"}`
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "/app/db/port")
+	 assert.Contains(t, err.Error(), "/app/db/x7f3a")
+
+	 annotations[anno.V1KeyMap] = `{"/app/db/x7f3a": "db host"}`
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+
+	 // Nested parameters are mapped by their full names, and can share a basename
+	 p.Directories["/app"] = map[string]string{"blue/host": "blue.internal", "green/host": "green.internal"}
+	 annotations = testutil.Annotations("/app", "Directory")
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.Error(t, err)
+	 assert.Contains(t, err.Error(), "Parameters /app/blue/host and /app/green/host both produce key 'host'")
+	 annotations[anno.V1KeyMap] = `{"/app/blue/host": "blue_host"}`
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"blue_host": "blue.internal", "host": "green.internal"}, obj.ConfigMap.Data)
+ }
+
+ func TestImportKMSKeyID(t *testing.T) {
+	 keyID := "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	 p := &testutil.Provider{
+		 Values: map[string]string{"password": "hunter2", "host": "db.internal"},
+		 KeyIDs: map[string]string{"password": keyID},
+	 }
+
+	 annotations := testutil.Annotations("password", "SecureString")
+	 annotations[anno.V1ImportKMSKeyID] = "true"
+	 annotations[anno.V1ParamKey] = "alias/app"
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.Equal(t, keyID, obj.ConfigMap.ObjectMeta.Annotations[anno.V1KMSKeyID])
+
+	 // Not encrypted: a stale key ID is removed
+	 annotations = testutil.Annotations("host", "String")
+	 annotations[anno.V1ImportKMSKeyID] = "true"
+	 annotations[anno.V1KMSKeyID] = keyID
+	 obj, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1KMSKeyID)
+	 assert.Equal(t, "db.internal", obj.ConfigMap.Data["String"])
+
+	 // Best-effort: the sync still succeeds
+	 annotations = testutil.Annotations("password", "SecureString")
+	 annotations[anno.V1ImportKMSKeyID] = "true"
+	 obj, err = FromKubernetesConfigMap(struct{ provider.Provider }{p}, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1KMSKeyID)
+ }
+
+ func TestUpdateAndPatchObject(t *testing.T) {
+	 for _, patch := range []bool{false, true} {
+		 annotations := testutil.Annotations("db-creds", "SecretsManager")
+		 annotations[anno.V1LastError] = "throttled"
+		 existing := testutil.ConfigMap("namespace", "foo", annotations)
+		 existing.Data = map[string]string{
+			 "user":  "root",
+			 "other": "owned by another controller",
+		 }
+		 cli := testutil.NewKubeClient(existing)
+		 p := &testutil.Provider{Values: map[string]string{"db-creds": `{"user": "root", "password": "new"}`}}
+
+		 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 obj, err := FromKubernetesConfigMap(p, *current.DeepCopy())
+		 require.NoError(t, err)
+
+		 // Written after the controller read the ConfigMap
+		 current.Data["other"] = "rotated by another controller"
+		 current.ObjectMeta.Annotations["example.com/owner"] = "team-a"
+		 _, err = cli.CoreV1().ConfigMaps("namespace").Update(current)
+		 require.NoError(t, err)
+
+		 if patch {
+			 _, err = obj.PatchObject(cli)
+		 } else {
+			 _, err = obj.UpdateObject(cli)
+		 }
+		 require.NoError(t, err)
+		 actions := cli.Actions()
+		 action, isPatch := actions[len(actions)-1].(k8stesting.PatchAction)
+		 require.Equal(t, patch, isPatch)
+
+		 updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 assert.Equal(t, "true", updated.ObjectMeta.Labels[anno.V1ManagedLabel], "patch=%t", patch)
+		 if !patch {
+			 assert.NotContains(t, updated.ObjectMeta.Annotations, anno.V1LastError)
+			 // The other controller's writes are lost
+			 assert.Equal(t, "owned by another controller", updated.Data["other"])
+			 assert.NotContains(t, updated.ObjectMeta.Annotations, "example.com/owner")
+			 continue
+		 }
+
+		 var patched struct {
+			 Data map[string]string
+		 }
+		 require.NoError(t, json.Unmarshal(action.GetPatch(), &patched))
+		 assert.Contains(t, string(action.GetPatch()), `"aws-ssm/last-error":null`)
+		 // Every key the controller set, changed or not
+		 assert.Equal(t, map[string]string{
+			 "user":           "root",
+			 "password":       "new",
+			 "SecretsManager": `{"user": "root", "password": "new"}`,
+		 }, patched.Data)
+
+		 assert.Equal(t, map[string]string{
+			 "user":           "root",
+			 "password":       "new",
+			 "SecretsManager": `{"user": "root", "password": "new"}`,
+			 "other":          "rotated by another controller",
+		 }, updated.Data)
+		 assert.Equal(t, "team-a", updated.ObjectMeta.Annotations["example.com/owner"])
+	 }
+ }
+
+ func TestApplyObject(t *testing.T) {
+	 annotations := testutil.Annotations("/app", "Directory")
+	 annotations[anno.V1LastError] = "throttled"
+	 existing := testutil.ConfigMap("namespace", "foo", annotations)
+	 existing.Data = map[string]string{"ca.crt": "owned by another controller"}
+	 srv := testutil.NewApplyServer(existing)
+	 defer srv.Close()
+	 cli := srv.Client()
+	 p := &testutil.Provider{Directories: map[string]map[string]string{"/app": {"host": "db", "port": "5432"}}}
+
+	 read := func() *ConfigMap {
+		 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 obj, err := FromKubernetesConfigMap(p, *current)
+		 require.NoError(t, err)
+		 return obj
+	 }
+
+	 result, err := read().ApplyObject(cli, "aws-ssm-controller")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"ca.crt": "owned by another controller", "host": "db", "port": "5432"}, result.Data)
+	 assert.Equal(t, "true", result.ObjectMeta.Labels[anno.V1ManagedLabel])
+	 assert.Equal(t, "/app", result.ObjectMeta.Annotations["aws-ssm/aws-param-name"])
+	 assert.NotContains(t, result.ObjectMeta.Annotations, anno.V1LastError)
+	 assert.Contains(t, srv.Requests, "PATCH /api/v1/namespaces/namespace/configmaps/foo fieldManager=aws-ssm-controller")
+
+	 // The key of a parameter deleted from the directory is removed by the
+	 // apiserver; the other controller's key stays
+	 delete(p.Directories["/app"], "port")
+	 result, err = read().ApplyObject(cli, "aws-ssm-controller")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"ca.crt": "owned by another controller", "host": "db"}, result.Data)
+
+	 // Not if it changed since it was read
+	 obj := read()
+	 _, err = cli.CoreV1().ConfigMaps("namespace").Patch("foo", types.MergePatchType, []byte(`{"metadata":{"labels":{"team":"a"}}}`))
+	 require.NoError(t, err)
+	 _, err = obj.ApplyObject(cli, "aws-ssm-controller")
+	 assert.True(t, apierrors.IsConflict(err))
+
+	 // Kubernetes < 1.16 is patched instead
+	 srv.Unsupported = true
+	 srv.Requests = nil
+	 _, err = read().ApplyObject(cli, "aws-ssm-controller")
+	 require.NoError(t, err)
+	 assert.Equal(t, []string{
+		 "GET /api/v1/namespaces/namespace/configmaps/foo",
+		 "PATCH /api/v1/namespaces/namespace/configmaps/foo fieldManager=aws-ssm-controller",
+		 "PATCH /api/v1/namespaces/namespace/configmaps/foo",
+	 }, srv.Requests)
+ }
+
+ func TestFailOnEmptyDirectory(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/db": {"host": "10.0.1.10"},
+	 }}
+	 for _, ptype := range []string{"Directory", "DirectoryArchive"} {
+		 // Lenient by default
+		 _, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/typo", ptype, "")
+		 require.NoError(t, err, ptype)
+
+		 annotations := map[string]string{anno.V1FailOnEmptyDirectory: "true"}
+		 _, err = NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/db", ptype, "")
+		 require.NoError(t, err, ptype)
+		 _, err = NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/db,/app/typo", ptype, "")
+		 assert.EqualError(t, err, "ConfigMap namespace/foo, parameter '/app/db,/app/typo' ("+ptype+"): "+
+			 "Path '/app/typo' has no parameters (aws-ssm/fail-on-empty-directory)", ptype)
+	 }
+ }
+
+ func TestDirectoryMeta(t *testing.T) {
+	 p := &testutil.Provider{Directories: map[string]map[string]string{
+		 "/app/common": {"region": "us-east-1"},
+		 "/app/db":     {"host": "10.0.1.10"},
+	 }}
+	 tests := []struct {
+		 annotations map[string]string
+		 expected    map[string]string
+	 }{
+		 // No meta key by default
+		 {map[string]string{}, map[string]string{"region": "us-east-1", "host": "10.0.1.10"}},
+		 {map[string]string{"aws-ssm/directory-meta": "none"}, map[string]string{"region": "us-east-1", "host": "10.0.1.10"}},
+		 {map[string]string{"aws-ssm/directory-meta": "true"}, map[string]string{"region": "us-east-1", "host": "10.0.1.10", "Directory": "true"}},
+		 {map[string]string{"aws-ssm/directory-meta": "path"}, map[string]string{"region": "us-east-1", "host": "10.0.1.10", "Directory": "/app/common,/app/db"}},
+		 {
+			 map[string]string{"aws-ssm/directory-meta": "path", "aws-ssm/directory-meta-key": "ssm-path"},
+			 map[string]string{"region": "us-east-1", "host": "10.0.1.10", "ssm-path": "/app/common,/app/db"},
+		 },
+	 }
+	 for _, test := range tests {
+		 obj, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}, p, "foo", "namespace", "/app/common,/app/db", "Directory", "")
+		 require.NoError(t, err, "%v", test.annotations)
+		 assert.Equal(t, test.expected, obj.ConfigMap.Data, "%v", test.annotations)
+	 }
+
+	 annotations := map[string]string{"aws-ssm/directory-meta": "true", "aws-ssm/directory-meta-key": "host"}
+	 _, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/db", "Directory", "")
+	 assert.EqualError(t, err, "ConfigMap namespace/foo, parameter '/app/db' (Directory): "+
+		 "The aws-ssm/directory-meta key 'host' is also a parameter's key; rename it with aws-ssm/directory-meta-key")
+
+	 // The meta key from the last sync isn't a collision
+	 annotations = map[string]string{"aws-ssm/directory-meta": "path"}
+	 obj, err := NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/db", "Directory", "")
+	 require.NoError(t, err)
+	 obj, err = NewConfigMap(obj.ConfigMap, p, "foo", "namespace", "/app/db", "Directory", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"host": "10.0.1.10", "Directory": "/app/db"}, obj.ConfigMap.Data)
+ }
+
+ func TestApplyObjectConflicts(t *testing.T) {
+	 srv := testutil.NewApplyServer(testutil.ConfigMap("namespace", "foo", testutil.Annotations("/app", "Directory")))
+	 defer srv.Close()
+	 cli := srv.Client()
+	 p := &testutil.Provider{Directories: map[string]map[string]string{"/app": {}}}
+
+	 apply := func(manager string, host string, force bool) (*v1.ConfigMap, error) {
+		 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		 require.NoError(t, err)
+		 if force {
+			 current.ObjectMeta.Annotations[anno.V1ForceApply] = "true"
+		 }
+		 p.Directories["/app"]["host"] = host
+		 obj, err := FromKubernetesConfigMap(p, *current)
+		 require.NoError(t, err)
+		 return obj.ApplyObject(cli, manager)
+	 }
+
+	 // Another controller applies the key first; applying the same value shares it
+	 _, err := apply("other-controller", "other", false)
+	 require.NoError(t, err)
+	 _, err = apply("aws-ssm-controller", "other", false)
+	 require.NoError(t, err)
+
+	 // Changing it conflicts by default, and nothing is written
+	 _, err = apply("aws-ssm-controller", "db", false)
+	 assert.EqualError(t, err, "Keys or annotations are owned by another field manager; set aws-ssm/force-apply to take them over: "+
+		 "Apply failed with 1 conflicts: conflict with \"other-controller\": .data.host")
+	 assert.Contains(t, srv.Requests, "PATCH /api/v1/namespaces/namespace/configmaps/foo fieldManager=aws-ssm-controller")
+	 current, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	 require.NoError(t, err)
+	 assert.Equal(t, "other", current.Data["host"])
+
+	 // aws-ssm/force-apply takes it over
+	 result, err := apply("aws-ssm-controller", "db", true)
+	 require.NoError(t, err)
+	 assert.Equal(t, "db", result.Data["host"])
+	 assert.Contains(t, srv.Requests, "PATCH /api/v1/namespaces/namespace/configmaps/foo fieldManager=aws-ssm-controller&force=true")
+
+	 // Then the other controller is the one that conflicts
+	 _, err = apply("other-controller", "other", false)
+	 assert.Contains(t, err.Error(), `conflict with "aws-ssm-controller": .data.host`)
+ }
+
+ func TestFromKubernetesConfigMapWithBasePath(t *testing.T) {
+	 p := &testutil.Provider{Values: map[string]string{
+		 "/app/prod/db-host": "app",
+		 "/shared/db-host":   "shared",
+		 "db-creds":          `{"password":"hunter2"}`,
+	 }}
+	 for name, expected := range map[string]string{"db-host": "/app/prod/db-host", "/shared/db-host": "/shared/db-host"} {
+		 obj, err := FromKubernetesConfigMapWithBasePath(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations(name, "String")), "/app/prod")
+		 require.NoError(t, err, name)
+		 assert.Equal(t, expected, obj.ParamName, name)
+	 }
+
+	 // Not Secrets Manager names
+	 obj, err := FromKubernetesConfigMapWithBasePath(p, *testutil.ConfigMap("namespace", "foo", testutil.Annotations("db-creds", "SecretsManager")), "/app/prod")
+	 require.NoError(t, err)
+	 assert.Equal(t, "db-creds", obj.ParamName)
+ }
+
+ func TestNormalizeNewlines(t *testing.T) {
+	 p := &testutil.Provider{
+		 Values: map[string]string{"/app/cert": "line1\r\nline2\r\n"},
+		 Directories: map[string]map[string]string{
+			 "/app/db": {"config": "host=10.0.1.10\r\nport=5432"},
+		 },
+	 }
+
+	 // Left alone by default
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/cert", "SecureString", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecureString": "line1\r\nline2\r\n"}, obj.ConfigMap.Data)
+
+	 annotations := map[string]string{anno.V1NormalizeNewlines: "true"}
+	 obj, err = NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/cert", "SecureString", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecureString": "line1\nline2\n"}, obj.ConfigMap.Data)
+
+	 obj, err = NewConfigMap(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}, p, "foo", "namespace", "/app/db", "Directory", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"config": "host=10.0.1.10\nport=5432"}, obj.ConfigMap.Data)
+ }
+
+ func TestTransformers(t *testing.T) {
+	 names := []string{}
+	 exclaim := transform.Func(func(ctx context.Context, paramName string, value string) (string, error) {
+		 names = append(names, paramName)
+		 return value + "!", nil
+	 })
+	 p := transform.WithTransformers(&testutil.Provider{
+		 Values:      map[string]string{"/app/host": "db.internal", "db-creds": "s3cret"},
+		 Directories: map[string]map[string]string{"/app": {"db/user": "root"}},
+	 }, exclaim)
+
+	 obj, err := NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app/host", "String", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"String": "db.internal!"}, obj.ConfigMap.Data)
+
+	 // By the full names of nested parameters
+	 obj, err = NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "/app", "Directory", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"user": "root!"}, obj.ConfigMap.Data)
+
+	 obj, err = NewConfigMap(v1.ConfigMap{}, p, "foo", "namespace", "db-creds", "SecretsManager", "")
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"SecretsManager": "s3cret!"}, obj.ConfigMap.Data)
+
+	 assert.Equal(t, []string{"/app/host", "/app/db/user", "db-creds"}, names)
+ }
+
+ func TestDirectoryFetchEach(t *testing.T) {
+	 denied := awserr.New("AccessDeniedException", "User is not authorized to perform: kms:Decrypt", nil)
+	 p := &testutil.Provider{
+		 // Only the names are listed from Directories
+		 Directories: map[string]map[string]string{
+			 "/app/db": {"host": "", "password": "", "token": ""},
+		 },
+		 Values:       map[string]string{"/app/db/host": "10.0.1.10", "/app/db/password": "hunter2"},
+		 Encrypted:    map[string]string{"/app/db/token": "AQICAHh..."},
+		 DecryptError: denied,
+	 }
+	 annotations := testutil.Annotations("/app/db", "Directory")
+	 annotations[anno.V1ParamKey] = "alias/aws/ssm"
+	 annotations[anno.V1DirectoryFetch] = anno.DirectoryFetchEach
+
+	 // Fatal by default
+	 _, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.True(t, errors.Is(err, denied))
+	 assert.Contains(t, err.Error(), "Failed to read /app/db/token")
+
+	 // The permitted parameters still land
+	 annotations[anno.V1DecryptFailureFatal] = "false"
+	 hook := logtest.NewGlobal()
+	 defer hook.Reset()
+	 obj, err := FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 require.NoError(t, err)
+	 require.NotNil(t, hook.LastEntry())
+	 assert.Equal(t, "/app/db/token", hook.LastEntry().Data["paramName"])
+	 assert.Equal(t, "foo", hook.LastEntry().Data["name"])
+	 assert.Equal(t, map[string]string{"host": "10.0.1.10", "password": "hunter2"}, obj.ConfigMap.Data)
+	 assert.Equal(t, "/app/db/token: "+denied.Error(), obj.ConfigMap.ObjectMeta.Annotations[anno.V1DecryptError])
+	 assert.Contains(t, p.Requested, "list:/app/db")
+	 assert.NotContains(t, p.Requested, "/app/db")
+
+	 // Other errors still fail the sync
+	 p.DecryptError = awserr.New("ThrottlingException", "", nil)
+	 _, err = FromKubernetesConfigMap(p, *testutil.ConfigMap("namespace", "foo", annotations))
+	 assert.Error(t, err)
+
+	 // Once decrypting succeeds, the error is removed
+	 p.Values["/app/db/token"] = "s3cret"
+	 delete(p.Encrypted, "/app/db/token")
+	 obj, err = FromKubernetesConfigMap(p, obj.ConfigMap)
+	 require.NoError(t, err)
+	 assert.Equal(t, map[string]string{"host": "10.0.1.10", "password": "hunter2", "token": "s3cret"}, obj.ConfigMap.Data)
+	 assert.NotContains(t, obj.ConfigMap.ObjectMeta.Annotations, anno.V1DecryptError)
+ }
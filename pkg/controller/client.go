@@ -16,8 +16,10 @@
 package controller
 
 import (
+	"github.com/cmattoon/aws-ssm/pkg/config"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"sync"
 )
@@ -32,7 +34,9 @@ type ClientGenerator interface {
 type SingletonClientGenerator struct {
 	KubeConfig string
 	KubeMaster string
-	client     kubernetes.Interface
+	// Identifies the controller's writes (see NewKubeClient)
+	FieldManager string
+	client       kubernetes.Interface
 	sync.Once
 }
 
@@ -40,23 +44,38 @@ type SingletonClientGenerator struct {
 func (p *SingletonClientGenerator) KubeClient() (kubernetes.Interface, error) {
 	var err error
 	p.Once.Do(func() {
-		p.client, err = NewKubeClient(p.KubeConfig, p.KubeMaster)
+		p.client, err = NewKubeClient(p.KubeConfig, p.KubeMaster, p.FieldManager)
 	})
 	return p.client, err
 }
 
 // will fallback to restclient.InClusterConfig() if both kubeconfig/master_url == ""
-func NewKubeClient(kubeconfig string, master_url string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags(master_url, kubeconfig)
+func NewKubeClient(kubeconfig string, master_url string, fieldManager string) (*kubernetes.Clientset, error) {
+	restConfig, err := kubeRestConfig(kubeconfig, master_url, fieldManager)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := kubernetes.NewForConfig(config)
+	client, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Infof("Connected to cluster at %s", config.Host)
+	log.Infof("Connected to cluster at %s", restConfig.Host)
 	return client, nil
 }
+
+// kubeRestConfig builds the client config, with fieldManager as the User-Agent.
+// This client-go predates the fieldManager option of writes, so the apiserver
+// names the manager of the fields we write (metadata.managedFields) after the
+// User-Agent instead: "<fieldManager>/<version>" is recorded as fieldManager.
+func kubeRestConfig(kubeconfig string, master_url string, fieldManager string) (*rest.Config, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags(master_url, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if fieldManager != "" {
+		restConfig.UserAgent = fieldManager + "/" + config.Version
+	}
+	return restConfig, nil
+}
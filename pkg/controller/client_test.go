@@ -17,21 +17,40 @@ package controller
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	k8s "k8s.io/client-go/tools/clientcmd"
 )
 
 func TestNewKubeClientFailsOnBadFile(t *testing.T) {
-	_, err := NewKubeClient("kube-config", "master-url")
+	_, err := NewKubeClient("kube-config", "master-url", "")
 	if !(err != nil && err.Error() == "stat kube-config: no such file or directory") {
 		t.Fail()
 	}
 }
 
 func TestNewKubeClientReturnsInClusterConfig(t *testing.T) {
-	_, err := NewKubeClient("", "")
+	_, err := NewKubeClient("", "", "")
 	if err.Error() != fmt.Sprintf("invalid configuration: %s", k8s.ErrEmptyConfig.Error()) {
 		t.Fail()
 	}
 }
+
+func TestKubeRestConfigSetsFieldManager(t *testing.T) {
+	restConfig, err := kubeRestConfig("", "https://kubernetes.example.com", "aws-ssm-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(restConfig.UserAgent, "aws-ssm-controller/") {
+		t.Errorf("User-Agent %q doesn't name the field manager", restConfig.UserAgent)
+	}
+
+	restConfig, err = kubeRestConfig("", "https://kubernetes.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restConfig.UserAgent != "" {
+		t.Errorf("User-Agent %q should be client-go's default", restConfig.UserAgent)
+	}
+}
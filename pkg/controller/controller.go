@@ -16,13 +16,23 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
 	"github.com/cmattoon/aws-ssm/pkg/config"
-	"github.com/tdmalone/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
 	"github.com/cmattoon/aws-ssm/pkg/provider"
-	"github.com/cmattoon/aws-ssm/pkg/secret"
+	"github.com/cmattoon/aws-ssm/pkg/transform"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -31,100 +41,677 @@ type Controller struct {
 	Interval time.Duration
 	Provider provider.Provider
 	KubeGen  ClientGenerator
+	// Warn when an object's data exceeds this many bytes
+	SizeWarningBytes int
+	// Fail Directory imports of more parameters than this (see checkDirectoryKeys); 0 is unlimited
+	MaxDirectoryKeys int
+	// Largest value of a single key (see limitValues); 0 is unlimited
+	MaxValueBytes int
+	// What to do with larger values (config.MaxValue*); "" fails the sync
+	MaxValuePolicy string
+	// Longest to wait for an object's parameters (see readConfigMap); 0 waits forever
+	ReconcileTimeout time.Duration
+	// How to handle objects managed by another tool (config.ManagedBy*)
+	ManagedByPolicy string
+	// Parameter -> referencing objects, rebuilt by each full sync
+	Index *Index
+	// Creates the provider for objects with their own region or role (see
+	// providerFor); nil uses Provider for every object
+	ProviderFor func(region string, roleARN string) (provider.Provider, error)
+	// Renders the role to assume for an aws-ssm/account-id: {{.AccountID}}
+	AssumeRoleTemplate *template.Template
+	// Don't record warnings for SecureStrings decrypted with the default KMS key
+	NoDefaultKeyWarning bool
+	// Write the data of each object to this directory before it's updated, for
+	// debugging ("" doesn't); Secret values are redacted
+	DumpDir string
+	// Re-sync ConfigMaps as soon as the keys the controller set are edited (see WatchEdits)
+	ResyncOnEdit bool
+	// What to do with ConfigMaps with SecureStrings (config.ForceSecret*; see forceToSecret)
+	ForceSecureStringToSecret string
+	// Every parameter is read decrypted (-always-decrypt), so
+	// ForceSecureStringToSecret applies to every ConfigMap
+	AlwaysDecrypt bool
+	// How objects are written (config.UpdateStrategy*); "" updates them
+	UpdateStrategy string
+	// The field manager of server-side applies (config.UpdateStrategyApply)
+	FieldManager string
+	// The path relative parameter names are read under (-base-path), unless
+	// their namespace has an aws-ssm/default-base-path
+	BasePath string
+	// The AWS credentials of -credentials-secret, watched while running; nil
+	// if the default credentials are used
+	Credentials *SecretCredentials
+	// "namespace/name" of the ConfigMap the Status is written to after each
+	// full sync (see writeStatus); "" doesn't
+	StatusConfigMap string
+
+	mu sync.Mutex
+	// By region and role
+	providers map[string]provider.Provider
+	// Objects already warned about the default KMS key, by kind/namespace/name
+	warnedDefaultKey map[string]bool
+	// Namespaces whose objects were skipped because the namespace is gone
+	goneNamespaces map[string]bool
+	// What was last written to each ConfigMap (with ResyncOnEdit)
+	synced map[ResourceKey]syncedObject
+	// Objects whose parameters timed out, until they're retried
+	timeouts map[ResourceKey]timeoutBackoff
+	// How many keys the controller last wrote to each object it syncs (see
+	// observeManaged)
+	managedKeys map[ResourceKey]int
+	// The last successful sync and error count of each object (see status)
+	statusHistory map[ResourceKey]resourceHistory
+	// The parameter name last read for each object with param-name-from
+	namesFrom map[ResourceKey]string
+	// 1 once the initial full sync is complete (see markReady)
+	ready int32
 }
 
-func NewController(cfg *config.Config) *Controller {
-	p, err := provider.NewProvider(cfg)
+// newProvider returns the provider of chain for cfg (see provider.Chain), with its
+// transforms (see transform.WithTransformers)
+func newProvider(chain *provider.Chain, cfg *config.Config, scope string) (provider.Provider, error) {
+	p, err := chain.Provider(cfg, scope)
 	if err != nil {
-		log.Fatalf("Failed to create provider: %s", err)
+		return nil, err
 	}
+	if len(cfg.Transforms) > 0 {
+		chain, err := transform.Parse(cfg.Transforms)
+		if err != nil {
+			return nil, err
+		}
+		p = transform.WithTransformers(p, chain...)
+	}
+	return p, nil
+}
 
+func NewController(cfg *config.Config) *Controller {
 	scg := &SingletonClientGenerator{
-		KubeConfig: cfg.KubeConfig,
-		KubeMaster: cfg.KubeMaster,
+		KubeConfig:   cfg.KubeConfig,
+		KubeMaster:   cfg.KubeMaster,
+		FieldManager: cfg.FieldManager,
+	}
+
+	var creds *SecretCredentials
+	if cfg.CredentialsSecret != "" {
+		cli, err := scg.KubeClient()
+		if err != nil {
+			log.Fatalf("Error with kubernetes client: %s", err)
+		}
+		creds, err = NewSecretCredentials(cli, cfg.CredentialsSecret)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		// Before the first provider is built
+		provider.UseCredentials(credentials.NewCredentials(creds))
+	}
+
+	// The providers of every region and role share one budget, cache and coalescer
+	chain := provider.NewChain(cfg)
+	p, err := newProvider(chain, cfg, "")
+	if err != nil {
+		log.Fatalf("Failed to create provider: %s", err)
+	}
+	roleTemplate, err := cfg.RoleTemplate()
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
 
 	ctrl := &Controller{
-		Interval: time.Duration(cfg.Interval) * time.Second,
-		Provider: p,
-		KubeGen:  scg,
+		Interval:         time.Duration(cfg.Interval) * time.Second,
+		Provider:         p,
+		KubeGen:          scg,
+		SizeWarningBytes: cfg.SizeWarningBytes,
+		MaxDirectoryKeys: cfg.MaxDirectoryKeys,
+		MaxValueBytes:    cfg.MaxValueBytes,
+		MaxValuePolicy:   cfg.MaxValuePolicy,
+		ReconcileTimeout: time.Duration(cfg.ReconcileTimeout) * time.Second,
+		ManagedByPolicy:  cfg.ManagedByPolicy,
+		Index:            NewIndex(),
+		ProviderFor: func(region string, roleARN string) (provider.Provider, error) {
+			objCfg := *cfg
+			if region != "" {
+				objCfg.AWSRegion = region
+				objCfg.ReadRegions = nil
+			}
+			if roleARN != "" {
+				objCfg.RoleARN = roleARN
+			}
+			return newProvider(chain, &objCfg, region+"|"+roleARN)
+		},
+		NoDefaultKeyWarning:       cfg.NoDefaultKeyWarning,
+		DumpDir:                   cfg.DumpDir,
+		ResyncOnEdit:              cfg.ResyncOnEdit,
+		ForceSecureStringToSecret: cfg.ForceSecureStringToSecret,
+		AlwaysDecrypt:             cfg.AlwaysDecrypt,
+		UpdateStrategy:            cfg.UpdateStrategy,
+		FieldManager:              cfg.FieldManager,
+		AssumeRoleTemplate:        roleTemplate,
+		Credentials:               creds,
+		BasePath:                  cfg.BasePath,
+		StatusConfigMap:           cfg.StatusConfigMap,
 	}
 
 	return ctrl
 }
 
 func (c *Controller) HandleConfigMaps(cli kubernetes.Interface) error {
+	return c.handleConfigMaps(cli, nil)
+}
+
+// handleConfigMaps lists and syncs every ConfigMap, recording results in summary (if not nil)
+func (c *Controller) handleConfigMaps(cli kubernetes.Interface, summary *Summary) error {
 	configmaps, err := cli.CoreV1().ConfigMaps("").List(metav1.ListOptions{})
 	if err != nil {
 		log.Fatalf("Error retrieving configmaps: %s", err)
 	}
+	c.indexConfigMaps(configmaps.Items)
+	return c.syncConfigMaps(cli, configmaps.Items, summary)
+}
 
+// syncConfigMaps updates each relevant ConfigMap in items, recording results in summary (if not nil)
+func (c *Controller) syncConfigMaps(cli kubernetes.Interface, items []v1.ConfigMap, summary *Summary) (err error) {
 	i, j, k := 0, 0, 0
-	for _, sec := range configmaps.Items {
+	defaults := newNamespaceDefaults(cli)
+	for _, sec := range items {
+		if c.isStatusConfigMap(sec.ObjectMeta) {
+			// Written by the controller (-status-configmap); never synced
+			continue
+		}
 		i += 1
 
-		obj, err := configmap.FromKubernetesConfigMap(c.Provider, sec)
+		if !enabled(sec.ObjectMeta) {
+			// Still indexed, so it's synced as soon as it's enabled again
+			log.Infof("Not syncing %s/%s: %s is false", sec.Namespace, sec.Name, anno.V1Enabled)
+			summary.skip()
+			continue
+		}
+
+		tool := managedBy(sec.ObjectMeta)
+		original := map[string]string{}
+		if tool != "" {
+			// Set writes into the object's (shared) data, so keep the original values
+			for k, v := range sec.Data {
+				original[k] = v
+			}
+		}
+
+		listed := sec.ObjectMeta.Annotations
+		err := c.resolveParamName(cli, "ConfigMap", &sec.ObjectMeta)
+		var p provider.Provider
+		if err == nil {
+			p, err = c.providerFor(sec.ObjectMeta, defaults)
+		}
+		basePath := ""
+		if err == nil {
+			basePath, err = c.basePathFor(sec.ObjectMeta, defaults)
+		}
 		if err != nil {
-			// Error: Irrelevant ConfigMap
+			j += 1
+			log.Warnf("Failed to sync %s/%s: %s", sec.Namespace, sec.Name, err)
+			setConfigMapError(cli, sec, err)
+			summary.add("ConfigMap", sec.Namespace, sec.Name, err)
 			continue
 		}
+
+		if forced, err := c.forceToSecret(cli, p, sec, basePath, listed); forced {
+			j += 1
+			if err != nil {
+				log.Warnf("Failed to sync %s/%s: %s", sec.Namespace, sec.Name, err)
+				setConfigMapError(cli, sec, err)
+				summary.add("ConfigMap", sec.Namespace, sec.Name, err)
+				continue
+			}
+			log.Infof("Synced the SecureString of %s/%s to its companion Secret", sec.Namespace, sec.Name)
+			summary.add("ConfigMap", sec.Namespace, sec.Name, nil)
+			k += 1
+			continue
+		}
+
+		obj, err := c.readConfigMap(p, sec, basePath)
+		if err == nil {
+			err = c.checkDirectoryKeys(obj.ParamType, sec.ObjectMeta.Annotations, len(obj.SourceParams()))
+		}
+		if err == nil {
+			err = c.limitConfigMapValues(obj)
+		}
+		if err != nil {
+			if err.Error() == "Irrelevant ConfigMap" {
+				c.forgetManaged(ResourceKey{Kind: "ConfigMap", Namespace: sec.Namespace, Name: sec.Name})
+				summary.skip()
+				continue
+			}
+			if errors.As(err, new(*provider.VersionNotReadyError)) {
+				// Not an error: retried on the next sync
+				log.Infof("Not syncing %s/%s yet: %s", sec.Namespace, sec.Name, err)
+				summary.pending("ConfigMap", sec.Namespace, sec.Name, err)
+				continue
+			}
+			if errors.As(err, new(*TimeoutError)) {
+				// Nothing was written; retried once the backoff is over
+				log.Warnf("Not syncing %s/%s: %s", sec.Namespace, sec.Name, err)
+				summary.pending("ConfigMap", sec.Namespace, sec.Name, err)
+				continue
+			}
+			j += 1
+			log.Warnf("Failed to sync %s/%s: %s", sec.Namespace, sec.Name, err)
+			setConfigMapError(cli, sec, err)
+			summary.add("ConfigMap", sec.Namespace, sec.Name, err)
+			continue
+		}
+
+		if tool != "" {
+			log.Warnf("%s/%s is managed by %s (-managed-by-policy=%s)", sec.Namespace, sec.Name, tool, c.ManagedByPolicy)
+			if c.ManagedByPolicy == config.ManagedBySkip {
+				c.forgetManaged(ResourceKey{Kind: "ConfigMap", Namespace: sec.Namespace, Name: sec.Name})
+				summary.skip()
+				continue
+			}
+			if c.ManagedByPolicy == config.ManagedByMerge {
+				if keys := obj.PreserveKeys(original); len(keys) > 0 {
+					log.Warnf("Kept the %s values of %s/%s keys: %s", tool, sec.Namespace, sec.Name, strings.Join(keys, ", "))
+				}
+			}
+		}
 		j += 1
 
-		_, err = obj.UpdateObject(cli)
+		c.checkSize(obj.Namespace, obj.Name, obj.Size())
+		c.dump("ConfigMap", obj.Namespace, obj.Name, obj.ConfigMap.Data)
+		restoreParamName(obj.ConfigMap.ObjectMeta.Annotations, listed)
+		_, err = c.writeConfigMap(cli, obj)
+		if err != nil && namespaceGone(err) {
+			c.dropObject("ConfigMap", sec.Namespace, sec.Name, err)
+			c.forgetManaged(ResourceKey{Kind: "ConfigMap", Namespace: sec.Namespace, Name: sec.Name})
+			j -= 1
+			summary.skip()
+			continue
+		}
 		if err != nil {
 			log.Warnf("Failed to update object %s/%s", obj.Namespace, obj.Name)
 			log.Warn(err.Error())
+			setConfigMapError(cli, sec, err)
+			summary.add("ConfigMap", sec.Namespace, sec.Name, err)
 			continue
 		}
 		log.Infof("Successfully updated %s/%s", obj.Namespace, obj.Name)
+		if c.ResyncOnEdit {
+			c.recordSynced(ResourceKey{Kind: "ConfigMap", Namespace: obj.Namespace, Name: obj.Name}, obj.ManagedKeys(), obj.Checksum())
+		}
+		if obj.DefaultKey {
+			c.warnDefaultKey(cli, "ConfigMap", sec.ObjectMeta)
+		}
+		summary.add("ConfigMap", sec.Namespace, sec.Name, nil)
+		c.recordManaged(ResourceKey{Kind: "ConfigMap", Namespace: obj.Namespace, Name: obj.Name}, len(obj.ManagedKeys()))
 		k += 1
+		c.mirrorConfigMap(cli, obj, defaults, summary)
 	}
 
+	metrics.ObserveSync("ConfigMap", k, j-k)
+	c.observeManaged("ConfigMap")
 	log.Infof("Updated %v/%v configmaps (of %v total configmaps)", k, j, i)
 	return err
 }
 
 func (c *Controller) HandleSecrets(cli kubernetes.Interface) error {
+	return c.handleSecrets(cli, nil)
+}
+
+// handleSecrets lists and syncs every Secret, recording results in summary (if not nil)
+func (c *Controller) handleSecrets(cli kubernetes.Interface, summary *Summary) error {
 	secrets, err := cli.CoreV1().Secrets("").List(metav1.ListOptions{})
 	if err != nil {
 		log.Fatalf("Error retrieving secrets: %s", err)
 	}
+	c.indexSecrets(secrets.Items)
+	return c.syncSecrets(cli, secrets.Items, summary)
+}
 
+// syncSecrets updates each relevant Secret in items, recording results in summary (if not nil)
+func (c *Controller) syncSecrets(cli kubernetes.Interface, items []v1.Secret, summary *Summary) (err error) {
 	i, j, k := 0, 0, 0
-	for _, sec := range secrets.Items {
+	defaults := newNamespaceDefaults(cli)
+	for _, sec := range items {
 		i += 1
 
-		obj, err := secret.FromKubernetesSecret(c.Provider, sec)
+		if !enabled(sec.ObjectMeta) {
+			// Still indexed, so it's synced as soon as it's enabled again
+			log.Infof("Not syncing %s/%s: %s is false", sec.Namespace, sec.Name, anno.V1Enabled)
+			summary.skip()
+			continue
+		}
+
+		tool := managedBy(sec.ObjectMeta)
+		original := map[string][]byte{}
+		if tool != "" {
+			// Set writes into the object's (shared) data, so keep the original values
+			for k, v := range sec.Data {
+				original[k] = v
+			}
+		}
+
+		listed := sec.ObjectMeta.Annotations
+		err := c.resolveParamName(cli, "Secret", &sec.ObjectMeta)
+		var p provider.Provider
+		if err == nil {
+			p, err = c.providerFor(sec.ObjectMeta, defaults)
+		}
+		basePath := ""
+		if err == nil {
+			basePath, err = c.basePathFor(sec.ObjectMeta, defaults)
+		}
+		if err != nil {
+			j += 1
+			log.Warnf("Failed to sync %s/%s: %s", sec.Namespace, sec.Name, err)
+			setSecretError(cli, sec, err)
+			summary.add("Secret", sec.Namespace, sec.Name, err)
+			continue
+		}
+
+		obj, err := c.readSecret(p, sec, basePath)
+		if err == nil {
+			err = c.checkDirectoryKeys(obj.ParamType, sec.ObjectMeta.Annotations, len(obj.SourceParams()))
+		}
+		if err == nil {
+			err = c.limitSecretValues(obj)
+		}
 		if err != nil {
-			// Error: Irrelevant Secret
+			if err.Error() == "Irrelevant Secret" {
+				c.forgetManaged(ResourceKey{Kind: "Secret", Namespace: sec.Namespace, Name: sec.Name})
+				summary.skip()
+				continue
+			}
+			if errors.As(err, new(*provider.VersionNotReadyError)) {
+				// Not an error: retried on the next sync
+				log.Infof("Not syncing %s/%s yet: %s", sec.Namespace, sec.Name, err)
+				summary.pending("Secret", sec.Namespace, sec.Name, err)
+				continue
+			}
+			if errors.As(err, new(*TimeoutError)) {
+				// Nothing was written; retried once the backoff is over
+				log.Warnf("Not syncing %s/%s: %s", sec.Namespace, sec.Name, err)
+				summary.pending("Secret", sec.Namespace, sec.Name, err)
+				continue
+			}
+			j += 1
+			log.Warnf("Failed to sync %s/%s: %s", sec.Namespace, sec.Name, err)
+			setSecretError(cli, sec, err)
+			summary.add("Secret", sec.Namespace, sec.Name, err)
 			continue
 		}
+
+		if tool != "" {
+			log.Warnf("%s/%s is managed by %s (-managed-by-policy=%s)", sec.Namespace, sec.Name, tool, c.ManagedByPolicy)
+			if c.ManagedByPolicy == config.ManagedBySkip {
+				c.forgetManaged(ResourceKey{Kind: "Secret", Namespace: sec.Namespace, Name: sec.Name})
+				summary.skip()
+				continue
+			}
+			if c.ManagedByPolicy == config.ManagedByMerge {
+				if keys := obj.PreserveKeys(original); len(keys) > 0 {
+					log.Warnf("Kept the %s values of %s/%s keys: %s", tool, sec.Namespace, sec.Name, strings.Join(keys, ", "))
+				}
+			}
+		}
 		j += 1
 
-		_, err = obj.UpdateObject(cli)
+		c.checkSize(obj.Namespace, obj.Name, obj.Size())
+		if c.DumpDir != "" {
+			data := make(map[string]string)
+			for k, v := range obj.Secret.Data {
+				data[k] = string(v)
+			}
+			for k, v := range obj.Secret.StringData {
+				data[k] = v
+			}
+			c.dump("Secret", obj.Namespace, obj.Name, data)
+		}
+		restoreParamName(obj.Secret.ObjectMeta.Annotations, listed)
+		_, err = c.writeSecret(cli, obj)
+		if err != nil && namespaceGone(err) {
+			c.dropObject("Secret", sec.Namespace, sec.Name, err)
+			c.forgetManaged(ResourceKey{Kind: "Secret", Namespace: sec.Namespace, Name: sec.Name})
+			j -= 1
+			summary.skip()
+			continue
+		}
 		if err != nil {
 			log.Warnf("Failed to update object %s/%s", obj.Namespace, obj.Name)
 			log.Warn(err.Error())
+			setSecretError(cli, sec, err)
+			summary.add("Secret", sec.Namespace, sec.Name, err)
 			continue
 		}
 		log.Infof("Successfully updated %s/%s", obj.Namespace, obj.Name)
+		if obj.DefaultKey {
+			c.warnDefaultKey(cli, "Secret", sec.ObjectMeta)
+		}
+		summary.add("Secret", sec.Namespace, sec.Name, nil)
+		c.recordManaged(ResourceKey{Kind: "Secret", Namespace: obj.Namespace, Name: obj.Name}, len(obj.ManagedKeys()))
 		k += 1
+		c.mirrorSecret(cli, obj, defaults, summary)
 	}
 
+	metrics.ObserveSync("Secret", k, j-k)
+	c.observeManaged("Secret")
 	log.Infof("Updated %v/%v secrets (of %v total secrets)", k, j, i)
 	return err
 }
 
+// indexConfigMaps rebuilds the ConfigMaps in the index from a full list
+func (c *Controller) indexConfigMaps(items []v1.ConfigMap) {
+	objects := make(map[ResourceKey]map[string]string)
+	for _, item := range items {
+		objects[ResourceKey{Kind: "ConfigMap", Namespace: item.Namespace, Name: item.Name}] = item.ObjectMeta.Annotations
+	}
+	c.Index.Replace("ConfigMap", objects)
+	c.retainManaged("ConfigMap", objects)
+}
+
+// indexSecrets rebuilds the Secrets in the index from a full list
+func (c *Controller) indexSecrets(items []v1.Secret) {
+	objects := make(map[ResourceKey]map[string]string)
+	for _, item := range items {
+		objects[ResourceKey{Kind: "Secret", Namespace: item.Namespace, Name: item.Name}] = item.ObjectMeta.Annotations
+	}
+	c.Index.Replace("Secret", objects)
+	c.retainManaged("Secret", objects)
+}
+
+// checkSize warns when an object's data is approaching the apiserver's size limit.
+// Returns true if a warning was logged.
+func (c *Controller) checkSize(namespace string, name string, size int) bool {
+	if c.SizeWarningBytes <= 0 || size <= c.SizeWarningBytes {
+		return false
+	}
+	log.Warnf("%s/%s is %d bytes, approaching the limit of %d bytes", namespace, name, size, v1.MaxSecretSize)
+	return true
+}
+
+// checkDirectoryKeys returns an error if a Directory/DirectoryArchive imported
+// n parameters, more than MaxDirectoryKeys, so it isn't written. The
+// max-directory-keys annotation overrides the limit for the object.
+func (c *Controller) checkDirectoryKeys(paramType string, annotations map[string]string, n int) error {
+	if paramType != "Directory" && paramType != "DirectoryArchive" {
+		return nil
+	}
+	max := c.MaxDirectoryKeys
+	if v, ok := annotations[anno.V1MaxDirectoryKeys]; ok {
+		m, err := strconv.Atoi(v)
+		if err != nil || m < 0 {
+			return fmt.Errorf("Invalid %s '%s' (a number of keys; 0 is unlimited)", anno.V1MaxDirectoryKeys, v)
+		}
+		max = m
+	}
+	if max > 0 && n > max {
+		return fmt.Errorf("%s has %d parameters, more than the limit of %d; raise it with the %s annotation if that's intended", paramType, n, max, anno.V1MaxDirectoryKeys)
+	}
+	return nil
+}
+
 func (c *Controller) RunOnce() (error, error) {
 	log.Info("Running...")
 	cli, err := c.KubeGen.KubeClient()
 	if err != nil {
 		log.Fatalf("Error with kubernetes client: %s", err)
 	}
-	return c.HandleConfigMaps(cli), c.HandleSecrets(cli)
+	summary := c.newStatusSummary()
+	errConfigMaps, errSecrets := c.handleConfigMaps(cli, summary), c.handleSecrets(cli, summary)
+	c.writeStatus(cli, summary)
+	return errConfigMaps, errSecrets
+}
+
+// Sync syncs all objects once and summarizes the results. Unlike RunOnce,
+// errors connecting to the apiserver are returned instead of being fatal.
+func (c *Controller) Sync() (*Summary, error) {
+	cli, err := c.KubeGen.KubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("Error with kubernetes client: %s", err)
+	}
+	configmaps, err := cli.CoreV1().ConfigMaps("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving configmaps: %s", err)
+	}
+	secrets, err := cli.CoreV1().Secrets("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving secrets: %s", err)
+	}
+
+	c.indexConfigMaps(configmaps.Items)
+	c.indexSecrets(secrets.Items)
+
+	summary := &Summary{Resources: []ResourceStatus{}}
+	c.syncConfigMaps(cli, configmaps.Items, summary)
+	c.syncSecrets(cli, secrets.Items, summary)
+	if c.StatusConfigMap != "" {
+		c.writeStatus(cli, summary)
+	}
+	return summary, nil
+}
+
+// SyncParameters syncs only the objects that reference the named parameters
+// (e.g., on a Parameter Store change event)
+func (c *Controller) SyncParameters(names []string) {
+	cli, err := c.KubeGen.KubeClient()
+	if err != nil {
+		log.Errorf("Error with kubernetes client: %s", err)
+		return
+	}
+
+	cms, secs, err := c.referencing(cli, names)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	log.Infof("Parameters changed: %s. Syncing %d configmaps and %d secrets", strings.Join(names, ", "), len(cms), len(secs))
+	c.syncConfigMaps(cli, cms, nil)
+	c.syncSecrets(cli, secs, nil)
+}
+
+// referencing returns the objects that reference any of the named parameters. The
+// index is used once a full sync has built it; until then, every object is listed.
+func (c *Controller) referencing(cli kubernetes.Interface, names []string) ([]v1.ConfigMap, []v1.Secret, error) {
+	cms := []v1.ConfigMap{}
+	secs := []v1.Secret{}
+
+	if !c.Index.Ready("ConfigMap") || !c.Index.Ready("Secret") {
+		configmaps, err := cli.CoreV1().ConfigMaps("").List(metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error retrieving configmaps: %s", err)
+		}
+		for _, cm := range configmaps.Items {
+			if referencesAny(cm.ObjectMeta.Annotations, names) {
+				cms = append(cms, cm)
+			}
+		}
+
+		secrets, err := cli.CoreV1().Secrets("").List(metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error retrieving secrets: %s", err)
+		}
+		for _, sec := range secrets.Items {
+			if referencesAny(sec.ObjectMeta.Annotations, names) {
+				secs = append(secs, sec)
+			}
+		}
+		return cms, secs, nil
+	}
+
+	seen := make(map[ResourceKey]bool)
+	for _, name := range names {
+		for _, key := range c.Index.Lookup(name) {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			var meta *metav1.ObjectMeta
+			var err error
+			switch key.Kind {
+			case "ConfigMap":
+				var cm *v1.ConfigMap
+				if cm, err = cli.CoreV1().ConfigMaps(key.Namespace).Get(key.Name, metav1.GetOptions{}); err == nil {
+					meta = &cm.ObjectMeta
+					if referencesAny(meta.Annotations, names) {
+						cms = append(cms, *cm)
+					}
+				}
+			case "Secret":
+				var sec *v1.Secret
+				if sec, err = cli.CoreV1().Secrets(key.Namespace).Get(key.Name, metav1.GetOptions{}); err == nil {
+					meta = &sec.ObjectMeta
+					if referencesAny(meta.Annotations, names) {
+						secs = append(secs, *sec)
+					}
+				}
+			}
+
+			if apierrors.IsNotFound(err) {
+				c.Index.Delete(key)
+			} else if err != nil {
+				log.Warnf("Failed to get %s %s/%s: %s", key.Kind, key.Namespace, key.Name, err)
+			} else {
+				// Annotations may have changed since the last full sync
+				c.Index.Set(key, meta.Annotations)
+			}
+		}
+	}
+	return cms, secs, nil
+}
+
+// RunNoWatch syncs all objects once, then blocks until stopChan is closed
+// without watching for changes. The process stays up to serve healthz/metrics.
+func (c *Controller) RunNoWatch(stopChan <-chan struct{}) {
+	errConfigMaps, errSecrets := c.RunOnce()
+	if errConfigMaps != nil {
+		log.Error(errConfigMaps)
+	}
+	if errSecrets != nil {
+		log.Error(errSecrets)
+	}
+	c.markReady()
+	if c.Credentials != nil {
+		// Rotated credentials are still picked up
+		go c.Credentials.Watch(stopChan)
+	}
+
+	log.Info("Not watching for changes (-no-watch)")
+	<-stopChan
+	log.Info("Ending main controller loop")
 }
 
 func (c *Controller) Run(stopChan <-chan struct{}) {
+	if c.ResyncOnEdit {
+		cli, err := c.KubeGen.KubeClient()
+		if err != nil {
+			log.Fatalf("Error with kubernetes client: %s", err)
+		}
+		go c.WatchEdits(cli, stopChan)
+	}
+	if c.Credentials != nil {
+		go c.Credentials.Watch(stopChan)
+	}
+
 	ticker := time.NewTicker(c.Interval)
 
 	defer ticker.Stop()
@@ -137,6 +724,7 @@ func (c *Controller) Run(stopChan <-chan struct{}) {
 		if errSecrets != nil {
 			log.Error(errSecrets)
 		}
+		c.markReady()
 
 		select {
 		case <-ticker.C:
@@ -0,0 +1,181 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckSize(t *testing.T) {
+	c := &Controller{SizeWarningBytes: 900 * 1024}
+
+	assert.False(t, c.checkSize("namespace", "foo", 0))
+	assert.False(t, c.checkSize("namespace", "foo", 900*1024))
+	assert.True(t, c.checkSize("namespace", "foo", 900*1024+1))
+
+	// Disabled
+	c.SizeWarningBytes = 0
+	assert.False(t, c.checkSize("namespace", "foo", 1024*1024))
+}
+
+func TestRunNoWatchSyncsOnceAndBlocks(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	c := &Controller{
+		Provider: provider.MockProvider{"FooBar123", "", map[string]string{}},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+	synced := promtest.ToFloat64(metrics.SyncedResources.WithLabelValues("ConfigMap"))
+
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.RunNoWatch(stopChan)
+		close(done)
+	}()
+
+	for i := 0; promtest.ToFloat64(metrics.SyncedResources.WithLabelValues("ConfigMap")) != synced+1; i++ {
+		require.True(t, i < 100, "ConfigMap was not synced")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "FooBar123", cm.Data["String"])
+
+	select {
+	case <-done:
+		t.Fatal("RunNoWatch returned before stopChan was closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(stopChan)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunNoWatch didn't return after stopChan was closed")
+	}
+
+	// Nothing else was synced
+	assert.Equal(t, synced+1, promtest.ToFloat64(metrics.SyncedResources.WithLabelValues("ConfigMap")))
+}
+
+func TestSyncWritesNoDataOnDecryptFailure(t *testing.T) {
+	annotations := testutil.Annotations("/app/db/password", "SecureString")
+	annotations[anno.V1DecryptFailureFatal] = "false"
+	cli := testutil.NewKubeClient(testutil.Secret("namespace", "db", annotations))
+	p := &testutil.Provider{
+		Encrypted:    map[string]string{"/app/db/password": "AQICAHh..."},
+		DecryptError: awserr.New("AccessDeniedException", "User is not authorized to perform: kms:Decrypt", nil),
+	}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Failed)
+
+	sec, err := cli.CoreV1().Secrets("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, sec.Data)
+	assert.Empty(t, sec.StringData)
+	assert.Contains(t, sec.ObjectMeta.Annotations[anno.V1DecryptError], "SecureString: AccessDeniedException")
+	assert.NotContains(t, sec.ObjectMeta.Annotations, anno.V1LastError)
+}
+
+func TestMaxDirectoryKeys(t *testing.T) {
+	params := map[string]string{}
+	for i := 0; i < 3; i++ {
+		params[fmt.Sprintf("key%d", i)] = "value"
+	}
+	p := &testutil.Provider{Directories: map[string]map[string]string{"/": params}}
+
+	for _, tt := range []struct {
+		max        int
+		annotation string
+		synced     bool
+	}{
+		{0, "", true},
+		{3, "", true},
+		{2, "", false},
+		{2, "3", true},
+		{2, "0", true},
+		{3, "2", false},
+		{3, "many", false},
+	} {
+		annotations := testutil.Annotations("/", "Directory")
+		if tt.annotation != "" {
+			annotations[anno.V1MaxDirectoryKeys] = tt.annotation
+		}
+		cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "root", annotations))
+		c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, MaxDirectoryKeys: tt.max}
+
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		assert.Equal(t, tt.synced, summary.Failed == 0, "max=%d annotation=%s", tt.max, tt.annotation)
+
+		// Nothing is written above the limit
+		cm, err := cli.CoreV1().ConfigMaps("namespace").Get("root", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, tt.synced, len(cm.Data) == 3, "max=%d annotation=%s", tt.max, tt.annotation)
+	}
+}
+
+func TestCheckDirectoryKeys(t *testing.T) {
+	c := &Controller{MaxDirectoryKeys: 2}
+	assert.NoError(t, c.checkDirectoryKeys("Directory", nil, 2))
+	assert.EqualError(t, c.checkDirectoryKeys("DirectoryArchive", nil, 3),
+		"DirectoryArchive has 3 parameters, more than the limit of 2; raise it with the aws-ssm/max-directory-keys annotation if that's intended")
+	// Only directories are limited
+	assert.NoError(t, c.checkDirectoryKeys("StringList", nil, 3))
+}
+
+func TestSyncTracksVersions(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("/foo", "String")))
+	p := &testutil.Provider{Values: map[string]string{"/foo": "v1"}, Versions: map[string]int64{"/foo": 1}}
+	c := &Controller{Provider: provider.WithVersionTracking(p), KubeGen: testutil.ClientGenerator{cli}}
+	data := func() map[string]string {
+		cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data
+	}
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "v1"}, data())
+
+	// The version is unchanged, so the value isn't read again
+	p.Values["/foo"] = "not read"
+	_, err = c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "v1"}, data())
+
+	p.Values["/foo"] = "v2"
+	p.Versions["/foo"] = 2
+	_, err = c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "v2"}, data())
+}
@@ -0,0 +1,145 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The keys of a -credentials-secret, like those of ~/.aws/credentials
+const (
+	AccessKeyIDKey     = "aws_access_key_id"
+	SecretAccessKeyKey = "aws_secret_access_key"
+	SessionTokenKey    = "aws_session_token"
+)
+
+// SecretCredentialsProviderName is the ProviderName of the values of SecretCredentials
+const SecretCredentialsProviderName = "KubernetesSecretProvider"
+
+// SecretCredentials is an AWS credentials provider (credentials.Provider) that
+// reads static credentials from a Secret (-credentials-secret). Once Watch sees
+// the Secret change (e.g., the keys were rotated), they expire, and are read
+// again before the next AWS request.
+type SecretCredentials struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+
+	mu      sync.Mutex
+	expired bool
+	// The resourceVersion of the Secret the credentials were read from
+	version string
+}
+
+// NewSecretCredentials returns the SecretCredentials of the Secret ref ("namespace/name")
+func NewSecretCredentials(cli kubernetes.Interface, ref string) (*SecretCredentials, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid credentials Secret '%s' (namespace/name)", ref)
+	}
+	return &SecretCredentials{Client: cli, Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// Retrieve reads the credentials from the Secret
+func (s *SecretCredentials) Retrieve() (credentials.Value, error) {
+	value := credentials.Value{ProviderName: SecretCredentialsProviderName}
+	sec, err := s.Client.CoreV1().Secrets(s.Namespace).Get(s.Name, metav1.GetOptions{})
+	if err != nil {
+		return value, fmt.Errorf("Failed to read AWS credentials from Secret %s/%s: %s", s.Namespace, s.Name, err)
+	}
+	value.AccessKeyID = string(sec.Data[AccessKeyIDKey])
+	value.SecretAccessKey = string(sec.Data[SecretAccessKeyKey])
+	value.SessionToken = string(sec.Data[SessionTokenKey])
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return value, fmt.Errorf("Secret %s/%s needs the keys %s and %s", s.Namespace, s.Name, AccessKeyIDKey, SecretAccessKeyKey)
+	}
+
+	s.mu.Lock()
+	s.expired = false
+	s.version = sec.ObjectMeta.ResourceVersion
+	s.mu.Unlock()
+	log.Infof("Read AWS credentials (access key %s) from Secret %s/%s", value.AccessKeyID, s.Namespace, s.Name)
+	return value, nil
+}
+
+// IsExpired returns true once the Secret changed since the credentials were read
+func (s *SecretCredentials) IsExpired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expired
+}
+
+// Watch watches the Secret until stopChan is closed, and expires the credentials
+// as soon as it changes
+func (s *SecretCredentials) Watch(stopChan <-chan struct{}) {
+	for {
+		w, err := s.Client.CoreV1().Secrets(s.Namespace).Watch(metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", s.Name).String(),
+		})
+		if err != nil {
+			log.Warnf("Failed to watch the AWS credentials Secret %s/%s: %s", s.Namespace, s.Name, err)
+		} else {
+			s.handleChanges(w, stopChan)
+		}
+
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// handleChanges expires the credentials when the Secret of w changes, until w
+// ends or stopChan is closed
+func (s *SecretCredentials) handleChanges(w watch.Interface, stopChan <-chan struct{}) {
+	defer w.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			sec, isSecret := event.Object.(*v1.Secret)
+			if !isSecret || sec.Name != s.Name || event.Type == watch.Error {
+				continue
+			}
+			s.mu.Lock()
+			// The watch starts with the Secret as it is (Added)
+			changed := event.Type != watch.Added || sec.ObjectMeta.ResourceVersion != s.version
+			if changed {
+				s.expired = true
+			}
+			s.mu.Unlock()
+			if changed {
+				log.Infof("AWS credentials Secret %s/%s changed; reading it again before the next AWS request", s.Namespace, s.Name)
+			}
+		}
+	}
+}
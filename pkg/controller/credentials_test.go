@@ -0,0 +1,128 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func credentialsSecret(data map[string]string) *v1.Secret {
+	sec := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "aws-credentials"},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		sec.Data[k] = []byte(v)
+	}
+	return sec
+}
+
+func TestNewSecretCredentials(t *testing.T) {
+	for _, ref := range []string{"", "name", "/name", "namespace/", "a/b/c"} {
+		_, err := NewSecretCredentials(testutil.NewKubeClient(), ref)
+		assert.Error(t, err, ref)
+	}
+	creds, err := NewSecretCredentials(testutil.NewKubeClient(), "kube-system/aws-credentials")
+	require.NoError(t, err)
+	assert.Equal(t, "kube-system", creds.Namespace)
+	assert.Equal(t, "aws-credentials", creds.Name)
+}
+
+func TestSecretCredentialsRetrieve(t *testing.T) {
+	cli := testutil.NewKubeClient(credentialsSecret(map[string]string{
+		AccessKeyIDKey:     "AKIAEXAMPLE",
+		SecretAccessKeyKey: "secret",
+		SessionTokenKey:    "token",
+	}))
+	creds, err := NewSecretCredentials(cli, "kube-system/aws-credentials")
+	require.NoError(t, err)
+
+	value, err := creds.Retrieve()
+	require.NoError(t, err)
+	assert.Equal(t, "AKIAEXAMPLE", value.AccessKeyID)
+	assert.Equal(t, "secret", value.SecretAccessKey)
+	assert.Equal(t, "token", value.SessionToken)
+	assert.Equal(t, SecretCredentialsProviderName, value.ProviderName)
+	assert.False(t, creds.IsExpired())
+}
+
+func TestSecretCredentialsRetrieveErrors(t *testing.T) {
+	// No Secret
+	creds, err := NewSecretCredentials(testutil.NewKubeClient(), "kube-system/aws-credentials")
+	require.NoError(t, err)
+	_, err = creds.Retrieve()
+	assert.Error(t, err)
+
+	// No secret access key
+	cli := testutil.NewKubeClient(credentialsSecret(map[string]string{AccessKeyIDKey: "AKIAEXAMPLE"}))
+	creds, err = NewSecretCredentials(cli, "kube-system/aws-credentials")
+	require.NoError(t, err)
+	_, err = creds.Retrieve()
+	assert.EqualError(t, err, "Secret kube-system/aws-credentials needs the keys aws_access_key_id and aws_secret_access_key")
+}
+
+func TestSecretCredentialsWatch(t *testing.T) {
+	cli := testutil.NewKubeClient(credentialsSecret(map[string]string{
+		AccessKeyIDKey:     "AKIAOLD",
+		SecretAccessKeyKey: "old",
+	}))
+	creds, err := NewSecretCredentials(cli, "kube-system/aws-credentials")
+	require.NoError(t, err)
+	_, err = creds.Retrieve()
+	require.NoError(t, err)
+
+	w, err := cli.CoreV1().Secrets("kube-system").Watch(metav1.ListOptions{})
+	require.NoError(t, err)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		creds.handleChanges(w, stop)
+		close(done)
+	}()
+
+	// Another Secret in the namespace doesn't expire them
+	other := credentialsSecret(nil)
+	other.Name = "other"
+	_, err = cli.CoreV1().Secrets("kube-system").Create(other)
+	require.NoError(t, err)
+
+	// The keys are rotated
+	_, err = cli.CoreV1().Secrets("kube-system").Update(credentialsSecret(map[string]string{
+		AccessKeyIDKey:     "AKIANEW",
+		SecretAccessKeyKey: "new",
+	}))
+	require.NoError(t, err)
+
+	for deadline := time.Now().Add(time.Second); !creds.IsExpired() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	require.True(t, creds.IsExpired())
+
+	value, err := creds.Retrieve()
+	require.NoError(t, err)
+	assert.Equal(t, "AKIANEW", value.AccessKeyID)
+	assert.Equal(t, "new", value.SecretAccessKey)
+	assert.False(t, creds.IsExpired())
+}
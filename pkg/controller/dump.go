@@ -0,0 +1,67 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dumpFile is the snapshot written to -dump-dir for each synced object
+type dumpFile struct {
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data"`
+}
+
+// redacted replaces a Secret value in a dump; only its length is kept
+func redacted(value string) string {
+	return fmt.Sprintf("<redacted: %d bytes>", len(value))
+}
+
+// dump writes the data about to be written to an object to DumpDir, as
+// <kind>_<namespace>_<name>.json, replacing the previous snapshot. The values
+// of Secrets are redacted. Failures are only logged.
+func (c *Controller) dump(kind string, namespace string, name string, data map[string]string) {
+	if c.DumpDir == "" {
+		return
+	}
+
+	snapshot := dumpFile{Kind: kind, Namespace: namespace, Name: name, Data: make(map[string]string, len(data))}
+	for k, v := range data {
+		if kind == "Secret" {
+			v = redacted(v)
+		}
+		snapshot.Data[k] = v
+	}
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(c.DumpDir, 0700)
+	}
+	if err == nil {
+		path := filepath.Join(c.DumpDir, fmt.Sprintf("%s_%s_%s.json", kind, namespace, name))
+		err = ioutil.WriteFile(path, append(b, '\n'), 0600)
+	}
+	if err != nil {
+		log.Warnf("Failed to dump %s/%s: %s", namespace, name, err)
+	}
+}
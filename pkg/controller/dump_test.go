@@ -0,0 +1,70 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-ssm-dump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cli := testutil.NewKubeClient(
+		testutil.Secret("namespace", "password", testutil.Annotations("/app/password", "SecureString")),
+		testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/host", "String")),
+	)
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/password": "hunter2", "/app/host": "db.internal"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+		DumpDir:  filepath.Join(dir, "dumps"),
+	}
+	_, err = c.Sync()
+	require.NoError(t, err)
+
+	read := func(name string) (string, dumpFile) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "dumps", name))
+		require.NoError(t, err)
+		var snapshot dumpFile
+		require.NoError(t, json.Unmarshal(b, &snapshot))
+		return string(b), snapshot
+	}
+
+	raw, snapshot := read("Secret_namespace_password.json")
+	assert.NotContains(t, raw, "hunter2")
+	assert.Equal(t, dumpFile{
+		Kind:      "Secret",
+		Namespace: "namespace",
+		Name:      "password",
+		Data:      map[string]string{"SecureString": "<redacted: 7 bytes>"},
+	}, snapshot)
+
+	_, snapshot = read("ConfigMap_namespace_host.json")
+	assert.Equal(t, map[string]string{"String": "db.internal"}, snapshot.Data)
+
+	info, err := os.Stat(filepath.Join(dir, "dumps", "Secret_namespace_password.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,89 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EventSource is the component of the events the controller records
+const EventSource = "aws-ssm"
+
+// DefaultKMSKeyReason is the reason of the warning recorded when a SecureString
+// is decrypted with the AWS-managed key because no aws-ssm/aws-param-key is set
+const DefaultKMSKeyReason = "DefaultKMSKey"
+
+// recordEvent records an event about an object. Failures are only logged: the
+// sync itself succeeded.
+func recordEvent(cli kubernetes.Interface, kind string, meta metav1.ObjectMeta, eventType string, reason string, message string) {
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Unique, like the names of events recorded by kubectl and the kubelet
+			Name:      fmt.Sprintf("%s.%x", meta.Name, now.UnixNano()),
+			Namespace: meta.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            kind,
+			Namespace:       meta.Namespace,
+			Name:            meta.Name,
+			UID:             meta.UID,
+			ResourceVersion: meta.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: EventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := cli.CoreV1().Events(meta.Namespace).Create(event); err != nil {
+		log.Warnf("Failed to record %s event for %s/%s: %s", reason, meta.Namespace, meta.Name, err)
+	}
+}
+
+// warnDefaultKey counts a sync of a SecureString that fell back to the default
+// KMS key, and records a warning the first time each object does (unless
+// -no-default-key-warning is set, for teams that use the default on purpose)
+func (c *Controller) warnDefaultKey(cli kubernetes.Interface, kind string, meta metav1.ObjectMeta) {
+	metrics.DefaultKMSKey.WithLabelValues(kind).Inc()
+	if c.NoDefaultKeyWarning {
+		return
+	}
+
+	c.mu.Lock()
+	key := kind + "/" + meta.Namespace + "/" + meta.Name
+	warned := c.warnedDefaultKey[key]
+	if c.warnedDefaultKey == nil {
+		c.warnedDefaultKey = make(map[string]bool)
+	}
+	c.warnedDefaultKey[key] = true
+	c.mu.Unlock()
+	if warned {
+		return
+	}
+
+	recordEvent(cli, kind, meta, v1.EventTypeWarning, DefaultKMSKeyReason,
+		"SecureString decrypted with the AWS-managed key alias/aws/ssm; set aws-ssm/aws-param-key to use a customer-managed key")
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDefaultKeyWarning(t *testing.T) {
+	withKey := testutil.Annotations("/app/password", "SecureString")
+	withKey[anno.V1ParamKey] = "alias/app"
+	cli := testutil.NewKubeClient(
+		testutil.Secret("namespace", "default-key", testutil.Annotations("/app/password", "SecureString")),
+		testutil.Secret("namespace", "own-key", withKey),
+		testutil.ConfigMap("namespace", "string", testutil.Annotations("/app/host", "String")),
+	)
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/password": "hunter2", "/app/host": "db"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+	before := promtest.ToFloat64(metrics.DefaultKMSKey.WithLabelValues("Secret"))
+
+	for i := 0; i < 2; i++ {
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		assert.Equal(t, 0, summary.Failed)
+	}
+
+	// Counted on every sync, but only recorded once
+	assert.Equal(t, before+2, promtest.ToFloat64(metrics.DefaultKMSKey.WithLabelValues("Secret")))
+	events, err := cli.CoreV1().Events("namespace").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	event := events.Items[0]
+	assert.Equal(t, v1.EventTypeWarning, event.Type)
+	assert.Equal(t, DefaultKMSKeyReason, event.Reason)
+	assert.Equal(t, "Secret", event.InvolvedObject.Kind)
+	assert.Equal(t, "default-key", event.InvolvedObject.Name)
+	assert.Equal(t, EventSource, event.Source.Component)
+	assert.Contains(t, event.Message, "alias/aws/ssm")
+}
+
+func TestNoDefaultKeyWarning(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "default-key", testutil.Annotations("/app/password", "SecureString")),
+	)
+	c := &Controller{
+		Provider:            &testutil.Provider{Values: map[string]string{"/app/password": "hunter2"}},
+		KubeGen:             testutil.ClientGenerator{cli},
+		NoDefaultKeyWarning: true,
+	}
+	before := promtest.ToFloat64(metrics.DefaultKMSKey.WithLabelValues("ConfigMap"))
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	assert.Equal(t, before+1, promtest.ToFloat64(metrics.DefaultKMSKey.WithLabelValues("ConfigMap")))
+	events, err := cli.CoreV1().Events("namespace").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, events.Items)
+}
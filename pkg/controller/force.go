@@ -0,0 +1,108 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations of a ConfigMap that record its own sync, so aren't copied to its
+// companion Secret
+var companionSkipAnnotations = map[string]bool{
+	anno.V1TargetKind:    true,
+	anno.V1ManagedKeys:   true,
+	anno.V1Checksum:      true,
+	anno.V1LastError:     true,
+	anno.V1LastErrorTime: true,
+}
+
+// forceToSecret applies ForceSecureStringToSecret to cm, if its parameter is
+// decrypted (see anno.Decrypted; with AlwaysDecrypt, every one is). Returns whether cm was handled, so mustn't be
+// synced as a ConfigMap, and why it failed. listed are the annotations of cm as
+// it was listed, before resolveParamName.
+func (c *Controller) forceToSecret(cli kubernetes.Interface, p provider.Provider, cm v1.ConfigMap, basePath string, listed map[string]string) (bool, error) {
+	if c.ForceSecureStringToSecret == "" || !(c.AlwaysDecrypt || anno.Decrypted(cm.ObjectMeta.Annotations)) {
+		return false, nil
+	}
+	if c.ForceSecureStringToSecret == config.ForceSecretError {
+		return true, fmt.Errorf("SecureStrings can't be stored in a ConfigMap (-force-securestring-to-secret=%s); use a Secret", config.ForceSecretError)
+	}
+	return true, c.redirectToSecret(cli, p, cm, basePath, listed)
+}
+
+// redirectToSecret syncs the parameter of cm into its companion Secret: the
+// Secret of the same name, owned by cm (so it's deleted along with it), which
+// is created if it's missing. The parameter annotations of cm are copied to it on
+// every sync; cm itself isn't written. A Secret of that name that isn't owned by
+// cm is an error, rather than being overwritten. Relative parameter names are
+// read under basePath, like cm's.
+func (c *Controller) redirectToSecret(cli kubernetes.Interface, p provider.Provider, cm v1.ConfigMap, basePath string, listed map[string]string) error {
+	sec, err := cli.CoreV1().Secrets(cm.Namespace).Get(cm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		sec, err = cli.CoreV1().Secrets(cm.Namespace).Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cm.Namespace,
+				Name:      cm.Name,
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "v1", Kind: "ConfigMap", Name: cm.Name, UID: cm.UID},
+				},
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if !ownedBy(sec.ObjectMeta, cm) {
+		return fmt.Errorf("Secret %s/%s already exists, and isn't owned by the ConfigMap", sec.Namespace, sec.Name)
+	}
+
+	if sec.ObjectMeta.Annotations == nil {
+		sec.ObjectMeta.Annotations = make(map[string]string)
+	}
+	for k, v := range cm.ObjectMeta.Annotations {
+		if (strings.HasPrefix(k, "aws-ssm/") || strings.HasPrefix(k, "alpha.ssm.cmattoon.com/")) && !companionSkipAnnotations[k] {
+			sec.ObjectMeta.Annotations[k] = v
+		}
+	}
+
+	obj, err := secret.FromKubernetesSecretWithBasePath(p, *sec, basePath)
+	if err != nil {
+		return err
+	}
+	restoreParamName(obj.Secret.ObjectMeta.Annotations, listed)
+	_, err = c.writeSecret(cli, obj)
+	return err
+}
+
+// ownedBy is whether the object of objMeta has an owner reference to cm
+func ownedBy(objMeta metav1.ObjectMeta, cm v1.ConfigMap) bool {
+	for _, ref := range objMeta.OwnerReferences {
+		if ref.Kind == "ConfigMap" && ref.Name == cm.Name && ref.UID == cm.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestForceSecureStringToSecretError(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "db", testutil.Annotations("/app/db/password", "SecureString"))
+	cli := testutil.NewKubeClient(cm, testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/db/host", "String")))
+	p := &testutil.Provider{Values: map[string]string{"/app/db/password": "hunter2", "/app/db/host": "db.internal"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ForceSecureStringToSecret: config.ForceSecretError}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 1, summary.Synced)
+
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Data)
+	assert.Contains(t, result.ObjectMeta.Annotations[anno.V1LastError], "-force-securestring-to-secret=error")
+
+	// Other types are synced as usual
+	result, err = cli.CoreV1().ConfigMaps("namespace").Get("host", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", result.Data["String"])
+}
+
+func TestForceSecureStringToSecretTemplates(t *testing.T) {
+	annotations := testutil.Annotations("/app/db/host", "String")
+	annotations[anno.V1TemplateParams] = "password=/app/db/password"
+	annotations[anno.V1TemplatePrefix+"url"] = "postgres://app:{{.password}}@db"
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "db", annotations))
+	p := &testutil.Provider{Values: map[string]string{"/app/db/host": "db.internal", "/app/db/password": "hunter2"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ForceSecureStringToSecret: config.ForceSecretError}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+
+	// Template parameters are read decrypted, so never rendered into the ConfigMap
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Data)
+}
+
+func TestForceSecureStringToSecretAlwaysDecrypt(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/db/host", "String")))
+	p := &testutil.Provider{Values: map[string]string{"/app/db/host": "db.internal"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ForceSecureStringToSecret: config.ForceSecretError, AlwaysDecrypt: true}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("host", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Data)
+}
+
+func TestForceSecureStringToSecretRedirect(t *testing.T) {
+	annotations := testutil.Annotations("/app/db", "Directory")
+	annotations[anno.V1ParamKey] = "alias/app"
+	annotations[anno.V1TargetKind] = "ConfigMap"
+	cm := testutil.ConfigMap("namespace", "db", annotations)
+	cm.UID = types.UID("cm-uid")
+	cli := testutil.NewKubeClient(cm)
+	p := &testutil.Provider{Directories: map[string]map[string]string{"/app/db": {"password": "hunter2"}}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ForceSecureStringToSecret: config.ForceSecretRedirect}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Failed)
+
+	sec, err := cli.CoreV1().Secrets("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", sec.StringData["password"])
+	assert.Equal(t, "/app/db", sec.ObjectMeta.Annotations[anno.V1ParamName])
+	assert.NotContains(t, sec.ObjectMeta.Annotations, anno.V1TargetKind)
+	require.Len(t, sec.ObjectMeta.OwnerReferences, 1)
+	assert.Equal(t, types.UID("cm-uid"), sec.ObjectMeta.OwnerReferences[0].UID)
+
+	// The ConfigMap isn't written
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Data)
+
+	// Later syncs update the companion
+	p.Directories["/app/db"]["password"] = "correct horse"
+	_, err = c.Sync()
+	require.NoError(t, err)
+	sec, err = cli.CoreV1().Secrets("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "correct horse", sec.StringData["password"])
+}
+
+func TestForceSecureStringToSecretRedirectKeepsOtherSecrets(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "db", testutil.Annotations("/app/db/password", "SecureString"))
+	cm.UID = types.UID("cm-uid")
+	theirs := testutil.Secret("namespace", "db", nil)
+	theirs.StringData = map[string]string{"password": "theirs"}
+	cli := testutil.NewKubeClient(cm, theirs)
+	p := &testutil.Provider{Values: map[string]string{"/app/db/password": "hunter2"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ForceSecureStringToSecret: config.ForceSecretRedirect}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+
+	sec, err := cli.CoreV1().Secrets("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "theirs", sec.StringData["password"])
+}
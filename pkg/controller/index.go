@@ -0,0 +1,207 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"path"
+	"sort"
+	"sync"
+)
+
+// ResourceKey identifies a ConfigMap or Secret
+type ResourceKey struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// Index maps SSM parameter names to the objects that reference them. It is
+// rebuilt by each full sync, so deleted objects are dropped, and is safe for
+// concurrent use. Methods on a nil *Index are no-ops.
+type Index struct {
+	mu    sync.RWMutex
+	ready map[string]bool
+	refs  map[ResourceKey]paramRefs
+	names map[string]map[ResourceKey]bool
+	paths map[string]map[ResourceKey]bool
+	// By "namespace/name" of the ConfigMap the parameter name is read from
+	nameFrom map[string]map[ResourceKey]bool
+}
+
+func NewIndex() *Index {
+	return &Index{
+		ready:    make(map[string]bool),
+		refs:     make(map[ResourceKey]paramRefs),
+		names:    make(map[string]map[ResourceKey]bool),
+		paths:    make(map[string]map[ResourceKey]bool),
+		nameFrom: make(map[string]map[ResourceKey]bool),
+	}
+}
+
+// Ready is true once every object of kind has been indexed
+func (idx *Index) Ready(kind string) bool {
+	if idx == nil {
+		return false
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.ready[kind]
+}
+
+// Replace indexes every object of a kind, dropping any that are no longer present
+func (idx *Index) Replace(kind string, objects map[ResourceKey]map[string]string) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for key := range idx.refs {
+		if _, ok := objects[key]; key.Kind == kind && !ok {
+			idx.remove(key)
+		}
+	}
+	for key, annotations := range objects {
+		idx.set(key, annotations)
+	}
+	idx.ready[kind] = true
+}
+
+// Set (re)indexes an added or updated object
+func (idx *Index) Set(key ResourceKey, annotations map[string]string) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.set(key, annotations)
+}
+
+// Delete removes a deleted object
+func (idx *Index) Delete(key ResourceKey) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(key)
+}
+
+// Lookup returns the objects referencing the named parameter, sorted
+func (idx *Index) Lookup(name string) []ResourceKey {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	found := make(map[ResourceKey]bool)
+	for key := range idx.names[name] {
+		found[key] = true
+	}
+	// Directories referencing any parent of name
+	for dir := path.Dir(name); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		for key := range idx.paths[dir] {
+			found[key] = true
+		}
+	}
+
+	return sortedKeys(found)
+}
+
+// LookupNameFrom returns the objects whose parameter name is read from the
+// ConfigMap namespace/name (param-name-from), with the key each reads
+func (idx *Index) LookupNameFrom(namespace string, name string) map[ResourceKey]string {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	keys := make(map[ResourceKey]string)
+	for key := range idx.nameFrom[namespace+"/"+name] {
+		keys[key] = idx.refs[key].NameFromKey
+	}
+	return keys
+}
+
+// sortedKeys returns the keys of found, sorted by kind, namespace, then name
+func sortedKeys(found map[ResourceKey]bool) []ResourceKey {
+	keys := make([]ResourceKey, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return keys
+}
+
+func (idx *Index) set(key ResourceKey, annotations map[string]string) {
+	idx.remove(key)
+	refs := references(annotations)
+	if len(refs.Names) == 0 && len(refs.Paths) == 0 && refs.NameFrom == "" {
+		return
+	}
+	idx.refs[key] = refs
+	for _, name := range refs.Names {
+		add(idx.names, name, key)
+	}
+	for _, p := range refs.Paths {
+		add(idx.paths, p, key)
+	}
+	if refs.NameFrom != "" {
+		add(idx.nameFrom, key.Namespace+"/"+refs.NameFrom, key)
+	}
+}
+
+func (idx *Index) remove(key ResourceKey) {
+	refs, ok := idx.refs[key]
+	if !ok {
+		return
+	}
+	delete(idx.refs, key)
+	for _, name := range refs.Names {
+		drop(idx.names, name, key)
+	}
+	for _, p := range refs.Paths {
+		drop(idx.paths, p, key)
+	}
+	if refs.NameFrom != "" {
+		drop(idx.nameFrom, key.Namespace+"/"+refs.NameFrom, key)
+	}
+}
+
+func add(m map[string]map[ResourceKey]bool, ref string, key ResourceKey) {
+	if m[ref] == nil {
+		m[ref] = make(map[ResourceKey]bool)
+	}
+	m[ref][key] = true
+}
+
+func drop(m map[string]map[ResourceKey]bool, ref string, key ResourceKey) {
+	delete(m[ref], key)
+	if len(m[ref]) == 0 {
+		delete(m, ref)
+	}
+}
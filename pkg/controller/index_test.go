@@ -0,0 +1,119 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIndexLookup(t *testing.T) {
+	idx := NewIndex()
+	host := ResourceKey{Kind: "ConfigMap", Namespace: "ns", Name: "host"}
+	dir := ResourceKey{Kind: "Secret", Namespace: "ns", Name: "dir"}
+	idx.Set(host, testutil.Annotations("/app/db/host", "String"))
+	idx.Set(dir, testutil.Annotations("/app", "Directory"))
+
+	assert.Equal(t, []ResourceKey{host, dir}, idx.Lookup("/app/db/host"))
+	assert.Equal(t, []ResourceKey{dir}, idx.Lookup("/app/db/port"))
+	assert.Empty(t, idx.Lookup("/other"))
+
+	// Updated to reference another parameter
+	idx.Set(host, testutil.Annotations("/other", "String"))
+	assert.Equal(t, []ResourceKey{dir}, idx.Lookup("/app/db/host"))
+	assert.Equal(t, []ResourceKey{host}, idx.Lookup("/other"))
+
+	idx.Delete(dir)
+	assert.Empty(t, idx.Lookup("/app/db/port"))
+	assert.Empty(t, idx.paths)
+}
+
+func TestIndexReplace(t *testing.T) {
+	idx := NewIndex()
+	assert.False(t, idx.Ready("ConfigMap"))
+
+	a := ResourceKey{Kind: "ConfigMap", Namespace: "ns", Name: "a"}
+	b := ResourceKey{Kind: "ConfigMap", Namespace: "ns", Name: "b"}
+	s := ResourceKey{Kind: "Secret", Namespace: "ns", Name: "a"}
+	idx.Set(s, testutil.Annotations("/foo", "String"))
+	idx.Replace("ConfigMap", map[ResourceKey]map[string]string{
+		a: testutil.Annotations("/foo", "String"),
+		b: testutil.Annotations("/foo", "String"),
+	})
+	assert.True(t, idx.Ready("ConfigMap"))
+	assert.Equal(t, []ResourceKey{a, b, s}, idx.Lookup("/foo"))
+
+	// b was deleted; Secrets are untouched
+	idx.Replace("ConfigMap", map[ResourceKey]map[string]string{
+		a: testutil.Annotations("/foo", "String"),
+	})
+	assert.Equal(t, []ResourceKey{a, s}, idx.Lookup("/foo"))
+}
+
+func TestIndexConcurrent(t *testing.T) {
+	idx := NewIndex()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := ResourceKey{Kind: "ConfigMap", Namespace: "ns", Name: fmt.Sprintf("cm-%d", i)}
+			idx.Set(key, testutil.Annotations("/foo", "String"))
+			idx.Lookup("/foo")
+			if i%2 == 0 {
+				idx.Delete(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, idx.Lookup("/foo"), 5)
+}
+
+func TestNilIndex(t *testing.T) {
+	var idx *Index
+	idx.Set(ResourceKey{}, nil)
+	idx.Delete(ResourceKey{})
+	idx.Replace("ConfigMap", nil)
+	assert.False(t, idx.Ready("ConfigMap"))
+	assert.Nil(t, idx.Lookup("/foo"))
+}
+
+func TestSyncParametersUsesIndex(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/host", "String")),
+		testutil.ConfigMap("namespace", "gone", testutil.Annotations("/app/host", "String")),
+	)
+	p := &testutil.Provider{Values: map[string]string{"/app/host": "db.internal"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+	require.Len(t, c.Index.Lookup("/app/host"), 2)
+
+	// Deleted since the last full sync
+	require.NoError(t, cli.CoreV1().ConfigMaps("namespace").Delete("gone", &metav1.DeleteOptions{}))
+
+	p.Requested = nil
+	c.SyncParameters([]string{"/app/host"})
+	assert.Equal(t, []string{"/app/host"}, p.Requested)
+	assert.Equal(t, []ResourceKey{{Kind: "ConfigMap", Namespace: "namespace", Name: "host"}}, c.Index.Lookup("/app/host"))
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaxLastErrorLength is the maximum length, in bytes, of the last-error annotation
+const MaxLastErrorLength = 1024
+
+// withLastError returns a copy of annotations recording err, and whether anything changed.
+// Repeating the same error is not a change, so a resource that fails on every sync
+// is only updated once (and the time is when the error was first seen).
+func withLastError(annotations map[string]string, err error) (map[string]string, bool) {
+	msg := truncate(err.Error(), MaxLastErrorLength)
+	if annotations[anno.V1LastError] == msg {
+		return annotations, false
+	}
+
+	result := make(map[string]string, len(annotations)+2)
+	for k, v := range annotations {
+		result[k] = v
+	}
+	result[anno.V1LastError] = msg
+	result[anno.V1LastErrorTime] = time.Now().UTC().Format(time.RFC3339)
+	return result, true
+}
+
+// truncate shortens s to at most max bytes without splitting a UTF-8 character
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max-3]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// lastErrorPatch returns a JSON merge patch of just the last-error annotations
+func lastErrorPatch(annotations map[string]string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				anno.V1LastError:     annotations[anno.V1LastError],
+				anno.V1LastErrorTime: annotations[anno.V1LastErrorTime],
+			},
+		},
+	})
+}
+
+// setConfigMapError records a failed sync on the ConfigMap. Only the last-error
+// annotations are patched: the data of cm may hold what the sync Set before it
+// failed, which mustn't be written.
+func setConfigMapError(cli kubernetes.Interface, cm v1.ConfigMap, err error) {
+	annotations, changed := withLastError(cm.ObjectMeta.Annotations, err)
+	if !changed {
+		return
+	}
+	patch, err := lastErrorPatch(annotations)
+	if err == nil {
+		_, err = cli.CoreV1().ConfigMaps(cm.Namespace).Patch(cm.Name, types.MergePatchType, patch)
+	}
+	if err != nil {
+		log.Warnf("Failed to record error on %s/%s: %s", cm.Namespace, cm.Name, err)
+	}
+}
+
+// setSecretError records a failed sync on the Secret, like setConfigMapError
+func setSecretError(cli kubernetes.Interface, sec v1.Secret, err error) {
+	annotations, changed := withLastError(sec.ObjectMeta.Annotations, err)
+	if !changed {
+		return
+	}
+	patch, err := lastErrorPatch(annotations)
+	if err == nil {
+		_, err = cli.CoreV1().Secrets(sec.Namespace).Patch(sec.Name, types.MergePatchType, patch)
+	}
+	if err != nil {
+		log.Warnf("Failed to record error on %s/%s: %s", sec.Namespace, sec.Name, err)
+	}
+}
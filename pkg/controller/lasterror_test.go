@@ -0,0 +1,131 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "exactly10!", truncate("exactly10!", 10))
+	assert.Equal(t, "this is...", truncate("this is too long", 10))
+	// Doesn't split the multi-byte character
+	assert.Equal(t, "ab...", truncate("abüüüüüü", 6))
+}
+
+func TestWithLastError(t *testing.T) {
+	err := errors.New("AccessDeniedException")
+	annotations, changed := withLastError(map[string]string{"foo": "bar"}, err)
+	assert.True(t, changed)
+	assert.Equal(t, "bar", annotations["foo"])
+	assert.Equal(t, "AccessDeniedException", annotations["aws-ssm/last-error"])
+	assert.NotEmpty(t, annotations["aws-ssm/last-error-time"])
+
+	// The same error again isn't a change
+	_, changed = withLastError(annotations, err)
+	assert.False(t, changed)
+
+	long, changed := withLastError(annotations, errors.New(strings.Repeat("x", 5000)))
+	assert.True(t, changed)
+	assert.Len(t, long["aws-ssm/last-error"], MaxLastErrorLength)
+}
+
+func TestHandleConfigMapsRecordsAndClearsLastError(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	get := func() *v1.ConfigMap {
+		cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm
+	}
+
+	c := &Controller{Provider: provider.MockProvider{"(error)", "ParameterNotFound", map[string]string{}}}
+	require.NoError(t, c.HandleConfigMaps(cli))
+	assert.Equal(t, "ConfigMap namespace/foo, parameter 'foo-param' (String): ParameterNotFound", get().Annotations["aws-ssm/last-error"])
+	assert.NotEmpty(t, get().Annotations["aws-ssm/last-error-time"])
+
+	// Failing again with the same error doesn't update the object
+	cli.ClearActions()
+	require.NoError(t, c.HandleConfigMaps(cli))
+	for _, action := range cli.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+		assert.NotEqual(t, "patch", action.GetVerb())
+	}
+
+	c.Provider = provider.MockProvider{"FooBar123", "", map[string]string{}}
+	require.NoError(t, c.HandleConfigMaps(cli))
+	cm := get()
+	assert.Equal(t, "FooBar123", cm.Data["String"])
+	assert.NotContains(t, cm.Annotations, "aws-ssm/last-error")
+	assert.NotContains(t, cm.Annotations, "aws-ssm/last-error-time")
+}
+
+func TestLastErrorLeavesDataAlone(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String"))
+	cm.Data = map[string]string{"String": "old"}
+	sec := testutil.Secret("namespace", "foo", testutil.Annotations("foo-param", "String"))
+	sec.Data = map[string][]byte{"String": []byte("old")}
+	cli := testutil.NewKubeClient(cm, sec)
+
+	// Rejected after it's Set into the listed object
+	c := &Controller{Provider: provider.MockProvider{"FooBar123", "", map[string]string{}}, MaxValueBytes: 3}
+	require.NoError(t, c.HandleConfigMaps(cli))
+	require.NoError(t, c.HandleSecrets(cli))
+
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "old"}, result.Data)
+	assert.Contains(t, result.Annotations["aws-ssm/last-error"], "-max-value-bytes=3")
+	resultSec, err := cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"String": []byte("old")}, resultSec.Data)
+	assert.Empty(t, resultSec.StringData)
+	assert.Contains(t, resultSec.Annotations["aws-ssm/last-error"], "-max-value-bytes=3")
+}
+
+func TestHandleSecretsRecordsLastError(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.Secret("namespace", "foo", testutil.Annotations("foo-param", "String")))
+
+	c := &Controller{Provider: provider.MockProvider{"(error)", "ParameterNotFound", map[string]string{}}}
+	require.NoError(t, c.HandleSecrets(cli))
+
+	sec, err := cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Secret namespace/foo, parameter 'foo-param' (String): ParameterNotFound", sec.Annotations["aws-ssm/last-error"])
+}
+
+func TestLastErrorNamesParameterAsWritten(t *testing.T) {
+	os.Setenv("AWS_SSM_TEST_CLUSTER", "prod-1")
+	defer os.Unsetenv("AWS_SSM_TEST_CLUSTER")
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("/app/${AWS_SSM_TEST_CLUSTER}/db-host", "String")))
+
+	p := provider.WithEnv(provider.MockProvider{"(error)", "ParameterNotFound", map[string]string{}}, []string{"AWS_SSM_TEST_CLUSTER"})
+	c := &Controller{Provider: p}
+	require.NoError(t, c.HandleConfigMaps(cli))
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "ConfigMap namespace/foo, parameter '/app/${AWS_SSM_TEST_CLUSTER}/db-host' (String): ParameterNotFound", cm.Annotations["aws-ssm/last-error"])
+}
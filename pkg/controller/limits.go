@@ -0,0 +1,100 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	log "github.com/sirupsen/logrus"
+)
+
+// limitValues applies MaxValueBytes to the value of each key, which is read with
+// size and truncated with truncate. Values that are too large fail the sync or
+// (with config.MaxValueTruncate) are truncated: each is logged, and the keys
+// are recorded in the truncated-keys annotation.
+func (c *Controller) limitValues(kind string, namespace string, name string, annotations map[string]string, keys []string, size func(string) int, truncate func(string, int)) error {
+	delete(annotations, anno.V1TruncatedKeys)
+	if c.MaxValueBytes <= 0 {
+		return nil
+	}
+
+	truncated := []string{}
+	for _, k := range keys {
+		n := size(k)
+		if n <= c.MaxValueBytes {
+			continue
+		}
+		if c.MaxValuePolicy != config.MaxValueTruncate {
+			return fmt.Errorf("The value of key '%s' is %d bytes, more than -max-value-bytes=%d", k, n, c.MaxValueBytes)
+		}
+		log.Warnf("Truncating the value of key '%s' of %s %s/%s from %d bytes to -max-value-bytes=%d", k, kind, namespace, name, n, c.MaxValueBytes)
+		truncate(k, c.MaxValueBytes)
+		truncated = append(truncated, k)
+	}
+	if len(truncated) > 0 {
+		annotations[anno.V1TruncatedKeys] = strings.Join(truncated, ",")
+	}
+	return nil
+}
+
+// limitConfigMapValues applies MaxValueBytes to the keys obj was synced with (see limitValues)
+func (c *Controller) limitConfigMapValues(obj *configmap.ConfigMap) error {
+	data := obj.ConfigMap.Data
+	return c.limitValues("ConfigMap", obj.Namespace, obj.Name, obj.ConfigMap.ObjectMeta.Annotations, obj.ManagedKeys(),
+		func(k string) int { return len(data[k]) },
+		func(k string, max int) { data[k] = truncateString(data[k], max) })
+}
+
+// limitSecretValues applies MaxValueBytes to the keys obj was synced with (see limitValues)
+func (c *Controller) limitSecretValues(obj *secret.Secret) error {
+	stringData, data := obj.Secret.StringData, obj.Secret.Data
+	return c.limitValues("Secret", obj.Namespace, obj.Name, obj.Secret.ObjectMeta.Annotations, obj.ManagedKeys(),
+		func(k string) int {
+			if v, ok := stringData[k]; ok {
+				return len(v)
+			}
+			return len(data[k])
+		},
+		func(k string, max int) {
+			if v, ok := stringData[k]; ok {
+				stringData[k] = truncateString(v, max)
+			} else {
+				data[k] = data[k][:max]
+			}
+		})
+}
+
+// truncateString truncates value to at most max bytes, without splitting a UTF-8 character
+func truncateString(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	cut := max
+	for cut > 0 && cut > max-utf8.UTFMax && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	if !utf8.RuneStart(value[cut]) {
+		// Not UTF-8 anyway
+		cut = max
+	}
+	return value[:cut]
+}
@@ -0,0 +1,95 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMaxValueBytes(t *testing.T) {
+	tests := []struct {
+		title    string
+		policy   string
+		value    string
+		failed   bool
+		expected map[string]string
+		marked   string
+	}{
+		{"under the limit", config.MaxValueError, "12345678", false, map[string]string{"String": "12345678"}, ""},
+		{"over the limit", config.MaxValueError, "123456789", true, nil, ""},
+		{"truncated", config.MaxValueTruncate, "123456789", false, map[string]string{"String": "12345678"}, "String"},
+		{"truncated under the limit", config.MaxValueTruncate, "1234", false, map[string]string{"String": "1234"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", testutil.Annotations("/foo", "String")))
+			p := &testutil.Provider{Values: map[string]string{"/foo": test.value}}
+			c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, MaxValueBytes: 8, MaxValuePolicy: test.policy}
+
+			summary, err := c.Sync()
+			require.NoError(t, err)
+			cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+			require.NoError(t, err)
+			if test.failed {
+				assert.Equal(t, 1, summary.Failed)
+				assert.Equal(t, "The value of key 'String' is 9 bytes, more than -max-value-bytes=8", summary.Resources[0].Error)
+				assert.Empty(t, cm.Data)
+				return
+			}
+			assert.Equal(t, 1, summary.Synced)
+			assert.Equal(t, test.expected, cm.Data)
+			assert.Equal(t, test.marked, cm.ObjectMeta.Annotations[anno.V1TruncatedKeys])
+		})
+	}
+}
+
+func TestMaxValueBytesTruncatesSecrets(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.Secret("namespace", "foo", testutil.Annotations("/foo", "StringList")))
+	p := &testutil.Provider{Values: map[string]string{"/foo": "short=1,long=123456789"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, MaxValueBytes: 8, MaxValuePolicy: config.MaxValueTruncate}
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+	sec, err := cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"StringList": "short=1,", "short": "1", "long": "12345678"}, sec.StringData)
+	assert.Equal(t, "StringList,long", sec.ObjectMeta.Annotations[anno.V1TruncatedKeys])
+
+	// The mark is removed once the values fit
+	p.Values["/foo"] = "short=1"
+	_, err = c.Sync()
+	require.NoError(t, err)
+	sec, err = cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, sec.ObjectMeta.Annotations, anno.V1TruncatedKeys)
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abc", 3))
+	assert.Equal(t, "ab", truncateString("abc", 2))
+	// "é" is 2 bytes
+	assert.Equal(t, "a", truncateString("aéb", 2))
+	assert.Equal(t, "aé", truncateString("aéb", 3))
+	assert.Equal(t, "\xff\xff", truncateString("\xff\xff\xff", 2))
+}
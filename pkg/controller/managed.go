@@ -0,0 +1,90 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// managedBy returns the name of the tool (e.g. Helm) managing an object, or "" if there isn't one
+func managedBy(meta metav1.ObjectMeta) string {
+	if v := meta.Labels["app.kubernetes.io/managed-by"]; v != "" && v != "aws-ssm" {
+		return v
+	}
+	// Helm 2
+	if v := meta.Labels["heritage"]; v == "Helm" || v == "Tiller" {
+		return "Helm"
+	}
+	if meta.Annotations["meta.helm.sh/release-name"] != "" {
+		return "Helm"
+	}
+	if meta.Labels["kustomize.toolkit.fluxcd.io/name"] != "" {
+		return "Kustomize"
+	}
+	return ""
+}
+
+// enabled is false if the controller mustn't touch the object (aws-ssm/enabled: "false")
+func enabled(meta metav1.ObjectMeta) bool {
+	return anno.Bool(meta.Annotations, anno.V1Enabled, true)
+}
+
+// recordManaged records the number of keys the controller wrote to an object
+func (c *Controller) recordManaged(key ResourceKey, keys int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.managedKeys == nil {
+		c.managedKeys = make(map[ResourceKey]int)
+	}
+	c.managedKeys[key] = keys
+}
+
+// forgetManaged stops counting an object the controller no longer syncs
+func (c *Controller) forgetManaged(key ResourceKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.managedKeys, key)
+}
+
+// retainManaged forgets the objects of kind that a full list no longer has,
+// e.g. because they were deleted
+func (c *Controller) retainManaged(kind string, objects map[ResourceKey]map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.managedKeys {
+		if _, ok := objects[key]; key.Kind == kind && !ok {
+			delete(c.managedKeys, key)
+		}
+	}
+}
+
+// observeManaged sets the ssm_managed_resources and ssm_managed_keys_total gauges
+// of kind. An object that fails to sync is still counted with the keys it was
+// last synced with.
+func (c *Controller) observeManaged(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resources, keys := 0, 0
+	for key, n := range c.managedKeys {
+		if key.Kind == kind {
+			resources += 1
+			keys += n
+		}
+	}
+	metrics.ObserveManaged(kind, resources, keys)
+}
@@ -0,0 +1,172 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestManagedBy(t *testing.T) {
+	tests := []struct {
+		meta     metav1.ObjectMeta
+		expected string
+	}{
+		{metav1.ObjectMeta{}, ""},
+		{metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/managed-by": "Helm"}}, "Helm"},
+		{metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/managed-by": "aws-ssm"}}, ""},
+		{metav1.ObjectMeta{Labels: map[string]string{"heritage": "Tiller"}}, "Helm"},
+		{metav1.ObjectMeta{Annotations: map[string]string{"meta.helm.sh/release-name": "app"}}, "Helm"},
+		{metav1.ObjectMeta{Labels: map[string]string{"kustomize.toolkit.fluxcd.io/name": "app"}}, "Kustomize"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, managedBy(test.meta))
+	}
+}
+
+// syncHelmConfigMap syncs a ConfigMap managed by Helm, with a key that conflicts
+// with the controller's, and returns the resulting data
+func syncHelmConfigMap(t *testing.T, policy string) map[string]string {
+	cm := testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String"))
+	cm.ObjectMeta.Labels = map[string]string{"app.kubernetes.io/managed-by": "Helm"}
+	cm.Data = map[string]string{"String": "from-helm", "other": "helm-only"}
+	cli := testutil.NewKubeClient(cm)
+
+	c := &Controller{
+		Provider:        &testutil.Provider{Values: map[string]string{"foo-param": "from-ssm"}},
+		KubeGen:         testutil.ClientGenerator{cli},
+		ManagedByPolicy: policy,
+	}
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Failed)
+
+	updated, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	return updated.Data
+}
+
+func TestManagedByPolicyUpdate(t *testing.T) {
+	data := syncHelmConfigMap(t, config.ManagedByUpdate)
+	assert.Equal(t, map[string]string{"String": "from-ssm", "other": "helm-only"}, data)
+}
+
+func TestManagedByPolicySkip(t *testing.T) {
+	data := syncHelmConfigMap(t, config.ManagedBySkip)
+	assert.Equal(t, map[string]string{"String": "from-helm", "other": "helm-only"}, data)
+}
+
+func TestManagedByPolicyMerge(t *testing.T) {
+	data := syncHelmConfigMap(t, config.ManagedByMerge)
+	assert.Equal(t, map[string]string{"String": "from-helm", "other": "helm-only"}, data)
+}
+
+func TestManagedByPolicyMergeUpdatesManagedKeys(t *testing.T) {
+	s := testutil.Secret("namespace", "foo", testutil.Annotations("/app", "Directory"))
+	s.ObjectMeta.Labels = map[string]string{"app.kubernetes.io/managed-by": "Helm"}
+	s.ObjectMeta.Annotations["aws-ssm/managed-keys"] = "user"
+	s.Data = map[string][]byte{"user": []byte("old"), "host": []byte("from-helm")}
+	cli := testutil.NewKubeClient(s)
+
+	c := &Controller{
+		Provider: &testutil.Provider{Directories: map[string]map[string]string{
+			"/app": {"user": "new", "host": "from-ssm", "port": "5432"},
+		}},
+		KubeGen:         testutil.ClientGenerator{cli},
+		ManagedByPolicy: config.ManagedByMerge,
+	}
+	_, err := c.Sync()
+	require.NoError(t, err)
+
+	updated, err := cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	// The fake clientset doesn't merge StringData into Data
+	assert.Equal(t, map[string]string{"user": "new", "port": "5432"}, updated.StringData)
+	assert.Equal(t, "from-helm", string(updated.Data["host"]))
+	assert.Equal(t, "port,user", updated.ObjectMeta.Annotations["aws-ssm/managed-keys"])
+}
+
+func TestSyncSkipsDisabledObjects(t *testing.T) {
+	disabled := testutil.Annotations("/disabled", "String")
+	disabled[anno.V1Enabled] = "false"
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "disabled", disabled),
+		testutil.Secret("namespace", "disabled", disabled),
+		testutil.ConfigMap("namespace", "enabled", testutil.Annotations("/enabled", "String")),
+	)
+	p := &testutil.Provider{Values: map[string]string{"/disabled": "new", "/enabled": "new"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Synced)
+	assert.Equal(t, 2, summary.Skipped)
+	assert.Equal(t, []string{"/enabled"}, p.Requested)
+
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("disabled", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, cm.Data)
+	assert.Equal(t, disabled, cm.ObjectMeta.Annotations)
+	sec, err := cli.CoreV1().Secrets("namespace").Get("disabled", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, sec.StringData)
+	assert.Equal(t, disabled, sec.ObjectMeta.Annotations)
+
+	// Still recognized as managed
+	assert.Len(t, c.Index.Lookup("/disabled"), 2)
+}
+
+func TestManagedGauges(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "dir", testutil.Annotations("/app", "Directory")),
+		testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/host", "String")),
+		testutil.ConfigMap("namespace", "irrelevant", nil),
+		testutil.Secret("namespace", "password", testutil.Annotations("/app/password", "SecureString")),
+	)
+	p := &testutil.Provider{
+		Values:      map[string]string{"/app/host": "db", "/app/password": "hunter2"},
+		Directories: map[string]map[string]string{"/app": {"host": "db", "port": "5432"}},
+	}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+	gauges := func(kind string) []float64 {
+		return []float64{
+			promtest.ToFloat64(metrics.ManagedResources.WithLabelValues(kind)),
+			promtest.ToFloat64(metrics.ManagedKeys.WithLabelValues(kind)),
+		}
+	}
+
+	_, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2, 3}, gauges("ConfigMap"))
+	assert.Equal(t, []float64{1, 1}, gauges("Secret"))
+
+	// A failed sync still counts the keys of the last one; deleted objects don't
+	delete(p.Values, "/app/password")
+	require.NoError(t, cli.CoreV1().ConfigMaps("namespace").Delete("host", &metav1.DeleteOptions{}))
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, []float64{1, 2}, gauges("ConfigMap"))
+	assert.Equal(t, []float64{1, 1}, gauges("Secret"))
+}
@@ -0,0 +1,205 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mirrorNamespaces returns the namespaces of the mirror-namespaces annotation,
+// other than the object's own, without duplicates
+func mirrorNamespaces(meta metav1.ObjectMeta) []string {
+	namespaces := []string{}
+	seen := map[string]bool{meta.Namespace: true}
+	for _, ns := range anno.List(meta.Annotations, anno.V1MirrorNamespaces) {
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// acceptsMirror returns why namespace ns doesn't accept mirrors of the objects
+// of namespace from: its aws-ssm/accept-mirrors-from doesn't name from (or "*").
+// A namespace that can't be read accepts none.
+func acceptsMirror(ns string, from string, defaults *namespaceDefaults) error {
+	for _, accepted := range strings.Split(defaults.get(ns, anno.V1AcceptMirrorsFrom), ",") {
+		if accepted = strings.TrimSpace(accepted); accepted == "*" || accepted == from {
+			return nil
+		}
+	}
+	return fmt.Errorf("Namespace %s doesn't accept mirrors from namespace %s (%s)", ns, from, anno.V1AcceptMirrorsFrom)
+}
+
+// checkMirror returns why the existing object of meta can't mirror source
+// ("namespace/name"): it has parameter annotations of its own, or isn't a
+// mirror of source. Only objects the mirror created are written.
+func checkMirror(kind string, meta metav1.ObjectMeta, source string) error {
+	if meta.Annotations[anno.V1ParamName] != "" || meta.Annotations[anno.AWSParamName] != "" {
+		return fmt.Errorf("%s %s/%s has parameter annotations of its own, so can't mirror %s", kind, meta.Namespace, meta.Name, source)
+	}
+	switch of := meta.Annotations[anno.V1MirrorOf]; of {
+	case source:
+		return nil
+	case "":
+		return fmt.Errorf("%s %s/%s already exists and isn't a mirror, so can't mirror %s", kind, meta.Namespace, meta.Name, source)
+	default:
+		return fmt.Errorf("%s %s/%s already mirrors %s", kind, meta.Namespace, meta.Name, of)
+	}
+}
+
+// markMirror records on the object of meta that it mirrors source and which keys
+// it was written, returning the keys of the previous sync that are gone
+func markMirror(meta *metav1.ObjectMeta, source string, keys []string) []string {
+	current := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		current[k] = true
+	}
+	stale := []string{}
+	for _, k := range anno.List(meta.Annotations, anno.V1ManagedKeys) {
+		if !current[k] {
+			stale = append(stale, k)
+		}
+	}
+
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[anno.V1MirrorOf] = source
+	meta.Annotations[anno.V1ManagedKeys] = strings.Join(keys, ",")
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	meta.Labels[anno.V1ManagedLabel] = "true"
+	return stale
+}
+
+// mirrorConfigMap writes the keys obj was synced with to the ConfigMap of the same
+// name in each of its mirror-namespaces that accepts them, creating those that
+// are missing. Other keys of a mirror are left alone; keys it was written by a
+// previous sync that obj no longer has are removed. Each mirror succeeds or fails on its own, and
+// is recorded in summary as a ConfigMap of its own.
+func (c *Controller) mirrorConfigMap(cli kubernetes.Interface, obj *configmap.ConfigMap, defaults *namespaceDefaults, summary *Summary) {
+	source := obj.Namespace + "/" + obj.Name
+	keys := obj.ManagedKeys()
+	for _, ns := range mirrorNamespaces(obj.ConfigMap.ObjectMeta) {
+		err := func() error {
+			if err := acceptsMirror(ns, obj.Namespace, defaults); err != nil {
+				return err
+			}
+			cm, err := cli.CoreV1().ConfigMaps(ns).Get(obj.Name, metav1.GetOptions{})
+			create := apierrors.IsNotFound(err)
+			if create {
+				cm, err = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: obj.Name}}, nil
+			}
+			if err != nil {
+				return err
+			}
+			if !create {
+				if err := checkMirror("ConfigMap", cm.ObjectMeta, source); err != nil {
+					return err
+				}
+			}
+
+			for _, k := range markMirror(&cm.ObjectMeta, source, keys) {
+				delete(cm.Data, k)
+			}
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			for _, k := range keys {
+				cm.Data[k] = obj.ConfigMap.Data[k]
+			}
+
+			if create {
+				_, err = cli.CoreV1().ConfigMaps(ns).Create(cm)
+			} else {
+				_, err = cli.CoreV1().ConfigMaps(ns).Update(cm)
+			}
+			return err
+		}()
+		if err != nil {
+			log.Warnf("Failed to mirror %s to namespace %s: %s", source, ns, err)
+		} else {
+			log.Infof("Mirrored %s to namespace %s", source, ns)
+		}
+		summary.add("ConfigMap", ns, obj.Name, err)
+	}
+}
+
+// mirrorSecret is mirrorConfigMap for Secrets. Created mirrors have the type of obj.
+func (c *Controller) mirrorSecret(cli kubernetes.Interface, obj *secret.Secret, defaults *namespaceDefaults, summary *Summary) {
+	source := obj.Namespace + "/" + obj.Name
+	keys := obj.ManagedKeys()
+	for _, ns := range mirrorNamespaces(obj.Secret.ObjectMeta) {
+		err := func() error {
+			if err := acceptsMirror(ns, obj.Namespace, defaults); err != nil {
+				return err
+			}
+			sec, err := cli.CoreV1().Secrets(ns).Get(obj.Name, metav1.GetOptions{})
+			create := apierrors.IsNotFound(err)
+			if create {
+				sec, err = &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: obj.Name}, Type: obj.Secret.Type}, nil
+			}
+			if err != nil {
+				return err
+			}
+			if !create {
+				if err := checkMirror("Secret", sec.ObjectMeta, source); err != nil {
+					return err
+				}
+			}
+
+			for _, k := range markMirror(&sec.ObjectMeta, source, keys) {
+				delete(sec.Data, k)
+			}
+			if sec.Data == nil {
+				sec.Data = make(map[string][]byte)
+			}
+			for _, k := range keys {
+				if v, ok := obj.Secret.StringData[k]; ok {
+					sec.Data[k] = []byte(v)
+				} else {
+					sec.Data[k] = obj.Secret.Data[k]
+				}
+			}
+
+			if create {
+				_, err = cli.CoreV1().Secrets(ns).Create(sec)
+			} else {
+				_, err = cli.CoreV1().Secrets(ns).Update(sec)
+			}
+			return err
+		}()
+		if err != nil {
+			log.Warnf("Failed to mirror %s to namespace %s: %s", source, ns, err)
+		} else {
+			log.Infof("Mirrored %s to namespace %s", source, ns)
+		}
+		summary.add("Secret", ns, obj.Name, err)
+	}
+}
@@ -0,0 +1,130 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMirrorConfigMap(t *testing.T) {
+	annotations := testutil.Annotations("/shared", "StringList")
+	annotations[anno.V1MirrorNamespaces] = "team-a, team-b, namespace, team-c, team-a, team-d"
+
+	existing := testutil.ConfigMap("team-b", "shared", map[string]string{
+		anno.V1MirrorOf:    "namespace/shared",
+		anno.V1ManagedKeys: "StringList,stale",
+		"owner":            "team-b",
+	})
+	existing.Data = map[string]string{"stale": "old", "local": "kept"}
+	other := testutil.ConfigMap("team-c", "shared", testutil.Annotations("/team-c", "String"))
+
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "shared", annotations), existing, other,
+		namespace("team-a", map[string]string{anno.V1AcceptMirrorsFrom: "other, namespace"}),
+		namespace("team-b", map[string]string{anno.V1AcceptMirrorsFrom: "*"}),
+		namespace("team-c", map[string]string{anno.V1AcceptMirrorsFrom: "*"}),
+		namespace("team-d", nil))
+	p := &testutil.Provider{Values: map[string]string{"/shared": "a=1,b=2", "/team-c": "own"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	// The source, team-c's own ConfigMap and the mirrors in team-a and team-b
+	assert.Equal(t, 4, summary.Synced)
+	assert.Equal(t, 2, summary.Failed)
+	expected := map[string]string{"StringList": "a=1,b=2", "a": "1", "b": "2"}
+
+	created, err := cli.CoreV1().ConfigMaps("team-a").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, expected, created.Data)
+	assert.Equal(t, "namespace/shared", created.ObjectMeta.Annotations[anno.V1MirrorOf])
+	assert.Equal(t, "StringList,a,b", created.ObjectMeta.Annotations[anno.V1ManagedKeys])
+	assert.Equal(t, "true", created.ObjectMeta.Labels[anno.V1ManagedLabel])
+
+	updated, err := cli.CoreV1().ConfigMaps("team-b").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	expected["local"] = "kept"
+	assert.Equal(t, expected, updated.Data)
+	assert.Equal(t, "team-b", updated.ObjectMeta.Annotations["owner"])
+
+	// team-c's ConfigMap has a parameter of its own, so is left alone
+	failed := map[string]string{}
+	for _, res := range summary.Resources {
+		if res.Status == "failed" {
+			failed[res.Namespace] = res.Error
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"team-c": "ConfigMap team-c/shared has parameter annotations of its own, so can't mirror namespace/shared",
+		"team-d": "Namespace team-d doesn't accept mirrors from namespace namespace (aws-ssm/accept-mirrors-from)",
+	}, failed)
+	cm, err := cli.CoreV1().ConfigMaps("team-c").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "own"}, cm.Data)
+
+	// team-d didn't opt in, so nothing is created there
+	_, err = cli.CoreV1().ConfigMaps("team-d").Get("shared", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestMirrorSecret(t *testing.T) {
+	annotations := testutil.Annotations("/shared", "String")
+	annotations[anno.V1MirrorNamespaces] = "team-a, team-c"
+	source := testutil.Secret("namespace", "shared", annotations)
+	source.Type = v1.SecretTypeOpaque
+
+	mirrored := testutil.Secret("team-b", "shared", map[string]string{anno.V1MirrorOf: "other/shared"})
+	annotations = testutil.Annotations("/other", "String")
+	annotations[anno.V1MirrorNamespaces] = "team-b"
+	conflict := testutil.Secret("other2", "shared", annotations)
+
+	// An unrelated Secret of the same name
+	unrelated := testutil.Secret("team-c", "shared", nil)
+	unrelated.Data = map[string][]byte{"token": []byte("team-c")}
+
+	accept := map[string]string{anno.V1AcceptMirrorsFrom: "*"}
+	cli := testutil.NewKubeClient(source, mirrored, conflict, unrelated,
+		namespace("team-a", accept), namespace("team-b", accept), namespace("team-c", accept))
+	p := &testutil.Provider{Values: map[string]string{"/shared": "secret", "/other": "other"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.Synced)
+	assert.Equal(t, 2, summary.Failed)
+
+	sec, err := cli.CoreV1().Secrets("team-a").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"String": []byte("secret")}, sec.Data)
+	assert.Equal(t, v1.SecretTypeOpaque, sec.Type)
+
+	// Already a mirror of another Secret
+	sec, err = cli.CoreV1().Secrets("team-b").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, sec.Data)
+
+	// Not a mirror at all
+	sec, err = cli.CoreV1().Secrets("team-c").Get("shared", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"token": []byte("team-c")}, sec.Data)
+}
@@ -0,0 +1,190 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// resolveParamName reads the parameter name of an object with a param-name-from
+// annotation from the referenced ConfigMap key, in the object's namespace, and
+// sets it as the object's aws-param-name (in a copy of its annotations, which
+// are shared with the list it came from) for the read. It's not written back to
+// the object (see restoreParamName). Other objects are left alone.
+func (c *Controller) resolveParamName(cli kubernetes.Interface, kind string, meta *metav1.ObjectMeta) error {
+	key := ResourceKey{Kind: kind, Namespace: meta.Namespace, Name: meta.Name}
+	name, err := c.paramNameFrom(cli, meta)
+	c.recordNameFrom(key, name)
+	if err != nil || name == "" {
+		return err
+	}
+
+	meta.Annotations = withParamName(meta.Annotations, name)
+	return nil
+}
+
+// withParamNameFrom is resolveParamName for an object read in one go (see Preview
+// and SyncObject): the name is set in a copy of obj, and isn't recorded
+func (c *Controller) withParamNameFrom(cli kubernetes.Interface, obj runtime.Object, meta *metav1.ObjectMeta) (runtime.Object, error) {
+	name, err := c.paramNameFrom(cli, meta)
+	if err != nil || name == "" {
+		return obj, err
+	}
+	meta.Annotations = withParamName(meta.Annotations, name)
+	obj = obj.DeepCopyObject()
+	accessor, err := apimeta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor.SetAnnotations(meta.Annotations)
+	return obj, nil
+}
+
+// withParamName returns a copy of annotations with the aws-param-name name
+func withParamName(annotations map[string]string, name string) map[string]string {
+	result := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		result[k] = v
+	}
+	delete(result, anno.AWSParamName)
+	result[anno.V1ParamName] = name
+	return result
+}
+
+// restoreParamName sets the parameter name annotations of an object read with
+// resolveParamName back to listed, those of the object as it was listed, before
+// it's written. The name read is only used for the sync, so an object whose
+// param-name-from is removed doesn't carry on reading the last name read.
+func restoreParamName(annotations map[string]string, listed map[string]string) {
+	if name, _, _ := anno.ParamNameFrom(listed); name == "" || annotations == nil {
+		return
+	}
+	for _, k := range []string{anno.V1ParamName, anno.AWSParamName} {
+		if v, ok := listed[k]; ok {
+			annotations[k] = v
+		} else {
+			delete(annotations, k)
+		}
+	}
+}
+
+// paramNameFrom returns the parameter name in the ConfigMap key referenced by
+// the param-name-from annotation, or "" if there isn't one
+func (c *Controller) paramNameFrom(cli kubernetes.Interface, meta *metav1.ObjectMeta) (string, error) {
+	name, key, err := anno.ParamNameFrom(meta.Annotations)
+	if err != nil || name == "" {
+		return "", err
+	}
+	if cli == nil {
+		return "", fmt.Errorf("%s requires a Kubernetes client", anno.V1ParamNameFrom)
+	}
+
+	cm, err := cli.CoreV1().ConfigMaps(meta.Namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("ConfigMap %s/%s (%s) doesn't exist", meta.Namespace, name, anno.V1ParamNameFrom)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to read ConfigMap %s/%s (%s): %s", meta.Namespace, name, anno.V1ParamNameFrom, err)
+	}
+	value, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("ConfigMap %s/%s has no key '%s' (%s)", meta.Namespace, name, key, anno.V1ParamNameFrom)
+	}
+	if value = strings.TrimSpace(value); value == "" {
+		return "", fmt.Errorf("Key '%s' of ConfigMap %s/%s is empty (%s)", key, meta.Namespace, name, anno.V1ParamNameFrom)
+	}
+	return value, nil
+}
+
+// recordNameFrom remembers the parameter name last read for an object with
+// param-name-from ("" if it couldn't be read), to tell which changes to the
+// referenced ConfigMap matter (see syncNameFrom)
+func (c *Controller) recordNameFrom(key ResourceKey, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == "" {
+		delete(c.namesFrom, key)
+		return
+	}
+	if c.namesFrom == nil {
+		c.namesFrom = make(map[ResourceKey]string)
+	}
+	c.namesFrom[key] = name
+}
+
+// syncNameFrom re-syncs the objects (from the index) whose parameter name is read from
+// cm, if the event changed the name they'd read. Their own updates don't change it,
+// so an object that reads its own keys isn't re-synced over and over.
+func (c *Controller) syncNameFrom(cli kubernetes.Interface, eventType watch.EventType, cm *v1.ConfigMap) {
+	refs := c.Index.LookupNameFrom(cm.Namespace, cm.Name)
+	if len(refs) == 0 {
+		return
+	}
+
+	changed := make(map[ResourceKey]bool)
+	c.mu.Lock()
+	for key, dataKey := range refs {
+		name := ""
+		if eventType != watch.Deleted {
+			name = strings.TrimSpace(cm.Data[dataKey])
+		}
+		if c.namesFrom[key] != name {
+			changed[key] = true
+		}
+	}
+	c.mu.Unlock()
+
+	cms := []v1.ConfigMap{}
+	secs := []v1.Secret{}
+	for _, key := range sortedKeys(changed) {
+		var err error
+		switch key.Kind {
+		case "ConfigMap":
+			var obj *v1.ConfigMap
+			if obj, err = cli.CoreV1().ConfigMaps(key.Namespace).Get(key.Name, metav1.GetOptions{}); err == nil {
+				cms = append(cms, *obj)
+			}
+		case "Secret":
+			var obj *v1.Secret
+			if obj, err = cli.CoreV1().Secrets(key.Namespace).Get(key.Name, metav1.GetOptions{}); err == nil {
+				secs = append(secs, *obj)
+			}
+		}
+		if apierrors.IsNotFound(err) {
+			c.Index.Delete(key)
+		} else if err != nil {
+			log.Warnf("Failed to get %s %s/%s: %s", key.Kind, key.Namespace, key.Name, err)
+		}
+	}
+	if len(cms) == 0 && len(secs) == 0 {
+		return
+	}
+
+	log.Infof("%s/%s changed; re-syncing the %d configmaps and %d secrets whose parameter name it has", cm.Namespace, cm.Name, len(cms), len(secs))
+	c.syncConfigMaps(cli, cms, nil)
+	c.syncSecrets(cli, secs, nil)
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// nameFromAnnotations reads the name of a String parameter from app-config/db-param
+func nameFromAnnotations() map[string]string {
+	return map[string]string{anno.V1ParamType: "String", anno.V1ParamNameFrom: "app-config/db-param"}
+}
+
+func TestParamNameFrom(t *testing.T) {
+	ref := testutil.ConfigMap("namespace", "app-config", nil)
+	ref.Data = map[string]string{"db-param": " /app/db/host\n"}
+	// The legacy name is replaced
+	annotations := nameFromAnnotations()
+	annotations[anno.AWSParamName] = "/app/old"
+	cli := testutil.NewKubeClient(ref, testutil.Secret("namespace", "foo", annotations))
+	p := &testutil.Provider{Values: map[string]string{"/app/db/host": "10.0.1.10"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Synced)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, []string{"/app/db/host"}, p.Requested)
+
+	sec, err := cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "10.0.1.10"}, sec.StringData)
+	// The name read isn't written back
+	assert.NotContains(t, sec.ObjectMeta.Annotations, anno.V1ParamName)
+	assert.Equal(t, "/app/old", sec.ObjectMeta.Annotations[anno.AWSParamName])
+	assert.Equal(t, "app-config/db-param", sec.ObjectMeta.Annotations[anno.V1ParamNameFrom])
+
+	// Nor by SyncObject
+	_, err = c.SyncObject(cli, sec)
+	require.NoError(t, err)
+	sec, err = cli.CoreV1().Secrets("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, sec.ObjectMeta.Annotations, anno.V1ParamName)
+
+	// Without param-name-from, the object's own name is read again
+	delete(sec.ObjectMeta.Annotations, anno.V1ParamNameFrom)
+	_, err = cli.CoreV1().Secrets("namespace").Update(sec)
+	require.NoError(t, err)
+	p.Requested = nil
+	summary, err = c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, []string{"/app/old"}, p.Requested)
+}
+
+func TestParamNameFromErrors(t *testing.T) {
+	tests := []struct {
+		data     map[string]string
+		expected string
+	}{
+		{nil, "ConfigMap namespace/app-config (aws-ssm/param-name-from) doesn't exist"},
+		{map[string]string{"other": "/app/db/host"}, "ConfigMap namespace/app-config has no key 'db-param' (aws-ssm/param-name-from)"},
+		{map[string]string{"db-param": " "}, "Key 'db-param' of ConfigMap namespace/app-config is empty (aws-ssm/param-name-from)"},
+	}
+	for _, test := range tests {
+		cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", nameFromAnnotations()))
+		if test.data != nil {
+			ref := testutil.ConfigMap("namespace", "app-config", nil)
+			ref.Data = test.data
+			cli = testutil.NewKubeClient(ref, testutil.ConfigMap("namespace", "foo", nameFromAnnotations()))
+		}
+		p := &testutil.Provider{Values: map[string]string{"/app/db/host": "10.0.1.10"}}
+		c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		require.Equal(t, 1, summary.Failed, test.expected)
+		assert.Equal(t, test.expected, summary.Resources[0].Error)
+		assert.Empty(t, p.Requested)
+	}
+}
+
+func TestSyncNameFrom(t *testing.T) {
+	ref := testutil.ConfigMap("namespace", "app-config", nil)
+	ref.Data = map[string]string{"db-param": "/app/db/host", "other": "x"}
+	cli := testutil.NewKubeClient(ref, testutil.ConfigMap("namespace", "foo", nameFromAnnotations()))
+	p := &testutil.Provider{Values: map[string]string{"/app/db/host": "10.0.1.10", "/app/replica/host": "10.0.1.11"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+	_, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, map[ResourceKey]string{{Kind: "ConfigMap", Namespace: "namespace", Name: "foo"}: "db-param"}, c.Index.LookupNameFrom("namespace", "app-config"))
+
+	// Other keys changing doesn't matter
+	ref.Data["other"] = "y"
+	c.syncNameFrom(cli, watch.Modified, ref)
+	assert.Equal(t, []string{"/app/db/host"}, p.Requested)
+
+	// The referenced key changing does
+	ref.Data["db-param"] = "/app/replica/host"
+	_, err = cli.CoreV1().ConfigMaps("namespace").Update(ref)
+	require.NoError(t, err)
+	c.syncNameFrom(cli, watch.Modified, ref)
+	assert.Equal(t, []string{"/app/db/host", "/app/replica/host"}, p.Requested)
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.11", cm.Data["String"])
+
+	// Once the referencing object is synced, its own update isn't a change
+	c.syncNameFrom(cli, watch.Modified, ref)
+	assert.Len(t, p.Requested, 2)
+
+	// Deleting the referenced ConfigMap fails the object
+	require.NoError(t, cli.CoreV1().ConfigMaps("namespace").Delete("app-config", &metav1.DeleteOptions{}))
+	c.syncNameFrom(cli, watch.Deleted, ref)
+	cm, err = cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "ConfigMap namespace/app-config (aws-ssm/param-name-from) doesn't exist", cm.ObjectMeta.Annotations[anno.V1LastError])
+}
+
+func TestPreviewParamNameFrom(t *testing.T) {
+	ref := testutil.ConfigMap("namespace", "app-config", nil)
+	ref.Data = map[string]string{"db-param": "/app/db/host"}
+	cli := testutil.NewKubeClient(ref)
+	c := &Controller{Provider: &testutil.Provider{Values: map[string]string{"/app/db/host": "10.0.1.10"}}}
+
+	obj := testutil.ConfigMap("namespace", "foo", nameFromAnnotations())
+	res := c.Preview(cli, obj, false)
+	require.NotNil(t, res)
+	assert.Empty(t, res.Error)
+	assert.Equal(t, "/app/db/host", res.ParamName)
+	assert.Equal(t, map[string]string{"String": "10.0.1.10"}, res.Data)
+	// obj isn't changed
+	assert.NotContains(t, obj.ObjectMeta.Annotations, anno.V1ParamName)
+}
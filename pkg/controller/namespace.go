@@ -0,0 +1,170 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespaceDefaults reads the aws-ssm/default-* annotations of each namespace
+// once per sync
+type namespaceDefaults struct {
+	cli         kubernetes.Interface
+	annotations map[string]map[string]string
+}
+
+func newNamespaceDefaults(cli kubernetes.Interface) *namespaceDefaults {
+	return &namespaceDefaults{cli: cli, annotations: make(map[string]map[string]string)}
+}
+
+func (d *namespaceDefaults) get(namespace string, key string) string {
+	if d.cli == nil {
+		// No cluster (e.g., a preview): the flags apply
+		return ""
+	}
+	annotations, ok := d.annotations[namespace]
+	if !ok {
+		ns, err := d.cli.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			// e.g., no RBAC for namespaces: the flags apply
+			log.Debugf("Failed to read the defaults of namespace %s: %s", namespace, err)
+		} else {
+			annotations = ns.ObjectMeta.Annotations
+		}
+		d.annotations[namespace] = annotations
+	}
+	return annotations[key]
+}
+
+// providerFor returns the provider for an object. The region and role come from
+// the object's annotations, then its namespace's defaults; if neither sets
+// them, c.Provider (configured by the flags) is used.
+func (c *Controller) providerFor(meta metav1.ObjectMeta, defaults *namespaceDefaults) (provider.Provider, error) {
+	if c.ProviderFor == nil {
+		return c.Provider, nil
+	}
+	if meta.Annotations[anno.V1ParamName] == "" && meta.Annotations[anno.AWSParamName] == "" {
+		// Irrelevant; don't look up its namespace
+		return c.Provider, nil
+	}
+
+	region := meta.Annotations[anno.V1Region]
+	if region == "" {
+		region = defaults.get(meta.Namespace, anno.V1DefaultRegion)
+	}
+	roleARN := meta.Annotations[anno.V1RoleARN]
+	if accountID := meta.Annotations[anno.V1AccountID]; roleARN == "" && accountID != "" {
+		var err error
+		if roleARN, err = c.accountRoleARN(accountID); err != nil {
+			return nil, err
+		}
+	}
+	if roleARN == "" {
+		roleARN = defaults.get(meta.Namespace, anno.V1DefaultRoleARN)
+	}
+	if region == "" && roleARN == "" {
+		return c.Provider, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := region + "|" + roleARN
+	if p, ok := c.providers[key]; ok {
+		return p, nil
+	}
+	p, err := c.ProviderFor(region, roleARN)
+	if err != nil {
+		return nil, err
+	}
+	if c.providers == nil {
+		c.providers = make(map[string]provider.Provider)
+	}
+	c.providers[key] = p
+	return p, nil
+}
+
+// basePathFor returns the base path of the relative parameter names of an
+// object: its namespace's aws-ssm/default-base-path, or else BasePath (-base-path)
+func (c *Controller) basePathFor(meta metav1.ObjectMeta, defaults *namespaceDefaults) (string, error) {
+	if meta.Annotations[anno.V1ParamName] == "" && meta.Annotations[anno.AWSParamName] == "" {
+		// Irrelevant; don't look up its namespace
+		return c.BasePath, nil
+	}
+	base := defaults.get(meta.Namespace, anno.V1DefaultBasePath)
+	if base == "" {
+		return c.BasePath, nil
+	}
+	if !strings.HasPrefix(base, "/") {
+		return "", fmt.Errorf("Invalid %s '%s' of namespace %s (must start with /)", anno.V1DefaultBasePath, base, meta.Namespace)
+	}
+	return base, nil
+}
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// accountRoleARN renders AssumeRoleTemplate for an aws-ssm/account-id annotation
+func (c *Controller) accountRoleARN(accountID string) (string, error) {
+	if c.AssumeRoleTemplate == nil {
+		return "", fmt.Errorf("%s requires -assume-role-template", anno.V1AccountID)
+	}
+	if !accountIDPattern.MatchString(accountID) {
+		return "", fmt.Errorf("Invalid %s '%s' (expected 12 digits)", anno.V1AccountID, accountID)
+	}
+	var buf bytes.Buffer
+	if err := c.AssumeRoleTemplate.Execute(&buf, struct{ AccountID string }{accountID}); err != nil {
+		return "", fmt.Errorf("Failed to render -assume-role-template for account %s: %s", accountID, err)
+	}
+	return buf.String(), nil
+}
+
+// namespaceGone is true if err means the object's namespace has been deleted,
+// or is being deleted. Retrying can never succeed.
+func namespaceGone(err error) bool {
+	if apierrors.IsNotFound(err) {
+		if status, ok := err.(apierrors.APIStatus); ok && status.Status().Details != nil {
+			return status.Status().Details.Kind == "namespaces"
+		}
+		return false
+	}
+	return apierrors.IsForbidden(err) && strings.Contains(err.Error(), "because it is being terminated")
+}
+
+// dropObject skips an object whose namespace is gone, instead of failing it on
+// every sync while the namespace is torn down. Logged once per namespace.
+func (c *Controller) dropObject(kind string, namespace string, name string, err error) {
+	c.mu.Lock()
+	logged := c.goneNamespaces[namespace]
+	if c.goneNamespaces == nil {
+		c.goneNamespaces = make(map[string]bool)
+	}
+	c.goneNamespaces[namespace] = true
+	c.mu.Unlock()
+
+	if !logged {
+		log.Infof("Namespace %s is gone or terminating; skipping its objects: %s", namespace, err)
+	}
+	log.Debugf("Skipped %s %s/%s: namespace is gone", kind, namespace, name)
+}
@@ -0,0 +1,258 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func namespace(name string, annotations map[string]string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestNamespaceDefaults(t *testing.T) {
+	team := testutil.Annotations("/app/host", "String")
+	own := testutil.Annotations("/app/host", "String")
+	own[anno.V1Region] = "us-east-1"
+	cli := testutil.NewKubeClient(
+		namespace("team-a", map[string]string{
+			anno.V1DefaultRegion:  "eu-west-1",
+			anno.V1DefaultRoleARN: "arn:aws:iam::123456789012:role/team-a",
+		}),
+		namespace("team-b", nil),
+		testutil.ConfigMap("team-a", "team", team),
+		testutil.ConfigMap("team-a", "own", own),
+		testutil.ConfigMap("team-b", "global", testutil.Annotations("/app/host", "String")),
+		testutil.ConfigMap("team-c", "no-namespace", testutil.Annotations("/app/host", "String")),
+		testutil.ConfigMap("team-a", "irrelevant", nil),
+	)
+
+	created := []string{}
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/host": "global"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+		ProviderFor: func(region string, roleARN string) (provider.Provider, error) {
+			created = append(created, region+" "+roleARN)
+			return &testutil.Provider{Values: map[string]string{"/app/host": region + " " + roleARN}}, nil
+		},
+	}
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Failed)
+
+	get := func(namespace string, name string) string {
+		cm, err := cli.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data["String"]
+	}
+	assert.Equal(t, "eu-west-1 arn:aws:iam::123456789012:role/team-a", get("team-a", "team"))
+	assert.Equal(t, "us-east-1 arn:aws:iam::123456789012:role/team-a", get("team-a", "own"))
+	assert.Equal(t, "global", get("team-b", "global"))
+	assert.Equal(t, "global", get("team-c", "no-namespace"))
+
+	// Providers are reused
+	_, err = c.Sync()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"us-east-1 arn:aws:iam::123456789012:role/team-a",
+		"eu-west-1 arn:aws:iam::123456789012:role/team-a",
+	}, created)
+}
+
+func TestNamespaceDefaultsProviderError(t *testing.T) {
+	annotations := testutil.Annotations("/app/host", "String")
+	annotations[anno.V1RoleARN] = "not-an-arn"
+	cli := testutil.NewKubeClient(testutil.ConfigMap("team-a", "foo", annotations))
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/host": "global"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+		ProviderFor: func(region string, roleARN string) (provider.Provider, error) {
+			return nil, errors.New("invalid role ARN")
+		},
+	}
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+}
+
+func TestNamespaceGone(t *testing.T) {
+	assert.True(t, namespaceGone(apierrors.NewNotFound(v1.Resource("namespaces"), "team-a")))
+	assert.True(t, namespaceGone(apierrors.NewForbidden(v1.Resource("configmaps"), "foo",
+		errors.New("unable to create new content in namespace team-a because it is being terminated"))))
+	assert.False(t, namespaceGone(apierrors.NewNotFound(v1.Resource("configmaps"), "foo")))
+	assert.False(t, namespaceGone(apierrors.NewForbidden(v1.Resource("configmaps"), "foo", errors.New("RBAC"))))
+	assert.False(t, namespaceGone(errors.New("namespaces not found")))
+}
+
+func TestNamespaceGoneSkipsObjects(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("team-a", "foo", testutil.Annotations("/app/host", "String")),
+		testutil.Secret("team-a", "bar", testutil.Annotations("/app/host", "String")),
+	)
+	gone := apierrors.NewNotFound(v1.Resource("namespaces"), "team-a")
+	updates := 0
+	cli.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates += 1
+		return true, nil, gone
+	})
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/host": "db"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+
+	for i := 0; i < 2; i++ {
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		assert.Equal(t, 0, summary.Failed)
+		assert.Equal(t, 2, summary.Skipped)
+	}
+	// No last-error annotations are written
+	assert.Equal(t, 4, updates)
+
+	logged := 0
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "Namespace team-a is gone") {
+			logged += 1
+		}
+	}
+	assert.Equal(t, 1, logged)
+}
+
+func TestAccountRoleARN(t *testing.T) {
+	c := &Controller{}
+	_, err := c.accountRoleARN("123456789012")
+	assert.Error(t, err, "no template")
+
+	c.AssumeRoleTemplate = template.Must(template.New("").Parse("arn:aws:iam::{{.AccountID}}:role/ssm-reader"))
+	roleARN, err := c.accountRoleARN("123456789012")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/ssm-reader", roleARN)
+
+	for _, accountID := range []string{"12345678901", "1234567890123", "12345678901a", "123456789012/../x"} {
+		_, err := c.accountRoleARN(accountID)
+		assert.Error(t, err, accountID)
+	}
+
+	c.AssumeRoleTemplate = template.Must(template.New("").Parse("arn:aws:iam::{{.Account}}:role/ssm-reader"))
+	_, err = c.accountRoleARN("123456789012")
+	assert.Error(t, err, "unknown field")
+}
+
+func TestAccountIDProviders(t *testing.T) {
+	account := func(accountID string) map[string]string {
+		annotations := testutil.Annotations("/app/host", "String")
+		annotations[anno.V1AccountID] = accountID
+		return annotations
+	}
+	explicit := account("111111111111")
+	explicit[anno.V1RoleARN] = "arn:aws:iam::111111111111:role/custom"
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("team-a", "one", account("111111111111")),
+		testutil.ConfigMap("team-b", "two", account("111111111111")),
+		testutil.ConfigMap("team-a", "three", account("222222222222")),
+		testutil.ConfigMap("team-a", "explicit", explicit),
+	)
+
+	created := []string{}
+	c := &Controller{
+		Provider:           &testutil.Provider{Values: map[string]string{"/app/host": "global"}},
+		KubeGen:            testutil.ClientGenerator{cli},
+		AssumeRoleTemplate: template.Must(template.New("").Parse("arn:aws:iam::{{.AccountID}}:role/ssm-reader")),
+		ProviderFor: func(region string, roleARN string) (provider.Provider, error) {
+			created = append(created, roleARN)
+			return &testutil.Provider{Values: map[string]string{"/app/host": roleARN}}, nil
+		},
+	}
+	for i := 0; i < 2; i++ {
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		assert.Equal(t, 0, summary.Failed)
+	}
+
+	get := func(namespace string, name string) string {
+		cm, err := cli.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data["String"]
+	}
+	assert.Equal(t, "arn:aws:iam::111111111111:role/ssm-reader", get("team-a", "one"))
+	assert.Equal(t, "arn:aws:iam::111111111111:role/ssm-reader", get("team-b", "two"))
+	assert.Equal(t, "arn:aws:iam::222222222222:role/ssm-reader", get("team-a", "three"))
+	assert.Equal(t, "arn:aws:iam::111111111111:role/custom", get("team-a", "explicit"))
+	// One client per account (and role), across objects and syncs
+	assert.ElementsMatch(t, []string{
+		"arn:aws:iam::111111111111:role/ssm-reader",
+		"arn:aws:iam::222222222222:role/ssm-reader",
+		"arn:aws:iam::111111111111:role/custom",
+	}, created)
+}
+
+func TestBasePath(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		namespace("team-a", map[string]string{anno.V1DefaultBasePath: "/team-a/prod"}),
+		namespace("team-b", map[string]string{anno.V1DefaultBasePath: "team-b"}),
+		testutil.ConfigMap("default", "relative", testutil.Annotations("db-host", "String")),
+		testutil.ConfigMap("default", "absolute", testutil.Annotations("/shared/db-host", "String")),
+		testutil.Secret("default", "relative", testutil.Annotations("db-host", "SecureString")),
+		testutil.ConfigMap("team-a", "relative", testutil.Annotations("db-host", "String")),
+		testutil.ConfigMap("team-b", "invalid", testutil.Annotations("db-host", "String")),
+	)
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{
+			"/app/prod/db-host":    "app",
+			"/shared/db-host":      "shared",
+			"/team-a/prod/db-host": "team-a",
+		}},
+		KubeGen:  testutil.ClientGenerator{cli},
+		BasePath: "/app/prod",
+	}
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+
+	get := func(namespace string, name string) string {
+		cm, err := cli.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data["String"]
+	}
+	assert.Equal(t, "app", get("default", "relative"))
+	assert.Equal(t, "shared", get("default", "absolute"))
+	assert.Equal(t, "team-a", get("team-a", "relative"))
+	sec, err := cli.CoreV1().Secrets("default").Get("relative", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app", sec.StringData["SecureString"])
+
+	cm, err := cli.CoreV1().ConfigMaps("team-b").Get("invalid", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Invalid aws-ssm/default-base-path 'team-b' of namespace team-b (must start with /)", cm.ObjectMeta.Annotations[anno.V1LastError])
+}
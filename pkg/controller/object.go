@@ -0,0 +1,94 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FromObject returns a *configmap.ConfigMap or *secret.Secret for a *v1.ConfigMap,
+// *v1.Secret or *unstructured.Unstructured. An unstructured object without a kind
+// (e.g., built by a library caller) is converted to the aws-ssm/target-kind it's
+// annotated with. A target kind that doesn't match the object's kind is an error.
+func FromObject(p provider.Provider, obj runtime.Object) (interface{}, error) {
+	return FromObjectWithBasePath(p, obj, "")
+}
+
+// FromObjectWithBasePath is FromObject, reading relative parameter names (that
+// don't start with "/") under basePath (-base-path)
+func FromObjectWithBasePath(p provider.Provider, obj runtime.Object, basePath string) (interface{}, error) {
+	switch o := obj.(type) {
+	case *v1.ConfigMap:
+		return configmap.FromKubernetesConfigMapWithBasePath(p, *o, basePath)
+	case *v1.Secret:
+		return secret.FromKubernetesSecretWithBasePath(p, *o, basePath)
+	case *unstructured.Unstructured:
+		kind := o.GetKind()
+		if kind == "" {
+			kind = o.GetAnnotations()[anno.V1TargetKind]
+		}
+		switch kind {
+		case "ConfigMap":
+			cm := v1.ConfigMap{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(o.Object, &cm); err != nil {
+				return nil, err
+			}
+			return configmap.FromKubernetesConfigMapWithBasePath(p, cm, basePath)
+		case "Secret":
+			sec := v1.Secret{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(o.Object, &sec); err != nil {
+				return nil, err
+			}
+			return secret.FromKubernetesSecretWithBasePath(p, sec, basePath)
+		case "":
+			return nil, fmt.Errorf("Object has no kind; set the %s annotation", anno.V1TargetKind)
+		}
+		return nil, fmt.Errorf("Unsupported kind '%s' (ConfigMap|Secret)", kind)
+	}
+	return nil, fmt.Errorf("Unsupported object %T", obj)
+}
+
+// writeConfigMap writes obj with the controller's -update-strategy
+func (c *Controller) writeConfigMap(cli kubernetes.Interface, obj *configmap.ConfigMap) (*v1.ConfigMap, error) {
+	switch c.UpdateStrategy {
+	case config.UpdateStrategyPatch:
+		return obj.PatchObject(cli)
+	case config.UpdateStrategyApply:
+		return obj.ApplyObject(cli, c.FieldManager)
+	}
+	return obj.UpdateObject(cli)
+}
+
+// writeSecret writes obj with the controller's -update-strategy
+func (c *Controller) writeSecret(cli kubernetes.Interface, obj *secret.Secret) (*v1.Secret, error) {
+	switch c.UpdateStrategy {
+	case config.UpdateStrategyPatch:
+		return obj.PatchObject(cli)
+	case config.UpdateStrategyApply:
+		return obj.ApplyObject(cli, c.FieldManager)
+	}
+	return obj.UpdateObject(cli)
+}
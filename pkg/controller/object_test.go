@@ -0,0 +1,122 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func untyped(kind string, targetKind string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if kind != "" {
+		u.SetAPIVersion("v1")
+		u.SetKind(kind)
+	}
+	u.SetNamespace("namespace")
+	u.SetName("foo")
+	annotations := testutil.Annotations("foo-param", "String")
+	if targetKind != "" {
+		annotations["aws-ssm/target-kind"] = targetKind
+	}
+	u.SetAnnotations(annotations)
+	return u
+}
+
+func TestFromObject(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	obj, err := FromObject(p, testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	require.NoError(t, err)
+	assert.IsType(t, &configmap.ConfigMap{}, obj)
+
+	obj, err = FromObject(p, testutil.Secret("namespace", "foo", testutil.Annotations("foo-param", "String")))
+	require.NoError(t, err)
+	assert.IsType(t, &secret.Secret{}, obj)
+
+	_, err = FromObject(p, &v1.Pod{})
+	assert.Error(t, err)
+}
+
+func TestFromObjectUnstructured(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+
+	tests := []struct {
+		title      string
+		kind       string
+		targetKind string
+		expected   interface{}
+	}{
+		{"ConfigMap target kind", "", "ConfigMap", &configmap.ConfigMap{}},
+		{"Secret target kind", "", "Secret", &secret.Secret{}},
+		{"kind without target kind", "Secret", "", &secret.Secret{}},
+		{"matching kinds", "ConfigMap", "ConfigMap", &configmap.ConfigMap{}},
+		{"mismatched kinds", "ConfigMap", "Secret", nil},
+		{"no kind", "", "", nil},
+		{"invalid target kind", "", "Deployment", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			obj, err := FromObject(p, untyped(test.kind, test.targetKind))
+			if test.expected == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, test.expected, obj)
+		})
+	}
+}
+
+func TestSyncUpdateStrategy(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{"foo-param": "bar"}}
+	for strategy, verb := range map[string]string{
+		"":                          "update",
+		config.UpdateStrategyUpdate: "update",
+		config.UpdateStrategyPatch:  "patch",
+		// A fake clientset can't send a server-side apply
+		config.UpdateStrategyApply: "patch",
+	} {
+		cli := testutil.NewKubeClient(
+			testutil.ConfigMap("namespace", "foo", testutil.Annotations("foo-param", "String")),
+			testutil.Secret("namespace", "foo", testutil.Annotations("foo-param", "String")),
+		)
+		c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, UpdateStrategy: strategy}
+
+		summary, err := c.Sync()
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Synced)
+
+		writes := map[string]string{}
+		for _, action := range cli.Actions() {
+			// Not the reads, e.g. of the namespace's defaults
+			if _, ok := action.(k8stesting.ListAction); !ok && action.GetVerb() != "get" {
+				writes[action.GetResource().Resource] = action.GetVerb()
+			}
+		}
+		assert.Equal(t, map[string]string{"configmaps": verb, "secrets": verb}, writes, "strategy=%s", strategy)
+	}
+}
@@ -0,0 +1,115 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Preview is what an object would be synced with
+type Preview struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	ParamName string   `json:"paramName,omitempty"`
+	ParamType string   `json:"paramType,omitempty"`
+	Keys      []string `json:"keys"`
+	// Values by key; redacted (see Controller.Preview) unless showSecrets
+	Data  map[string]string `json:"data,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// Preview reads the values obj (see FromObject) would be synced with, using the
+// provider the controller would use for it, without writing anything. The
+// namespace defaults are read with cli, unless it's nil. Values from a Secret,
+// or from a decrypted or SecretsManager param, are redacted unless showSecrets.
+// Objects without parameter annotations return nil.
+func (c *Controller) Preview(cli kubernetes.Interface, obj runtime.Object, showSecrets bool) *Preview {
+	objMeta := metav1.ObjectMeta{}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		objMeta.Namespace = accessor.GetNamespace()
+		objMeta.Name = accessor.GetName()
+		objMeta.Annotations = accessor.GetAnnotations()
+	}
+	res := &Preview{Namespace: objMeta.Namespace, Name: objMeta.Name, Keys: []string{}}
+
+	defaults := newNamespaceDefaults(cli)
+	obj, err := c.withParamNameFrom(cli, obj, &objMeta)
+	var p provider.Provider
+	if err == nil {
+		p, err = c.providerFor(objMeta, defaults)
+	}
+	basePath := ""
+	if err == nil {
+		basePath, err = c.basePathFor(objMeta, defaults)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	o, err := FromObjectWithBasePath(p, obj, basePath)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "Irrelevant ") {
+			return nil
+		}
+		res.Kind = obj.GetObjectKind().GroupVersionKind().Kind
+		res.Error = err.Error()
+		return res
+	}
+
+	data := make(map[string]string)
+	redact := false
+	switch o := o.(type) {
+	case *configmap.ConfigMap:
+		res.Kind = "ConfigMap"
+		res.ParamName, res.ParamType = o.ParamName, o.ParamType
+		redact = o.ParamKey != "" || o.ParamType == "SecretsManager"
+		for k, v := range o.ConfigMap.Data {
+			data[k] = v
+		}
+	case *secret.Secret:
+		res.Kind = "Secret"
+		res.ParamName, res.ParamType = o.ParamName, o.ParamType
+		redact = true
+		for k, v := range o.Secret.Data {
+			data[k] = string(v)
+		}
+		for k, v := range o.Secret.StringData {
+			data[k] = v
+		}
+	}
+
+	res.Data = make(map[string]string, len(data))
+	for k, v := range data {
+		res.Keys = append(res.Keys, k)
+		if redact && !showSecrets {
+			v = redacted(v)
+		}
+		res.Data[k] = v
+	}
+	sort.Strings(res.Keys)
+	return res
+}
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPreview(t *testing.T) {
+	cli := testutil.NewKubeClient()
+	p := &testutil.Provider{
+		Values:      map[string]string{"/app/host": "db.internal", "/app/password": "hunter2"},
+		Directories: map[string]map[string]string{"/app": {"user": "admin", "port": "5432"}},
+	}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+
+	res := c.Preview(nil, testutil.ConfigMap("default", "host", testutil.Annotations("/app/host", "String")), false)
+	require.NotNil(t, res)
+	assert.Equal(t, &Preview{
+		Kind:      "ConfigMap",
+		Namespace: "default",
+		Name:      "host",
+		ParamName: "/app/host",
+		ParamType: "String",
+		Keys:      []string{"String"},
+		Data:      map[string]string{"String": "db.internal"},
+	}, res)
+
+	res = c.Preview(nil, testutil.ConfigMap("default", "app", testutil.Annotations("/app", "Directory")), false)
+	require.NotNil(t, res)
+	assert.Equal(t, []string{"port", "user"}, res.Keys)
+	assert.Equal(t, "admin", res.Data["user"])
+
+	// Nothing is written
+	assert.Empty(t, cli.Actions())
+}
+
+func TestPreviewRedactsSecrets(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{"/app/password": "hunter2"}}
+	c := &Controller{Provider: p}
+	sec := testutil.Secret("default", "password", testutil.Annotations("/app/password", "SecureString"))
+	cm := testutil.ConfigMap("default", "password", testutil.Annotations("/app/password", "SecureString"))
+
+	for _, obj := range []runtime.Object{sec, cm} {
+		res := c.Preview(nil, obj, false)
+		require.NotNil(t, res)
+		assert.Equal(t, []string{"SecureString"}, res.Keys)
+		assert.Equal(t, "<redacted: 7 bytes>", res.Data["SecureString"])
+	}
+
+	res := c.Preview(nil, sec, true)
+	assert.Equal(t, "hunter2", res.Data["SecureString"])
+}
+
+func TestPreviewUsesObjectProvider(t *testing.T) {
+	regional := &testutil.Provider{Values: map[string]string{"/app/host": "eu"}}
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"/app/host": "global"}},
+		ProviderFor: func(region string, roleARN string) (provider.Provider, error) {
+			return regional, nil
+		},
+	}
+	annotations := testutil.Annotations("/app/host", "String")
+	annotations[anno.V1Region] = "eu-west-1"
+
+	// Without a cluster, the namespace defaults are skipped
+	res := c.Preview(nil, testutil.ConfigMap("default", "host", annotations), false)
+	require.NotNil(t, res)
+	assert.Equal(t, "eu", res.Data["String"])
+}
+
+func TestPreviewErrors(t *testing.T) {
+	c := &Controller{Provider: &testutil.Provider{}}
+
+	assert.Nil(t, c.Preview(nil, testutil.ConfigMap("default", "unannotated", nil), false))
+
+	res := c.Preview(nil, testutil.ConfigMap("default", "missing", testutil.Annotations("/missing", "String")), false)
+	require.NotNil(t, res)
+	assert.NotEmpty(t, res.Error)
+	assert.Equal(t, []string{}, res.Keys)
+
+	res = c.Preview(nil, &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "untyped",
+			"annotations": map[string]interface{}{anno.V1ParamName: "/app/host", anno.V1ParamType: "String"},
+		},
+	}}, false)
+	require.NotNil(t, res)
+	assert.Equal(t, "untyped", res.Name)
+	assert.Contains(t, res.Error, "has no kind")
+}
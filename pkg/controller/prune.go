@@ -0,0 +1,108 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PruneResult is what pruning one object removed (or, with dry-run, would remove)
+type PruneResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Managed keys in the object, sorted
+	Removed []string `json:"removed"`
+	DryRun  bool     `json:"dryRun"`
+}
+
+// Prune removes the keys the controller set from a ConfigMap or Secret (kind),
+// leaving the other keys alone, to stop managing it. The keys are those of the
+// managed-keys annotation (i.e., synced with -managed-by-policy=merge); objects
+// without it are an error. The annotation, the checksum and the managed label
+// are removed too. With dryRun, the object isn't written.
+func Prune(cli kubernetes.Interface, kind string, namespace string, name string, dryRun bool) (*PruneResult, error) {
+	res := &PruneResult{Kind: kind, Namespace: namespace, Name: name, Removed: []string{}, DryRun: dryRun}
+	switch kind {
+	case "ConfigMap":
+		cm, err := cli.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		keys, err := managedKeys(kind, cm.ObjectMeta)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if _, ok := cm.Data[k]; ok {
+				delete(cm.Data, k)
+				res.Removed = append(res.Removed, k)
+			}
+		}
+		unmanage(&cm.ObjectMeta)
+		if !dryRun {
+			_, err = cli.CoreV1().ConfigMaps(namespace).Update(cm)
+		}
+		return res, err
+	case "Secret":
+		sec, err := cli.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		keys, err := managedKeys(kind, sec.ObjectMeta)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if _, ok := sec.Data[k]; ok {
+				delete(sec.Data, k)
+				res.Removed = append(res.Removed, k)
+			}
+		}
+		unmanage(&sec.ObjectMeta)
+		if !dryRun {
+			_, err = cli.CoreV1().Secrets(namespace).Update(sec)
+		}
+		return res, err
+	}
+	return nil, fmt.Errorf("Unsupported kind '%s' (ConfigMap|Secret)", kind)
+}
+
+// managedKeys returns the keys of the managed-keys annotation, sorted
+func managedKeys(kind string, meta metav1.ObjectMeta) ([]string, error) {
+	if _, ok := meta.Annotations[anno.V1ManagedKeys]; !ok {
+		return nil, fmt.Errorf("%s %s/%s has no %s annotation, so its keys can't be told apart; only objects synced with -managed-by-policy=merge can be pruned", kind, meta.Namespace, meta.Name, anno.V1ManagedKeys)
+	}
+	if meta.Annotations[anno.V1ParamName] != "" || meta.Annotations[anno.AWSParamName] != "" {
+		log.Warnf("%s %s/%s still has a parameter annotation, so the controller will set its keys again", kind, meta.Namespace, meta.Name)
+	}
+	keys := anno.List(meta.Annotations, anno.V1ManagedKeys)
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// unmanage removes what the controller records about the keys it set
+func unmanage(meta *metav1.ObjectMeta) {
+	delete(meta.Annotations, anno.V1ManagedKeys)
+	delete(meta.Annotations, anno.V1Checksum)
+	delete(meta.Labels, anno.V1ManagedLabel)
+}
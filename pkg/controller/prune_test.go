@@ -0,0 +1,80 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPruneConfigMap(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		cm := testutil.ConfigMap("namespace", "app", map[string]string{
+			anno.V1ManagedKeys: "host,port,gone",
+			anno.V1Checksum:    "abc123",
+			"example.com/team": "data",
+		})
+		cm.ObjectMeta.Labels = map[string]string{anno.V1ManagedLabel: "true", "app": "api"}
+		cm.Data = map[string]string{"host": "db.internal", "port": "5432", "manual": "kept"}
+		cli := testutil.NewKubeClient(cm)
+
+		res, err := Prune(cli, "ConfigMap", "namespace", "app", dryRun)
+		require.NoError(t, err)
+		assert.Equal(t, &PruneResult{Kind: "ConfigMap", Namespace: "namespace", Name: "app", Removed: []string{"host", "port"}, DryRun: dryRun}, res)
+
+		got, err := cli.CoreV1().ConfigMaps("namespace").Get("app", metav1.GetOptions{})
+		require.NoError(t, err)
+		if dryRun {
+			assert.Len(t, got.Data, 3)
+			assert.Equal(t, "true", got.ObjectMeta.Labels[anno.V1ManagedLabel])
+			continue
+		}
+		assert.Equal(t, map[string]string{"manual": "kept"}, got.Data)
+		assert.Equal(t, map[string]string{"example.com/team": "data"}, got.ObjectMeta.Annotations)
+		assert.Equal(t, map[string]string{"app": "api"}, got.ObjectMeta.Labels)
+	}
+}
+
+func TestPruneSecret(t *testing.T) {
+	sec := testutil.Secret("namespace", "app", map[string]string{anno.V1ManagedKeys: "password"})
+	sec.Data = map[string][]byte{"password": []byte("hunter2"), "ca.crt": []byte("manual")}
+	cli := testutil.NewKubeClient(sec)
+
+	res, err := Prune(cli, "Secret", "namespace", "app", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"password"}, res.Removed)
+
+	got, err := cli.CoreV1().Secrets("namespace").Get("app", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"ca.crt": []byte("manual")}, got.Data)
+}
+
+func TestPruneRequiresManagedKeys(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "app", testutil.Annotations("foo-param", "String"))
+	cm.Data = map[string]string{"String": "bar"}
+	cli := testutil.NewKubeClient(cm)
+
+	_, err := Prune(cli, "ConfigMap", "namespace", "app", false)
+	assert.EqualError(t, err, "ConfigMap namespace/app has no aws-ssm/managed-keys annotation, so its keys can't be told apart; only objects synced with -managed-by-policy=merge can be pruned")
+
+	_, err = Prune(cli, "Deployment", "namespace", "app", false)
+	assert.EqualError(t, err, "Unsupported kind 'Deployment' (ConfigMap|Secret)")
+}
@@ -0,0 +1,49 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// markReady records that the initial full sync is complete. Objects that failed
+// or are pending (including those that timed out; see withTimeout) don't hold it back.
+func (c *Controller) markReady() {
+	if atomic.CompareAndSwapInt32(&c.ready, 0, 1) {
+		log.Info("Initial sync complete. Ready")
+	}
+}
+
+// Ready is true once the initial full sync is complete (see Run and RunNoWatch)
+func (c *Controller) Ready() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+// ReadyHandler serves /readyz: 503 until the initial full sync is complete, then 200
+func (c *Controller) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !c.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Initial sync in progress"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+}
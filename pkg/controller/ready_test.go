@@ -0,0 +1,83 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitReady waits up to a second for c to be ready
+func waitReady(c *Controller) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if c.Ready() {
+			return true
+		}
+	}
+	return false
+}
+
+func readyz(c *Controller) int {
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec.Code
+}
+
+func TestReadyAfterInitialSync(t *testing.T) {
+	p := &slowProvider{release: make(chan struct{})}
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "slow", testutil.Annotations("/slow", "String")))
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Interval: time.Hour}
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go c.Run(stopChan)
+
+	// Not ready while the initial sync is waiting on the provider
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, c.Ready())
+	assert.Equal(t, http.StatusServiceUnavailable, readyz(c))
+
+	close(p.release)
+	require.True(t, waitReady(c))
+	assert.Equal(t, http.StatusOK, readyz(c))
+}
+
+func TestReadyDespiteTimeoutsAndFailures(t *testing.T) {
+	p := &slowProvider{release: make(chan struct{})}
+	defer close(p.release)
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "slow", testutil.Annotations("/slow", "String")))
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Interval: time.Hour, ReconcileTimeout: 10 * time.Millisecond}
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go c.RunNoWatch(stopChan)
+
+	// The slow object times out and is retried later, without holding up readiness
+	require.True(t, waitReady(c))
+	assert.Len(t, c.timeouts, 1)
+
+	failing := &testutil.Provider{Values: map[string]string{}}
+	cli = testutil.NewKubeClient(testutil.Secret("namespace", "failing", testutil.Annotations("/failing", "String")))
+	c = &Controller{Provider: failing, KubeGen: testutil.ClientGenerator{cli}, Interval: time.Hour}
+	stopChan2 := make(chan struct{})
+	defer close(stopChan2)
+	go c.Run(stopChan2)
+	require.True(t, waitReady(c))
+}
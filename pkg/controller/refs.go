@@ -0,0 +1,100 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"strings"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/templates"
+)
+
+// paramRefs are the SSM parameters an object's annotations reference
+type paramRefs struct {
+	// Parameter names, without version selectors
+	Names []string
+	// Directory paths ("/app/db"), which reference every parameter below them
+	Paths []string
+	// The ConfigMap (in the object's namespace) and key the parameter name is
+	// read from (param-name-from), if any
+	NameFrom    string
+	NameFromKey string
+}
+
+// references returns the SSM parameters referenced by annotations.
+// SecretsManager secrets aren't SSM parameters and aren't included.
+func references(annotations map[string]string) paramRefs {
+	refs := paramRefs{}
+	name := annotations[anno.V1ParamName]
+	if name == "" {
+		name = annotations[anno.AWSParamName]
+	}
+	ptype := annotations[anno.V1ParamType]
+	if ptype == "" {
+		ptype = annotations[anno.AWSParamType]
+	}
+
+	if name != "" {
+		switch ptype {
+		case "Directory", "DirectoryArchive":
+			for _, ppath := range strings.Split(name, ",") {
+				if ppath = strings.TrimSpace(ppath); ppath != "" {
+					refs.Paths = append(refs.Paths, "/"+strings.Trim(ppath, "/"))
+				}
+			}
+		case "SecretsManager":
+		default:
+			refs.Names = append(refs.Names, provider.Unversioned(name))
+		}
+	}
+
+	if params, err := templates.Params(annotations[anno.V1TemplateParams]); err == nil {
+		for _, param := range params {
+			refs.Names = append(refs.Names, provider.Unversioned(param))
+		}
+	}
+	if cm, key, err := anno.ParamNameFrom(annotations); err == nil {
+		refs.NameFrom, refs.NameFromKey = cm, key
+	}
+	return refs
+}
+
+// Matches is true if the named parameter is referenced
+func (r paramRefs) Matches(name string) bool {
+	for _, n := range r.Names {
+		if n == name {
+			return true
+		}
+	}
+	for _, p := range r.Paths {
+		if strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// referencesAny is true if annotations reference any of the named parameters
+func referencesAny(annotations map[string]string, names []string) bool {
+	refs := references(annotations)
+	for _, name := range names {
+		if refs.Matches(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReferences(t *testing.T) {
+	refs := references(map[string]string{
+		"aws-ssm/aws-param-name":  "/app/db/host:3",
+		"aws-ssm/aws-param-type":  "String",
+		"aws-ssm/template-params": "port=/app/db/port",
+	})
+	assert.Equal(t, []string{"/app/db/host", "/app/db/port"}, refs.Names)
+	assert.True(t, refs.Matches("/app/db/host"))
+	assert.True(t, refs.Matches("/app/db/port"))
+	assert.False(t, refs.Matches("/app/db"))
+
+	refs = references(map[string]string{
+		"aws-ssm/aws-param-name": "app/db/",
+		"aws-ssm/aws-param-type": "Directory",
+	})
+	assert.Equal(t, []string{"/app/db"}, refs.Paths)
+	assert.True(t, refs.Matches("/app/db/host"))
+	assert.True(t, refs.Matches("/app/db/nested/host"))
+	assert.False(t, refs.Matches("/app/dbx/host"))
+
+	refs = references(map[string]string{
+		"aws-ssm/aws-param-name": "/app/common, app/db/",
+		"aws-ssm/aws-param-type": "Directory",
+	})
+	assert.Equal(t, []string{"/app/common", "/app/db"}, refs.Paths)
+	assert.True(t, refs.Matches("/app/common/region"))
+	assert.True(t, refs.Matches("/app/db/host"))
+
+	refs = references(map[string]string{
+		"aws-ssm/aws-param-name": "/app/db/host",
+		"aws-ssm/aws-param-type": "SecretsManager",
+	})
+	assert.False(t, refs.Matches("/app/db/host"))
+}
+
+func TestSyncParameters(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/host", "String")),
+		testutil.ConfigMap("namespace", "port", testutil.Annotations("/app/port", "String")),
+		testutil.Secret("namespace", "app", testutil.Annotations("/app", "Directory")),
+	)
+	p := &testutil.Provider{
+		Values:      map[string]string{"/app/host": "db.internal", "/app/port": "5432"},
+		Directories: map[string]map[string]string{"/app": {"host": "db.internal"}},
+	}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}}
+
+	c.SyncParameters([]string{"/app/host"})
+	assert.ElementsMatch(t, []string{"/app/host", "/app"}, p.Requested)
+
+	host, err := cli.CoreV1().ConfigMaps("namespace").Get("host", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", host.Data["String"])
+	port, err := cli.CoreV1().ConfigMaps("namespace").Get("port", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, port.Data)
+}
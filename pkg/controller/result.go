@@ -0,0 +1,131 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"fmt"
+
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SyncResult is what syncing one object changed, for library callers that
+// record their own events or metrics
+type SyncResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Keys set that weren't in the object before
+	Added []string `json:"added"`
+	// Keys set whose values changed
+	Changed []string `json:"changed"`
+	// Keys left alone, because something else set them
+	Skipped []string `json:"skipped"`
+	// Full names of the parameters read, sorted
+	Params []string `json:"params"`
+	// Version of each String/SecureString/StringList parameter read, by name
+	Versions map[string]int64 `json:"versions,omitempty"`
+}
+
+// SyncObject syncs obj (see FromObject) with the provider the controller would
+// use for it, and writes it with cli. An error means obj wasn't written; objects
+// without parameter annotations are an error too. Unlike a full sync, the
+// -managed-by-policy isn't applied, and nothing is counted in the metrics.
+func (c *Controller) SyncObject(cli kubernetes.Interface, obj runtime.Object) (*SyncResult, error) {
+	objMeta := metav1.ObjectMeta{}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		objMeta.Namespace = accessor.GetNamespace()
+		objMeta.Name = accessor.GetName()
+		objMeta.Annotations = accessor.GetAnnotations()
+	}
+	defaults := newNamespaceDefaults(cli)
+	listed := objMeta.Annotations
+	obj, err := c.withParamNameFrom(cli, obj, &objMeta)
+	var p provider.Provider
+	if err == nil {
+		p, err = c.providerFor(objMeta, defaults)
+	}
+	basePath := ""
+	if err == nil {
+		basePath, err = c.basePathFor(objMeta, defaults)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := FromObjectWithBasePath(p, obj, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SyncResult{}
+	paramName, paramType := "", ""
+	switch o := o.(type) {
+	case *configmap.ConfigMap:
+		if err := c.checkDirectoryKeys(o.ParamType, objMeta.Annotations, len(o.SourceParams())); err != nil {
+			return nil, err
+		}
+		restoreParamName(o.ConfigMap.ObjectMeta.Annotations, listed)
+		if _, err := c.writeConfigMap(cli, o); err != nil {
+			return nil, err
+		}
+		res.Kind, res.Namespace, res.Name = "ConfigMap", o.Namespace, o.Name
+		res.Added, res.Changed = o.KeyChanges()
+		res.Skipped = o.SkippedKeys()
+		res.Params = o.SourceParams()
+		paramName, paramType = o.ParamName, o.ParamType
+	case *secret.Secret:
+		if err := c.checkDirectoryKeys(o.ParamType, objMeta.Annotations, len(o.SourceParams())); err != nil {
+			return nil, err
+		}
+		restoreParamName(o.Secret.ObjectMeta.Annotations, listed)
+		if _, err := c.writeSecret(cli, o); err != nil {
+			return nil, err
+		}
+		res.Kind, res.Namespace, res.Name = "Secret", o.Namespace, o.Name
+		res.Added, res.Changed = o.KeyChanges()
+		res.Skipped = o.SkippedKeys()
+		res.Params = o.SourceParams()
+		paramName, paramType = o.ParamName, o.ParamType
+	default:
+		return nil, fmt.Errorf("Unsupported object %T", o)
+	}
+	res.Versions = paramVersions(p, paramName, paramType)
+	return res, nil
+}
+
+// paramVersions returns the version of the parameter of a String, SecureString
+// or StringList (the pinned one, if it's pinned), by name. Other types have no
+// single version, so return nil. It's informational, so errors are only logged.
+func paramVersions(p provider.Provider, paramName string, paramType string) map[string]int64 {
+	switch paramType {
+	case "String", "SecureString", "StringList":
+	default:
+		return nil
+	}
+	version, err := p.GetParameterVersion(paramName)
+	if err != nil {
+		log.Warnf("Failed to read the version of %s: %s", paramName, err)
+		return nil
+	}
+	return map[string]int64{provider.Unversioned(paramName): version}
+}
@@ -0,0 +1,107 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncObjectString(t *testing.T) {
+	annotations := testutil.Annotations("/app/db/host", "String")
+	annotations[anno.V1ExtraData] = `{"String": "ignored", "port": "5432"}`
+	cm := testutil.ConfigMap("namespace", "db", annotations)
+	cm.Data = map[string]string{"port": "3306"}
+	cli := testutil.NewKubeClient(cm)
+	p := &testutil.Provider{
+		Values:   map[string]string{"/app/db/host": "db.internal"},
+		Versions: map[string]int64{"/app/db/host": 7},
+	}
+	c := &Controller{Provider: p}
+
+	res, err := c.SyncObject(cli, cm.DeepCopy())
+	require.NoError(t, err)
+	assert.Equal(t, &SyncResult{
+		Kind:      "ConfigMap",
+		Namespace: "namespace",
+		Name:      "db",
+		Added:     []string{"String"},
+		Changed:   []string{"port"},
+		Skipped:   []string{},
+		Params:    []string{"/app/db/host"},
+		Versions:  map[string]int64{"/app/db/host": 7},
+	}, res)
+
+	result, err := cli.CoreV1().ConfigMaps("namespace").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"String": "db.internal", "port": "5432"}, result.Data)
+
+	// A resync with a new value
+	p.Values["/app/db/host"] = "db2.internal"
+	res, err = c.SyncObject(cli, result)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, res.Added)
+	assert.Equal(t, []string{"String"}, res.Changed)
+}
+
+func TestSyncObjectDirectory(t *testing.T) {
+	sec := testutil.Secret("namespace", "db", testutil.Annotations("/app/db", "Directory"))
+	sec.Data = map[string][]byte{"host": []byte("db.internal")}
+	cli := testutil.NewKubeClient(sec)
+	p := &testutil.Provider{Directories: map[string]map[string]string{
+		"/app/db": {"host": "db.internal", "password": "hunter2"},
+	}}
+	c := &Controller{Provider: p}
+
+	res, err := c.SyncObject(cli, sec.DeepCopy())
+	require.NoError(t, err)
+	assert.Equal(t, "Secret", res.Kind)
+	assert.Equal(t, []string{"password"}, res.Added)
+	assert.Equal(t, []string{}, res.Changed)
+	assert.Equal(t, []string{"/app/db/host", "/app/db/password"}, res.Params)
+	// Each parameter of a Directory has its own version
+	assert.Nil(t, res.Versions)
+}
+
+func TestSyncObjectStringList(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "hosts", testutil.Annotations("/app/hosts", "StringList"))
+	cli := testutil.NewKubeClient(cm)
+	p := &testutil.Provider{Values: map[string]string{"/app/hosts": "a=1,b=2"}}
+	c := &Controller{Provider: p}
+
+	res, err := c.SyncObject(cli, cm.DeepCopy())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"StringList", "a", "b"}, res.Added)
+	assert.Equal(t, []string{"/app/hosts"}, res.Params)
+	assert.Equal(t, map[string]int64{"/app/hosts": 1}, res.Versions)
+}
+
+func TestSyncObjectErrors(t *testing.T) {
+	cm := testutil.ConfigMap("namespace", "db", testutil.Annotations("/app/db/host", "String"))
+	c := &Controller{Provider: &testutil.Provider{}}
+
+	// Not found: nothing is written
+	_, err := c.SyncObject(testutil.NewKubeClient(cm), cm.DeepCopy())
+	assert.Error(t, err)
+
+	_, err = c.SyncObject(testutil.NewKubeClient(), testutil.ConfigMap("namespace", "other", nil))
+	assert.EqualError(t, err, "Irrelevant ConfigMap")
+}
@@ -0,0 +1,147 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StatusKey is the key of the -status-configmap with the JSON Status
+const StatusKey = "status.json"
+
+// Status is the summary of the controller's state written to -status-configmap
+type Status struct {
+	// When the last sync finished
+	Updated time.Time `json:"updated"`
+	// The results of the last sync
+	Synced    int             `json:"synced"`
+	Skipped   int             `json:"skipped"`
+	Failed    int             `json:"failed"`
+	Pending   int             `json:"pending"`
+	Resources []ResourceState `json:"resources"`
+}
+
+// ResourceState is the result of the last sync of one ConfigMap/Secret, and
+// its history since the controller started
+type ResourceState struct {
+	ResourceStatus
+	// The last successful sync; unset if it hasn't synced yet
+	LastSync *time.Time `json:"lastSync,omitempty"`
+	// Failed syncs since the controller started
+	Errors int `json:"errors"`
+}
+
+// resourceHistory is what the Status records of an object across syncs
+type resourceHistory struct {
+	lastSync time.Time
+	errors   int
+}
+
+// isStatusConfigMap is true for the -status-configmap, which is written by the
+// controller and mustn't be synced
+func (c *Controller) isStatusConfigMap(meta metav1.ObjectMeta) bool {
+	return c.StatusConfigMap != "" && meta.Namespace+"/"+meta.Name == c.StatusConfigMap
+}
+
+// newStatusSummary returns the Summary to record a sync in for the
+// -status-configmap, or nil if there isn't one
+func (c *Controller) newStatusSummary() *Summary {
+	if c.StatusConfigMap == "" {
+		return nil
+	}
+	return &Summary{Resources: []ResourceStatus{}}
+}
+
+// status adds the results of a sync, finished at now, to the history of each
+// object, and returns the Status. Objects summary doesn't have (e.g., because
+// they were deleted, or no longer have aws-ssm annotations) are forgotten.
+func (c *Controller) status(summary *Summary, now time.Time) *Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	history := make(map[ResourceKey]resourceHistory, len(summary.Resources))
+	status := &Status{
+		Updated:   now.UTC(),
+		Synced:    summary.Synced,
+		Skipped:   summary.Skipped,
+		Failed:    summary.Failed,
+		Pending:   summary.Pending,
+		Resources: make([]ResourceState, 0, len(summary.Resources)),
+	}
+	for _, res := range summary.Resources {
+		key := ResourceKey{Kind: res.Kind, Namespace: res.Namespace, Name: res.Name}
+		h := c.statusHistory[key]
+		switch res.Status {
+		case "synced":
+			h.lastSync = now.UTC()
+		case "failed":
+			h.errors += 1
+		}
+		history[key] = h
+
+		state := ResourceState{ResourceStatus: res, Errors: h.errors}
+		if !h.lastSync.IsZero() {
+			lastSync := h.lastSync
+			state.LastSync = &lastSync
+		}
+		status.Resources = append(status.Resources, state)
+	}
+	c.statusHistory = history
+	return status
+}
+
+// writeStatus writes the Status of a sync to the -status-configmap, creating it
+// if it's missing. No-op on a nil Summary; failures are only logged.
+func (c *Controller) writeStatus(cli kubernetes.Interface, summary *Summary) {
+	if summary == nil {
+		return
+	}
+	b, err := json.MarshalIndent(c.status(summary, time.Now()), "", "  ")
+	if err != nil {
+		log.Warnf("Failed to encode the status: %s", err)
+		return
+	}
+
+	parts := strings.SplitN(c.StatusConfigMap, "/", 2)
+	namespace, name := parts[0], parts[1]
+	cm, err := cli.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	create := apierrors.IsNotFound(err)
+	if create {
+		cm, err = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+	}
+	if err == nil {
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[StatusKey] = string(b)
+		if create {
+			_, err = cli.CoreV1().ConfigMaps(namespace).Create(cm)
+		} else {
+			_, err = cli.CoreV1().ConfigMaps(namespace).Update(cm)
+		}
+	}
+	if err != nil {
+		log.Warnf("Failed to write the status to %s: %s", c.StatusConfigMap, err)
+	}
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func readStatus(t *testing.T, cli kubernetes.Interface) *Status {
+	cm, err := cli.CoreV1().ConfigMaps("kube-system").Get("aws-ssm-status", metav1.GetOptions{})
+	require.NoError(t, err)
+	status := &Status{}
+	require.NoError(t, json.Unmarshal([]byte(cm.Data[StatusKey]), status))
+	return status
+}
+
+func TestStatusConfigMap(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "ok", testutil.Annotations("/app/ok", "String")),
+		testutil.Secret("namespace", "broken", testutil.Annotations("/app/missing", "String")),
+	)
+	c := &Controller{
+		Provider:        &testutil.Provider{Values: map[string]string{"/app/ok": "value"}},
+		KubeGen:         testutil.ClientGenerator{cli},
+		Index:           NewIndex(),
+		StatusConfigMap: "kube-system/aws-ssm-status",
+	}
+
+	before := time.Now().Add(-time.Second)
+	c.RunOnce()
+	status := readStatus(t, cli)
+	assert.True(t, status.Updated.After(before))
+	assert.Equal(t, 1, status.Synced)
+	assert.Equal(t, 1, status.Failed)
+	require.Len(t, status.Resources, 2)
+	ok, broken := status.Resources[0], status.Resources[1]
+	assert.Equal(t, ResourceStatus{Kind: "ConfigMap", Namespace: "namespace", Name: "ok", Status: "synced"}, ok.ResourceStatus)
+	require.NotNil(t, ok.LastSync)
+	assert.True(t, ok.LastSync.After(before))
+	assert.Equal(t, 0, ok.Errors)
+	assert.Equal(t, "failed", broken.Status)
+	assert.Contains(t, broken.Error, "/app/missing")
+	assert.Nil(t, broken.LastSync)
+	assert.Equal(t, 1, broken.Errors)
+
+	// Errors add up; the status ConfigMap itself isn't synced, or skipped
+	c.RunOnce()
+	status = readStatus(t, cli)
+	assert.Equal(t, 0, status.Skipped)
+	require.Len(t, status.Resources, 2)
+	assert.Equal(t, 2, status.Resources[1].Errors)
+
+	// Deleted objects are forgotten
+	require.NoError(t, cli.CoreV1().Secrets("namespace").Delete("broken", &metav1.DeleteOptions{}))
+	c.RunOnce()
+	status = readStatus(t, cli)
+	require.Len(t, status.Resources, 1)
+	assert.Equal(t, "ok", status.Resources[0].Name)
+}
+
+func TestStatusConfigMapNotSynced(t *testing.T) {
+	// Even with aws-ssm annotations
+	cli := testutil.NewKubeClient(testutil.ConfigMap("kube-system", "aws-ssm-status", testutil.Annotations("/app/ok", "String")))
+	p := &testutil.Provider{Values: map[string]string{"/app/ok": "value"}}
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex(), StatusConfigMap: "kube-system/aws-ssm-status"}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Empty(t, summary.Resources)
+	assert.Empty(t, p.Requested)
+	cm, err := cli.CoreV1().ConfigMaps("kube-system").Get("aws-ssm-status", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, cm.Data, 1)
+	assert.Contains(t, cm.Data, StatusKey)
+}
+
+func TestNoStatusConfigMap(t *testing.T) {
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "ok", testutil.Annotations("/app/ok", "String")))
+	c := &Controller{Provider: &testutil.Provider{Values: map[string]string{"/app/ok": "value"}}, KubeGen: testutil.ClientGenerator{cli}, Index: NewIndex()}
+	c.RunOnce()
+	configmaps, err := cli.CoreV1().ConfigMaps("kube-system").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, configmaps.Items)
+}
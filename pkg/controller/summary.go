@@ -0,0 +1,75 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+// Summary is the machine-readable result of a single sync (-run-once)
+type Summary struct {
+	Synced    int              `json:"synced"`
+	Skipped   int              `json:"skipped"`
+	Failed    int              `json:"failed"`
+	Pending   int              `json:"pending"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// ResourceStatus is the result of syncing one ConfigMap/Secret
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// "synced", "failed" or "pending"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExitCode is 0 if every resource synced, 1 otherwise
+func (s *Summary) ExitCode() int {
+	if s.Failed > 0 || s.Pending > 0 {
+		return 1
+	}
+	return 0
+}
+
+// skip counts an object without aws-ssm annotations. No-op on a nil Summary.
+func (s *Summary) skip() {
+	if s != nil {
+		s.Skipped += 1
+	}
+}
+
+// add records the result of syncing an object. No-op on a nil Summary.
+func (s *Summary) add(kind string, namespace string, name string, err error) {
+	if s == nil {
+		return
+	}
+	status := ResourceStatus{Kind: kind, Namespace: namespace, Name: name, Status: "synced"}
+	if err != nil {
+		status.Status = "failed"
+		status.Error = err.Error()
+		s.Failed += 1
+	} else {
+		s.Synced += 1
+	}
+	s.Resources = append(s.Resources, status)
+}
+
+// pending records an object that will be synced once its parameters are ready. No-op on a nil Summary.
+func (s *Summary) pending(kind string, namespace string, name string, err error) {
+	if s == nil {
+		return
+	}
+	s.Pending += 1
+	s.Resources = append(s.Resources, ResourceStatus{Kind: kind, Namespace: namespace, Name: name, Status: "pending", Error: err.Error()})
+}
@@ -0,0 +1,115 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type errClientGenerator struct{}
+
+func (errClientGenerator) KubeClient() (kubernetes.Interface, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestSyncSummary(t *testing.T) {
+	cli := testutil.NewKubeClient(
+		testutil.ConfigMap("namespace", "good", testutil.Annotations("good-param", "String")),
+		testutil.ConfigMap("namespace", "unrelated", nil),
+		testutil.Secret("namespace", "bad", testutil.Annotations("missing-param", "String")),
+	)
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"good-param": "foo"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Synced)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 1, summary.ExitCode())
+
+	out, err := json.Marshal(summary)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"synced": 1,
+		"skipped": 1,
+		"failed": 1,
+		"pending": 0,
+		"resources": [
+			{"kind": "ConfigMap", "namespace": "namespace", "name": "good", "status": "synced"},
+			{"kind": "Secret", "namespace": "namespace", "name": "bad", "status": "failed", "error": "Secret namespace/bad, parameter 'missing-param' (String): ParameterNotFound: missing-param"}
+		]
+	}`, string(out))
+}
+
+func TestSyncSummaryAllSynced(t *testing.T) {
+	cli := testutil.NewKubeClient()
+	c := &Controller{
+		Provider: &testutil.Provider{},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.ExitCode())
+
+	out, err := json.Marshal(summary)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"synced": 0, "skipped": 0, "failed": 0, "pending": 0, "resources": []}`, string(out))
+}
+
+func TestSyncClientError(t *testing.T) {
+	c := &Controller{
+		Provider: &testutil.Provider{},
+		KubeGen:  errClientGenerator{},
+	}
+
+	_, err := c.Sync()
+	assert.Error(t, err)
+}
+
+func TestSyncSummaryPending(t *testing.T) {
+	annotations := testutil.Annotations("foo-param", "String")
+	annotations["aws-ssm/min-version"] = "2"
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "foo", annotations))
+	c := &Controller{
+		Provider: &testutil.Provider{Values: map[string]string{"foo-param": "stale"}},
+		KubeGen:  testutil.ClientGenerator{cli},
+	}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Pending)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, "pending", summary.Resources[0].Status)
+	assert.Equal(t, 1, summary.ExitCode())
+
+	// Not written, and not recorded as an error
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, cm.Data)
+	assert.NotContains(t, cm.ObjectMeta.Annotations, "aws-ssm/last-error")
+}
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Longest to wait before retrying an object whose parameters keep timing out
+const maxTimeoutBackoff = 10 * time.Minute
+
+// TimeoutError means an object's parameters weren't read within -reconcile-timeout
+type TimeoutError struct {
+	Timeout time.Duration
+	// When the object is retried
+	RetryAt time.Time
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Reading parameters timed out after %s; retrying at %s", e.Timeout, e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// timeoutBackoff is how long an object that timed out waits before it's read again
+type timeoutBackoff struct {
+	delay time.Duration
+	err   *TimeoutError
+	// Closed once the read that timed out returns
+	finished chan struct{}
+}
+
+// readConfigMap reads the parameters of cm, relative names under basePath (see withTimeout)
+func (c *Controller) readConfigMap(p provider.Provider, cm v1.ConfigMap, basePath string) (*configmap.ConfigMap, error) {
+	if c.ReconcileTimeout <= 0 {
+		return configmap.FromKubernetesConfigMapWithBasePath(p, cm, basePath)
+	}
+	// A read that times out carries on writing into its copy, not the caller's
+	cm = *cm.DeepCopy()
+	var obj *configmap.ConfigMap
+	err := c.withTimeout(ResourceKey{Kind: "ConfigMap", Namespace: cm.Namespace, Name: cm.Name}, provider.Context(p), func(ctx context.Context) (err error) {
+		obj, err = configmap.FromKubernetesConfigMapWithBasePath(provider.WithContext(ctx, p), cm, basePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// readSecret reads the parameters of sec, relative names under basePath (see withTimeout)
+func (c *Controller) readSecret(p provider.Provider, sec v1.Secret, basePath string) (*secret.Secret, error) {
+	if c.ReconcileTimeout <= 0 {
+		return secret.FromKubernetesSecretWithBasePath(p, sec, basePath)
+	}
+	sec = *sec.DeepCopy()
+	var obj *secret.Secret
+	err := c.withTimeout(ResourceKey{Kind: "Secret", Namespace: sec.Namespace, Name: sec.Name}, provider.Context(p), func(ctx context.Context) (err error) {
+		obj, err = secret.FromKubernetesSecretWithBasePath(provider.WithContext(ctx, p), sec, basePath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// withTimeout calls read, giving up with a *TimeoutError after c.ReconcileTimeout.
+// Objects are only written once they're read in full, so a timed-out object is
+// left as it was. read is passed a context of parent that's done at the timeout,
+// which the provider it reads with (see provider.WithContext) stops at: the
+// provider call in flight can't be interrupted, but no more are made, and its
+// result is discarded. The object is retried after a backoff, starting at the timeout and
+// doubling with each timeout in a row; until then, and while the read that timed
+// out is still in its provider call, the last *TimeoutError is returned without
+// calling read, so there's at most one read of each object in flight.
+func (c *Controller) withTimeout(key ResourceKey, parent context.Context, read func(ctx context.Context) error) error {
+	c.mu.Lock()
+	b, ok := c.timeouts[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(b.err.RetryAt) {
+		return b.err
+	}
+	if ok && b.finished != nil {
+		select {
+		case <-b.finished:
+		default:
+			// Still in flight: another timeout in a row
+			return c.timedOut(key, b, ok, b.finished)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, c.ReconcileTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		done <- read(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		c.mu.Lock()
+		delete(c.timeouts, key)
+		c.mu.Unlock()
+		return err
+	case <-ctx.Done():
+	}
+	return c.timedOut(key, b, ok, finished)
+}
+
+// timedOut records another timeout of the object of key, whose read (closing
+// finished when it returns) may still be in flight. b is its previous backoff, if ok.
+func (c *Controller) timedOut(key ResourceKey, b timeoutBackoff, ok bool, finished chan struct{}) error {
+	delay := c.ReconcileTimeout
+	if ok {
+		delay = b.delay * 2
+	}
+	if delay > maxTimeoutBackoff {
+		delay = maxTimeoutBackoff
+	}
+	err := &TimeoutError{Timeout: c.ReconcileTimeout, RetryAt: time.Now().Add(delay)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timeouts == nil {
+		c.timeouts = make(map[ResourceKey]timeoutBackoff)
+	}
+	c.timeouts[key] = timeoutBackoff{delay: delay, err: err, finished: finished}
+	return err
+}
@@ -0,0 +1,159 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// slowProvider blocks reads until release is closed
+type slowProvider struct {
+	provider.NullProvider
+	release  chan struct{}
+	calls    int32
+	tagCalls int32
+}
+
+func (p *slowProvider) GetParameterTags(name string) (map[string]string, error) {
+	atomic.AddInt32(&p.tagCalls, 1)
+	return map[string]string{}, nil
+}
+
+func (p *slowProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	atomic.AddInt32(&p.calls, 1)
+	<-p.release
+	return "value", nil
+}
+
+func TestSyncTimesOutSlowProvider(t *testing.T) {
+	p := &slowProvider{release: make(chan struct{})}
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "slow", testutil.Annotations("/slow", "String")))
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ReconcileTimeout: 10 * time.Millisecond}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Pending)
+	require.Len(t, summary.Resources, 1)
+	assert.Contains(t, summary.Resources[0].Error, "timed out after 10ms")
+
+	// Nothing is written
+	cm, err := cli.CoreV1().ConfigMaps("namespace").Get("slow", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, cm.Data)
+
+	// Not read again until the backoff is over
+	summary, err = c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Pending)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&p.calls))
+
+	close(p.release)
+	key := ResourceKey{Kind: "ConfigMap", Namespace: "namespace", Name: "slow"}
+	<-c.timeouts[key].finished
+	c.timeouts[key].err.RetryAt = time.Now()
+
+	summary, err = c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Synced)
+	assert.Empty(t, c.timeouts)
+	cm, err = cli.CoreV1().ConfigMaps("namespace").Get("slow", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, cm.Data)
+}
+
+func TestWithTimeoutBacksOff(t *testing.T) {
+	c := &Controller{ReconcileTimeout: time.Millisecond}
+	key := ResourceKey{Kind: "Secret", Namespace: "namespace", Name: "slow"}
+	block := make(chan struct{})
+	slow := func(ctx context.Context) error {
+		<-block
+		return nil
+	}
+
+	for _, delay := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond} {
+		if b, ok := c.timeouts[key]; ok {
+			b.err.RetryAt = time.Now()
+		}
+		err := c.withTimeout(key, context.Background(), slow)
+		require.IsType(t, &TimeoutError{}, err)
+		assert.Equal(t, delay, c.timeouts[key].delay)
+	}
+
+	c.timeouts[key] = timeoutBackoff{delay: maxTimeoutBackoff, err: &TimeoutError{}}
+	c.withTimeout(key, context.Background(), slow)
+	assert.Equal(t, maxTimeoutBackoff, c.timeouts[key].delay)
+
+	// A read in time clears the backoff
+	close(block)
+	<-c.timeouts[key].finished
+	c.timeouts[key].err.RetryAt = time.Now()
+	assert.NoError(t, c.withTimeout(key, context.Background(), func(ctx context.Context) error { return nil }))
+	assert.Empty(t, c.timeouts)
+}
+
+func TestWithTimeoutBoundsReadsInFlight(t *testing.T) {
+	c := &Controller{ReconcileTimeout: time.Millisecond}
+	key := ResourceKey{Kind: "Secret", Namespace: "namespace", Name: "slow"}
+	block := make(chan struct{})
+	var reads int32
+	slow := func(ctx context.Context) error {
+		atomic.AddInt32(&reads, 1)
+		<-block
+		return ctx.Err()
+	}
+
+	require.IsType(t, &TimeoutError{}, c.withTimeout(key, context.Background(), slow))
+	// The first read is still in flight, so isn't started again
+	c.timeouts[key].err.RetryAt = time.Now()
+	require.IsType(t, &TimeoutError{}, c.withTimeout(key, context.Background(), slow))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reads))
+	assert.Equal(t, 2*time.Millisecond, c.timeouts[key].delay)
+
+	// Once it's returned, the object is read again
+	close(block)
+	<-c.timeouts[key].finished
+	c.timeouts[key].err.RetryAt = time.Now()
+	c.withTimeout(key, context.Background(), slow)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&reads))
+}
+
+func TestSyncStopsReadingAfterTimeout(t *testing.T) {
+	p := &slowProvider{release: make(chan struct{})}
+	annotations := testutil.Annotations("/slow", "String")
+	annotations[anno.V1ImportTags] = "true"
+	cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "slow", annotations))
+	c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ReconcileTimeout: 10 * time.Millisecond}
+
+	summary, err := c.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Pending)
+
+	// The read in flight returns after the timeout; its next call isn't made
+	close(p.release)
+	key := ResourceKey{Kind: "ConfigMap", Namespace: "namespace", Name: "slow"}
+	<-c.timeouts[key].finished
+	assert.Equal(t, int32(0), atomic.LoadInt32(&p.tagCalls))
+}
@@ -0,0 +1,113 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"time"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// How long to wait before watching again, once a watch fails or ends
+var watchRetryDelay = 5 * time.Second
+
+// syncedObject is what the controller last wrote to an object
+type syncedObject struct {
+	keys     []string
+	checksum string
+}
+
+// recordSynced remembers the keys written to an object, and their checksum
+func (c *Controller) recordSynced(key ResourceKey, keys []string, checksum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.synced == nil {
+		c.synced = make(map[ResourceKey]syncedObject)
+	}
+	c.synced[key] = syncedObject{keys: keys, checksum: checksum}
+}
+
+// editedExternally returns true if the keys the controller set in cm no longer
+// match what it wrote: the checksum annotation (with compute-checksum), or the
+// checksum of the last sync. ConfigMaps this controller hasn't synced yet return
+// false; the next resync takes care of them.
+func (c *Controller) editedExternally(cm *v1.ConfigMap) bool {
+	c.mu.Lock()
+	synced, ok := c.synced[ResourceKey{Kind: "ConfigMap", Namespace: cm.Namespace, Name: cm.Name}]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	want := synced.checksum
+	if anno.Bool(cm.ObjectMeta.Annotations, anno.V1ComputeChecksum, false) && cm.ObjectMeta.Annotations[anno.V1Checksum] != "" {
+		want = cm.ObjectMeta.Annotations[anno.V1Checksum]
+	}
+	return configmap.DataChecksum(cm.Data, synced.keys) != want
+}
+
+// WatchEdits watches ConfigMaps until stopChan is closed, and re-syncs each one
+// as soon as the keys the controller set are edited by someone else, instead
+// of at the next resync. The controller's own updates match what it recorded
+// for them, so they don't trigger a re-sync. Objects whose parameter name is
+// read from a ConfigMap (param-name-from) are re-synced as soon as it changes.
+func (c *Controller) WatchEdits(cli kubernetes.Interface, stopChan <-chan struct{}) {
+	for {
+		w, err := cli.CoreV1().ConfigMaps("").Watch(metav1.ListOptions{})
+		if err != nil {
+			log.Warnf("Failed to watch configmaps: %s", err)
+		} else {
+			c.handleEdits(cli, w, stopChan)
+		}
+
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// handleEdits re-syncs the edited ConfigMaps of w, until it ends or stopChan is closed
+func (c *Controller) handleEdits(cli kubernetes.Interface, w watch.Interface, stopChan <-chan struct{}) {
+	defer w.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			cm, isConfigMap := event.Object.(*v1.ConfigMap)
+			if isConfigMap {
+				c.syncNameFrom(cli, event.Type, cm)
+			}
+			if event.Type != watch.Modified || !isConfigMap || !c.editedExternally(cm) {
+				continue
+			}
+			log.Infof("%s/%s was edited; re-syncing it", cm.Namespace, cm.Name)
+			// Syncing writes into the object, which the watch may share
+			c.syncConfigMaps(cli, []v1.ConfigMap{*cm.DeepCopy()}, nil)
+		}
+	}
+}
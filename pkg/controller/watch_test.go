@@ -0,0 +1,105 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package controller
+
+import (
+	"testing"
+	"time"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// waitForData polls the ConfigMap until its data is want, or a second passes
+func waitForData(t *testing.T, cli kubernetes.Interface, want map[string]string) *v1.ConfigMap {
+	var cm *v1.ConfigMap
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		cm, err = cli.CoreV1().ConfigMaps("namespace").Get("host", metav1.GetOptions{})
+		require.NoError(t, err)
+		if assert.ObjectsAreEqual(want, cm.Data) {
+			break
+		}
+	}
+	return cm
+}
+
+func TestWatchEditsResyncsExternalEdits(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		annotations := testutil.Annotations("/app/host", "String")
+		if checksum {
+			annotations[anno.V1ComputeChecksum] = "true"
+		}
+		cli := testutil.NewKubeClient(testutil.ConfigMap("namespace", "host", annotations))
+		p := &testutil.Provider{Values: map[string]string{"/app/host": "db.internal"}}
+		c := &Controller{Provider: p, KubeGen: testutil.ClientGenerator{cli}, ResyncOnEdit: true}
+		_, err := c.Sync()
+		require.NoError(t, err)
+		requested := len(p.Requested)
+
+		w, err := cli.CoreV1().ConfigMaps("").Watch(metav1.ListOptions{})
+		require.NoError(t, err)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			c.handleEdits(cli, w, stop)
+			close(done)
+		}()
+
+		// Someone else edits a managed key, and adds one of their own
+		cm, err := cli.CoreV1().ConfigMaps("namespace").Get("host", metav1.GetOptions{})
+		require.NoError(t, err)
+		cm.Data = map[string]string{"String": "edited", "theirs": "kept"}
+		_, err = cli.CoreV1().ConfigMaps("namespace").Update(cm)
+		require.NoError(t, err)
+
+		cm = waitForData(t, cli, map[string]string{"String": "db.internal", "theirs": "kept"})
+		assert.Equal(t, map[string]string{"String": "db.internal", "theirs": "kept"}, cm.Data, "checksum=%v", checksum)
+		close(stop)
+		<-done
+
+		// Only the edit was re-synced, not the controller's own update after it
+		assert.Equal(t, requested+1, len(p.Requested), "checksum=%v", checksum)
+	}
+}
+
+func TestEditedExternally(t *testing.T) {
+	c := &Controller{}
+	cm := testutil.ConfigMap("namespace", "host", testutil.Annotations("/app/host", "String"))
+	cm.Data = map[string]string{"String": "db.internal", "theirs": "foo"}
+
+	// Not synced by this controller yet
+	assert.False(t, c.editedExternally(cm))
+
+	key := ResourceKey{Kind: "ConfigMap", Namespace: "namespace", Name: "host"}
+	c.recordSynced(key, []string{"String"}, "")
+	assert.True(t, c.editedExternally(cm))
+
+	c.recordSynced(key, []string{"String"}, configmap.DataChecksum(map[string]string{"String": "db.internal"}, []string{"String"}))
+	assert.False(t, c.editedExternally(cm))
+
+	// Keys the controller didn't set can change
+	cm.Data["theirs"] = "bar"
+	assert.False(t, c.editedExternally(cm))
+	cm.Data["String"] = "edited"
+	assert.True(t, c.editedExternally(cm))
+}
@@ -0,0 +1,140 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// ParameterStoreChange is the EventBridge event for a changed SSM parameter
+const ParameterStoreChange = "Parameter Store Change"
+
+// How long to wait after a failed ReceiveMessage
+var retryDelay = 5 * time.Second
+
+type event struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Detail     struct {
+		Name      string `json:"name"`
+		Operation string `json:"operation"`
+	} `json:"detail"`
+}
+
+// Consumer reads Parameter Store change events from an SQS queue that's the
+// target of an EventBridge rule
+type Consumer struct {
+	SQS      sqsiface.SQSAPI
+	QueueURL string
+}
+
+func NewConsumer(cfg *config.Config) (*Consumer, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(cfg.AWSRegion),
+		Credentials: provider.Credentials(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Consumer{SQS: sqs.New(sess), QueueURL: cfg.SQSQueueURL}, nil
+}
+
+// ParameterName returns the name of the changed parameter in an EventBridge
+// event, or "" if body isn't a Parameter Store change event
+func ParameterName(body string) string {
+	var e event
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return ""
+	}
+	if e.Source != "aws.ssm" || e.DetailType != ParameterStoreChange {
+		return ""
+	}
+	return e.Detail.Name
+}
+
+// Receive waits for a batch of messages and calls handle with the names of the
+// changed parameters. Every message received is deleted once handle returns,
+// including ones that aren't change events.
+func (c *Consumer) Receive(handle func(names []string)) error {
+	out, err := c.SQS.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.QueueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Messages) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	seen := make(map[string]bool)
+	entries := []*sqs.DeleteMessageBatchRequestEntry{}
+	for _, msg := range out.Messages {
+		name := ParameterName(aws.StringValue(msg.Body))
+		if name == "" {
+			log.Warnf("Ignoring SQS message %s: not a %s event", aws.StringValue(msg.MessageId), ParameterStoreChange)
+		} else if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            msg.MessageId,
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+	}
+
+	if len(names) > 0 {
+		handle(names)
+	}
+
+	_, err = c.SQS.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(c.QueueURL),
+		Entries:  entries,
+	})
+	return err
+}
+
+// Run calls handle with each batch of changed parameters until stopChan is closed
+func (c *Consumer) Run(stopChan <-chan struct{}, handle func(names []string)) {
+	log.Infof("Consuming Parameter Store change events from %s", c.QueueURL)
+	for {
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+
+		if err := c.Receive(handle); err != nil {
+			log.Errorf("Failed to receive Parameter Store change events: %s", err)
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func changeEvent(name string) string {
+	return `{
+		"version": "0",
+		"detail-type": "Parameter Store Change",
+		"source": "aws.ssm",
+		"detail": {"operation": "Update", "name": "` + name + `", "type": "String"}
+	}`
+}
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	Batches [][]*sqs.Message
+	Err     error
+	Deleted []string
+}
+
+func (f *fakeSQS) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if len(f.Batches) == 0 {
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	batch := f.Batches[0]
+	f.Batches = f.Batches[1:]
+	return &sqs.ReceiveMessageOutput{Messages: batch}, nil
+}
+
+func (f *fakeSQS) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	for _, e := range in.Entries {
+		f.Deleted = append(f.Deleted, aws.StringValue(e.ReceiptHandle))
+	}
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func message(id string, body string) *sqs.Message {
+	return &sqs.Message{MessageId: aws.String(id), ReceiptHandle: aws.String("handle-" + id), Body: aws.String(body)}
+}
+
+func TestParameterName(t *testing.T) {
+	assert.Equal(t, "/app/db/host", ParameterName(changeEvent("/app/db/host")))
+	assert.Equal(t, "", ParameterName(`{"source": "aws.ec2", "detail-type": "Parameter Store Change"}`))
+	assert.Equal(t, "", ParameterName("not json"))
+}
+
+func TestReceive(t *testing.T) {
+	f := &fakeSQS{Batches: [][]*sqs.Message{{
+		message("1", changeEvent("/app/db/host")),
+		message("2", "garbage"),
+		message("3", changeEvent("/app/db/host")),
+		message("4", changeEvent("/app/db/port")),
+	}}}
+	c := &Consumer{SQS: f, QueueURL: "https://sqs.us-west-2.amazonaws.com/123456789012/ssm-changes"}
+
+	var handled [][]string
+	require.NoError(t, c.Receive(func(names []string) {
+		handled = append(handled, names)
+		assert.Empty(t, f.Deleted, "messages deleted before they were handled")
+	}))
+
+	assert.Equal(t, [][]string{{"/app/db/host", "/app/db/port"}}, handled)
+	assert.Equal(t, []string{"handle-1", "handle-2", "handle-3", "handle-4"}, f.Deleted)
+}
+
+func TestReceiveEmpty(t *testing.T) {
+	c := &Consumer{SQS: &fakeSQS{}}
+	require.NoError(t, c.Receive(func(names []string) {
+		t.Fatal("handle called without messages")
+	}))
+}
+
+func TestRunStopsAfterErrors(t *testing.T) {
+	retryDelay = time.Millisecond
+	c := &Consumer{SQS: &fakeSQS{Err: errors.New("AccessDenied")}}
+
+	stopChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Run(stopChan, func([]string) {})
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(stopChan)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after stopChan was closed")
+	}
+}
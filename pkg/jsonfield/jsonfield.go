@@ -0,0 +1,111 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package jsonfield
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Split separates an optional field selector from a secret name: "name#a.b" -> ("name", "a.b")
+func Split(name string) (string, string) {
+	if i := strings.Index(name, "#"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// Fields returns the top-level fields of a JSON object as strings.
+// ok is false if value isn't a JSON object.
+func Fields(value string) (fields map[string]string, ok bool) {
+	obj, err := decode(value)
+	if err != nil {
+		return nil, false
+	}
+
+	fields = make(map[string]string)
+	for k, v := range obj {
+		fields[k] = stringify(v)
+	}
+	return fields, true
+}
+
+// Get returns the field of a JSON object selected by path. Nested fields are
+// separated by dots ("a.b.c").
+func Get(value string, path string) (string, error) {
+	obj, err := decode(value)
+	if err != nil {
+		return "", fmt.Errorf("Cannot select field '%s': value is not a JSON object", path)
+	}
+
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		v, ok := obj[part]
+		if !ok {
+			return "", fmt.Errorf("Field '%s' not found", strings.Join(parts[:i+1], "."))
+		}
+		if i == len(parts)-1 {
+			return stringify(v), nil
+		}
+		if obj, ok = v.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("Field '%s' is not a JSON object", strings.Join(parts[:i+1], "."))
+		}
+	}
+	return "", fmt.Errorf("Field '%s' not found", path)
+}
+
+// Subset returns a JSON object with only the named top-level fields of value.
+// Every name must be a field of value.
+func Subset(value string, names []string) (string, error) {
+	obj, err := decode(value)
+	if err != nil {
+		return "", fmt.Errorf("Cannot select fields: value is not a JSON object")
+	}
+
+	subset := make(map[string]interface{})
+	for _, name := range names {
+		v, ok := obj[name]
+		if !ok {
+			return "", fmt.Errorf("Field '%s' not found", name)
+		}
+		subset[name] = v
+	}
+	return stringify(subset), nil
+}
+
+func decode(value string) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// stringify returns strings as-is; anything else as JSON
+func stringify(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,95 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package jsonfield
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = `{"username": "admin", "password": "hunter2", "port": 5432, "db": {"host": "10.0.0.1", "opts": {"ssl": true}}}`
+
+func TestSplit(t *testing.T) {
+	for name, exp := range map[string][2]string{
+		"my-secret":          {"my-secret", ""},
+		"my-secret#password": {"my-secret", "password"},
+		"my-secret#db.host":  {"my-secret", "db.host"},
+	} {
+		n, f := Split(name)
+		assert.Equal(t, exp, [2]string{n, f})
+	}
+}
+
+func TestFields(t *testing.T) {
+	fields, ok := Fields(secret)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"username": "admin",
+		"password": "hunter2",
+		"port":     "5432",
+		"db":       `{"host":"10.0.0.1","opts":{"ssl":true}}`,
+	}, fields)
+
+	_, ok = Fields("not json")
+	assert.False(t, ok)
+	_, ok = Fields(`["a", "b"]`)
+	assert.False(t, ok)
+}
+
+func TestGetPresentFields(t *testing.T) {
+	for path, exp := range map[string]string{
+		"password":    "hunter2",
+		"port":        "5432",
+		"db.host":     "10.0.0.1",
+		"db.opts.ssl": "true",
+	} {
+		v, err := Get(secret, path)
+		require.NoError(t, err, path)
+		assert.Equal(t, exp, v)
+	}
+}
+
+func TestGetMissingFields(t *testing.T) {
+	for path, msg := range map[string]string{
+		"nope":          "Field 'nope' not found",
+		"db.nope":       "Field 'db.nope' not found",
+		"password.nope": "Field 'password' is not a JSON object",
+	} {
+		_, err := Get(secret, path)
+		require.Error(t, err, path)
+		assert.Equal(t, msg, err.Error())
+	}
+
+	_, err := Get("plaintext", "password")
+	assert.Error(t, err)
+}
+
+func TestSubset(t *testing.T) {
+	value, err := Subset(secret, []string{"password", "db"})
+	require.NoError(t, err)
+	fields, ok := Fields(value)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"password": "hunter2", "db": `{"host":"10.0.0.1","opts":{"ssl":true}}`}, fields)
+
+	_, err = Subset(secret, []string{"password", "nope"})
+	require.Error(t, err)
+	assert.Equal(t, "Field 'nope' not found", err.Error())
+
+	_, err = Subset("plaintext", []string{"password"})
+	assert.Error(t, err)
+}
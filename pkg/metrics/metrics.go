@@ -0,0 +1,110 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	SyncedResources = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_resources_synced_total",
+		Help: "Number of ConfigMaps/Secrets successfully updated",
+	}, []string{"kind"})
+
+	FailedResources = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_resources_failed_total",
+		Help: "Number of ConfigMaps/Secrets that failed to update",
+	}, []string{"kind"})
+
+	LastSyncFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ssm_last_sync_failed_resources",
+		Help: "Number of ConfigMaps/Secrets that failed to update during the last sync",
+	}, []string{"kind"})
+
+	LastSyncTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ssm_last_sync_timestamp_seconds",
+		Help: "Unix time of the last completed sync",
+	}, []string{"kind"})
+
+	CacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ssm_cache_hits_total",
+		Help: "Number of values served from the cache (-cache-ttl)",
+	})
+
+	CacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ssm_cache_misses_total",
+		Help: "Number of values fetched because they weren't cached (-cache-ttl)",
+	})
+
+	Throttles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_throttled_requests_total",
+		Help: "Number of parameter reads throttled by SSM or KMS, after the AWS SDK's own retries",
+	}, []string{"service"})
+
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_retries_total",
+		Help: "Number of SSM or KMS requests retried after a transient error, by service and error category",
+	}, []string{"service", "category"})
+
+	RetriesExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_retries_exhausted_total",
+		Help: "Number of SSM or KMS requests that still failed with a transient error after every retry, by service and error category",
+	}, []string{"service", "category"})
+
+	DefaultKMSKey = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ssm_default_kms_key_total",
+		Help: "Number of ConfigMap/Secret syncs that decrypted a SecureString with the default KMS key (alias/aws/ssm)",
+	}, []string{"kind"})
+
+	ManagedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ssm_managed_resources",
+		Help: "Number of ConfigMaps/Secrets the controller syncs",
+	}, []string{"kind"})
+
+	ManagedKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ssm_managed_keys_total",
+		Help: "Number of keys the controller writes to ConfigMaps/Secrets, summed over every object",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(SyncedResources, FailedResources, LastSyncFailures, LastSyncTimestamp, CacheHits, CacheMisses, Throttles, Retries, RetriesExhausted, DefaultKMSKey, ManagedResources, ManagedKeys)
+}
+
+// ObserveSync records the outcome of a sync of all objects of a kind
+func ObserveSync(kind string, synced int, failed int) {
+	SyncedResources.WithLabelValues(kind).Add(float64(synced))
+	FailedResources.WithLabelValues(kind).Add(float64(failed))
+	LastSyncFailures.WithLabelValues(kind).Set(float64(failed))
+	LastSyncTimestamp.WithLabelValues(kind).Set(float64(time.Now().Unix()))
+}
+
+// ObserveManaged records how many objects of a kind the controller syncs, and
+// how many keys it writes to them in all
+func ObserveManaged(kind string, resources int, keys int) {
+	ManagedResources.WithLabelValues(kind).Set(float64(resources))
+	ManagedKeys.WithLabelValues(kind).Set(float64(keys))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
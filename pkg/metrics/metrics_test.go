@@ -0,0 +1,44 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveSync(t *testing.T) {
+	synced := testutil.ToFloat64(SyncedResources.WithLabelValues("Test"))
+	failed := testutil.ToFloat64(FailedResources.WithLabelValues("Test"))
+
+	ObserveSync("Test", 3, 1)
+	ObserveSync("Test", 2, 0)
+
+	assert.Equal(t, synced+5, testutil.ToFloat64(SyncedResources.WithLabelValues("Test")))
+	assert.Equal(t, failed+1, testutil.ToFloat64(FailedResources.WithLabelValues("Test")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(LastSyncFailures.WithLabelValues("Test")))
+	assert.NotZero(t, testutil.ToFloat64(LastSyncTimestamp.WithLabelValues("Test")))
+}
+
+func TestObserveManaged(t *testing.T) {
+	ObserveManaged("Test", 3, 10)
+	ObserveManaged("Test", 2, 4)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(ManagedResources.WithLabelValues("Test")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(ManagedKeys.WithLabelValues("Test")))
+}
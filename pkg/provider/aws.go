@@ -16,84 +16,372 @@
 package provider
 
 import (
-	"path"
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/cmattoon/aws-ssm/pkg/config"
 	log "github.com/sirupsen/logrus"
 )
 
-type AWSProvider struct {
-	Session *session.Session
-	Service *ssm.SSM
+// UserAgentHandlerName identifies the handler that appends the controller's
+// User-Agent to every request made with the session
+const UserAgentHandlerName = "aws-ssm.UserAgentHandler"
 
-	results map[string]string
+type AWSProvider struct {
+	Session        *session.Session
+	Service        ssmiface.SSMAPI
+	SecretsManager secretsmanageriface.SecretsManagerAPI
+	KMS            kmsiface.KMSAPI
+	// Returns the KMS client that decrypts as an IAM role, for
+	// GetParameterValueDecryptedAs; nil can't decrypt as another role
+	KMSFor func(roleARN string) kmsiface.KMSAPI
+	// Which failed requests to retry; nil retries throttling (IsRetryable)
+	RetryPredicate RetryPredicate
+	// Decrypt every read of values, whether or not it asks to (-always-decrypt)
+	AlwaysDecrypt bool
 }
 
+// NewAWSProvider returns a provider for cfg. Its clients are shared with every
+// other provider for the same region, role and session options (see clientCache).
 func NewAWSProvider(cfg *config.Config) (Provider, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.AWSRegion),
+	p, err := sharedClients.provider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.RetryErrorCodes) > 0 {
+		p.RetryPredicate = RetryErrorCodes(cfg.RetryErrorCodes...)
+	}
+	p.AlwaysDecrypt = cfg.AlwaysDecrypt
+	return p, nil
+}
+
+// decrypts is whether a read that asks to decrypt or not is decrypted
+func (p AWSProvider) decrypts(decrypt bool) bool {
+	return decrypt || p.AlwaysDecrypt
+}
+
+// readCABundle reads a PEM file of CAs to trust in place of the system roots
+// (e.g., the private CA of a VPC endpoint). The session's HTTP client is built from it.
+func readCABundle(caBundle string) ([]byte, error) {
+	pem, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CA bundle: %s", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in CA bundle %s", caBundle)
+	}
+	return pem, nil
+}
+
+func (p AWSProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	var param *ssm.GetParameterOutput
+	err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() (err error) {
+		param, err = p.Service.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(p.decrypts(decrypt) && !IsPublicParameter(name)),
+		})
+		return err
 	})
 
 	if err != nil {
-		log.Fatalf("%s", err)
+		log.Errorf("Failed to GetParameterValue: %s", err)
+		return "", err
 	}
 
-	return AWSProvider{
-		Session: sess,
-		Service: ssm.New(sess),
-	}, nil
+	return *param.Parameter.Value, nil
 }
 
-func (p AWSProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+// GetParameterValueWithGrants reads a parameter, decrypting a SecureString with KMS
+// directly so that grant tokens can be passed (SSM's decryption doesn't accept them).
+// SSM encrypts standard parameters with the parameter's ARN as encryption context.
+func (p AWSProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	return p.decryptWith(p.KMS, name, grantTokens)
+}
+
+// GetParameterValueDecryptedAs reads a parameter like GetParameterValueWithGrants,
+// but decrypts a SecureString as roleARN, for when the role that reads parameters
+// isn't allowed to use their KMS key
+func (p AWSProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	if p.KMSFor == nil {
+		return "", fmt.Errorf("Can't decrypt '%s' as %s: the provider has no session to assume it with", name, roleARN)
+	}
+	return p.decryptWith(p.KMSFor(roleARN), name, grantTokens)
+}
+
+// decryptWith reads a parameter without decryption, and decrypts a SecureString
+// with client
+func (p AWSProvider) decryptWith(client kmsiface.KMSAPI, name string, grantTokens []string) (string, error) {
 	param, err := p.Service.GetParameter(&ssm.GetParameterInput{
 		Name:           aws.String(name),
-		WithDecryption: aws.Bool(decrypt),
+		WithDecryption: aws.Bool(false),
 	})
-
 	if err != nil {
 		log.Errorf("Failed to GetParameterValue: %s", err)
 		return "", err
 	}
+	if aws.StringValue(param.Parameter.Type) != ssm.ParameterTypeSecureString {
+		return aws.StringValue(param.Parameter.Value), nil
+	}
 
-	return *param.Parameter.Value, nil
+	blob, err := base64.StdEncoding.DecodeString(aws.StringValue(param.Parameter.Value))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode encrypted value of '%s': %s", name, err)
+	}
+	var out *kms.DecryptOutput
+	err = retryThrottled(ThrottledServiceKMS, p.RetryPredicate, func() (err error) {
+		out, err = client.Decrypt(&kms.DecryptInput{
+			CiphertextBlob:    blob,
+			EncryptionContext: map[string]*string{"PARAMETER_ARN": param.Parameter.ARN},
+			GrantTokens:       aws.StringSlice(grantTokens),
+		})
+		return err
+	})
+	if err != nil {
+		log.Errorf("Failed to decrypt '%s' with KMS: %s", name, err)
+		return "", err
+	}
+	return string(out.Plaintext), nil
 }
 
-func (p AWSProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
-	p.results = make(map[string]string)
+// GetParameterVersion returns the version of a parameter, without decrypting it
+func (p AWSProvider) GetParameterVersion(name string) (int64, error) {
+	param, err := p.Service.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(false),
+	})
+	if err != nil {
+		log.Errorf("Failed to GetParameterVersion: %s", err)
+		return 0, err
+	}
+	return aws.Int64Value(param.Parameter.Version), nil
+}
 
-	params, err := p.Service.GetParametersByPath(&ssm.GetParametersByPathInput{
-		Path:           aws.String(ppath),
-		Recursive:      aws.Bool(true),
-		WithDecryption: aws.Bool(decrypt),
+// GetSecretValue returns the SecretString or SecretBinary of the version of a
+// Secrets Manager secret with versionStage ("" is AWSCURRENT)
+func (p AWSProvider) GetSecretValue(name string, versionStage string) (SecretValue, error) {
+	in := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	}
+	if versionStage != "" {
+		in.VersionStage = aws.String(versionStage)
+	}
+	out, err := p.SecretsManager.GetSecretValue(in)
+
+	if err != nil {
+		log.Errorf("Failed to GetSecretValue: %s", err)
+		if versionStage != "" && IsNotFound(err) {
+			// Also returned when the secret exists, but no version has the stage
+			return SecretValue{}, awserr.New(secretsmanager.ErrCodeResourceNotFoundException,
+				fmt.Sprintf("Secret '%s' has no version with stage %s", name, versionStage), err)
+		}
+		return SecretValue{}, err
+	}
+
+	if out.SecretBinary != nil {
+		return SecretValue{Binary: out.SecretBinary}, nil
+	}
+	if out.SecretString == nil {
+		return SecretValue{}, fmt.Errorf("Secret '%s' has no value", name)
+	}
+	return SecretValue{String: *out.SecretString}, nil
+}
+
+// GetParameterTags returns the tags of the named parameter. ListTagsForResource
+// isn't paginated; every tag is returned in a single response.
+func (p AWSProvider) GetParameterTags(name string) (map[string]string, error) {
+	if IsPublicParameter(name) {
+		// Public parameters can't be tagged (or listed) by other accounts
+		return map[string]string{}, nil
+	}
+	out, err := p.Service.ListTagsForResource(&ssm.ListTagsForResourceInput{
+		ResourceId:   aws.String(Unversioned(name)),
+		ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
 	})
 
 	if err != nil {
-		log.Errorf("Failed to GetParameterDataByPath: %s", err)
+		log.Errorf("Failed to GetParameterTags: %s", err)
 		return nil, err
 	}
 
-	// '/path/to/env/foo' -> 'foo': *pa.Value
-	for _, pa := range params.Parameters {
-		_, basename := path.Split(*pa.Name)
-		p.results[basename] = *pa.Value
+	tags := make(map[string]string)
+	for _, tag := range out.TagList {
+		tags[*tag.Key] = *tag.Value
 	}
+	return tags, nil
+}
 
-	if params.NextToken == nil {
-		return p.results, nil
+// GetParameterDescription returns the description of the named parameter ("" if it has none)
+func (p AWSProvider) GetParameterDescription(name string) (string, error) {
+	meta, err := p.describeParameter(name)
+	if err != nil {
+		log.Errorf("Failed to GetParameterDescription: %s", err)
+		return "", err
 	}
+	return aws.StringValue(meta.Description), nil
+}
 
-	return p.getParameterDataByPath(ppath, decrypt, *params.NextToken)
+// GetParameterKeyID returns the ID of the KMS key that encrypted a SecureString
+// (from DescribeParameters: GetParameter doesn't return it), or "" for other types
+func (p AWSProvider) GetParameterKeyID(name string) (string, error) {
+	var meta *ssm.ParameterMetadata
+	err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() (err error) {
+		meta, err = p.describeParameter(name)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	if aws.StringValue(meta.Type) != ssm.ParameterTypeSecureString {
+		return "", nil
+	}
+	return aws.StringValue(meta.KeyId), nil
 }
 
-func (p AWSProvider) getParameterDataByPath(ppath string, decrypt bool, nextToken string) (map[string]string, error) {
-	params, err := p.Service.GetParametersByPath(&ssm.GetParametersByPathInput{
+// describeParameter returns the metadata of the named parameter (of its latest version)
+func (p AWSProvider) describeParameter(name string) (*ssm.ParameterMetadata, error) {
+	out, err := p.Service.DescribeParameters(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Name"),
+			Option: aws.String("Equals"),
+			Values: []*string{aws.String(Unversioned(name))},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Parameters) == 0 {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "Parameter "+name+" not found", nil)
+	}
+	return out.Parameters[0], nil
+}
+
+// GetParameterTiersByPath returns the tier of each parameter under ppath (from
+// DescribeParameters: GetParametersByPath doesn't return it), by full name
+func (p AWSProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	tiers := make(map[string]string)
+	in := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Path"),
+			Option: aws.String("Recursive"),
+			Values: []*string{aws.String(ppath)},
+		}},
+		MaxResults: aws.Int64(50),
+	}
+	for {
+		var page *tierPage
+		err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() (err error) {
+			page, err = p.describeTiers(in)
+			return
+		})
+		if err != nil {
+			log.Errorf("Failed to GetParameterTiersByPath: %s", err)
+			return nil, err
+		}
+		for _, meta := range page.Parameters {
+			tiers[meta.Name] = meta.Tier
+		}
+		if page.NextToken == "" {
+			return tiers, nil
+		}
+		in.NextToken = aws.String(page.NextToken)
+	}
+}
+
+// tierPage is a page of DescribeParameters, with just the tier of each parameter
+type tierPage struct {
+	Parameters []struct {
+		Name string
+		Tier string
+	}
+	NextToken string
+}
+
+// describeTiers reads a page of DescribeParameters. The SDK's ParameterMetadata
+// (as of the vendored version) predates tiers, so they're read from the response body.
+func (p AWSProvider) describeTiers(in *ssm.DescribeParametersInput) (*tierPage, error) {
+	page := &tierPage{}
+	req, _ := p.Service.DescribeParametersRequest(in)
+	req.Handlers.Unmarshal.PushFront(func(r *request.Request) {
+		body, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := json.Unmarshal(body, page); err != nil {
+			r.Error = fmt.Errorf("Failed to read parameter tiers: %s", err)
+		}
+	})
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetParameterARN returns the ARN of the parameter, without reading its value
+func (p AWSProvider) GetParameterARN(name string) (string, error) {
+	var param *ssm.GetParameterOutput
+	err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() (err error) {
+		param, err = p.Service.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(false),
+		})
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(param.Parameter.ARN), nil
+}
+
+// ARNFor returns the ARN of parameter name in the same region and account as
+// the parameter of arn, without a request
+func ARNFor(arn string, name string) string {
+	if i := strings.Index(arn, ":parameter/"); i >= 0 {
+		arn = arn[:i]
+	}
+	return arn + ":parameter/" + strings.TrimPrefix(Unversioned(name), "/")
+}
+
+// GetParameterDataByPath returns the values of all parameters under ppath, by full name.
+// When decrypt is set, only SecureStrings are decrypted, so plain Strings in a
+// mixed directory don't require KMS permissions. Large paths (e.g., public
+// parameters under /aws/service) are read 10 parameters per page.
+func (p AWSProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	decrypt = p.decrypts(decrypt)
+	results := make(map[string]string)
+	// The full names of SecureStrings to decrypt
+	var secure []string
+
+	err := p.Service.GetParametersByPathPages(&ssm.GetParametersByPathInput{
 		Path:           aws.String(ppath),
 		Recursive:      aws.Bool(true),
-		WithDecryption: aws.Bool(decrypt),
-		NextToken:      aws.String(nextToken),
+		MaxResults:     aws.Int64(10),
+		WithDecryption: aws.Bool(false),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, pa := range page.Parameters {
+			results[*pa.Name] = *pa.Value
+			if decrypt && aws.StringValue(pa.Type) == ssm.ParameterTypeSecureString {
+				secure = append(secure, *pa.Name)
+			}
+		}
+		return true
 	})
 
 	if err != nil {
@@ -101,14 +389,143 @@ func (p AWSProvider) getParameterDataByPath(ppath string, decrypt bool, nextToke
 		return nil, err
 	}
 
-	for _, pa := range params.Parameters {
-		_, basename := path.Split(*pa.Name)
-		p.results[basename] = *pa.Value
+	if len(secure) == 0 {
+		return results, nil
+	}
+
+	sort.Strings(secure)
+
+	decrypted, err := p.getParameters(secure, true)
+	if err != nil {
+		log.Errorf("Failed to GetParameterDataByPath: %s", err)
+		return nil, err
+	}
+	for name, value := range decrypted {
+		results[name] = value
+	}
+	return results, nil
+}
+
+// ListParametersByPath returns the full names of the parameters under ppath, from
+// DescribeParameters: no values are read, so nothing is decrypted.
+func (p AWSProvider) ListParametersByPath(ppath string) ([]string, error) {
+	names := []string{}
+	err := p.Service.DescribeParametersPages(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Path"),
+			Option: aws.String("Recursive"),
+			Values: []*string{aws.String(ppath)},
+		}},
+		MaxResults: aws.Int64(50),
+	}, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, meta := range page.Parameters {
+			names = append(names, *meta.Name)
+		}
+		return true
+	})
+	if err != nil {
+		log.Errorf("Failed to ListParametersByPath: %s", err)
+		return nil, err
+	}
+	return names, nil
+}
+
+// GetParameterHistory reads every page of the parameter's history (SSM returns
+// the oldest versions first, 50 per page at most), and returns the newest limit
+// versions, newest first. SSM keeps the last 100 versions of a parameter.
+func (p AWSProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	decrypt = p.decrypts(decrypt)
+	var versions []ParameterVersion
+	err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() error {
+		versions = nil
+		return p.Service.GetParameterHistoryPages(&ssm.GetParameterHistoryInput{
+			Name:           aws.String(name),
+			MaxResults:     aws.Int64(50),
+			WithDecryption: aws.Bool(decrypt),
+		}, func(page *ssm.GetParameterHistoryOutput, lastPage bool) bool {
+			for _, pa := range page.Parameters {
+				versions = append(versions, ParameterVersion{
+					Version: aws.Int64Value(pa.Version),
+					Value:   aws.StringValue(pa.Value),
+				})
+			}
+			return true
+		})
+	})
+	if err != nil {
+		log.Errorf("Failed to GetParameterHistory: %s", err)
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	if limit > 0 && len(versions) > limit {
+		versions = versions[:limit]
+	}
+	return versions, nil
+}
+
+// BatchGetParameterValues reads names with GetParameters instead of a GetParameter
+// call each. Public parameters are read in their own batches, without decryption.
+func (p AWSProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	decrypt = p.decrypts(decrypt)
+	var private, public []string
+	for _, name := range names {
+		if decrypt && IsPublicParameter(name) {
+			public = append(public, name)
+		} else {
+			private = append(private, name)
+		}
 	}
 
-	if params.NextToken == nil {
-		return p.results, nil
+	results, err := p.getParameters(private, decrypt)
+	if err != nil {
+		log.Errorf("Failed to BatchGetParameterValues: %s", err)
+		return nil, err
+	}
+	values, err := p.getParameters(public, false)
+	if err != nil {
+		log.Errorf("Failed to BatchGetParameterValues: %s", err)
+		return nil, err
 	}
+	for name, value := range values {
+		results[name] = value
+	}
+	return results, nil
+}
 
-	return p.getParameterDataByPath(ppath, decrypt, *params.NextToken)
+// getParameters fetches the values of names, 10 at a time (the GetParameters limit),
+// keyed by the names as given ("name:3" included). A name that doesn't exist is a
+// ParameterNotFound error. A large directory of SecureStrings is where KMS
+// throttling shows up, so each batch is retried.
+func (p AWSProvider) getParameters(names []string, decrypt bool) (map[string]string, error) {
+	results := make(map[string]string)
+
+	for i := 0; i < len(names); i += 10 {
+		end := i + 10
+		if end > len(names) {
+			end = len(names)
+		}
+
+		var out *ssm.GetParametersOutput
+		err := retryThrottled(ThrottledServiceSSM, p.RetryPredicate, func() (err error) {
+			out, err = p.Service.GetParameters(&ssm.GetParametersInput{
+				Names:          aws.StringSlice(names[i:end]),
+				WithDecryption: aws.Bool(decrypt),
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(out.InvalidParameters) > 0 {
+			return nil, awserr.New(ssm.ErrCodeParameterNotFound,
+				"Invalid parameters: "+strings.Join(aws.StringValueSlice(out.InvalidParameters), ", "), nil)
+		}
+
+		for _, pa := range out.Parameters {
+			// A version or label selector is returned separately from the name
+			results[*pa.Name+aws.StringValue(pa.Selector)] = *pa.Value
+		}
+	}
+	return results, nil
 }
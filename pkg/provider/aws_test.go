@@ -0,0 +1,839 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSM serves Parameters from memory. SecureString values are returned
+// "encrypted" unless decryption is requested.
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	Parameters []*ssm.Parameter
+	PageSize   int
+	// By parameter name
+	Descriptions map[string]string
+	KeyIDs       map[string]string
+	// The value of each version of a parameter by name, from version 1
+	History map[string][]string
+	// Pages read by GetParameterHistoryPages
+	HistoryPages int
+
+	// Names passed to each GetParameters call
+	GetParametersCalls [][]string
+	// Returned by the first GetParameters calls, in order
+	GetParametersErrors []error
+}
+
+func (f *fakeSSM) value(pa *ssm.Parameter, decrypt bool) *string {
+	if *pa.Type == ssm.ParameterTypeSecureString && !decrypt {
+		return aws.String("encrypted:" + *pa.Value)
+	}
+	return pa.Value
+}
+
+func (f *fakeSSM) GetParametersByPathPages(in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	matches := []*ssm.Parameter{}
+	for _, pa := range f.Parameters {
+		if strings.HasPrefix(*pa.Name, *in.Path+"/") {
+			matches = append(matches, &ssm.Parameter{
+				Name:  pa.Name,
+				Type:  pa.Type,
+				Value: f.value(pa, aws.BoolValue(in.WithDecryption)),
+			})
+		}
+	}
+
+	size := f.PageSize
+	if size == 0 {
+		size = 10
+	}
+	for i := 0; i < len(matches) || i == 0; i += size {
+		end := i + size
+		if end > len(matches) {
+			end = len(matches)
+		}
+		last := end == len(matches)
+		if !fn(&ssm.GetParametersByPathOutput{Parameters: matches[i:end]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+// GetParameterHistoryPages returns the oldest versions first, in pages of MaxResults
+func (f *fakeSSM) GetParameterHistoryPages(in *ssm.GetParameterHistoryInput, fn func(*ssm.GetParameterHistoryOutput, bool) bool) error {
+	values, ok := f.History[*in.Name]
+	if !ok {
+		return fmt.Errorf("ParameterNotFound: %s", *in.Name)
+	}
+	size := int(aws.Int64Value(in.MaxResults))
+	for i := 0; i < len(values); i += size {
+		page := &ssm.GetParameterHistoryOutput{}
+		for j := i; j < i+size && j < len(values); j++ {
+			value := values[j]
+			if !aws.BoolValue(in.WithDecryption) {
+				value = "encrypted:" + value
+			}
+			page.Parameters = append(page.Parameters, &ssm.ParameterHistory{
+				Name:    in.Name,
+				Version: aws.Int64(int64(j + 1)),
+				Value:   aws.String(value),
+			})
+		}
+		f.HistoryPages += 1
+		last := i+size >= len(values)
+		if !fn(page, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeSSM) GetParameters(in *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+	names := aws.StringValueSlice(in.Names)
+	f.GetParametersCalls = append(f.GetParametersCalls, names)
+	if len(f.GetParametersErrors) > 0 {
+		err := f.GetParametersErrors[0]
+		f.GetParametersErrors = f.GetParametersErrors[1:]
+		return nil, err
+	}
+	if len(names) > 10 {
+		return nil, fmt.Errorf("ValidationException: too many names")
+	}
+
+	out := &ssm.GetParametersOutput{}
+	for _, name := range names {
+		found := false
+		for _, pa := range f.Parameters {
+			if *pa.Name == name {
+				out.Parameters = append(out.Parameters, &ssm.Parameter{
+					Name:  pa.Name,
+					Type:  pa.Type,
+					Value: f.value(pa, aws.BoolValue(in.WithDecryption)),
+				})
+				found = true
+			}
+		}
+		if !found {
+			out.InvalidParameters = append(out.InvalidParameters, aws.String(name))
+		}
+	}
+	return out, nil
+}
+
+// GetParameter returns undecrypted SecureStrings base64 encoded, like SSM
+func (f *fakeSSM) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	for _, pa := range f.Parameters {
+		if *pa.Name == *in.Name {
+			value := f.value(pa, aws.BoolValue(in.WithDecryption))
+			if *pa.Type == ssm.ParameterTypeSecureString && !aws.BoolValue(in.WithDecryption) {
+				value = aws.String(base64.StdEncoding.EncodeToString([]byte(*value)))
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{
+				Name:  pa.Name,
+				Type:  pa.Type,
+				ARN:   aws.String("arn:aws:ssm:us-west-2:123456789012:parameter" + *pa.Name),
+				Value: value,
+			}}, nil
+		}
+	}
+	return nil, fmt.Errorf("ParameterNotFound: %s", *in.Name)
+}
+
+// DescribeParameters supports only the Name Equals filter
+func (f *fakeSSM) DescribeParameters(in *ssm.DescribeParametersInput) (*ssm.DescribeParametersOutput, error) {
+	out := &ssm.DescribeParametersOutput{}
+	for _, pa := range f.Parameters {
+		for _, filter := range in.ParameterFilters {
+			if *filter.Key == "Name" && *filter.Option == "Equals" && *filter.Values[0] == *pa.Name {
+				meta := &ssm.ParameterMetadata{Name: pa.Name, Type: pa.Type}
+				if description, ok := f.Descriptions[*pa.Name]; ok {
+					meta.Description = aws.String(description)
+				}
+				if keyID, ok := f.KeyIDs[*pa.Name]; ok {
+					meta.KeyId = aws.String(keyID)
+				}
+				out.Parameters = append(out.Parameters, meta)
+			}
+		}
+	}
+	return out, nil
+}
+
+// DescribeParametersPages supports only the Path Recursive filter, in pages of PageSize
+func (f *fakeSSM) DescribeParametersPages(in *ssm.DescribeParametersInput, fn func(*ssm.DescribeParametersOutput, bool) bool) error {
+	matches := []*ssm.ParameterMetadata{}
+	for _, pa := range f.Parameters {
+		for _, filter := range in.ParameterFilters {
+			if *filter.Key == "Path" && *filter.Option == "Recursive" && strings.HasPrefix(*pa.Name, *filter.Values[0]+"/") {
+				matches = append(matches, &ssm.ParameterMetadata{Name: pa.Name, Type: pa.Type})
+			}
+		}
+	}
+
+	size := f.PageSize
+	if size == 0 {
+		size = 50
+	}
+	for i := 0; i < len(matches) || i == 0; i += size {
+		end := i + size
+		if end > len(matches) {
+			end = len(matches)
+		}
+		last := end == len(matches)
+		if !fn(&ssm.DescribeParametersOutput{Parameters: matches[i:end]}, last) || last {
+			break
+		}
+	}
+	return nil
+}
+
+// fakeSecretsManager serves the string secrets in Stages, by name then stage
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	Stages map[string]map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	stages, ok := f.Stages[*in.SecretId]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "Secrets Manager can't find the specified secret.", nil)
+	}
+	stage := "AWSCURRENT"
+	if in.VersionStage != nil {
+		stage = *in.VersionStage
+	}
+	value, ok := stages[stage]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException,
+			"Secrets Manager can't find the specified secret value for staging label: "+stage, nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: in.SecretId, SecretString: aws.String(value)}, nil
+}
+
+// fakeKMS "decrypts" by stripping the "encrypted:" prefix added by fakeSSM
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	DecryptCalls []*kms.DecryptInput
+	// Returned by the first Decrypt calls, in order
+	Errors []error
+}
+
+func (f *fakeKMS) Decrypt(in *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	f.DecryptCalls = append(f.DecryptCalls, in)
+	if len(f.Errors) > 0 {
+		err := f.Errors[0]
+		f.Errors = f.Errors[1:]
+		return nil, err
+	}
+	return &kms.DecryptOutput{Plaintext: []byte(strings.TrimPrefix(string(in.CiphertextBlob), "encrypted:"))}, nil
+}
+
+func param(name string, ptype string, value string) *ssm.Parameter {
+	return &ssm.Parameter{Name: aws.String(name), Type: aws.String(ptype), Value: aws.String(value)}
+}
+
+func TestGetParameterDataByPathDecryptsOnlySecureStrings(t *testing.T) {
+	svc := &fakeSSM{
+		PageSize: 2,
+		Parameters: []*ssm.Parameter{
+			param("/app/db/host", ssm.ParameterTypeString, "10.0.1.10"),
+			param("/app/db/user", ssm.ParameterTypeString, "root"),
+			param("/app/db/pass", ssm.ParameterTypeSecureString, "password123"),
+			param("/app/db/hosts", ssm.ParameterTypeStringList, "a,b"),
+			param("/app/db/token", ssm.ParameterTypeSecureString, "t0ken"),
+			param("/app/other/pass", ssm.ParameterTypeSecureString, "nope"),
+		},
+	}
+	p := AWSProvider{Service: svc}
+
+	data, err := p.GetParameterDataByPath("/app/db", true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/app/db/host":  "10.0.1.10",
+		"/app/db/user":  "root",
+		"/app/db/pass":  "password123",
+		"/app/db/hosts": "a,b",
+		"/app/db/token": "t0ken",
+	}, data)
+	assert.Equal(t, [][]string{{"/app/db/pass", "/app/db/token"}}, svc.GetParametersCalls)
+}
+
+func TestGetParameterDataByPathWithoutDecryption(t *testing.T) {
+	svc := &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/db/host", ssm.ParameterTypeString, "10.0.1.10"),
+			param("/app/db/pass", ssm.ParameterTypeSecureString, "password123"),
+		},
+	}
+	p := AWSProvider{Service: svc}
+
+	data, err := p.GetParameterDataByPath("/app/db", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/app/db/host": "10.0.1.10",
+		"/app/db/pass": "encrypted:password123",
+	}, data)
+	assert.Empty(t, svc.GetParametersCalls)
+}
+
+func TestGetParameterDataByPathDecryptsInBatchesOfTen(t *testing.T) {
+	svc := &fakeSSM{}
+	for i := 0; i < 25; i++ {
+		svc.Parameters = append(svc.Parameters, param(fmt.Sprintf("/app/key%02d", i), ssm.ParameterTypeSecureString, "secret"))
+	}
+	p := AWSProvider{Service: svc}
+
+	data, err := p.GetParameterDataByPath("/app", true)
+	require.NoError(t, err)
+	assert.Len(t, data, 25)
+	assert.Equal(t, "secret", data["/app/key24"])
+	require.Len(t, svc.GetParametersCalls, 3)
+	assert.Len(t, svc.GetParametersCalls[2], 5)
+}
+
+func TestNewAWSProviderRegistersUserAgentHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.UserAgentSuffix = "aws-ssm-controller/test"
+
+	p, err := NewAWSProvider(cfg)
+	require.NoError(t, err)
+
+	ap := p.(AWSProvider)
+	req, _ := ap.Service.GetParameterRequest(&ssm.GetParameterInput{
+		Name: aws.String("foo-param"),
+	})
+	require.NoError(t, req.Build())
+
+	ua := req.HTTPRequest.Header.Get("User-Agent")
+	assert.True(t, strings.HasSuffix(ua, " aws-ssm-controller/test"), ua)
+}
+
+func TestNewAWSProviderSkipsEmptyUserAgentSuffix(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.UserAgentSuffix = ""
+
+	p, err := NewAWSProvider(cfg)
+	require.NoError(t, err)
+
+	before := p.(AWSProvider).Session.Handlers.Build.Len()
+	cfg.UserAgentSuffix = "aws-ssm-controller/test"
+	p, err = NewAWSProvider(cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, before+1, p.(AWSProvider).Session.Handlers.Build.Len())
+}
+
+func TestClientCacheReusesClients(t *testing.T) {
+	c := newClientCache()
+	cfg := config.DefaultConfig()
+	cfg.AWSRegion = "us-east-1"
+
+	first, err := c.provider(cfg)
+	require.NoError(t, err)
+	again, err := c.provider(cfg)
+	require.NoError(t, err)
+	assert.True(t, first.Service == again.Service)
+	assert.True(t, first.Session == again.Session)
+
+	cfg.AWSRegion = "eu-west-1"
+	regional, err := c.provider(cfg)
+	require.NoError(t, err)
+	assert.False(t, first.Service == regional.Service)
+	assert.Equal(t, "eu-west-1", aws.StringValue(regional.Session.Config.Region))
+
+	cfg.RoleARN = "arn:aws:iam::123456789012:role/reader"
+	role, err := c.provider(cfg)
+	require.NoError(t, err)
+	assert.False(t, regional.Service == role.Service)
+	assert.False(t, regional.Session.Config.Credentials == role.Session.Config.Credentials)
+
+	// Every provider was derived from the one base session
+	assert.Len(t, c.sessions, 1)
+	assert.Len(t, c.clients, 3)
+}
+
+func TestClientCacheDecrypters(t *testing.T) {
+	c := newClientCache()
+	p, err := c.provider(config.DefaultConfig())
+	require.NoError(t, err)
+
+	decrypter := p.KMSFor("arn:aws:iam::123456789012:role/kms-decrypt")
+	assert.True(t, decrypter == p.KMSFor("arn:aws:iam::123456789012:role/kms-decrypt"))
+	assert.False(t, decrypter == p.KMSFor("arn:aws:iam::123456789012:role/other"))
+	assert.False(t, decrypter == p.KMS)
+	// Signed as the role, not with the provider's credentials
+	assert.False(t, decrypter.(*kms.KMS).Config.Credentials == p.Session.Config.Credentials)
+	assert.Len(t, c.decrypters, 2)
+}
+
+// writeCABundle writes a self-signed CA certificate to a temporary PEM file
+func writeCABundle(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "aws-ssm test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "ca-bundle")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return f.Name()
+}
+
+func TestNewAWSProviderUsesCABundle(t *testing.T) {
+	bundle := writeCABundle(t)
+	defer os.Remove(bundle)
+
+	cfg := config.DefaultConfig()
+	cfg.CABundle = bundle
+
+	p, err := NewAWSProvider(cfg)
+	require.NoError(t, err)
+
+	transport, ok := p.(AWSProvider).Session.Config.HTTPClient.Transport.(*http.Transport)
+	require.True(t, ok)
+	require.NotNil(t, transport.TLSClientConfig)
+	subjects := transport.TLSClientConfig.RootCAs.Subjects()
+	require.Len(t, subjects, 1)
+	assert.Contains(t, string(subjects[0]), "aws-ssm test CA")
+}
+
+func TestNewAWSProviderRejectsInvalidCABundle(t *testing.T) {
+	f, err := ioutil.TempFile("", "ca-bundle")
+	require.NoError(t, err)
+	f.WriteString("not a certificate")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	cfg := config.DefaultConfig()
+	cfg.CABundle = f.Name()
+	_, err = NewAWSProvider(cfg)
+	assert.Error(t, err)
+
+	cfg.CABundle = f.Name() + ".missing"
+	_, err = NewAWSProvider(cfg)
+	assert.Error(t, err)
+}
+
+func TestNewAWSProviderUsesSSMEndpoint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SSMEndpoint = "https://vpce-123.ssm.us-west-2.vpce.amazonaws.com"
+
+	p, err := NewAWSProvider(cfg)
+	require.NoError(t, err)
+
+	ap := p.(AWSProvider)
+	assert.Equal(t, cfg.SSMEndpoint, ap.Service.(*ssm.SSM).Endpoint)
+	assert.NotEqual(t, cfg.SSMEndpoint, ap.SecretsManager.(*secretsmanager.SecretsManager).Endpoint)
+}
+
+func TestGetParameterValueWithGrantsPassesTokens(t *testing.T) {
+	fk := &fakeKMS{}
+	p := AWSProvider{
+		Service: &fakeSSM{Parameters: []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")}},
+		KMS:     fk,
+	}
+
+	value, err := p.GetParameterValueWithGrants("/app/password", []string{"token-a"})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	require.Len(t, fk.DecryptCalls, 1)
+	assert.Equal(t, []string{"token-a"}, aws.StringValueSlice(fk.DecryptCalls[0].GrantTokens))
+	assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/password",
+		aws.StringValue(fk.DecryptCalls[0].EncryptionContext["PARAMETER_ARN"]))
+}
+
+func TestGetParameterValueWithGrantsSkipsKMSForStrings(t *testing.T) {
+	fk := &fakeKMS{}
+	p := AWSProvider{
+		Service: &fakeSSM{Parameters: []*ssm.Parameter{param("/app/host", ssm.ParameterTypeString, "db.internal")}},
+		KMS:     fk,
+	}
+
+	value, err := p.GetParameterValueWithGrants("/app/host", []string{"token-a"})
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", value)
+	assert.Empty(t, fk.DecryptCalls)
+}
+
+func TestGetParameterValueDecryptedAs(t *testing.T) {
+	fk := &fakeKMS{}
+	decrypter := &fakeKMS{}
+	roles := []string{}
+	p := AWSProvider{
+		Service: &fakeSSM{Parameters: []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")}},
+		KMS:     fk,
+		KMSFor: func(roleARN string) kmsiface.KMSAPI {
+			roles = append(roles, roleARN)
+			return decrypter
+		},
+	}
+
+	value, err := p.GetParameterValueDecryptedAs("/app/password", "arn:aws:iam::123456789012:role/kms-decrypt", []string{"token-a"})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/kms-decrypt"}, roles)
+	assert.Empty(t, fk.DecryptCalls)
+	require.Len(t, decrypter.DecryptCalls, 1)
+	assert.Equal(t, []string{"token-a"}, aws.StringValueSlice(decrypter.DecryptCalls[0].GrantTokens))
+
+	p.KMSFor = nil
+	_, err = p.GetParameterValueDecryptedAs("/app/password", "arn:aws:iam::123456789012:role/kms-decrypt", nil)
+	assert.Error(t, err)
+}
+
+func TestGetParameterDescription(t *testing.T) {
+	p := AWSProvider{Service: &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/host", ssm.ParameterTypeString, "db.internal"),
+			param("/app/port", ssm.ParameterTypeString, "5432"),
+		},
+		Descriptions: map[string]string{"/app/host": "Primary DB host"},
+	}}
+
+	description, err := p.GetParameterDescription("/app/host:3")
+	require.NoError(t, err)
+	assert.Equal(t, "Primary DB host", description)
+
+	description, err = p.GetParameterDescription("/app/port")
+	require.NoError(t, err)
+	assert.Equal(t, "", description)
+
+	_, err = p.GetParameterDescription("/app/user")
+	assert.Error(t, err)
+}
+
+func TestGetParameterKeyID(t *testing.T) {
+	p := AWSProvider{Service: &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/db/password", ssm.ParameterTypeSecureString, "hunter2"),
+			param("/app/db/host", ssm.ParameterTypeString, "db.internal"),
+		},
+		KeyIDs: map[string]string{
+			"/app/db/password": "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		},
+	}}
+
+	keyID, err := GetParameterKeyID(p, "/app/db/password:2")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", keyID)
+
+	// Not encrypted
+	keyID, err = GetParameterKeyID(p, "/app/db/host")
+	require.NoError(t, err)
+	assert.Equal(t, "", keyID)
+
+	_, err = GetParameterKeyID(p, "/app/db/user")
+	assert.Error(t, err)
+}
+
+func TestGetParameterARN(t *testing.T) {
+	p := AWSProvider{Service: &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/db/password", ssm.ParameterTypeSecureString, "hunter2"),
+		},
+	}}
+
+	arn, err := p.GetParameterARN("/app/db/password")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/password", arn)
+
+	_, err = p.GetParameterARN("/app/db/user")
+	assert.Error(t, err)
+}
+
+func TestGetParameterHistory(t *testing.T) {
+	values := []string{}
+	for i := 1; i <= 120; i++ {
+		values = append(values, fmt.Sprintf("v%d", i))
+	}
+	fake := &fakeSSM{History: map[string][]string{"/app/db/password": values}}
+	p := AWSProvider{Service: fake}
+
+	// Every page is read, since the newest versions are last
+	versions, err := p.GetParameterHistory("/app/db/password", true, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []ParameterVersion{{120, "v120"}, {119, "v119"}, {118, "v118"}}, versions)
+	assert.Equal(t, 3, fake.HistoryPages)
+
+	versions, err = p.GetParameterHistory("/app/db/password", false, 0)
+	require.NoError(t, err)
+	require.Len(t, versions, 120)
+	assert.Equal(t, ParameterVersion{1, "encrypted:v1"}, versions[119])
+
+	_, err = p.GetParameterHistory("/app/db/user", true, 3)
+	assert.Error(t, err)
+}
+
+func TestARNFor(t *testing.T) {
+	arn := "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/password"
+	assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/host", ARNFor(arn, "/app/db/host"))
+	assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/app/db/host", ARNFor(arn, "/app/db/host:3"))
+	assert.Equal(t, "arn:aws:ssm:us-west-2:123456789012:parameter/db-host", ARNFor(arn, "db-host"))
+}
+
+func TestPublicParameters(t *testing.T) {
+	svc := &fakeSSM{Parameters: []*ssm.Parameter{
+		param(AmazonLinux2AMIParameter, ssm.ParameterTypeString, "ami-0a5e707736615003c"),
+		param(EKSOptimizedAMIParameter("1.11"), ssm.ParameterTypeString, "ami-0e8b2c7b4ba0e8f4e"),
+	}}
+	p := AWSProvider{Service: svc}
+
+	// Decryption isn't requested, so it can't fail for a public parameter
+	value, err := p.GetParameterValue(EKSOptimizedAMIParameter("1.11"), true)
+	require.NoError(t, err)
+	assert.Equal(t, "ami-0e8b2c7b4ba0e8f4e", value)
+
+	tags, err := p.GetParameterTags(AmazonLinux2AMIParameter)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	assert.True(t, IsPublicParameter("/aws/service/ami-amazon-linux-latest"))
+	assert.True(t, IsPublicParameter("/aws/service"))
+	assert.False(t, IsPublicParameter("/aws/servicefoo"))
+	assert.False(t, IsPublicParameter("/app/aws/service/foo"))
+}
+
+func TestGetParameterDataByPathPublicParameters(t *testing.T) {
+	svc := &fakeSSM{}
+	for i := 0; i < 35; i++ {
+		svc.Parameters = append(svc.Parameters, param(fmt.Sprintf("/aws/service/ami-amazon-linux-latest/ami-%d", i), ssm.ParameterTypeString, fmt.Sprintf("ami-%08d", i)))
+	}
+	// Nested parameters with the same basename
+	svc.Parameters = append(svc.Parameters,
+		param("/aws/service/eks/optimized-ami/1.10/amazon-linux-2/recommended/image_id", ssm.ParameterTypeString, "ami-1"),
+		param("/aws/service/eks/optimized-ami/1.11/amazon-linux-2/recommended/image_id", ssm.ParameterTypeString, "ami-2"),
+	)
+	p := AWSProvider{Service: svc}
+
+	values, err := p.GetParameterDataByPath("/aws/service/ami-amazon-linux-latest", false)
+	require.NoError(t, err)
+	assert.Len(t, values, 35)
+	assert.Equal(t, "ami-00000034", values["/aws/service/ami-amazon-linux-latest/ami-34"])
+
+	values, err = p.GetParameterDataByPath("/aws/service/eks/optimized-ami", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/aws/service/eks/optimized-ami/1.10/amazon-linux-2/recommended/image_id": "ami-1",
+		"/aws/service/eks/optimized-ami/1.11/amazon-linux-2/recommended/image_id": "ami-2",
+	}, values)
+}
+
+// Importing the latest Amazon Linux 2 AMI ID, as a ConfigMap with the
+// annotations
+//
+//	aws-ssm/aws-param-name: /aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2
+//	aws-ssm/aws-param-type: String
+func ExampleAmazonLinux2AMIParameter() {
+	p := AWSProvider{Service: &fakeSSM{Parameters: []*ssm.Parameter{
+		param(AmazonLinux2AMIParameter, ssm.ParameterTypeString, "ami-0a5e707736615003c"),
+	}}}
+
+	ami, err := p.GetParameterValue(AmazonLinux2AMIParameter, false)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(ami)
+	// Output: ami-0a5e707736615003c
+}
+
+func TestGetSecretValueVersionStage(t *testing.T) {
+	p := AWSProvider{SecretsManager: &fakeSecretsManager{Stages: map[string]map[string]string{
+		"db-creds": {
+			"AWSCURRENT": "current",
+			"AWSPENDING": "pending",
+		},
+		"api-key": {"AWSCURRENT": "current"},
+	}}}
+
+	for stage, expected := range map[string]string{"": "current", "AWSCURRENT": "current", "AWSPENDING": "pending"} {
+		value, err := p.GetSecretValue("db-creds", stage)
+		require.NoError(t, err, stage)
+		assert.Equal(t, SecretValue{String: expected}, value, stage)
+	}
+
+	_, err := p.GetSecretValue("api-key", "AWSPENDING")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Secret 'api-key' has no version with stage AWSPENDING")
+	assert.True(t, IsNotFound(err))
+
+	_, err = p.GetSecretValue("missing", "")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestBatchGetParameterValues(t *testing.T) {
+	svc := &fakeSSM{}
+	names := []string{}
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("/app/key%02d", i)
+		svc.Parameters = append(svc.Parameters, param(name, ssm.ParameterTypeSecureString, "secret"))
+		names = append(names, name)
+	}
+	svc.Parameters = append(svc.Parameters, param(AmazonLinux2AMIParameter, ssm.ParameterTypeString, "ami-123"))
+	names = append(names, AmazonLinux2AMIParameter)
+	p := AWSProvider{Service: svc}
+
+	values, err := p.BatchGetParameterValues(names, true)
+	require.NoError(t, err)
+	assert.Len(t, values, 13)
+	assert.Equal(t, "secret", values["/app/key11"])
+	assert.Equal(t, "ami-123", values[AmazonLinux2AMIParameter])
+	// Public parameters in their own batch
+	require.Len(t, svc.GetParametersCalls, 3)
+	assert.Len(t, svc.GetParametersCalls[1], 2)
+	assert.Equal(t, []string{AmazonLinux2AMIParameter}, svc.GetParametersCalls[2])
+
+	_, err = p.BatchGetParameterValues([]string{"/app/key00", "/app/missing"}, true)
+	assert.True(t, IsNotFound(err))
+	assert.Contains(t, err.Error(), "/app/missing")
+}
+
+func TestGetParameterTiersByPath(t *testing.T) {
+	// DescribeParameters, in two pages
+	pages := []string{
+		`{"Parameters":[{"Name":"/app/small","Tier":"Standard"},{"Name":"/app/nested/large","Tier":"Advanced"}],"NextToken":"2"}`,
+		`{"Parameters":[{"Name":"/app/other","Tier":"Standard"}]}`,
+	}
+	requests := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		requests = append(requests, in)
+		assert.Equal(t, "AmazonSSM.DescribeParameters", r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(pages[len(requests)-1]))
+	}))
+	defer srv.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	require.NoError(t, err)
+	p := AWSProvider{Service: ssm.New(sess)}
+
+	tiers, err := p.GetParameterTiersByPath("/app")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/small": "Standard", "/app/nested/large": "Advanced", "/app/other": "Standard"}, tiers)
+	require.Len(t, requests, 2)
+	assert.Equal(t, []interface{}{map[string]interface{}{"Key": "Path", "Option": "Recursive", "Values": []interface{}{"/app"}}}, requests[0]["ParameterFilters"])
+	assert.Nil(t, requests[0]["NextToken"])
+	assert.Equal(t, "2", requests[1]["NextToken"])
+}
+
+func TestListParametersByPath(t *testing.T) {
+	svc := &fakeSSM{
+		PageSize: 2,
+		Parameters: []*ssm.Parameter{
+			param("/app/plain", ssm.ParameterTypeString, "hello"),
+			param("/app/secret", ssm.ParameterTypeSecureString, "s3cret"),
+			param("/app/nested/key", ssm.ParameterTypeSecureString, "nested"),
+			param("/other/key", ssm.ParameterTypeString, "other"),
+		},
+	}
+	p := AWSProvider{Service: svc}
+
+	names, err := p.ListParametersByPath("/app")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/app/plain", "/app/secret", "/app/nested/key"}, names)
+	// Nothing is read, let alone decrypted
+	assert.Empty(t, svc.GetParametersCalls)
+
+	names, err = p.ListParametersByPath("/missing")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestAlwaysDecrypt(t *testing.T) {
+	svc := &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/plain", ssm.ParameterTypeString, "hello"),
+			param("/app/secret", ssm.ParameterTypeSecureString, "s3cret"),
+		},
+	}
+	p := AWSProvider{Service: svc, AlwaysDecrypt: true}
+
+	// Decrypted without asking
+	value, err := p.GetParameterValue("/app/secret", false)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+	values, err := p.GetParameterDataByPath("/app", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/plain": "hello", "/app/secret": "s3cret"}, values)
+	values, err = p.BatchGetParameterValues([]string{"/app/plain", "/app/secret"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/plain": "hello", "/app/secret": "s3cret"}, values)
+
+	p.AlwaysDecrypt = false
+	value, err = p.GetParameterValue("/app/secret", false)
+	require.NoError(t, err)
+	assert.NotEqual(t, "s3cret", value)
+}
+
+func TestNewAWSProviderAlwaysDecrypt(t *testing.T) {
+	cfg := config.DefaultConfig()
+	p, err := NewAWSProvider(cfg)
+	require.NoError(t, err)
+	assert.False(t, p.(AWSProvider).AlwaysDecrypt)
+
+	cfg.AlwaysDecrypt = true
+	p, err = NewAWSProvider(cfg)
+	require.NoError(t, err)
+	assert.True(t, p.(AWSProvider).AlwaysDecrypt)
+}
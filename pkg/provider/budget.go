@@ -0,0 +1,154 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// BudgetProvider spaces out the calls made to Provider so that no more than
+// the budgeted number happen per minute. A resync of many objects is spread
+// across the interval instead of bursting against the AWS API quotas.
+type BudgetProvider struct {
+	Provider Provider
+
+	*budget
+}
+
+// budget is the pacing of a BudgetProvider, which it can share (see Share)
+type budget struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// WithBudget limits p to callsPerMinute calls per minute
+func WithBudget(p Provider, callsPerMinute int) *BudgetProvider {
+	return &BudgetProvider{
+		Provider: p,
+		budget: &budget{
+			interval: time.Minute / time.Duration(callsPerMinute),
+			now:      time.Now,
+			sleep:    time.Sleep,
+		},
+	}
+}
+
+// Share returns p limited by the same budget as b: the calls to either count
+// against both
+func (b *BudgetProvider) Share(p Provider) *BudgetProvider {
+	return &BudgetProvider{Provider: p, budget: b.budget}
+}
+
+// wait blocks until the next call is within budget
+func (b *budget) wait() {
+	b.mu.Lock()
+	now := b.now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(b.interval)
+	b.mu.Unlock()
+
+	if delay > 0 {
+		b.sleep(delay)
+	}
+}
+
+func (b *BudgetProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	b.wait()
+	return b.Provider.GetParameterValue(name, decrypt)
+}
+
+// BatchGetParameterValues waits once per GetParameters call (10 names) when
+// Provider batches, or once per name when it doesn't
+func (b *BudgetProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	if _, ok := b.Provider.(BatchProvider); !ok {
+		return getEach(b, names, decrypt)
+	}
+	for i := 0; i < len(names); i += 10 {
+		b.wait()
+	}
+	return BatchGetParameterValues(b.Provider, names, decrypt)
+}
+
+// GetParameterHistory waits once, though a history of more than 50 versions
+// takes a second call
+func (b *BudgetProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	b.wait()
+	return GetParameterHistory(b.Provider, name, decrypt, limit)
+}
+
+func (b *BudgetProvider) GetParameterKeyID(name string) (string, error) {
+	b.wait()
+	return GetParameterKeyID(b.Provider, name)
+}
+
+// GetParameterTiersByPath waits once, though more than 50 parameters take more calls
+func (b *BudgetProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	b.wait()
+	return GetParameterTiersByPath(b.Provider, ppath)
+}
+
+// ListParametersByPath waits once, though more than 50 parameters take more calls
+func (b *BudgetProvider) ListParametersByPath(ppath string) ([]string, error) {
+	b.wait()
+	return ListParametersByPath(b.Provider, ppath)
+}
+
+func (b *BudgetProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	b.wait()
+	return GetParameterValueDecryptedAs(b.Provider, name, roleARN, grantTokens)
+}
+
+func (b *BudgetProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	b.wait()
+	return b.Provider.GetParameterValueWithGrants(name, grantTokens)
+}
+
+func (b *BudgetProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	b.wait()
+	return b.Provider.GetParameterDataByPath(ppath, decrypt)
+}
+
+func (b *BudgetProvider) GetParameterTags(name string) (map[string]string, error) {
+	b.wait()
+	return b.Provider.GetParameterTags(name)
+}
+
+func (b *BudgetProvider) GetParameterDescription(name string) (string, error) {
+	b.wait()
+	return b.Provider.GetParameterDescription(name)
+}
+
+func (b *BudgetProvider) GetParameterARN(name string) (string, error) {
+	b.wait()
+	return b.Provider.GetParameterARN(name)
+}
+
+func (b *BudgetProvider) GetParameterVersion(name string) (int64, error) {
+	b.wait()
+	return b.Provider.GetParameterVersion(name)
+}
+
+func (b *BudgetProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	b.wait()
+	return b.Provider.GetSecretValue(secretId, versionStage)
+}
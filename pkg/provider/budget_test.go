@@ -0,0 +1,109 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock advances only when slept on
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestBudgetPacesCalls(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	start := clock.t
+
+	b := WithBudget(MockProvider{"foo", "", map[string]string{}}, 60)
+	b.now = clock.Now
+	b.sleep = clock.Sleep
+
+	var calls []time.Duration
+	for i := 0; i < 5; i++ {
+		_, err := b.GetParameterValue("foo", false)
+		assert.Nil(t, err)
+		calls = append(calls, clock.t.Sub(start))
+	}
+
+	assert.Equal(t, []time.Duration{
+		0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second,
+	}, calls)
+}
+
+func TestBudgetDoesNotBankIdleTime(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+
+	b := WithBudget(MockProvider{"foo", "", map[string]string{}}, 60)
+	b.now = clock.Now
+	b.sleep = clock.Sleep
+
+	b.GetSecretValue("foo", "")
+	// Idle for longer than the whole window
+	clock.t = clock.t.Add(5 * time.Minute)
+	resumed := clock.t
+
+	b.GetParameterTags("foo")
+	assert.Equal(t, resumed, clock.t)
+	b.GetParameterDataByPath("/foo", false)
+	assert.Equal(t, resumed.Add(time.Second), clock.t)
+}
+
+func TestBudgetPacesBatches(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	start := clock.t
+
+	b := WithBudget(&batchingProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}}, 60)
+	b.now = clock.Now
+	b.sleep = clock.Sleep
+
+	names := []string{}
+	for i := 0; i < 25; i++ {
+		names = append(names, fmt.Sprintf("/app/key%02d", i))
+	}
+	_, err := b.BatchGetParameterValues(names, false)
+	assert.Nil(t, err)
+	_, err = b.GetParameterValue("foo", false)
+	assert.Nil(t, err)
+
+	// 3 GetParameters calls, then the next call waits its turn
+	assert.Equal(t, 3*time.Second, clock.t.Sub(start))
+}
+
+func TestBudgetBatchesWithoutBatchProvider(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	start := clock.t
+
+	b := WithBudget(MockProvider{"foo", "", map[string]string{}}, 60)
+	b.now = clock.Now
+	b.sleep = clock.Sleep
+
+	_, err := b.BatchGetParameterValues([]string{"a", "b", "c"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, clock.t.Sub(start))
+}
@@ -0,0 +1,260 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often the cache hit ratio is logged (and expired entries removed)
+var cacheStatsInterval = 5 * time.Minute
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// CachedProvider caches values from Provider for TTL, so objects referencing
+// the same parameter, and resyncs within the TTL, don't each call AWS. Errors
+// and parameter versions (see CheckMinVersion) are never cached. Maps returned
+// from the cache are shared and must not be modified.
+type CachedProvider struct {
+	Provider Provider
+	TTL      time.Duration
+
+	*cache
+	// Prefixes the keys of the entries of Provider, in a cache it shares (see Share)
+	scope string
+}
+
+// cache holds the entries of one or more CachedProviders
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+	// Since the hit ratio was last logged
+	hits, misses int
+	lastStats    time.Time
+}
+
+// WithCache caches the values of p for ttl
+func WithCache(p Provider, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{
+		Provider: p,
+		TTL:      ttl,
+		cache: &cache{
+			entries:   make(map[string]cacheEntry),
+			now:       time.Now,
+			lastStats: time.Now(),
+		},
+	}
+}
+
+// Share returns p cached in the same cache as c, for the same TTL. scope
+// keeps its values apart from those of the other providers of the cache (e.g.,
+// the same name in another region).
+func (c *CachedProvider) Share(p Provider, scope string) *CachedProvider {
+	return &CachedProvider{Provider: p, TTL: c.TTL, cache: c.cache, scope: scope + "|"}
+}
+
+// get returns the cached value for key, or calls fetch and caches its result
+func (c *CachedProvider) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	now := c.now()
+	c.logStats(now)
+	if e, ok := c.entries[c.scope+key]; ok && now.Before(e.expires) {
+		c.hits += 1
+		c.mu.Unlock()
+		metrics.CacheHits.Inc()
+		return e.value, nil
+	}
+	c.misses += 1
+	c.mu.Unlock()
+	metrics.CacheMisses.Inc()
+
+	value, err := fetch()
+	if err == nil {
+		c.set(key, value)
+	}
+	return value, err
+}
+
+func (c *CachedProvider) set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[c.scope+key] = cacheEntry{value: value, expires: c.now().Add(c.TTL)}
+}
+
+// logStats logs the hit ratio, and removes expired entries, once per cacheStatsInterval.
+// c.mu must be held.
+func (c *cache) logStats(now time.Time) {
+	if now.Sub(c.lastStats) < cacheStatsInterval {
+		return
+	}
+	if total := c.hits + c.misses; total > 0 {
+		log.Infof("Cache hit ratio: %.1f%% (%d hits, %d misses in %s)",
+			100*float64(c.hits)/float64(total), c.hits, c.misses, now.Sub(c.lastStats).Round(time.Second))
+	}
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.hits, c.misses = 0, 0
+	c.lastStats = now
+}
+
+func (c *CachedProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	v, err := c.get("value:"+strconv.FormatBool(decrypt)+":"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterValue(name, decrypt)
+	})
+	return v.(string), err
+}
+
+// GetParameterValueFresh reads name from Provider even if it's cached (and
+// not expired), then caches the value for the full TTL. On error the cached
+// value, if any, is kept. Fresh reads aren't counted as hits or misses.
+func (c *CachedProvider) GetParameterValueFresh(name string, decrypt bool) (string, error) {
+	value, err := GetParameterValueFresh(c.Provider, name, decrypt)
+	if err == nil {
+		c.set("value:"+strconv.FormatBool(decrypt)+":"+name, value)
+	}
+	return value, err
+}
+
+// BatchGetParameterValues serves the cached names and reads the rest in one
+// batch. Values are cached as if each was read with GetParameterValue.
+func (c *CachedProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	missing := []string{}
+
+	c.mu.Lock()
+	now := c.now()
+	c.logStats(now)
+	for _, name := range names {
+		if e, ok := c.entries[c.scope+"value:"+strconv.FormatBool(decrypt)+":"+name]; ok && now.Before(e.expires) {
+			values[name] = e.value.(string)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	hits := len(names) - len(missing)
+	c.hits += hits
+	c.misses += len(missing)
+	c.mu.Unlock()
+	metrics.CacheHits.Add(float64(hits))
+	metrics.CacheMisses.Add(float64(len(missing)))
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+	fetched, err := BatchGetParameterValues(c.Provider, missing, decrypt)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fetched {
+		c.set("value:"+strconv.FormatBool(decrypt)+":"+name, value)
+		values[name] = value
+	}
+	return values, nil
+}
+
+func (c *CachedProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	v, err := c.get("history:"+strconv.FormatBool(decrypt)+":"+strconv.Itoa(limit)+":"+name, func() (interface{}, error) {
+		return GetParameterHistory(c.Provider, name, decrypt, limit)
+	})
+	return v.([]ParameterVersion), err
+}
+
+func (c *CachedProvider) GetParameterKeyID(name string) (string, error) {
+	v, err := c.get("keyid:"+name, func() (interface{}, error) {
+		return GetParameterKeyID(c.Provider, name)
+	})
+	return v.(string), err
+}
+
+func (c *CachedProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	v, err := c.get("tiers:"+ppath, func() (interface{}, error) {
+		return GetParameterTiersByPath(c.Provider, ppath)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CachedProvider) ListParametersByPath(ppath string) ([]string, error) {
+	v, err := c.get("list:"+ppath, func() (interface{}, error) {
+		return ListParametersByPath(c.Provider, ppath)
+	})
+	return v.([]string), err
+}
+
+func (c *CachedProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	v, err := c.get("decrypt-as:"+roleARN+":"+strings.Join(grantTokens, ",")+":"+name, func() (interface{}, error) {
+		return GetParameterValueDecryptedAs(c.Provider, name, roleARN, grantTokens)
+	})
+	return v.(string), err
+}
+
+func (c *CachedProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	v, err := c.get("grants:"+strings.Join(grantTokens, ",")+":"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterValueWithGrants(name, grantTokens)
+	})
+	return v.(string), err
+}
+
+func (c *CachedProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	v, err := c.get("path:"+strconv.FormatBool(decrypt)+":"+ppath, func() (interface{}, error) {
+		return c.Provider.GetParameterDataByPath(ppath, decrypt)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CachedProvider) GetParameterTags(name string) (map[string]string, error) {
+	v, err := c.get("tags:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterTags(name)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CachedProvider) GetParameterDescription(name string) (string, error) {
+	v, err := c.get("description:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterDescription(name)
+	})
+	return v.(string), err
+}
+
+func (c *CachedProvider) GetParameterARN(name string) (string, error) {
+	v, err := c.get("arn:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterARN(name)
+	})
+	return v.(string), err
+}
+
+func (c *CachedProvider) GetParameterVersion(name string) (int64, error) {
+	return c.Provider.GetParameterVersion(name)
+}
+
+func (c *CachedProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	v, err := c.get("secret:"+versionStage+":"+secretId, func() (interface{}, error) {
+		return c.Provider.GetSecretValue(secretId, versionStage)
+	})
+	return v.(SecretValue), err
+}
@@ -0,0 +1,174 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider counts calls to GetParameterValue
+type countingProvider struct {
+	MockProvider
+	calls int
+}
+
+func (cp *countingProvider) GetParameterValue(s string, b bool) (string, error) {
+	cp.calls += 1
+	return cp.MockProvider.GetParameterValue(s, b)
+}
+
+// batchingProvider records the names of each BatchGetParameterValues call
+type batchingProvider struct {
+	MockProvider
+	batches [][]string
+}
+
+func (bp *batchingProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	bp.batches = append(bp.batches, names)
+	return getEach(bp.MockProvider, names, decrypt)
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	cp := &countingProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}}
+	c := WithCache(cp, time.Minute)
+	c.now = clock.Now
+
+	hits := testutil.ToFloat64(metrics.CacheHits)
+	misses := testutil.ToFloat64(metrics.CacheMisses)
+
+	value, err := c.GetParameterValue("foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", value)
+	assert.Equal(t, hits, testutil.ToFloat64(metrics.CacheHits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.CacheMisses))
+
+	value, err = c.GetParameterValue("foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", value)
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.CacheHits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.CacheMisses))
+	assert.Equal(t, 1, cp.calls)
+
+	// Expired
+	clock.Sleep(time.Minute)
+	c.GetParameterValue("foo", false)
+	assert.Equal(t, misses+2, testutil.ToFloat64(metrics.CacheMisses))
+	assert.Equal(t, 2, cp.calls)
+}
+
+func TestCacheKeysOnDecrypt(t *testing.T) {
+	cp := &countingProvider{MockProvider: MockProvider{"foo", "decrypted", map[string]string{}}}
+	c := WithCache(cp, time.Minute)
+
+	value, _ := c.GetParameterValue("foo", false)
+	assert.Equal(t, "foo", value)
+	value, _ = c.GetParameterValue("foo", true)
+	assert.Equal(t, "decrypted", value)
+	assert.Equal(t, 2, cp.calls)
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+	cp := &countingProvider{MockProvider: MockProvider{"(error)", "throttled", map[string]string{}}}
+	c := WithCache(cp, time.Minute)
+
+	_, err := c.GetParameterValue("foo", false)
+	assert.Error(t, err)
+	_, err = c.GetParameterValue("foo", false)
+	assert.Error(t, err)
+	assert.Equal(t, 2, cp.calls)
+}
+
+func TestCacheStatsRemoveExpiredEntries(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	c := WithCache(MockProvider{"foo", "", map[string]string{}}, time.Minute)
+	c.now = clock.Now
+	c.lastStats = clock.t
+
+	c.GetParameterValue("foo", false)
+	c.GetParameterValue("bar", false)
+	assert.Len(t, c.entries, 2)
+
+	clock.Sleep(cacheStatsInterval)
+	c.GetSecretValue("baz", "")
+	assert.Len(t, c.entries, 1)
+	assert.Equal(t, 0, c.hits)
+	assert.Equal(t, 1, c.misses)
+}
+
+func TestCacheGetParameterValueFresh(t *testing.T) {
+	cp := &countingProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}}
+	c := WithCache(cp, time.Minute)
+
+	c.GetParameterValue("foo", false)
+	cp.MockProvider.Value = "bar"
+	value, _ := c.GetParameterValue("foo", false)
+	assert.Equal(t, "foo", value)
+
+	hits := testutil.ToFloat64(metrics.CacheHits)
+	misses := testutil.ToFloat64(metrics.CacheMisses)
+	value, err := GetParameterValueFresh(Coalesced(c), "foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", value)
+	assert.Equal(t, 2, cp.calls)
+	assert.Equal(t, hits, testutil.ToFloat64(metrics.CacheHits))
+	assert.Equal(t, misses, testutil.ToFloat64(metrics.CacheMisses))
+
+	// The cache was updated
+	value, _ = c.GetParameterValue("foo", false)
+	assert.Equal(t, "bar", value)
+	assert.Equal(t, 2, cp.calls)
+
+	// Errors keep the cached value
+	cp.MockProvider = MockProvider{"(error)", "throttled", map[string]string{}}
+	_, err = c.GetParameterValueFresh("foo", false)
+	assert.Error(t, err)
+	value, _ = c.GetParameterValue("foo", false)
+	assert.Equal(t, "bar", value)
+}
+
+func TestGetParameterValueFreshWithoutCache(t *testing.T) {
+	value, err := GetParameterValueFresh(MockProvider{"foo", "", map[string]string{}}, "foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", value)
+}
+
+func TestCacheBatchesMisses(t *testing.T) {
+	bp := &batchingProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}}
+	c := WithCache(bp, time.Minute)
+	hits := testutil.ToFloat64(metrics.CacheHits)
+	misses := testutil.ToFloat64(metrics.CacheMisses)
+
+	_, err := c.GetParameterValue("a", false)
+	assert.Nil(t, err)
+
+	values, err := c.BatchGetParameterValues([]string{"a", "b", "c"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "foo", "b": "foo", "c": "foo"}, values)
+	assert.Equal(t, [][]string{{"b", "c"}}, bp.batches)
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.CacheHits))
+	assert.Equal(t, misses+3, testutil.ToFloat64(metrics.CacheMisses))
+
+	// The batch is cached by name
+	_, err = c.GetParameterValue("c", false)
+	assert.Nil(t, err)
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.CacheHits))
+}
@@ -0,0 +1,155 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CoalescedProvider shares one underlying call between concurrent identical
+// requests to Provider (e.g., several objects reading the same parameter).
+// Results are only shared while a call is in flight; nothing is cached.
+// Maps returned to concurrent callers are the same map and must not be modified.
+type CoalescedProvider struct {
+	Provider Provider
+
+	group *singleflight.Group
+	// Prefixes the keys of the calls to Provider, in a group it shares (see Share)
+	scope string
+}
+
+// Coalesced returns p with concurrent identical requests coalesced
+func Coalesced(p Provider) *CoalescedProvider {
+	return &CoalescedProvider{Provider: p, group: &singleflight.Group{}}
+}
+
+// Share returns p coalesced in the same group of calls as c. scope keeps its
+// calls apart from those of the other providers of the group.
+func (c *CoalescedProvider) Share(p Provider, scope string) *CoalescedProvider {
+	return &CoalescedProvider{Provider: p, group: c.group, scope: scope + "|"}
+}
+
+func (c *CoalescedProvider) do(key string, fn func() (interface{}, error)) (interface{}, error, bool) {
+	return c.group.Do(c.scope+key, fn)
+}
+
+func (c *CoalescedProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	v, err, _ := c.do("value:"+strconv.FormatBool(decrypt)+":"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterValue(name, decrypt)
+	})
+	return v.(string), err
+}
+
+// GetParameterValueFresh only joins another fresh read that's in flight, so
+// it's never served a cached value
+func (c *CoalescedProvider) GetParameterValueFresh(name string, decrypt bool) (string, error) {
+	v, err, _ := c.do("fresh:"+strconv.FormatBool(decrypt)+":"+name, func() (interface{}, error) {
+		return GetParameterValueFresh(c.Provider, name, decrypt)
+	})
+	return v.(string), err
+}
+
+// BatchGetParameterValues isn't coalesced: concurrent batches rarely hold the same names
+func (c *CoalescedProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	return BatchGetParameterValues(c.Provider, names, decrypt)
+}
+
+func (c *CoalescedProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	v, err, _ := c.do("history:"+strconv.FormatBool(decrypt)+":"+strconv.Itoa(limit)+":"+name, func() (interface{}, error) {
+		return GetParameterHistory(c.Provider, name, decrypt, limit)
+	})
+	return v.([]ParameterVersion), err
+}
+
+func (c *CoalescedProvider) GetParameterKeyID(name string) (string, error) {
+	v, err, _ := c.do("keyid:"+name, func() (interface{}, error) {
+		return GetParameterKeyID(c.Provider, name)
+	})
+	return v.(string), err
+}
+
+func (c *CoalescedProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	v, err, _ := c.do("tiers:"+ppath, func() (interface{}, error) {
+		return GetParameterTiersByPath(c.Provider, ppath)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CoalescedProvider) ListParametersByPath(ppath string) ([]string, error) {
+	v, err, _ := c.do("list:"+ppath, func() (interface{}, error) {
+		return ListParametersByPath(c.Provider, ppath)
+	})
+	return v.([]string), err
+}
+
+func (c *CoalescedProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	v, err, _ := c.do("decrypt-as:"+roleARN+":"+strings.Join(grantTokens, ",")+":"+name, func() (interface{}, error) {
+		return GetParameterValueDecryptedAs(c.Provider, name, roleARN, grantTokens)
+	})
+	return v.(string), err
+}
+
+func (c *CoalescedProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	v, err, _ := c.do("grants:"+strings.Join(grantTokens, ",")+":"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterValueWithGrants(name, grantTokens)
+	})
+	return v.(string), err
+}
+
+func (c *CoalescedProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	v, err, _ := c.do("path:"+strconv.FormatBool(decrypt)+":"+ppath, func() (interface{}, error) {
+		return c.Provider.GetParameterDataByPath(ppath, decrypt)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CoalescedProvider) GetParameterTags(name string) (map[string]string, error) {
+	v, err, _ := c.do("tags:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterTags(name)
+	})
+	return v.(map[string]string), err
+}
+
+func (c *CoalescedProvider) GetParameterDescription(name string) (string, error) {
+	v, err, _ := c.do("description:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterDescription(name)
+	})
+	return v.(string), err
+}
+
+func (c *CoalescedProvider) GetParameterARN(name string) (string, error) {
+	v, err, _ := c.do("arn:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterARN(name)
+	})
+	return v.(string), err
+}
+
+func (c *CoalescedProvider) GetParameterVersion(name string) (int64, error) {
+	v, err, _ := c.do("version:"+name, func() (interface{}, error) {
+		return c.Provider.GetParameterVersion(name)
+	})
+	return v.(int64), err
+}
+
+func (c *CoalescedProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	v, err, _ := c.do("secret:"+versionStage+":"+secretId, func() (interface{}, error) {
+		return c.Provider.GetSecretValue(secretId, versionStage)
+	})
+	return v.(SecretValue), err
+}
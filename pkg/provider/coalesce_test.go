@@ -0,0 +1,89 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingProvider counts calls, and blocks them until release is closed
+type blockingProvider struct {
+	NullProvider
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	if atomic.AddInt32(&b.calls, 1) == 1 {
+		close(b.started)
+	}
+	<-b.release
+	return name + "-value", nil
+}
+
+func TestCoalescedSharesConcurrentCalls(t *testing.T) {
+	bp := &blockingProvider{started: make(chan struct{}), release: make(chan struct{})}
+	c := Coalesced(bp)
+
+	var ready, done sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		ready.Add(1)
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			ready.Done()
+			results[i], _ = c.GetParameterValue("foo", true)
+		}(i)
+	}
+
+	// Hold the first call open until every request has been made
+	<-bp.started
+	ready.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(bp.release)
+	done.Wait()
+
+	for _, r := range results {
+		assert.Equal(t, "foo-value", r)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&bp.calls))
+}
+
+func TestCoalescedKeysOnDecrypt(t *testing.T) {
+	bp := &blockingProvider{started: make(chan struct{}), release: make(chan struct{})}
+	close(bp.release)
+	c := Coalesced(bp)
+
+	c.GetParameterValue("foo", true)
+	c.GetParameterValue("foo", false)
+	assert.Equal(t, int32(2), bp.calls)
+}
+
+func TestCoalescedReturnsErrors(t *testing.T) {
+	c := Coalesced(MockProvider{"(error)", "", map[string]string{}})
+
+	_, err := c.GetParameterValue("foo", false)
+	assert.Error(t, err)
+	_, err = c.GetSecretValue("foo", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,147 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"context"
+)
+
+// ContextProvider stops calling Provider once Context is done: each call then
+// returns the context's error instead. A call already in flight isn't
+// interrupted, but a read of many parameters ends at its next call.
+type ContextProvider struct {
+	Provider Provider
+	Context  context.Context
+}
+
+// WithContext returns p, making no more calls once ctx is done
+func WithContext(ctx context.Context, p Provider) *ContextProvider {
+	return &ContextProvider{Provider: p, Context: ctx}
+}
+
+// Context returns the context of p (see WithContext), or context.Background()
+// if it has none
+func Context(p Provider) context.Context {
+	if c, ok := p.(*ContextProvider); ok {
+		return c.Context
+	}
+	return context.Background()
+}
+
+func (c *ContextProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return c.Provider.GetParameterValue(name, decrypt)
+}
+
+func (c *ContextProvider) GetParameterValueFresh(name string, decrypt bool) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return GetParameterValueFresh(c.Provider, name, decrypt)
+}
+
+func (c *ContextProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return BatchGetParameterValues(c.Provider, names, decrypt)
+}
+
+func (c *ContextProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return GetParameterHistory(c.Provider, name, decrypt, limit)
+}
+
+func (c *ContextProvider) GetParameterKeyID(name string) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return GetParameterKeyID(c.Provider, name)
+}
+
+func (c *ContextProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return GetParameterTiersByPath(c.Provider, ppath)
+}
+
+func (c *ContextProvider) ListParametersByPath(ppath string) ([]string, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return ListParametersByPath(c.Provider, ppath)
+}
+
+func (c *ContextProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return GetParameterValueDecryptedAs(c.Provider, name, roleARN, grantTokens)
+}
+
+func (c *ContextProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return c.Provider.GetParameterValueWithGrants(name, grantTokens)
+}
+
+func (c *ContextProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return c.Provider.GetParameterDataByPath(ppath, decrypt)
+}
+
+func (c *ContextProvider) GetParameterTags(name string) (map[string]string, error) {
+	if err := c.Context.Err(); err != nil {
+		return nil, err
+	}
+	return c.Provider.GetParameterTags(name)
+}
+
+func (c *ContextProvider) GetParameterDescription(name string) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return c.Provider.GetParameterDescription(name)
+}
+
+func (c *ContextProvider) GetParameterARN(name string) (string, error) {
+	if err := c.Context.Err(); err != nil {
+		return "", err
+	}
+	return c.Provider.GetParameterARN(name)
+}
+
+func (c *ContextProvider) GetParameterVersion(name string) (int64, error) {
+	if err := c.Context.Err(); err != nil {
+		return 0, err
+	}
+	return c.Provider.GetParameterVersion(name)
+}
+
+func (c *ContextProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	if err := c.Context.Err(); err != nil {
+		return SecretValue{}, err
+	}
+	return c.Provider.GetSecretValue(secretId, versionStage)
+}
@@ -0,0 +1,270 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces each ${VAR} in name with the value of the environment
+// variable VAR, which must be one of allowed. Other and unset variables are an
+// error, rather than read as a literal name. Errors only name the variables.
+func ExpandEnv(name string, allowed []string) (string, error) {
+	var denied, missing []string
+	expanded := envReference.ReplaceAllStringFunc(name, func(ref string) string {
+		key := ref[2 : len(ref)-1]
+		if !contains(allowed, key) {
+			denied = append(denied, key)
+			return ref
+		}
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			missing = append(missing, key)
+		}
+		return v
+	})
+	if len(denied) > 0 {
+		return "", fmt.Errorf("Parameter '%s': environment variable(s) not in -interpolate-env-vars: %s", name, strings.Join(denied, ", "))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Parameter '%s': environment variable(s) not set: %s", name, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvProvider expands ${VAR} references to the Vars environment variables in
+// the names (and paths) read from Provider (-interpolate-env). Only the AWS
+// calls see the expanded names: errors name parameters as they were written,
+// so the values of the variables never end up on the objects.
+type EnvProvider struct {
+	Provider Provider
+	Vars     []string
+}
+
+// WithEnv expands references to vars in the names read from p
+func WithEnv(p Provider, vars []string) *EnvProvider {
+	return &EnvProvider{Provider: p, Vars: vars}
+}
+
+// envError is an error of a read of expanded names, with the names as they
+// were written in its message; errors.Is and errors.As still see the original
+type envError struct {
+	err      error
+	replacer *strings.Replacer
+}
+
+func (e *envError) Error() string {
+	return e.replacer.Replace(e.err.Error())
+}
+
+func (e *envError) Unwrap() error {
+	return e.err
+}
+
+// expand expands each of names, calling read with the expanded names. Errors
+// of read name the parameters as they were written.
+func (e *EnvProvider) expand(read func(expanded []string) error, names ...string) error {
+	expanded := make([]string, len(names))
+	var pairs []string
+	for i, name := range names {
+		var err error
+		if expanded[i], err = ExpandEnv(name, e.Vars); err != nil {
+			return err
+		}
+		if expanded[i] != name {
+			pairs = append(pairs, expanded[i], name)
+		}
+	}
+	err := read(expanded)
+	if err != nil && len(pairs) > 0 {
+		return &envError{err: err, replacer: strings.NewReplacer(pairs...)}
+	}
+	return err
+}
+
+// unexpand returns name, read under the expanded path, under ppath as it was written
+func unexpand(name string, ppath string, expanded string) string {
+	if strings.HasPrefix(name, expanded) {
+		return ppath + strings.TrimPrefix(name, expanded)
+	}
+	return name
+}
+
+// unexpandKeys returns read, keyed by the full names under ppath as it was written
+func unexpandKeys(read map[string]string, ppath string, expanded string) map[string]string {
+	if read == nil {
+		return nil
+	}
+	values := make(map[string]string, len(read))
+	for name, value := range read {
+		values[unexpand(name, ppath, expanded)] = value
+	}
+	return values
+}
+
+func (e *EnvProvider) GetParameterValue(name string, decrypt bool) (value string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		value, err = e.Provider.GetParameterValue(expanded[0], decrypt)
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterValueFresh(name string, decrypt bool) (value string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		value, err = GetParameterValueFresh(e.Provider, expanded[0], decrypt)
+		return
+	}, name)
+	return
+}
+
+// BatchGetParameterValues returns the values keyed by the names as they were written
+func (e *EnvProvider) BatchGetParameterValues(names []string, decrypt bool) (values map[string]string, err error) {
+	err = e.expand(func(expanded []string) error {
+		read, err := BatchGetParameterValues(e.Provider, expanded, decrypt)
+		if err != nil {
+			return err
+		}
+		values = make(map[string]string, len(names))
+		for i, name := range names {
+			values[name] = read[expanded[i]]
+		}
+		return nil
+	}, names...)
+	return
+}
+
+func (e *EnvProvider) GetParameterHistory(name string, decrypt bool, limit int) (versions []ParameterVersion, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		versions, err = GetParameterHistory(e.Provider, expanded[0], decrypt, limit)
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterKeyID(name string) (keyID string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		keyID, err = GetParameterKeyID(e.Provider, expanded[0])
+		return
+	}, name)
+	return
+}
+
+// GetParameterTiersByPath returns the tiers by the full names under ppath as it was written
+func (e *EnvProvider) GetParameterTiersByPath(ppath string) (tiers map[string]string, err error) {
+	err = e.expand(func(expanded []string) error {
+		read, err := GetParameterTiersByPath(e.Provider, expanded[0])
+		tiers = unexpandKeys(read, ppath, expanded[0])
+		return err
+	}, ppath)
+	return
+}
+
+// ListParametersByPath returns the full names under ppath as it was written
+func (e *EnvProvider) ListParametersByPath(ppath string) (names []string, err error) {
+	err = e.expand(func(expanded []string) error {
+		listed, err := ListParametersByPath(e.Provider, expanded[0])
+		if err != nil {
+			return err
+		}
+		names = make([]string, len(listed))
+		for i, name := range listed {
+			names[i] = unexpand(name, ppath, expanded[0])
+		}
+		return nil
+	}, ppath)
+	return
+}
+
+func (e *EnvProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (value string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		value, err = GetParameterValueDecryptedAs(e.Provider, expanded[0], roleARN, grantTokens)
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterValueWithGrants(name string, grantTokens []string) (value string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		value, err = e.Provider.GetParameterValueWithGrants(expanded[0], grantTokens)
+		return
+	}, name)
+	return
+}
+
+// GetParameterDataByPath returns the values by the full names under ppath as it was written
+func (e *EnvProvider) GetParameterDataByPath(ppath string, decrypt bool) (values map[string]string, err error) {
+	err = e.expand(func(expanded []string) error {
+		read, err := e.Provider.GetParameterDataByPath(expanded[0], decrypt)
+		values = unexpandKeys(read, ppath, expanded[0])
+		return err
+	}, ppath)
+	return
+}
+
+func (e *EnvProvider) GetParameterTags(name string) (tags map[string]string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		tags, err = e.Provider.GetParameterTags(expanded[0])
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterDescription(name string) (description string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		description, err = e.Provider.GetParameterDescription(expanded[0])
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterARN(name string) (arn string, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		arn, err = e.Provider.GetParameterARN(expanded[0])
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetParameterVersion(name string) (version int64, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		version, err = e.Provider.GetParameterVersion(expanded[0])
+		return
+	}, name)
+	return
+}
+
+func (e *EnvProvider) GetSecretValue(secretId string, versionStage string) (value SecretValue, err error) {
+	err = e.expand(func(expanded []string) (err error) {
+		value, err = e.Provider.GetSecretValue(expanded[0], versionStage)
+		return
+	}, secretId)
+	return
+}
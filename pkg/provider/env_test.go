@@ -0,0 +1,113 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namingProvider records the names read, which aren't found under /missing/
+type namingProvider struct {
+	MockProvider
+	names []string
+}
+
+func (np *namingProvider) GetParameterValue(s string, b bool) (string, error) {
+	np.names = append(np.names, s)
+	if strings.HasPrefix(s, "/missing/") {
+		return "", awserr.New(ssm.ErrCodeParameterNotFound, "Parameter "+s+" not found", nil)
+	}
+	return np.MockProvider.GetParameterValue(s, b)
+}
+
+func setTestEnv() func() {
+	os.Setenv("AWS_SSM_TEST_CLUSTER", "prod-1")
+	os.Setenv("AWS_SSM_TEST_SECRET", "SUPERSECRET")
+	os.Unsetenv("AWS_SSM_TEST_UNSET")
+	return func() {
+		os.Unsetenv("AWS_SSM_TEST_CLUSTER")
+		os.Unsetenv("AWS_SSM_TEST_SECRET")
+	}
+}
+
+var testEnvVars = []string{"AWS_SSM_TEST_CLUSTER", "AWS_SSM_TEST_UNSET"}
+
+func TestExpandEnv(t *testing.T) {
+	defer setTestEnv()()
+
+	for name, exp := range map[string]string{
+		"/app/db-host":                                     "/app/db-host",
+		"/app/${AWS_SSM_TEST_CLUSTER}/db-host":             "/app/prod-1/db-host",
+		"/${AWS_SSM_TEST_CLUSTER}/${AWS_SSM_TEST_CLUSTER}": "/prod-1/prod-1",
+		"/app/$AWS_SSM_TEST_CLUSTER":                       "/app/$AWS_SSM_TEST_CLUSTER",
+	} {
+		v, err := ExpandEnv(name, testEnvVars)
+		assert.NoError(t, err)
+		assert.Equal(t, exp, v)
+	}
+
+	_, err := ExpandEnv("/app/${AWS_SSM_TEST_CLUSTER}/${AWS_SSM_TEST_UNSET}", testEnvVars)
+	assert.EqualError(t, err, "Parameter '/app/${AWS_SSM_TEST_CLUSTER}/${AWS_SSM_TEST_UNSET}': environment variable(s) not set: AWS_SSM_TEST_UNSET")
+
+	// Set, but not allowed
+	_, err = ExpandEnv("/x/${AWS_SSM_TEST_SECRET}", testEnvVars)
+	assert.EqualError(t, err, "Parameter '/x/${AWS_SSM_TEST_SECRET}': environment variable(s) not in -interpolate-env-vars: AWS_SSM_TEST_SECRET")
+	_, err = ExpandEnv("/x/${AWS_SSM_TEST_CLUSTER}", nil)
+	assert.Error(t, err)
+}
+
+func TestEnvProvider(t *testing.T) {
+	defer setTestEnv()()
+	np := &namingProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}}
+	e := WithEnv(np, testEnvVars)
+
+	value, err := e.GetParameterValue("/app/${AWS_SSM_TEST_CLUSTER}/db-host", false)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", value)
+	assert.Equal(t, []string{"/app/prod-1/db-host"}, np.names)
+
+	// Errors name the parameter as it was written
+	_, err = e.GetParameterValue("/missing/${AWS_SSM_TEST_CLUSTER}", false)
+	require.Error(t, err)
+	assert.Equal(t, "ParameterNotFound: Parameter /missing/${AWS_SSM_TEST_CLUSTER} not found", err.Error())
+	assert.True(t, IsNotFound(err))
+
+	// Variables outside Vars are never read
+	np.names = nil
+	_, err = e.GetParameterValue("/x/${AWS_SSM_TEST_SECRET}", false)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "SUPERSECRET")
+	assert.Empty(t, np.names)
+
+	// Batches are keyed by the names as they were written
+	values, err := e.BatchGetParameterValues([]string{"/app/${AWS_SSM_TEST_CLUSTER}/a", "/app/b"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/${AWS_SSM_TEST_CLUSTER}/a": "foo", "/app/b": "foo"}, values)
+	assert.Equal(t, []string{"/app/prod-1/a", "/app/b"}, np.names)
+
+	// So are the full names under a path
+	np.DirectoryContents = map[string]string{"/app/prod-1/db/host": "db.internal"}
+	values, err = e.GetParameterDataByPath("/app/${AWS_SSM_TEST_CLUSTER}", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/${AWS_SSM_TEST_CLUSTER}/db/host": "db.internal"}, values)
+}
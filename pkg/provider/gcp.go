@@ -0,0 +1,247 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	GCPSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1"
+	// Serves the tokens of the pod's service account (with Workload Identity) or the node's
+	GCPMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCPSecretManagerProvider reads GCP Secret Manager secrets in Project, for clusters
+// that run in both clouds (-provider=gcp). Secret IDs can't contain slashes, so
+// parameter names map to IDs with the leading slash trimmed and the rest replaced
+// by underscores ("/app/db/host" -> "app_db_host"). Values are the latest version.
+type GCPSecretManagerProvider struct {
+	Project     string
+	Endpoint    string
+	TokenURL    string
+	Client      *http.Client
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCPSecretManagerProvider authenticates with the metadata server's tokens
+func NewGCPSecretManagerProvider(cfg *config.Config) (Provider, error) {
+	if cfg.GCPProject == "" {
+		return nil, fmt.Errorf("-gcp-project is required with -provider=gcp")
+	}
+	return &GCPSecretManagerProvider{
+		Project:  cfg.GCPProject,
+		Endpoint: GCPSecretManagerEndpoint,
+		TokenURL: GCPMetadataTokenURL,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// GCPSecretID returns the secret ID for a parameter name
+func GCPSecretID(name string) string {
+	return strings.Replace(strings.Trim(name, "/"), "/", "_", -1)
+}
+
+// accessToken returns a cached token, refreshing it a minute before it expires
+func (g *GCPSecretManagerProvider) accessToken() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.tokenExpiry) {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequest("GET", g.TokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := g.do(req, &token); err != nil {
+		return "", fmt.Errorf("Failed to get a GCP access token: %s", err)
+	}
+	g.token = token.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+// get reads resource (relative to the project) into out
+func (g *GCPSecretManagerProvider) get(resource string, query url.Values, out interface{}) error {
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+	u := g.Endpoint + "/projects/" + url.PathEscape(g.Project) + "/" + resource
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return g.do(req, out)
+}
+
+func (g *GCPSecretManagerProvider) do(req *http.Request, out interface{}) error {
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("%s: %s", resp.Status, body.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// access returns the payload of the latest version of the secret
+func (g *GCPSecretManagerProvider) access(id string) ([]byte, error) {
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := g.get("secrets/"+url.PathEscape(id)+"/versions/latest:access", nil, &version); err != nil {
+		log.Errorf("Failed to access GCP secret %s: %s", id, err)
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(version.Payload.Data)
+}
+
+// GetParameterValue returns the latest version of the secret. GCP decrypts
+// every secret, so decrypt is ignored.
+func (g *GCPSecretManagerProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	value, err := g.access(GCPSecretID(name))
+	return string(value), err
+}
+
+func (g *GCPSecretManagerProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	return g.GetParameterValue(name, true)
+}
+
+// GetParameterDataByPath returns every secret whose ID starts with the ID of
+// ppath and an underscore, keyed by ppath and the rest of the ID ("/app/db" ->
+// "app_db_host": "/app/db/host")
+func (g *GCPSecretManagerProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	prefix := GCPSecretID(ppath) + "_"
+	if prefix == "_" {
+		prefix = ""
+	}
+	results := make(map[string]string)
+	query := url.Values{"pageSize": {"100"}}
+	for {
+		var page struct {
+			Secrets []struct {
+				Name string `json:"name"`
+			} `json:"secrets"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := g.get("secrets", query, &page); err != nil {
+			log.Errorf("Failed to list GCP secrets: %s", err)
+			return nil, err
+		}
+		for _, secret := range page.Secrets {
+			id := path.Base(secret.Name)
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+			value, err := g.access(id)
+			if err != nil {
+				return nil, err
+			}
+			results[strings.TrimRight(ppath, "/")+"/"+strings.TrimPrefix(id, prefix)] = string(value)
+		}
+		if page.NextPageToken == "" {
+			return results, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// GetParameterTags returns the secret's labels
+func (g *GCPSecretManagerProvider) GetParameterTags(name string) (map[string]string, error) {
+	var secret struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := g.get("secrets/"+url.PathEscape(GCPSecretID(name)), nil, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return secret.Labels, nil
+}
+
+// GetParameterDescription returns "": GCP secrets don't have descriptions
+func (g *GCPSecretManagerProvider) GetParameterDescription(name string) (string, error) {
+	return "", nil
+}
+
+// GetParameterARN returns the secret's resource name (projects/<project>/secrets/<id>),
+// GCP's equivalent of an ARN
+func (g *GCPSecretManagerProvider) GetParameterARN(name string) (string, error) {
+	var secret struct {
+		Name string `json:"name"`
+	}
+	if err := g.get("secrets/"+url.PathEscape(GCPSecretID(name)), nil, &secret); err != nil {
+		return "", err
+	}
+	return secret.Name, nil
+}
+
+// GetParameterVersion returns the number of the latest version of the secret
+func (g *GCPSecretManagerProvider) GetParameterVersion(name string) (int64, error) {
+	var version struct {
+		Name string `json:"name"`
+	}
+	if err := g.get("secrets/"+url.PathEscape(GCPSecretID(name))+"/versions/latest", nil, &version); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(path.Base(version.Name), 10, 64)
+}
+
+// GetSecretValue returns the latest version of the secret. GCP has no version
+// stages, so only "" and AWSCURRENT are accepted.
+func (g *GCPSecretManagerProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	if versionStage != "" && versionStage != "AWSCURRENT" {
+		return SecretValue{}, fmt.Errorf("GCP secrets have no version stages (%s)", versionStage)
+	}
+	value, err := g.access(GCPSecretID(secretId))
+	return SecretValue{String: string(value)}, err
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretManager serves the Secret Manager REST API (and the metadata token
+// endpoint) for the "my-project" secrets, 2 per page
+func fakeSecretManager(t *testing.T, secrets map[string]string) (*httptest.Server, *GCPSecretManagerProvider) {
+	ids := []string{}
+	for id := range secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tokens := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			tokens += 1
+			fmt.Fprint(w, `{"access_token": "ya29.token", "expires_in": 3599, "token_type": "Bearer"}`)
+			return
+		}
+		assert.Equal(t, "Bearer ya29.token", r.Header.Get("Authorization"))
+		assert.Equal(t, 1, tokens)
+
+		resource := strings.TrimPrefix(r.URL.Path, "/v1/projects/my-project/")
+		if resource == "secrets" {
+			start := 0
+			if token := r.URL.Query().Get("pageToken"); token != "" {
+				fmt.Sscanf(token, "%d", &start)
+			}
+			end := start + 2
+			next := fmt.Sprintf("%d", end)
+			if end >= len(ids) {
+				end, next = len(ids), ""
+			}
+			names := []string{}
+			for _, id := range ids[start:end] {
+				names = append(names, fmt.Sprintf(`{"name": "projects/my-project/secrets/%s"}`, id))
+			}
+			fmt.Fprintf(w, `{"secrets": [%s], "nextPageToken": "%s"}`, strings.Join(names, ","), next)
+			return
+		}
+
+		parts := strings.Split(resource, "/")
+		value, ok := secrets[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": {"code": 404, "message": "Secret [%s] not found", "status": "NOT_FOUND"}}`, parts[1])
+			return
+		}
+		switch strings.Join(parts[2:], "/") {
+		case "versions/latest:access":
+			fmt.Fprintf(w, `{"name": "projects/123/secrets/%s/versions/3", "payload": {"data": "%s"}}`,
+				parts[1], base64.StdEncoding.EncodeToString([]byte(value)))
+		case "versions/latest":
+			fmt.Fprintf(w, `{"name": "projects/123/secrets/%s/versions/3", "state": "ENABLED"}`, parts[1])
+		case "":
+			fmt.Fprintf(w, `{"name": "projects/123/secrets/%s", "labels": {"team": "data"}}`, parts[1])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	cfg := config.DefaultConfig()
+	cfg.GCPProject = "my-project"
+	p, err := NewGCPSecretManagerProvider(cfg)
+	require.NoError(t, err)
+	g := p.(*GCPSecretManagerProvider)
+	g.Endpoint = srv.URL + "/v1"
+	g.TokenURL = srv.URL + "/token"
+	return srv, g
+}
+
+func TestGCPSecretID(t *testing.T) {
+	assert.Equal(t, "app_db_host", GCPSecretID("/app/db/host"))
+	assert.Equal(t, "app_db_host", GCPSecretID("app/db/host/"))
+	assert.Equal(t, "db-password", GCPSecretID("db-password"))
+}
+
+func TestGCPSecretManagerProvider(t *testing.T) {
+	srv, p := fakeSecretManager(t, map[string]string{
+		"app_db_host":     "10.0.1.10",
+		"app_db_password": "hunter2",
+		"app_dbx":         "not in /app/db",
+		"app_web_port":    "8080",
+	})
+	defer srv.Close()
+
+	value, err := p.GetParameterValue("/app/db/host", false)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.10", value)
+
+	secret, err := p.GetSecretValue("app_db_password", "")
+	require.NoError(t, err)
+	assert.Equal(t, SecretValue{String: "hunter2"}, secret)
+
+	values, err := p.GetParameterDataByPath("/app/db/", true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/db/host": "10.0.1.10", "/app/db/password": "hunter2"}, values)
+
+	version, err := p.GetParameterVersion("/app/db/host")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), version)
+
+	tags, err := p.GetParameterTags("/app/db/host")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "data"}, tags)
+
+	_, err = p.GetParameterValue("/app/db/user", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Secret [app_db_user] not found")
+}
+
+func TestNewGCPSecretManagerProviderRequiresProject(t *testing.T) {
+	_, err := NewGCPSecretManagerProvider(config.DefaultConfig())
+	assert.Error(t, err)
+}
@@ -17,18 +17,369 @@ package provider
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 	//log "github.com/sirupsen/logrus"
 	"github.com/cmattoon/aws-ssm/pkg/config"
 )
 
 type Provider interface {
 	GetParameterValue(string, bool) (string, error)
+	GetParameterValueWithGrants(string, []string) (string, error)
+	// The values of the parameters under a path (recursively), by full name
 	GetParameterDataByPath(string, bool) (map[string]string, error)
+	GetParameterTags(string) (map[string]string, error)
+	GetParameterDescription(string) (string, error)
+	GetParameterARN(string) (string, error)
+	GetParameterVersion(string) (int64, error)
+	GetSecretValue(string, string) (SecretValue, error)
+}
+
+// FreshProvider is implemented by providers that cache values (and those that
+// wrap them), to read a value that's guaranteed to be fetched from AWS
+type FreshProvider interface {
+	GetParameterValueFresh(string, bool) (string, error)
+}
+
+// GetParameterValueFresh reads a parameter, bypassing (and refreshing) any cache
+// of p. Providers that don't cache are read as usual.
+func GetParameterValueFresh(p Provider, name string, decrypt bool) (string, error) {
+	if fp, ok := p.(FreshProvider); ok {
+		return fp.GetParameterValueFresh(name, decrypt)
+	}
+	return p.GetParameterValue(name, decrypt)
+}
+
+// BatchProvider is implemented by providers that read several parameters in
+// fewer calls than one per parameter (and those that wrap them)
+type BatchProvider interface {
+	BatchGetParameterValues([]string, bool) (map[string]string, error)
+}
+
+// BatchGetParameterValues reads the named parameters, keyed by name. Every
+// parameter must exist. Providers that can't batch are read one name at a time.
+func BatchGetParameterValues(p Provider, names []string, decrypt bool) (map[string]string, error) {
+	if bp, ok := p.(BatchProvider); ok {
+		return bp.BatchGetParameterValues(names, decrypt)
+	}
+	return getEach(p, names, decrypt)
+}
+
+// getEach reads names with a GetParameterValue call per name
+func getEach(p Provider, names []string, decrypt bool) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := p.GetParameterValue(name, decrypt)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// ParameterVersion is a version of a parameter, from its history
+type ParameterVersion struct {
+	Version int64
+	Value   string
+}
+
+// HistoryProvider is implemented by providers that read the previous versions of
+// a parameter (and those that wrap them)
+type HistoryProvider interface {
+	GetParameterHistory(string, bool, int) ([]ParameterVersion, error)
+}
+
+// GetParameterHistory returns up to limit versions of the named parameter,
+// newest (i.e., the current version) first; limit <= 0 returns every version.
+// Providers without a history are an error.
+func GetParameterHistory(p Provider, name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	if hp, ok := p.(HistoryProvider); ok {
+		return hp.GetParameterHistory(name, decrypt, limit)
+	}
+	return nil, fmt.Errorf("Parameter history isn't supported by %T", p)
+}
+
+// KeyIDProvider is implemented by providers that can read which KMS key
+// encrypted a parameter (and those that wrap them)
+type KeyIDProvider interface {
+	GetParameterKeyID(string) (string, error)
+}
+
+// GetParameterKeyID returns the ID of the KMS key that encrypted the named
+// SecureString, or "" for other parameters. Providers without KMS keys are an error.
+func GetParameterKeyID(p Provider, name string) (string, error) {
+	if kp, ok := p.(KeyIDProvider); ok {
+		return kp.GetParameterKeyID(name)
+	}
+	return "", fmt.Errorf("KMS key IDs aren't supported by %T", p)
+}
+
+// DecryptRoleProvider is implemented by providers that can decrypt a
+// SecureString as another IAM role than the one that reads it (and those that
+// wrap them)
+type DecryptRoleProvider interface {
+	GetParameterValueDecryptedAs(string, string, []string) (string, error)
+}
+
+// GetParameterValueDecryptedAs reads the named parameter, decrypting a
+// SecureString with KMS as roleARN (passing any grant tokens). Providers that
+// can't assume a role for decryption are an error.
+func GetParameterValueDecryptedAs(p Provider, name string, roleARN string, grantTokens []string) (string, error) {
+	if dp, ok := p.(DecryptRoleProvider); ok {
+		return dp.GetParameterValueDecryptedAs(name, roleARN, grantTokens)
+	}
+	return "", fmt.Errorf("Decrypting as another role isn't supported by %T", p)
+}
+
+// TierProvider is implemented by providers that can read the tiers of the
+// parameters under a path (and those that wrap them)
+type TierProvider interface {
+	GetParameterTiersByPath(string) (map[string]string, error)
+}
+
+// GetParameterTiersByPath returns the tier (StandardTier or AdvancedTier) of each
+// parameter under ppath, keyed by full name like GetParameterDataByPath.
+// Providers without tiers are an error.
+func GetParameterTiersByPath(p Provider, ppath string) (map[string]string, error) {
+	if tp, ok := p.(TierProvider); ok {
+		return tp.GetParameterTiersByPath(ppath)
+	}
+	return nil, fmt.Errorf("Parameter tiers aren't supported by %T", p)
+}
+
+// ListProvider is implemented by providers that can list the parameters under
+// a path without reading their values (and those that wrap them)
+type ListProvider interface {
+	ListParametersByPath(string) ([]string, error)
+}
+
+// ListParametersByPath returns the full names of the parameters under ppath.
+// Providers that can't list parameters are an error.
+func ListParametersByPath(p Provider, ppath string) ([]string, error) {
+	if lp, ok := p.(ListProvider); ok {
+		return lp.ListParametersByPath(ppath)
+	}
+	return nil, fmt.Errorf("Listing parameters isn't supported by %T", p)
+}
+
+// SecretValue is the value of a Secrets Manager secret. Binary is nil for string secrets.
+type SecretValue struct {
+	String string
+	Binary []byte
+}
+
+// VersionNotReadyError means a parameter hasn't reached the required version yet
+type VersionNotReadyError struct {
+	Name       string
+	Version    int64
+	MinVersion int64
+}
+
+func (e *VersionNotReadyError) Error() string {
+	return fmt.Sprintf("Parameter '%s' is at version %d, waiting for version %d", e.Name, e.Version, e.MinVersion)
+}
+
+// CheckMinVersion returns a *VersionNotReadyError if the named parameter is below minVersion
+func CheckMinVersion(p Provider, name string, minVersion string) error {
+	min, err := strconv.ParseInt(minVersion, 10, 64)
+	if err != nil || min < 1 {
+		return fmt.Errorf("Invalid minimum version '%s'", minVersion)
+	}
+	version, err := p.GetParameterVersion(name)
+	if err != nil {
+		return err
+	}
+	if version < min {
+		return &VersionNotReadyError{Name: name, Version: version, MinVersion: min}
+	}
+	return nil
 }
 
 func NewProvider(cfg *config.Config) (Provider, error) {
-	p, err := NewAWSProvider(cfg)
-	return p, err
+	return NewChain(cfg).Provider(cfg, "")
+}
+
+// Chain builds the providers of NewProvider for a config: the read provider of
+// each region and role, wrapped as the config sets. Every provider of a chain
+// shares one budget, cache and coalescer, so -sync-budget limits their calls
+// together.
+type Chain struct {
+	cfg *config.Config
+
+	// Shared by every provider of the chain (see their Share); they don't read
+	// themselves
+	budget    *BudgetProvider
+	cache     *CachedProvider
+	coalesced *CoalescedProvider
+}
+
+// NewChain returns a Chain of the wrappers cfg sets
+func NewChain(cfg *config.Config) *Chain {
+	ch := &Chain{cfg: cfg, coalesced: Coalesced(nil)}
+	if cfg.SyncBudget > 0 {
+		ch.budget = WithBudget(nil, cfg.SyncBudget)
+	}
+	if cfg.CacheTTL > 0 {
+		ch.cache = WithCache(nil, time.Duration(cfg.CacheTTL)*time.Second)
+	}
+	return ch
+}
+
+// Provider returns the read provider of readCfg (which differs from the
+// chain's config in its region or role), wrapped as the chain's config sets.
+// scope keeps its cached and coalesced reads apart from those of the other
+// providers of the chain, e.g. "region|role".
+func (ch *Chain) Provider(readCfg *config.Config, scope string) (Provider, error) {
+	p, err := newReadProvider(readCfg)
+	if err != nil {
+		return p, err
+	}
+	return ch.wrap(p, scope), nil
+}
+
+// wrap wraps the read provider p as the chain's config sets
+func (ch *Chain) wrap(p Provider, scope string) Provider {
+	cfg := ch.cfg
+	if ch.budget != nil {
+		p = ch.budget.Share(p)
+	}
+	// Each retry counts against the budget
+	if cfg.NotFoundRetryWindow > 0 {
+		p = WithNotFoundRetry(p, time.Duration(cfg.NotFoundRetryWindow)*time.Second)
+	}
+	// Version reads count against the budget, but are cached along with the values
+	if cfg.TrackVersions {
+		p = WithVersionTracking(p)
+	}
+	// Cache outside the budget, so cached values don't count against it
+	if ch.cache != nil {
+		p = ch.cache.Share(p, scope)
+	}
+	// Coalesce outside the budget, so shared calls are only counted once
+	p = ch.coalesced.Share(p, scope)
+	// Expand names outermost, so the cache and versions are keyed by what's read
+	if cfg.InterpolateEnv {
+		p = WithEnv(p, cfg.InterpolateEnvVars)
+	}
+	return p
+}
+
+// WithVersion returns the SSM selector for a specific version of the named parameter
+// ("name:version"). Any selector already present on name is replaced.
+func WithVersion(name string, version string) (string, error) {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || v < 1 {
+		return "", fmt.Errorf("Invalid parameter version '%s'", version)
+	}
+	return fmt.Sprintf("%s:%d", Unversioned(name), v), nil
+}
+
+// Unversioned strips any ":version" or ":label" selector from name. A selector
+// only follows the last "/", so the colons of an ARN are kept.
+func Unversioned(name string) string {
+	start := strings.LastIndex(name, "/") + 1
+	if i := strings.Index(name[start:], ":"); i >= 0 {
+		return name[:start+i]
+	}
+	return name
+}
+
+// WithBasePath prepends base to each of the comma-separated names that doesn't
+// start with "/", e.g. "db-host" is "/app/prod/db-host" under "/app/prod".
+// Absolute names and ARNs are left as they are, as is everything if base is "".
+func WithBasePath(name string, base string) string {
+	if base == "" {
+		return name
+	}
+	names := strings.Split(name, ",")
+	for i, n := range names {
+		n = strings.TrimSpace(n)
+		if n != "" && !strings.HasPrefix(n, "/") && !strings.HasPrefix(n, "arn:") {
+			names[i] = strings.TrimRight(base, "/") + "/" + n
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Validating is whether p is the NullProvider of "aws-ssm validate": its values
+// are empty, so checks that need a real value (e.g., selecting a JSON field)
+// are skipped
+func Validating(p Provider) bool {
+	if c, ok := p.(*ContextProvider); ok {
+		return Validating(c.Provider)
+	}
+	_, ok := p.(NullProvider)
+	return ok
+}
+
+// NullProvider returns empty values without contacting AWS. It is used to
+// inspect how resources would be handled (e.g., "aws-ssm validate").
+type NullProvider struct{}
+
+func (np NullProvider) GetParameterValue(s string, b bool) (string, error) {
+	return "", nil
+}
+
+func (np NullProvider) GetParameterValueWithGrants(s string, g []string) (string, error) {
+	return "", nil
+}
+
+func (np NullProvider) GetParameterDataByPath(s string, b bool) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// ListParametersByPath lists no parameters, like GetParameterDataByPath
+func (np NullProvider) ListParametersByPath(s string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (np NullProvider) GetParameterTags(s string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (np NullProvider) GetParameterDescription(s string) (string, error) {
+	return "", nil
+}
+
+func (np NullProvider) GetParameterARN(s string) (string, error) {
+	return "", nil
+}
+
+// GetParameterVersion returns the largest version, so every aws-ssm/min-version
+// is satisfied
+func (np NullProvider) GetParameterVersion(s string) (int64, error) {
+	return math.MaxInt64, nil
+}
+
+func (np NullProvider) GetSecretValue(s string, stage string) (SecretValue, error) {
+	return SecretValue{}, nil
+}
+
+// newReadProvider returns an AWSProvider for cfg.AWSRegion or, with -read-regions,
+// a RegionalProvider of one AWSProvider per region (or, with -provider=gcp, a
+// GCPSecretManagerProvider)
+func newReadProvider(cfg *config.Config) (Provider, error) {
+	if cfg.Provider == "gcp" {
+		return NewGCPSecretManagerProvider(cfg)
+	}
+	if len(cfg.ReadRegions) == 0 {
+		return NewAWSProvider(cfg)
+	}
+	providers := make(map[string]Provider)
+	for _, region := range cfg.ReadRegions {
+		regionCfg := *cfg
+		regionCfg.AWSRegion = region
+		p, err := NewAWSProvider(&regionCfg)
+		if err != nil {
+			return p, err
+		}
+		providers[region] = p
+	}
+	return WithReadRegions(cfg.ReadRegions, providers), nil
 }
 
 // Mock an error with {"(error)", "error message"}
@@ -50,6 +401,36 @@ func (mp MockProvider) GetParameterValue(s string, b bool) (string, error) {
 	return mp.Value, nil
 }
 
+func (mp MockProvider) GetParameterValueWithGrants(s string, g []string) (string, error) {
+	return mp.GetParameterValue(s, true)
+}
+
 func (mp MockProvider) GetParameterDataByPath(s string, b bool) (map[string]string, error) {
 	return mp.DirectoryContents, nil
 }
+
+func (mp MockProvider) GetParameterTags(s string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+func (mp MockProvider) GetParameterDescription(s string) (string, error) {
+	return "", nil
+}
+
+func (mp MockProvider) GetParameterARN(s string) (string, error) {
+	return "", nil
+}
+
+func (mp MockProvider) GetParameterVersion(s string) (int64, error) {
+	if mp.Value == "(error)" {
+		return 0, errors.New(mp.DecryptedValue)
+	}
+	return 1, nil
+}
+
+func (mp MockProvider) GetSecretValue(s string, stage string) (SecretValue, error) {
+	if mp.Value == "(error)" {
+		return SecretValue{}, errors.New(mp.DecryptedValue)
+	}
+	return SecretValue{String: mp.Value}, nil
+}
@@ -0,0 +1,121 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithVersion(t *testing.T) {
+	for name, exp := range map[string]string{
+		"foo":          "foo:3",
+		"/foo/bar":     "/foo/bar:3",
+		"/foo/bar:1":   "/foo/bar:3",
+		"/foo/bar:dev": "/foo/bar:3",
+	} {
+		v, err := WithVersion(name, "3")
+		assert.NoError(t, err)
+		assert.Equal(t, exp, v)
+	}
+
+	for _, version := range []string{"", "0", "-1", "latest", "3.1"} {
+		_, err := WithVersion("foo", version)
+		assert.Error(t, err, version)
+	}
+}
+
+func TestUnversioned(t *testing.T) {
+	for name, exp := range map[string]string{
+		"foo":          "foo",
+		"foo:3":        "foo",
+		"/foo/bar:dev": "/foo/bar",
+		"arn:aws:ssm:us-east-1:123456789012:parameter/foo/bar":   "arn:aws:ssm:us-east-1:123456789012:parameter/foo/bar",
+		"arn:aws:ssm:us-east-1:123456789012:parameter/foo/bar:3": "arn:aws:ssm:us-east-1:123456789012:parameter/foo/bar",
+	} {
+		assert.Equal(t, exp, Unversioned(name), name)
+	}
+	v, err := WithVersion("arn:aws:ssm:us-east-1:123456789012:parameter/foo:1", "3")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:ssm:us-east-1:123456789012:parameter/foo:3", v)
+}
+
+func TestWithBasePath(t *testing.T) {
+	for name, exp := range map[string]string{
+		"db-host":                 "/app/prod/db-host",
+		"db/host":                 "/app/prod/db/host",
+		"/shared/db-host":         "/shared/db-host",
+		"db-host:3":               "/app/prod/db-host:3",
+		"db,/shared/common,cache": "/app/prod/db,/shared/common,/app/prod/cache",
+		"arn:aws:ssm:us-west-2:123456789012:parameter/app/db-host": "arn:aws:ssm:us-west-2:123456789012:parameter/app/db-host",
+	} {
+		assert.Equal(t, exp, WithBasePath(name, "/app/prod"), name)
+		assert.Equal(t, exp, WithBasePath(name, "/app/prod/"), name)
+	}
+	assert.Equal(t, "db-host", WithBasePath("db-host", ""))
+}
+
+func TestGetParameterKeyIDUnsupported(t *testing.T) {
+	_, err := GetParameterKeyID(NullProvider{}, "foo")
+	assert.EqualError(t, err, "KMS key IDs aren't supported by provider.NullProvider")
+}
+
+func TestGetParameterHistoryUnsupported(t *testing.T) {
+	_, err := GetParameterHistory(NullProvider{}, "foo", false, 1)
+	assert.EqualError(t, err, "Parameter history isn't supported by provider.NullProvider")
+}
+
+func TestListParametersByPathUnsupported(t *testing.T) {
+	_, err := ListParametersByPath(MockProvider{}, "/app")
+	assert.EqualError(t, err, "Listing parameters isn't supported by provider.MockProvider")
+
+	// Nothing to list when validating
+	names, err := ListParametersByPath(NullProvider{}, "/app")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestChainSharesBudgetAndCache(t *testing.T) {
+	ch := NewChain(&config.Config{SyncBudget: 60, CacheTTL: 60})
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	start := clock.t
+	ch.budget.now = clock.Now
+	ch.budget.sleep = clock.Sleep
+
+	east := &countingProvider{MockProvider: MockProvider{Value: "east"}}
+	west := &countingProvider{MockProvider: MockProvider{Value: "west"}}
+	pEast := ch.wrap(east, "us-east-1|")
+	pWest := ch.wrap(west, "us-west-2|")
+
+	for i := 0; i < 2; i++ {
+		value, err := pEast.GetParameterValue("/app/host", false)
+		require.NoError(t, err)
+		assert.Equal(t, "east", value)
+		value, err = pWest.GetParameterValue("/app/host", false)
+		require.NoError(t, err)
+		assert.Equal(t, "west", value)
+	}
+	// Each is cached apart, in the same cache
+	assert.Equal(t, 1, east.calls)
+	assert.Equal(t, 1, west.calls)
+	assert.Len(t, ch.cache.entries, 2)
+	// The second call waited for the budget the first used
+	assert.Equal(t, time.Second, clock.t.Sub(start))
+}
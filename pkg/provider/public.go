@@ -0,0 +1,39 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PublicParameterPrefix is the path of the public parameters published by AWS
+// (e.g., AMI IDs), which any account can read. They're never SecureStrings.
+const PublicParameterPrefix = "/aws/service/"
+
+// AmazonLinux2AMIParameter holds the ID of the latest Amazon Linux 2 AMI (x86_64, gp2)
+const AmazonLinux2AMIParameter = "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2"
+
+// IsPublicParameter returns true if name (or a Directory path) is an AWS public parameter
+func IsPublicParameter(name string) bool {
+	return strings.HasPrefix(Unversioned(name)+"/", PublicParameterPrefix)
+}
+
+// EKSOptimizedAMIParameter returns the name of the parameter holding the ID of the
+// recommended EKS-optimized Amazon Linux 2 AMI for a Kubernetes version ("1.11")
+func EKSOptimizedAMIParameter(kubernetesVersion string) string {
+	return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id", kubernetesVersion)
+}
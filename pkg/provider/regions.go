@@ -0,0 +1,252 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+)
+
+// How long a region that failed a read is tried only after every other region
+var regionRetryAfter = time.Minute
+
+type regionStats struct {
+	// Moving average of successful reads; 0 until the first one
+	latency     time.Duration
+	failedUntil time.Time
+}
+
+// RegionalProvider reads from the fastest of several regions, each of which
+// holds the parameters (e.g., replicated by a pipeline). The latency of every
+// read is averaged per region; a read that fails in a way that's the region's
+// (see regionFailure) is retried in the next region, and the failed region is
+// tried last for regionRetryAfter. Other errors are returned as they are.
+type RegionalProvider struct {
+	// In order of preference, until latencies are known
+	Regions   []string
+	Providers map[string]Provider
+
+	mu    sync.Mutex
+	stats map[string]*regionStats
+	now   func() time.Time
+}
+
+// WithReadRegions reads from providers (by region), preferring the fastest
+func WithReadRegions(regions []string, providers map[string]Provider) *RegionalProvider {
+	stats := make(map[string]*regionStats)
+	for _, region := range regions {
+		stats[region] = &regionStats{}
+	}
+	return &RegionalProvider{
+		Regions:   regions,
+		Providers: providers,
+		stats:     stats,
+		now:       time.Now,
+	}
+}
+
+// order returns the regions to try: reachable ones, fastest (or unmeasured) first,
+// then those that recently failed
+func (r *RegionalProvider) order() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	regions := append([]string{}, r.Regions...)
+	sort.SliceStable(regions, func(i, j int) bool {
+		a, b := r.stats[regions[i]], r.stats[regions[j]]
+		if failedA, failedB := now.Before(a.failedUntil), now.Before(b.failedUntil); failedA != failedB {
+			return failedB
+		}
+		return a.latency < b.latency
+	})
+	return regions
+}
+
+// regionFailure is whether err is a failure of the region, rather than of the
+// request, so the read may succeed in another: a transport error, a 5xx or
+// throttling. Errors such as ParameterNotFound, AccessDenied or a
+// ValidationException would fail in every region.
+func regionFailure(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		var nerr net.Error
+		return errors.As(err, &nerr)
+	}
+	if request.IsErrorRetryable(aerr) && !request.IsErrorExpiredCreds(aerr) {
+		// e.g., RequestError: the request didn't reach the region, or timed out
+		return true
+	}
+	if throttledService(ThrottledServiceSSM, aerr) != "" {
+		return true
+	}
+	var rerr awserr.RequestFailure
+	return errors.As(err, &rerr) && rerr.StatusCode() >= 500
+}
+
+// observe records a read of region that took latency. Only an err that's a
+// regionFailure marks the region as failed.
+func (r *RegionalProvider) observe(region string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats[region]
+	if err != nil && !regionFailure(err) {
+		return
+	}
+	if err != nil {
+		stats.failedUntil = r.now().Add(regionRetryAfter)
+		return
+	}
+	stats.failedUntil = time.Time{}
+	if stats.latency == 0 {
+		stats.latency = latency
+	} else {
+		stats.latency = (3*stats.latency + latency) / 4
+	}
+}
+
+// read calls fn with each region's provider until one succeeds, or fails with
+// an error that isn't a regionFailure
+func (r *RegionalProvider) read(fn func(p Provider) error) (err error) {
+	for _, region := range r.order() {
+		start := r.now()
+		err = fn(r.Providers[region])
+		r.observe(region, r.now().Sub(start), err)
+		if err == nil || !regionFailure(err) {
+			return err
+		}
+		log.Warnf("Read from %s failed, trying the next region: %s", region, err)
+	}
+	return err
+}
+
+func (r *RegionalProvider) GetParameterValue(name string, decrypt bool) (value string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		value, err = p.GetParameterValue(name, decrypt)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) BatchGetParameterValues(names []string, decrypt bool) (values map[string]string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		values, err = BatchGetParameterValues(p, names, decrypt)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterHistory(name string, decrypt bool, limit int) (versions []ParameterVersion, err error) {
+	err = r.read(func(p Provider) (err error) {
+		versions, err = GetParameterHistory(p, name, decrypt, limit)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterKeyID(name string) (keyID string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		keyID, err = GetParameterKeyID(p, name)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterTiersByPath(ppath string) (tiers map[string]string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		tiers, err = GetParameterTiersByPath(p, ppath)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) ListParametersByPath(ppath string) (names []string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		names, err = ListParametersByPath(p, ppath)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (value string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		value, err = GetParameterValueDecryptedAs(p, name, roleARN, grantTokens)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterValueWithGrants(name string, grantTokens []string) (value string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		value, err = p.GetParameterValueWithGrants(name, grantTokens)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterDataByPath(ppath string, decrypt bool) (data map[string]string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		data, err = p.GetParameterDataByPath(ppath, decrypt)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterTags(name string) (tags map[string]string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		tags, err = p.GetParameterTags(name)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterDescription(name string) (description string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		description, err = p.GetParameterDescription(name)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterARN(name string) (arn string, err error) {
+	err = r.read(func(p Provider) (err error) {
+		arn, err = p.GetParameterARN(name)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetParameterVersion(name string) (version int64, err error) {
+	err = r.read(func(p Provider) (err error) {
+		version, err = p.GetParameterVersion(name)
+		return
+	})
+	return
+}
+
+func (r *RegionalProvider) GetSecretValue(secretId string, versionStage string) (value SecretValue, err error) {
+	err = r.read(func(p Provider) (err error) {
+		value, err = p.GetSecretValue(secretId, versionStage)
+		return
+	})
+	return
+}
@@ -0,0 +1,151 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+// regionProvider is one region of a fake multi-region deployment. Each read
+// advances clock by latency.
+type regionProvider struct {
+	MockProvider
+	clock   *fakeClock
+	latency time.Duration
+	down    bool
+	// Returned by every read, if set
+	err   error
+	calls int
+}
+
+func (rp *regionProvider) GetParameterValue(s string, b bool) (string, error) {
+	rp.calls += 1
+	rp.clock.Sleep(rp.latency)
+	if rp.down {
+		return "", awserr.New("RequestError", "send request failed", errors.New("connection refused"))
+	}
+	if rp.err != nil {
+		return "", rp.err
+	}
+	return rp.MockProvider.GetParameterValue(s, b)
+}
+
+func newRegions(clock *fakeClock, latencies ...time.Duration) (map[string]*regionProvider, *RegionalProvider) {
+	names := []string{"us-east-1", "us-west-2", "eu-west-1"}[:len(latencies)]
+	regions := make(map[string]*regionProvider)
+	providers := make(map[string]Provider)
+	for i, name := range names {
+		regions[name] = &regionProvider{MockProvider: MockProvider{name, "", map[string]string{}}, clock: clock, latency: latencies[i]}
+		providers[name] = regions[name]
+	}
+	r := WithReadRegions(names, providers)
+	r.now = clock.Now
+	return regions, r
+}
+
+func TestReadRegionsPrefersFastest(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	regions, r := newRegions(clock, 300*time.Millisecond, 20*time.Millisecond, 100*time.Millisecond)
+	assert.Equal(t, []string{"us-east-1", "us-west-2", "eu-west-1"}, r.order())
+
+	// Unmeasured regions are tried first, so each read measures another region
+	for i := 0; i < 3; i++ {
+		r.GetParameterValue("foo", false)
+	}
+	for _, region := range regions {
+		assert.Equal(t, 1, region.calls)
+	}
+	assert.Equal(t, []string{"us-west-2", "eu-west-1", "us-east-1"}, r.order())
+	assert.Equal(t, []string{"us-west-2", "eu-west-1", "us-east-1"}, r.order())
+
+	value, err := r.GetParameterValue("foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", value)
+}
+
+func TestReadRegionsFailsOver(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	regions, r := newRegions(clock, 20*time.Millisecond, 100*time.Millisecond)
+	r.GetParameterValue("foo", false)
+	r.GetParameterValue("foo", false)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, r.order())
+
+	regions["us-east-1"].down = true
+	value, err := r.GetParameterValue("foo", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "us-west-2", value)
+
+	// The failed region is tried last, until regionRetryAfter
+	assert.Equal(t, []string{"us-west-2", "us-east-1"}, r.order())
+	calls := regions["us-east-1"].calls
+	r.GetParameterValue("foo", false)
+	assert.Equal(t, calls, regions["us-east-1"].calls)
+
+	regions["us-east-1"].down = false
+	clock.Sleep(regionRetryAfter)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, r.order())
+}
+
+func TestReadRegionsAllDown(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	regions, r := newRegions(clock, 20*time.Millisecond, 100*time.Millisecond)
+	regions["us-east-1"].down = true
+	regions["us-west-2"].down = true
+
+	_, err := r.GetParameterValue("foo", false)
+	assert.Error(t, err)
+	assert.Equal(t, 1, regions["us-east-1"].calls)
+	assert.Equal(t, 1, regions["us-west-2"].calls)
+}
+
+func TestReadRegionsFailsOverOnlyForRegionFailures(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	regions, r := newRegions(clock, 20*time.Millisecond, 100*time.Millisecond)
+	r.GetParameterValue("foo", false)
+	r.GetParameterValue("foo", false)
+
+	for _, err := range []error{
+		awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil),
+		awserr.New("AccessDeniedException", "denied", nil),
+		awserr.NewRequestFailure(awserr.New("ValidationException", "invalid", nil), 400, "id"),
+	} {
+		regions["us-east-1"].err = err
+		calls := regions["us-west-2"].calls
+		_, readErr := r.GetParameterValue("foo", false)
+		assert.Equal(t, err, readErr)
+		assert.Equal(t, calls, regions["us-west-2"].calls, err.Error())
+		assert.Equal(t, []string{"us-east-1", "us-west-2"}, r.order(), err.Error())
+	}
+
+	for _, err := range []error{
+		awserr.New("ThrottlingException", "rate exceeded", nil),
+		awserr.NewRequestFailure(awserr.New("InternalServerError", "oops", nil), 500, "id"),
+	} {
+		regions["us-east-1"].err = err
+		value, readErr := r.GetParameterValue("foo", false)
+		assert.NoError(t, readErr, err.Error())
+		assert.Equal(t, "us-west-2", value)
+		assert.Equal(t, []string{"us-west-2", "us-east-1"}, r.order(), err.Error())
+		regions["us-east-1"].err = nil
+		clock.Sleep(regionRetryAfter)
+	}
+}
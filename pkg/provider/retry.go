@@ -0,0 +1,214 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often a read that wasn't found is retried, within the window
+var notFoundRetryInterval = time.Second
+
+// NotFoundRetryProvider retries reads of Provider that fail because the
+// parameter (or secret, or version) wasn't found, for up to Window. Right after
+// a parameter is written, SSM can briefly return not-found; a pipeline that
+// writes and then syncs would otherwise fail. Other errors, including
+// throttling (which the AWS SDK retries itself), are returned immediately.
+type NotFoundRetryProvider struct {
+	Provider Provider
+	Window   time.Duration
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// WithNotFoundRetry retries not-found reads of p for up to window
+func WithNotFoundRetry(p Provider, window time.Duration) *NotFoundRetryProvider {
+	return &NotFoundRetryProvider{
+		Provider: p,
+		Window:   window,
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// IsNotFound returns true if err means the parameter, version or secret doesn't exist
+func IsNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case ssm.ErrCodeParameterNotFound, ssm.ErrCodeParameterVersionNotFound, secretsmanager.ErrCodeResourceNotFoundException:
+			return true
+		}
+	}
+	return false
+}
+
+// IsAccessDenied returns true if err means the caller isn't allowed to make the
+// request, e.g. to decrypt with the parameter's KMS key
+func IsAccessDenied(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case "AccessDeniedException", "AccessDenied":
+			return true
+		}
+	}
+	return false
+}
+
+// IsDecryptError returns true if err means the parameter couldn't be decrypted,
+// e.g. because its KMS key is disabled or the caller may not use it
+func IsDecryptError(err error) bool {
+	if IsAccessDenied(err) {
+		return true
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch code := aerr.Code(); code {
+		case "InvalidCiphertextException", "InvalidKeyId":
+			return true
+		default:
+			// e.g. KMS.DisabledException, KMS.NotFoundException
+			return strings.HasPrefix(code, "KMS")
+		}
+	}
+	return false
+}
+
+// retry calls fn until it returns an error other than not-found, or Window has passed
+func (r *NotFoundRetryProvider) retry(name string, fn func() error) error {
+	deadline := r.now().Add(r.Window)
+	for {
+		err := fn()
+		if !IsNotFound(err) || !r.now().Add(notFoundRetryInterval).Before(deadline) {
+			return err
+		}
+		log.Debugf("%s not found; retrying in %s", name, notFoundRetryInterval)
+		r.sleep(notFoundRetryInterval)
+	}
+}
+
+func (r *NotFoundRetryProvider) GetParameterValue(name string, decrypt bool) (value string, err error) {
+	err = r.retry(name, func() (err error) {
+		value, err = r.Provider.GetParameterValue(name, decrypt)
+		return
+	})
+	return
+}
+
+// BatchGetParameterValues retries the whole batch until every name is found
+func (r *NotFoundRetryProvider) BatchGetParameterValues(names []string, decrypt bool) (values map[string]string, err error) {
+	err = r.retry(strings.Join(names, ", "), func() (err error) {
+		values, err = BatchGetParameterValues(r.Provider, names, decrypt)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterHistory(name string, decrypt bool, limit int) (versions []ParameterVersion, err error) {
+	err = r.retry(name, func() (err error) {
+		versions, err = GetParameterHistory(r.Provider, name, decrypt, limit)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterKeyID(name string) (keyID string, err error) {
+	err = r.retry(name, func() (err error) {
+		keyID, err = GetParameterKeyID(r.Provider, name)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterValueWithGrants(name string, grantTokens []string) (value string, err error) {
+	err = r.retry(name, func() (err error) {
+		value, err = r.Provider.GetParameterValueWithGrants(name, grantTokens)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (value string, err error) {
+	err = r.retry(name, func() (err error) {
+		value, err = GetParameterValueDecryptedAs(r.Provider, name, roleARN, grantTokens)
+		return
+	})
+	return
+}
+
+// GetParameterTiersByPath isn't retried, like GetParameterDataByPath
+func (r *NotFoundRetryProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	return GetParameterTiersByPath(r.Provider, ppath)
+}
+
+// ListParametersByPath isn't retried, like GetParameterDataByPath
+func (r *NotFoundRetryProvider) ListParametersByPath(ppath string) ([]string, error) {
+	return ListParametersByPath(r.Provider, ppath)
+}
+
+// GetParameterDataByPath isn't retried: a path that doesn't exist (yet) has no parameters
+func (r *NotFoundRetryProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	return r.Provider.GetParameterDataByPath(ppath, decrypt)
+}
+
+func (r *NotFoundRetryProvider) GetParameterTags(name string) (tags map[string]string, err error) {
+	err = r.retry(name, func() (err error) {
+		tags, err = r.Provider.GetParameterTags(name)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterDescription(name string) (description string, err error) {
+	err = r.retry(name, func() (err error) {
+		description, err = r.Provider.GetParameterDescription(name)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterARN(name string) (arn string, err error) {
+	err = r.retry(name, func() (err error) {
+		arn, err = r.Provider.GetParameterARN(name)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetParameterVersion(name string) (version int64, err error) {
+	err = r.retry(name, func() (err error) {
+		version, err = r.Provider.GetParameterVersion(name)
+		return
+	})
+	return
+}
+
+func (r *NotFoundRetryProvider) GetSecretValue(secretId string, versionStage string) (value SecretValue, err error) {
+	err = r.retry(secretId, func() (err error) {
+		value, err = r.Provider.GetSecretValue(secretId, versionStage)
+		return
+	})
+	return
+}
@@ -0,0 +1,123 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventualProvider returns err for the first failures reads of a parameter
+type eventualProvider struct {
+	MockProvider
+	err      error
+	failures int
+	calls    int
+}
+
+func (ep *eventualProvider) GetParameterValue(s string, b bool) (string, error) {
+	ep.calls += 1
+	if ep.calls <= ep.failures {
+		return "", ep.err
+	}
+	return ep.MockProvider.GetParameterValue(s, b)
+}
+
+func newNotFoundRetry(p Provider, window time.Duration) (*fakeClock, *NotFoundRetryProvider) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	r := WithNotFoundRetry(p, window)
+	r.now = clock.Now
+	r.sleep = clock.Sleep
+	return clock, r
+}
+
+func TestNotFoundRetrySucceedsWithinWindow(t *testing.T) {
+	ep := &eventualProvider{
+		MockProvider: MockProvider{"foo", "", map[string]string{}},
+		err:          awserr.New(ssm.ErrCodeParameterNotFound, "Parameter /app/host not found", nil),
+		failures:     2,
+	}
+	clock, r := newNotFoundRetry(ep, 5*time.Second)
+
+	value, err := r.GetParameterValue("/app/host", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", value)
+	assert.Equal(t, 3, ep.calls)
+	assert.Equal(t, time.Unix(1002, 0), clock.Now())
+}
+
+func TestNotFoundRetryGivesUpAfterWindow(t *testing.T) {
+	ep := &eventualProvider{
+		MockProvider: MockProvider{"foo", "", map[string]string{}},
+		err:          awserr.New(ssm.ErrCodeParameterNotFound, "Parameter /app/host not found", nil),
+		failures:     10,
+	}
+	clock, r := newNotFoundRetry(ep, 3*time.Second)
+
+	_, err := r.GetParameterValue("/app/host", false)
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, 3, ep.calls)
+	assert.Equal(t, time.Unix(1002, 0), clock.Now())
+}
+
+func TestNotFoundRetryOnlyRetriesNotFound(t *testing.T) {
+	for _, err := range []error{
+		awserr.New("ThrottlingException", "Rate exceeded", nil),
+		errors.New("connection refused"),
+	} {
+		ep := &eventualProvider{MockProvider: MockProvider{"foo", "", map[string]string{}}, err: err, failures: 1}
+		_, r := newNotFoundRetry(ep, 5*time.Second)
+
+		_, got := r.GetParameterValue("/app/host", false)
+		assert.Equal(t, err, got)
+		assert.Equal(t, 1, ep.calls)
+	}
+}
+
+func TestNotFoundRetryDisabled(t *testing.T) {
+	ep := &eventualProvider{
+		MockProvider: MockProvider{"foo", "", map[string]string{}},
+		err:          awserr.New(ssm.ErrCodeParameterNotFound, "Parameter /app/host not found", nil),
+		failures:     1,
+	}
+	_, r := newNotFoundRetry(ep, 0)
+
+	_, err := r.GetParameterValue("/app/host", false)
+	assert.Error(t, err)
+	assert.Equal(t, 1, ep.calls)
+}
+
+func TestIsAccessDenied(t *testing.T) {
+	assert.True(t, IsAccessDenied(awserr.New("AccessDeniedException", "not authorized to perform: kms:Decrypt", nil)))
+	assert.True(t, IsAccessDenied(awserr.New("AccessDenied", "", nil)))
+	assert.False(t, IsAccessDenied(awserr.New(ssm.ErrCodeParameterNotFound, "", nil)))
+	assert.False(t, IsAccessDenied(errors.New("AccessDeniedException")))
+	assert.False(t, IsAccessDenied(nil))
+}
+
+func TestIsDecryptError(t *testing.T) {
+	assert.True(t, IsDecryptError(awserr.New("AccessDeniedException", "not authorized to perform: kms:Decrypt", nil)))
+	assert.True(t, IsDecryptError(awserr.New("InvalidCiphertextException", "", nil)))
+	assert.True(t, IsDecryptError(awserr.New("KMS.DisabledException", "", nil)))
+	assert.False(t, IsDecryptError(awserr.New(ssm.ErrCodeParameterNotFound, "", nil)))
+	assert.False(t, IsDecryptError(awserr.New("ThrottlingException", "", nil)))
+	assert.False(t, IsDecryptError(nil))
+}
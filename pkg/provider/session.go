@@ -0,0 +1,193 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"bytes"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/cmattoon/aws-ssm/pkg/config"
+)
+
+// sessionKey is what a base session is built with. The profile is read from
+// AWS_PROFILE, like the session itself would.
+type sessionKey struct {
+	caBundle        string
+	userAgentSuffix string
+	profile         string
+	// See UseCredentials; nil uses the SDK's default credentials
+	credentials *credentials.Credentials
+}
+
+// clientKey is what the clients of a provider are derived from a base session with
+type clientKey struct {
+	sessionKey
+	region      string
+	roleARN     string
+	ssmEndpoint string
+}
+
+// clientCache shares one base session between every provider built with the
+// same session options, and the clients derived from it for each region and
+// role, so per-region and per-role providers don't each build a full session.
+// It's safe for concurrent use.
+type clientCache struct {
+	mu          sync.Mutex
+	sessions    map[sessionKey]*session.Session
+	clients     map[clientKey]AWSProvider
+	credentials *credentials.Credentials
+	// KMS clients that decrypt as a role (see AWSProvider.KMSFor), by the
+	// provider's key and the role
+	decrypters map[decrypterKey]kmsiface.KMSAPI
+}
+
+type decrypterKey struct {
+	clientKey
+	decryptRoleARN string
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{
+		sessions:   make(map[sessionKey]*session.Session),
+		clients:    make(map[clientKey]AWSProvider),
+		decrypters: make(map[decrypterKey]kmsiface.KMSAPI),
+	}
+}
+
+// The clients of every provider built with NewAWSProvider
+var sharedClients = newClientCache()
+
+// UseCredentials signs the requests of every provider built from now on with
+// creds, instead of the SDK's default credentials (environment, profile,
+// instance role). Providers that assume a role assume it with creds.
+func UseCredentials(creds *credentials.Credentials) {
+	sharedClients.mu.Lock()
+	defer sharedClients.mu.Unlock()
+	sharedClients.credentials = creds
+}
+
+// Credentials returns the credentials of UseCredentials, or nil for the SDK's
+// default credentials, for other AWS clients (e.g. of SQS)
+func Credentials() *credentials.Credentials {
+	sharedClients.mu.Lock()
+	defer sharedClients.mu.Unlock()
+	return sharedClients.credentials
+}
+
+// provider returns an AWSProvider with the clients for cfg, building them (and
+// the base session) only the first time
+func (c *clientCache) provider(cfg *config.Config) (AWSProvider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := clientKey{
+		sessionKey: sessionKey{
+			caBundle:        cfg.CABundle,
+			userAgentSuffix: cfg.UserAgentSuffix,
+			profile:         os.Getenv("AWS_PROFILE"),
+			credentials:     c.credentials,
+		},
+		region:      cfg.AWSRegion,
+		roleARN:     cfg.RoleARN,
+		ssmEndpoint: cfg.SSMEndpoint,
+	}
+	if p, ok := c.clients[key]; ok {
+		return p, nil
+	}
+
+	base, err := c.session(key.sessionKey)
+	if err != nil {
+		return AWSProvider{}, err
+	}
+	sess := base.Copy(&aws.Config{Region: aws.String(key.region)})
+	if key.roleARN != "" {
+		sess.Config.Credentials = stscreds.NewCredentials(sess, key.roleARN)
+	}
+
+	ssmCfg := &aws.Config{}
+	if key.ssmEndpoint != "" {
+		ssmCfg.Endpoint = aws.String(key.ssmEndpoint)
+	}
+	p := AWSProvider{
+		Session:        sess,
+		Service:        ssm.New(sess, ssmCfg),
+		SecretsManager: secretsmanager.New(sess),
+		KMS:            kms.New(sess),
+		KMSFor: func(roleARN string) kmsiface.KMSAPI {
+			return c.decrypter(key, sess, roleARN)
+		},
+	}
+	c.clients[key] = p
+	return p, nil
+}
+
+// decrypter returns the KMS client of the provider of key (whose session is
+// sess) that decrypts as roleARN, building it the first time. The role is
+// assumed with the provider's own credentials.
+func (c *clientCache) decrypter(key clientKey, sess *session.Session, roleARN string) kmsiface.KMSAPI {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dk := decrypterKey{clientKey: key, decryptRoleARN: roleARN}
+	if client, ok := c.decrypters[dk]; ok {
+		return client
+	}
+	client := kms.New(sess, &aws.Config{Credentials: stscreds.NewCredentials(sess, roleARN)})
+	c.decrypters[dk] = client
+	return client
+}
+
+// session returns the base session for key, building it the first time. c.mu
+// must be held.
+func (c *clientCache) session(key sessionKey) (*session.Session, error) {
+	if sess, ok := c.sessions[key]; ok {
+		return sess, nil
+	}
+
+	opts := session.Options{Profile: key.profile}
+	if key.caBundle != "" {
+		// Takes precedence over $AWS_CA_BUNDLE
+		bundle, err := readCABundle(key.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		opts.CustomCABundle = bytes.NewReader(bundle)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if key.credentials != nil {
+		sess.Config.Credentials = key.credentials
+	}
+
+	if key.userAgentSuffix != "" {
+		sess.Handlers.Build.PushBackNamed(request.NamedHandler{
+			Name: UserAgentHandlerName,
+			Fn:   request.MakeAddToUserAgentFreeFormHandler(key.userAgentSuffix),
+		})
+	}
+	c.sessions[key] = sess
+	return sess, nil
+}
@@ -0,0 +1,142 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Retries of a throttled decrypt, once the AWS SDK's own retries are exhausted.
+// The delay doubles after each one.
+var (
+	throttleRetries = 4
+	throttleDelay   = 500 * time.Millisecond
+	throttleSleep   = time.Sleep
+)
+
+// Services a throttling error is attributed to (the "service" label of metrics.Throttles)
+const (
+	ThrottledServiceSSM = "ssm"
+	ThrottledServiceKMS = "kms"
+)
+
+// Categories of the errors a request is retried for (the "category" label of
+// metrics.Retries and metrics.RetriesExhausted), besides the codes of the AWS
+// errors retried with RetryErrorCodes
+const (
+	RetryCategoryThrottling = "throttling"
+	// An error that isn't an AWS error, retried by a custom RetryPredicate
+	RetryCategoryOther = "other"
+)
+
+// retryCategory returns the category of err, a retryable error, which throttled
+// is the service that throttled, if any
+func retryCategory(throttled string, err error) string {
+	if throttled != "" {
+		return RetryCategoryThrottling
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return RetryCategoryOther
+}
+
+// throttledService returns the service that throttled err, or "" if err isn't
+// throttling. KMS reports hitting its request rate as ThrottlingException (or
+// LimitExceededException from older endpoints); when SSM decrypts on our behalf,
+// the KMS error comes back from SSM with KMS named in the message.
+func throttledService(service string, err error) string {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return ""
+	}
+	if !request.IsErrorThrottle(err) && aerr.Code() != kms.ErrCodeLimitExceededException {
+		return ""
+	}
+	if service == ThrottledServiceSSM && strings.Contains(aerr.Message(), "KMS") {
+		return ThrottledServiceKMS
+	}
+	return service
+}
+
+// RetryPredicate returns true if err is transient, so the request is worth
+// retrying. Proxies and VPC endpoints can fail in their own transient ways.
+type RetryPredicate func(err error) bool
+
+// IsRetryable is the builtin RetryPredicate: throttling, by SSM or by KMS
+func IsRetryable(err error) bool {
+	return throttledService(ThrottledServiceSSM, err) != ""
+}
+
+// RetryErrorCodes returns a RetryPredicate that also retries the AWS errors
+// with one of codes (e.g., "RequestError"), besides those of IsRetryable
+func RetryErrorCodes(codes ...string) RetryPredicate {
+	return func(err error) bool {
+		if IsRetryable(err) {
+			return true
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			for _, code := range codes {
+				if aerr.Code() == code {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// retryThrottled calls fn, retrying with backoff while retryable (IsRetryable
+// if nil) returns true for its error, e.g. while service (or KMS, behind it) throttles
+func retryThrottled(service string, retryable RetryPredicate, fn func() error) error {
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	delay := throttleDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		throttled := throttledService(service, err)
+		if throttled != "" {
+			metrics.Throttles.WithLabelValues(throttled).Inc()
+		}
+		labels := []string{service, retryCategory(throttled, err)}
+		if throttled != "" {
+			labels[0] = throttled
+		}
+		if attempt == throttleRetries {
+			metrics.RetriesExhausted.WithLabelValues(labels...).Inc()
+			return err
+		}
+		metrics.Retries.WithLabelValues(labels...).Inc()
+		if throttled != "" {
+			log.Warnf("Throttled by %s; retrying in %s", strings.ToUpper(throttled), delay)
+		} else {
+			log.Warnf("%s request failed; retrying in %s: %s", strings.ToUpper(service), delay, err)
+		}
+		throttleSleep(delay)
+		delay *= 2
+	}
+}
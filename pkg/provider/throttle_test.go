@@ -0,0 +1,199 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/cmattoon/aws-ssm/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kmsThrottling is what KMS returns when its request rate is exceeded
+var kmsThrottling = awserr.New("ThrottlingException", "Rate exceeded", nil)
+
+// ssmKMSThrottling is what SSM returns when KMS throttles a decrypt made on our behalf
+var ssmKMSThrottling = awserr.New("ThrottlingException", "Rate exceeded for KMS Decrypt", nil)
+
+// recordThrottleSleeps replaces throttleSleep until restore is called
+func recordThrottleSleeps() (slept *[]time.Duration, restore func()) {
+	slept = &[]time.Duration{}
+	throttleSleep = func(d time.Duration) { *slept = append(*slept, d) }
+	return slept, func() { throttleSleep = time.Sleep }
+}
+
+func TestThrottledService(t *testing.T) {
+	assert.Equal(t, ThrottledServiceKMS, throttledService(ThrottledServiceKMS, kmsThrottling))
+	assert.Equal(t, ThrottledServiceKMS, throttledService(ThrottledServiceKMS, awserr.New(kms.ErrCodeLimitExceededException, "Rate exceeded", nil)))
+	assert.Equal(t, ThrottledServiceKMS, throttledService(ThrottledServiceSSM, ssmKMSThrottling))
+	assert.Equal(t, ThrottledServiceSSM, throttledService(ThrottledServiceSSM, awserr.New("ThrottlingException", "Rate exceeded", nil)))
+
+	assert.Equal(t, "", throttledService(ThrottledServiceSSM, nil))
+	assert.Equal(t, "", throttledService(ThrottledServiceSSM, errors.New("ThrottlingException")))
+	assert.Equal(t, "", throttledService(ThrottledServiceKMS, awserr.New(kms.ErrCodeInvalidCiphertextException, "KMS", nil)))
+}
+
+func TestGetParameterValueWithGrantsRetriesKMSThrottling(t *testing.T) {
+	slept, restore := recordThrottleSleeps()
+	defer restore()
+	before := testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS))
+	retries := testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling))
+	exhausted := testutil.ToFloat64(metrics.RetriesExhausted.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling))
+	fk := &fakeKMS{Errors: []error{kmsThrottling, kmsThrottling}}
+	p := AWSProvider{
+		Service: &fakeSSM{Parameters: []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")}},
+		KMS:     fk,
+	}
+
+	value, err := p.GetParameterValueWithGrants("/app/password", []string{"token-a"})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+	assert.Len(t, fk.DecryptCalls, 3)
+	assert.Equal(t, []time.Duration{throttleDelay, 2 * throttleDelay}, *slept)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS)))
+	assert.Equal(t, retries+2, testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling)))
+	assert.Equal(t, exhausted, testutil.ToFloat64(metrics.RetriesExhausted.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling)))
+}
+
+func TestGetParameterValueWithGrantsGivesUpOnKMSThrottling(t *testing.T) {
+	slept, restore := recordThrottleSleeps()
+	defer restore()
+	retries := testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling))
+	exhausted := testutil.ToFloat64(metrics.RetriesExhausted.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling))
+	errs := []error{}
+	for i := 0; i <= throttleRetries+1; i++ {
+		errs = append(errs, kmsThrottling)
+	}
+	fk := &fakeKMS{Errors: errs}
+	p := AWSProvider{
+		Service: &fakeSSM{Parameters: []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")}},
+		KMS:     fk,
+	}
+
+	_, err := p.GetParameterValueWithGrants("/app/password", nil)
+	assert.Equal(t, kmsThrottling, err)
+	assert.Len(t, fk.DecryptCalls, throttleRetries+1)
+	assert.Len(t, *slept, throttleRetries)
+	assert.Equal(t, retries+float64(throttleRetries), testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling)))
+	assert.Equal(t, exhausted+1, testutil.ToFloat64(metrics.RetriesExhausted.WithLabelValues(ThrottledServiceKMS, RetryCategoryThrottling)))
+}
+
+func TestGetParameterDataByPathRetriesKMSThrottling(t *testing.T) {
+	_, restore := recordThrottleSleeps()
+	defer restore()
+	kmsBefore := testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS))
+	ssmBefore := testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceSSM))
+	svc := &fakeSSM{
+		Parameters: []*ssm.Parameter{
+			param("/app/password", ssm.ParameterTypeSecureString, "hunter2"),
+			param("/app/token", ssm.ParameterTypeSecureString, "s3cr3t"),
+		},
+		GetParametersErrors: []error{ssmKMSThrottling, awserr.New("ThrottlingException", "Rate exceeded", nil)},
+	}
+	p := AWSProvider{Service: svc}
+
+	data, err := p.GetParameterDataByPath("/app", true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/password": "hunter2", "/app/token": "s3cr3t"}, data)
+	assert.Len(t, svc.GetParametersCalls, 3)
+	assert.Equal(t, kmsBefore+1, testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS)))
+	assert.Equal(t, ssmBefore+1, testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceSSM)))
+}
+
+func TestGetParameterDataByPathDoesNotRetryOtherErrors(t *testing.T) {
+	slept, restore := recordThrottleSleeps()
+	defer restore()
+	denied := awserr.New(kms.ErrCodeInvalidCiphertextException, "KMS could not decrypt", nil)
+	svc := &fakeSSM{
+		Parameters:          []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")},
+		GetParametersErrors: []error{denied},
+	}
+	p := AWSProvider{Service: svc}
+
+	_, err := p.GetParameterDataByPath("/app", true)
+	assert.Equal(t, denied, err)
+	assert.Len(t, svc.GetParametersCalls, 1)
+	assert.Empty(t, *slept)
+}
+
+func TestRetryErrorCodes(t *testing.T) {
+	retryable := RetryErrorCodes("RequestError")
+	assert.True(t, retryable(kmsThrottling))
+	assert.True(t, retryable(awserr.New("RequestError", "proxy reset the connection", nil)))
+	assert.False(t, retryable(awserr.New(kms.ErrCodeInvalidCiphertextException, "KMS", nil)))
+	assert.False(t, retryable(errors.New("RequestError")))
+	assert.False(t, retryable(nil))
+
+	assert.True(t, IsRetryable(ssmKMSThrottling))
+	assert.False(t, IsRetryable(awserr.New("RequestError", "proxy reset the connection", nil)))
+}
+
+func TestRetryCategory(t *testing.T) {
+	assert.Equal(t, RetryCategoryThrottling, retryCategory(ThrottledServiceKMS, kmsThrottling))
+	assert.Equal(t, "RequestError", retryCategory("", awserr.New("RequestError", "proxy reset the connection", nil)))
+	assert.Equal(t, RetryCategoryOther, retryCategory("", errors.New("connection reset")))
+}
+
+func TestRetryPredicateRetriesPermanentErrors(t *testing.T) {
+	slept, restore := recordThrottleSleeps()
+	defer restore()
+	before := testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS))
+	retries := testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceSSM, kms.ErrCodeInvalidCiphertextException))
+	// Normally permanent, but e.g. a misbehaving VPC endpoint can fail this way
+	invalid := awserr.New(kms.ErrCodeInvalidCiphertextException, "KMS could not decrypt", nil)
+	svc := &fakeSSM{
+		Parameters:          []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")},
+		GetParametersErrors: []error{invalid, invalid},
+	}
+	predicate := 0
+	p := AWSProvider{Service: svc, RetryPredicate: func(err error) bool {
+		predicate++
+		return err == invalid
+	}}
+
+	data, err := p.GetParameterDataByPath("/app", true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/app/password": "hunter2"}, data)
+	assert.Len(t, svc.GetParametersCalls, 3)
+	assert.Equal(t, 2, predicate)
+	assert.Equal(t, []time.Duration{throttleDelay, 2 * throttleDelay}, *slept)
+	// Not throttling, so not counted as such
+	assert.Equal(t, before, testutil.ToFloat64(metrics.Throttles.WithLabelValues(ThrottledServiceKMS)))
+	assert.Equal(t, retries+2, testutil.ToFloat64(metrics.Retries.WithLabelValues(ThrottledServiceSSM, kms.ErrCodeInvalidCiphertextException)))
+}
+
+func TestRetryPredicateReplacesThrottling(t *testing.T) {
+	slept, restore := recordThrottleSleeps()
+	defer restore()
+	fk := &fakeKMS{Errors: []error{kmsThrottling}}
+	p := AWSProvider{
+		Service:        &fakeSSM{Parameters: []*ssm.Parameter{param("/app/password", ssm.ParameterTypeSecureString, "hunter2")}},
+		KMS:            fk,
+		RetryPredicate: func(err error) bool { return false },
+	}
+
+	_, err := p.GetParameterValueWithGrants("/app/password", nil)
+	assert.Equal(t, kmsThrottling, err)
+	assert.Len(t, fk.DecryptCalls, 1)
+	assert.Empty(t, *slept)
+}
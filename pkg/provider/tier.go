@@ -0,0 +1,55 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// SSM parameter tiers, and the largest value (in bytes) each allows. This SDK
+// doesn't return a parameter's tier, so it's inferred from the value's size.
+const (
+	StandardTier = "Standard"
+	AdvancedTier = "Advanced"
+
+	StandardTierMaxBytes = 4 * 1024
+	AdvancedTierMaxBytes = 8 * 1024
+)
+
+// ParameterTier returns the smallest tier that fits the value of parameter name.
+// It's an error if no tier fits, or if the value looks truncated: it ends
+// mid-character at exactly a tier's limit, as when a larger value is cut to fit.
+func ParameterTier(name string, value string) (string, error) {
+	size := len(value)
+	if size > AdvancedTierMaxBytes {
+		return "", fmt.Errorf("Parameter %s is %d bytes, more than the %s tier allows (%d bytes)", name, size, AdvancedTier, AdvancedTierMaxBytes)
+	}
+
+	tier := StandardTier
+	limit := StandardTierMaxBytes
+	if size > StandardTierMaxBytes {
+		tier = AdvancedTier
+		limit = AdvancedTierMaxBytes
+	}
+
+	if size == limit {
+		if r, n := utf8.DecodeLastRuneInString(value); r == utf8.RuneError && n <= 1 {
+			return "", fmt.Errorf("Parameter %s looks truncated: it's exactly the %s tier limit (%d bytes) and ends mid-character", name, tier, limit)
+		}
+	}
+	return tier, nil
+}
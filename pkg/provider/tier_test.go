@@ -0,0 +1,65 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameterTier(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		value string
+		tier  string
+	}{
+		{"empty", "", StandardTier},
+		{"small", "foo", StandardTier},
+		{"standard limit", strings.Repeat("a", StandardTierMaxBytes), StandardTier},
+		{"over standard limit", strings.Repeat("a", StandardTierMaxBytes+1), AdvancedTier},
+		{"advanced limit", strings.Repeat("a", AdvancedTierMaxBytes), AdvancedTier},
+		{"multibyte at the limit", strings.Repeat("a", StandardTierMaxBytes-3) + "€", StandardTier},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			tier, err := ParameterTier("/p", tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.tier, tier)
+		})
+	}
+}
+
+func TestParameterTierRejectsTooLarge(t *testing.T) {
+	_, err := ParameterTier("/p", strings.Repeat("a", AdvancedTierMaxBytes+1))
+	require.Error(t, err)
+	assert.Equal(t, "Parameter /p is 8193 bytes, more than the Advanced tier allows (8192 bytes)", err.Error())
+}
+
+func TestParameterTierRejectsTruncated(t *testing.T) {
+	// "€" is 3 bytes; cut after the first 2 at each limit
+	for _, limit := range []int{StandardTierMaxBytes, AdvancedTierMaxBytes} {
+		value := (strings.Repeat("a", limit-2) + "€")[:limit]
+		_, err := ParameterTier("/p", value)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "looks truncated")
+	}
+
+	// Invalid UTF-8 short of a limit isn't a truncation
+	_, err := ParameterTier("/p", "a\xe2\x82")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,174 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type trackedValue struct {
+	version int64
+	value   string
+}
+
+// VersionTrackingProvider remembers the version of each parameter value read
+// from Provider. Before reading a parameter again, it reads just its version
+// (with GetParameterVersion, which isn't decrypted), and only reads the value
+// if the version has changed: a stable SecureString isn't decrypted on every
+// sync. If the version can't be read, the value is read as usual.
+type VersionTrackingProvider struct {
+	Provider Provider
+
+	mu sync.Mutex
+	// By decrypt and name
+	values map[string]trackedValue
+}
+
+// WithVersionTracking only reads values of p whose version has changed
+func WithVersionTracking(p Provider) *VersionTrackingProvider {
+	return &VersionTrackingProvider{Provider: p, values: make(map[string]trackedValue)}
+}
+
+// current returns the remembered value of name, if it's still the current version.
+// Otherwise, it returns the current version to remember the value with (or
+// 0 if it can't be read, so the value isn't remembered).
+func (v *VersionTrackingProvider) current(key string, name string) (string, bool, int64) {
+	version, err := v.Provider.GetParameterVersion(name)
+	if err != nil {
+		log.Debugf("Failed to read the version of '%s'; reading its value: %s", name, err)
+		return "", false, 0
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok := v.values[key]; ok && t.version == version {
+		return t.value, true, version
+	}
+	return "", false, version
+}
+
+func (v *VersionTrackingProvider) remember(key string, version int64, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if version == 0 {
+		delete(v.values, key)
+		return
+	}
+	v.values[key] = trackedValue{version: version, value: value}
+}
+
+func (v *VersionTrackingProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	key := strconv.FormatBool(decrypt) + ":" + name
+	value, ok, version := v.current(key, name)
+	if ok {
+		log.Debugf("'%s' is still version %d; not reading it again", name, version)
+		return value, nil
+	}
+	value, err := v.Provider.GetParameterValue(name, decrypt)
+	if err != nil {
+		return "", err
+	}
+	// The value may be newer than version, in which case it's read again next time
+	v.remember(key, version, value)
+	return value, nil
+}
+
+// GetParameterValueFresh reads name from Provider whatever its version, and
+// forgets the remembered value
+func (v *VersionTrackingProvider) GetParameterValueFresh(name string, decrypt bool) (string, error) {
+	v.remember(strconv.FormatBool(decrypt)+":"+name, 0, "")
+	return GetParameterValueFresh(v.Provider, name, decrypt)
+}
+
+// BatchGetParameterValues reads the version of each name, then reads the values
+// of those that changed in one batch
+func (v *VersionTrackingProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	changed := []string{}
+	versions := make(map[string]int64, len(names))
+	for _, name := range names {
+		value, ok, version := v.current(strconv.FormatBool(decrypt)+":"+name, name)
+		if ok {
+			values[name] = value
+		} else {
+			changed = append(changed, name)
+			versions[name] = version
+		}
+	}
+	if len(changed) == 0 {
+		return values, nil
+	}
+
+	fetched, err := BatchGetParameterValues(v.Provider, changed, decrypt)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fetched {
+		v.remember(strconv.FormatBool(decrypt)+":"+name, versions[name], value)
+		values[name] = value
+	}
+	return values, nil
+}
+
+func (v *VersionTrackingProvider) GetParameterHistory(name string, decrypt bool, limit int) ([]ParameterVersion, error) {
+	return GetParameterHistory(v.Provider, name, decrypt, limit)
+}
+
+func (v *VersionTrackingProvider) GetParameterKeyID(name string) (string, error) {
+	return GetParameterKeyID(v.Provider, name)
+}
+
+func (v *VersionTrackingProvider) GetParameterTiersByPath(ppath string) (map[string]string, error) {
+	return GetParameterTiersByPath(v.Provider, ppath)
+}
+
+func (v *VersionTrackingProvider) ListParametersByPath(ppath string) ([]string, error) {
+	return ListParametersByPath(v.Provider, ppath)
+}
+
+func (v *VersionTrackingProvider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	return GetParameterValueDecryptedAs(v.Provider, name, roleARN, grantTokens)
+}
+
+func (v *VersionTrackingProvider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	return v.Provider.GetParameterValueWithGrants(name, grantTokens)
+}
+
+func (v *VersionTrackingProvider) GetParameterDataByPath(ppath string, decrypt bool) (map[string]string, error) {
+	return v.Provider.GetParameterDataByPath(ppath, decrypt)
+}
+
+func (v *VersionTrackingProvider) GetParameterTags(name string) (map[string]string, error) {
+	return v.Provider.GetParameterTags(name)
+}
+
+func (v *VersionTrackingProvider) GetParameterDescription(name string) (string, error) {
+	return v.Provider.GetParameterDescription(name)
+}
+
+func (v *VersionTrackingProvider) GetParameterARN(name string) (string, error) {
+	return v.Provider.GetParameterARN(name)
+}
+
+func (v *VersionTrackingProvider) GetParameterVersion(name string) (int64, error) {
+	return v.Provider.GetParameterVersion(name)
+}
+
+func (v *VersionTrackingProvider) GetSecretValue(secretId string, versionStage string) (SecretValue, error) {
+	return v.Provider.GetSecretValue(secretId, versionStage)
+}
@@ -0,0 +1,115 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionedProvider has a single version of every parameter, and counts the values read
+type versionedProvider struct {
+	MockProvider
+	version    int64
+	versionErr error
+	reads      int
+	batches    [][]string
+}
+
+func (vp *versionedProvider) GetParameterValue(name string, decrypt bool) (string, error) {
+	vp.reads += 1
+	return vp.MockProvider.GetParameterValue(name, decrypt)
+}
+
+func (vp *versionedProvider) GetParameterVersion(name string) (int64, error) {
+	return vp.version, vp.versionErr
+}
+
+func (vp *versionedProvider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	vp.batches = append(vp.batches, names)
+	return getEach(vp, names, decrypt)
+}
+
+func TestVersionTrackingUnchanged(t *testing.T) {
+	vp := &versionedProvider{MockProvider: MockProvider{DecryptedValue: "v1"}, version: 1}
+	p := WithVersionTracking(vp)
+
+	for i := 0; i < 3; i++ {
+		value, err := p.GetParameterValue("/foo", true)
+		require.NoError(t, err)
+		assert.Equal(t, "v1", value)
+	}
+	assert.Equal(t, 1, vp.reads)
+
+	// Values are remembered with the decrypt flag
+	_, err := p.GetParameterValue("/foo", false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, vp.reads)
+}
+
+func TestVersionTrackingAdvanced(t *testing.T) {
+	vp := &versionedProvider{MockProvider: MockProvider{DecryptedValue: "v1"}, version: 1}
+	p := WithVersionTracking(vp)
+	_, err := p.GetParameterValue("/foo", true)
+	require.NoError(t, err)
+
+	vp.version, vp.DecryptedValue = 2, "v2"
+	value, err := p.GetParameterValue("/foo", true)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", value)
+	assert.Equal(t, 2, vp.reads)
+
+	_, err = p.GetParameterValue("/foo", true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, vp.reads)
+
+	// A fresh read always reads the value
+	_, err = p.GetParameterValueFresh("/foo", true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, vp.reads)
+}
+
+func TestVersionTrackingVersionError(t *testing.T) {
+	vp := &versionedProvider{MockProvider: MockProvider{DecryptedValue: "v1"}, versionErr: errors.New("AccessDeniedException")}
+	p := WithVersionTracking(vp)
+
+	for i := 0; i < 2; i++ {
+		value, err := p.GetParameterValue("/foo", true)
+		require.NoError(t, err)
+		assert.Equal(t, "v1", value)
+	}
+	assert.Equal(t, 2, vp.reads)
+}
+
+func TestVersionTrackingBatch(t *testing.T) {
+	vp := &versionedProvider{MockProvider: MockProvider{DecryptedValue: "v1"}, version: 1}
+	p := WithVersionTracking(vp)
+	_, err := p.GetParameterValue("/a", true)
+	require.NoError(t, err)
+
+	values, err := p.BatchGetParameterValues([]string{"/a", "/b"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/a": "v1", "/b": "v1"}, values)
+	assert.Equal(t, [][]string{{"/b"}}, vp.batches)
+
+	// Neither changed
+	_, err = p.BatchGetParameterValues([]string{"/a", "/b"}, true)
+	require.NoError(t, err)
+	assert.Len(t, vp.batches, 1)
+}
@@ -16,16 +16,33 @@
 package secret
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 
 	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/archive"
+	"github.com/cmattoon/aws-ssm/pkg/jsonfield"
 	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/templates"
+	"github.com/cmattoon/aws-ssm/pkg/transform"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type Secret struct {
@@ -40,13 +57,39 @@ type Secret struct {
 	ParamType string
 	// AWS Param Key (Default: "alias/aws/ssm")
 	ParamKey string
+	// True if ParamKey is the default key, because no key was annotated
+	DefaultKey bool
 	// AWS Param Value
 	ParamValue string
 	// The data to add to Kubernetes Secret Data
 	Data map[string]string
+	// Keys set during this sync
+	keys map[string]bool
+	// Data as it was read, to log which keys changed
+	original map[string]string
+	// Keys left alone during this sync, because something else set them
+	skipped map[string]bool
+	// Directory/DirectoryArchive: the full name of the parameter of each key
+	sources map[string]string
+
+	// Guards the data and keys in Set, which may be called concurrently (e.g.,
+	// by the workers of a parallel import)
+	mu sync.Mutex
 }
 
-func NewSecret(sec v1.Secret, p provider.Provider, secret_name string, secret_namespace string, param_name string, param_type string, param_key string) (*Secret, error) {
+// NewSecret reads the parameter named by an object's annotations into a Secret.
+// Any error is wrapped with the object and parameter it happened for, for
+// logs and events; errors.Is and errors.As still see the original.
+func NewSecret(sec v1.Secret, p provider.Provider, secret_name string, secret_namespace string, param_name string, param_type string, param_key string) (_ *Secret, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("Secret %s/%s, parameter '%s' (%s): %w", secret_namespace, secret_name, param_name, param_type, err)
+		}
+	}()
+	if anno.Bool(sec.ObjectMeta.Annotations, anno.V1NormalizeNewlines, false) {
+		// Before any value is parsed, after the controller's transforms
+		p = transform.WithTransformers(p, transform.NormalizeNewlines)
+	}
 
 	s := &Secret{
 		Secret:     sec,
@@ -57,9 +100,13 @@ func NewSecret(sec v1.Secret, p provider.Provider, secret_name string, secret_na
 		ParamKey:   param_key,
 		ParamValue: "",
 		Data:       map[string]string{},
+		original:   map[string]string{},
+	}
+	for k, v := range sec.Data {
+		s.original[k] = string(v)
 	}
 
-	log.Debugf("Getting value for '%s/%s'", s.Namespace, s.Name)
+	s.logger().Debug("Getting value")
 
 	decrypt := false
 	if s.ParamKey != "" {
@@ -67,51 +114,196 @@ func NewSecret(sec v1.Secret, p provider.Provider, secret_name string, secret_na
 	}
 
 	if s.ParamType == "String" || s.ParamType == "SecureString" {
-		value, err := p.GetParameterValue(s.ParamName, decrypt)
+		value, err := s.getParameterValue(p, decrypt)
 		if err != nil {
+			if s.decryptFailed(err, decrypt) {
+				return s, nil
+			}
 			return nil, err
 		}
 		s.ParamValue = value
+		if err := s.setParameterTier(value); err != nil {
+			return nil, err
+		}
 	} else if s.ParamType == "StringList" {
-		value, err := p.GetParameterValue(s.ParamName, decrypt)
+		value, err := s.getParameterValue(p, decrypt)
 		if err != nil {
+			if s.decryptFailed(err, decrypt) {
+				return s, nil
+			}
 			return nil, err
 		}
 		s.ParamValue = value
-		// StringList: Also set each key
-		values := s.ParseStringList()
-		for k, v := range values {
-			s.Set(k, v)
+		if err := s.setParameterTier(value); err != nil {
+			return nil, err
+		}
+		if sec.ObjectMeta.Annotations[anno.V1ListOutput] == anno.ListOutputJoined {
+			// StringList: Only the entries, one per line, under the raw key
+			s.ParamValue = strings.Join(listEntries(sec.ObjectMeta.Annotations, value), "\n")
+		} else {
+			// StringList: Also set each key
+			if err := s.setListEntries(sec.ObjectMeta.Annotations[anno.V1ListTarget]); err != nil {
+				return nil, err
+			}
 		}
 	} else if s.ParamType == "Directory" {
 		// Directory: Set each sub-key
-		all_params, err := p.GetParameterDataByPath(s.ParamName, decrypt)
+		ppaths, data, sources, err := s.directoryData(p, sec.ObjectMeta.Annotations, s.ParamName, decrypt)
 		if err != nil {
 			return nil, err
 		}
-
-		for k, v := range all_params {
-			s.Set(safeKeyName(k), v)
+		s.ParamName = ppaths
+		for k, v := range data {
+			s.Set(k, v)
+		}
+		s.ParamValue = "true"
+		if err := s.setDirectoryMeta(sec.ObjectMeta.Annotations); err != nil {
+			return nil, err
+		}
+		if err := s.setExtraData(sec.ObjectMeta.Annotations); err != nil {
+			return nil, err
+		}
+		s.sources = sources
+		if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportARN, false) {
+			s.importARNs(p, sources)
 		}
-		s.ParamValue = "true" // Reads "Directory": "true"
 		return s, nil
+	} else if s.ParamType == "DirectoryArchive" {
+		// DirectoryArchive: Store all sub-keys as a single gzipped JSON value
+		ppaths, data, sources, err := s.directoryData(p, sec.ObjectMeta.Annotations, s.ParamName, decrypt)
+		if err != nil {
+			return nil, err
+		}
+		s.ParamName = ppaths
+		value, err := archive.Encode(data)
+		if err != nil {
+			return nil, err
+		}
+		s.ParamValue = value
+		s.sources = sources
+
+		if s.Secret.ObjectMeta.Annotations == nil {
+			s.Secret.ObjectMeta.Annotations = make(map[string]string)
+		}
+		s.Secret.ObjectMeta.Annotations[anno.V1ArchiveKeyCount] = strconv.Itoa(len(data))
+	} else if s.ParamType == "SecretsManager" {
+		// SecretsManager: "name#field" (or the secret-field-path annotation) selects a
+		// single JSON field. Otherwise, also set each top-level field of a JSON secret.
+		// The secret-fields annotation narrows the secret to its listed fields first.
+		secret_id, field := jsonfield.Split(s.ParamName)
+		if v, ok := sec.ObjectMeta.Annotations[anno.V1SecretFieldPath]; ok {
+			field = v
+		}
+
+		secret_value, err := p.GetSecretValue(secret_id, sec.ObjectMeta.Annotations[anno.V1VersionStage])
+		if err != nil {
+			return nil, err
+		}
+		if secret_value.Binary != nil {
+			if field != "" {
+				return nil, fmt.Errorf("Secret '%s': cannot select field '%s' of a binary secret", secret_id, field)
+			}
+			// Binary secrets are stored as-is in Data, under the "$ParamType" key
+			s.SetBinary(s.ParamType, secret_value.Binary)
+			return s, nil
+		}
+		value, err := transform.Value(p, secret_id, secret_value.String)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields := anno.List(sec.ObjectMeta.Annotations, anno.V1SecretFields); len(fields) > 0 && !provider.Validating(p) {
+			value, err = jsonfield.Subset(value, fields)
+			if err != nil {
+				return nil, fmt.Errorf("Secret '%s': %s", secret_id, err)
+			}
+		}
+		if field != "" && !provider.Validating(p) {
+			value, err = jsonfield.Get(value, field)
+			if err != nil {
+				return nil, fmt.Errorf("Secret '%s': %s", secret_id, err)
+			}
+		} else if fields, ok := jsonfield.Fields(value); ok {
+			for k, v := range fields {
+				s.Set(k, v)
+			}
+		}
+		s.ParamValue = value
+	}
+
+	if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportTags, false) && anno.AppliesTo(anno.V1ImportTags, s.ParamType) {
+		tags, err := p.GetParameterTags(s.ParamName)
+		s.importFailed("tags", err)
+		for k, v := range tags {
+			s.Set(tagKeyName(k), v)
+		}
+	}
+
+	if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportDescription, false) {
+		s.importDescription(p)
+	}
+
+	if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportKMSKeyID, false) {
+		s.importKMSKeyID(p)
+	}
+
+	if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ImportARN, false) && s.ParamType != "SecretsManager" {
+		if s.sources != nil {
+			s.importARNs(p, s.sources)
+		} else {
+			s.importARNs(p, map[string]string{s.ParamName: s.ParamName})
+		}
+	}
+
+	if err := s.setPreviousValues(p, sec.ObjectMeta.Annotations, decrypt); err != nil {
+		return nil, err
+	}
+
+	rendered, err := templates.Render(sec.ObjectMeta.Annotations, p)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range rendered {
+		s.Set(k, v)
+	}
+
+	if err := s.setExtraData(sec.ObjectMeta.Annotations); err != nil {
+		return nil, err
 	}
 
 	// Always set the "$ParamType" key:
 	//   String: Value
 	//   SecureString: Value
-	//   StringList: Value
-	//   Directory: <ssm-path>
-	s.Set(s.ParamType, s.ParamValue)
+	//   StringList: Value (unless renamed or omitted)
+	// (Directory only sets a key with aws-ssm/directory-meta)
+	key := s.ParamType
+	if s.ParamType == "StringList" {
+		if anno.Bool(sec.ObjectMeta.Annotations, anno.V1ListOmitRaw, false) {
+			return s, nil
+		}
+		if k := sec.ObjectMeta.Annotations[anno.V1ListRawKey]; k != "" {
+			key = k
+		}
+	}
+	s.Set(key, s.ParamValue)
 
 	return s, nil
 }
 
 // FromKubernetesSecret returns an internal Secret struct, if the v1.Secret is properly annotated.
 func FromKubernetesSecret(p provider.Provider, secret v1.Secret) (*Secret, error) {
+	return FromKubernetesSecretWithBasePath(p, secret, "")
+}
+
+// FromKubernetesSecretWithBasePath is FromKubernetesSecret, reading relative
+// parameter names (that don't start with "/") under basePath (-base-path)
+func FromKubernetesSecretWithBasePath(p provider.Provider, secret v1.Secret, basePath string) (*Secret, error) {
 	param_name := ""
 	param_type := ""
+	default_key := false
 	param_key := ""
+	param_version := ""
+	min_version := ""
 
 	for k, v := range secret.ObjectMeta.Annotations {
 		switch k {
@@ -121,6 +313,10 @@ func FromKubernetesSecret(p provider.Provider, secret v1.Secret) (*Secret, error
 			param_type = v
 		case anno.AWSParamKey, anno.V1ParamKey:
 			param_key = v
+		case anno.V1PinVersion:
+			param_version = v
+		case anno.V1MinVersion:
+			min_version = v
 		}
 	}
 
@@ -128,10 +324,53 @@ func FromKubernetesSecret(p provider.Provider, secret v1.Secret) (*Secret, error
 		return nil, errors.New("Irrelevant Secret")
 	}
 
+	// Reports every problem at once, rather than one per sync
+	warnings, err := anno.Validate("Secret", secret.ObjectMeta.Annotations)
+	for _, w := range warnings {
+		log.WithFields(log.Fields{
+			"namespace": secret.Namespace,
+			"name":      secret.Name,
+			"paramName": param_name,
+			"paramType": param_type,
+		}).Warn(w)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	if param_name != "" && param_type != "" {
 		if param_type == "SecureString" && param_key == "" {
-			log.Info("No KMS key defined. Using default key 'alias/aws/ssm'")
+			// Logged for every sync, so only at debug level
+			log.WithFields(log.Fields{
+				"namespace": secret.Namespace,
+				"name":      secret.Name,
+				"paramName": param_name,
+				"paramType": param_type,
+			}).Debug("No KMS key defined. Using default key 'alias/aws/ssm'")
 			param_key = "alias/aws/ssm"
+			default_key = true
+		}
+	}
+
+	// Secrets Manager names aren't paths
+	if param_type != "SecretsManager" {
+		param_name = provider.WithBasePath(param_name, basePath)
+	}
+
+	// An explicit pin-version annotation takes precedence over any
+	// inline "name:version" selector in the param name
+	if param_version != "" {
+		versioned, err := provider.WithVersion(param_name, param_version)
+		if err != nil {
+			return nil, err
+		}
+		param_name = versioned
+	}
+
+	// Don't write a stale value while an external update is propagating
+	if min_version != "" {
+		if err := provider.CheckMinVersion(p, param_name, min_version); err != nil {
+			return nil, err
 		}
 	}
 
@@ -147,25 +386,29 @@ func FromKubernetesSecret(p provider.Provider, secret v1.Secret) (*Secret, error
 	if err != nil {
 		return nil, err
 	}
+	s.DefaultKey = default_key
+
+	if registry, ok := secret.ObjectMeta.Annotations[anno.V1DockerRegistry]; ok {
+		if err := s.setDockerConfig(registry); err != nil {
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
 func (s *Secret) ParseStringList() (values map[string]string) {
 	values = make(map[string]string)
 
-	for _, pair := range strings.Split(strings.TrimSpace(s.ParamValue), ",") {
-		pair = strings.TrimSpace(pair)
+	delimiters := listPairDelimiters(s.Secret.ObjectMeta.Annotations)
+	for _, pair := range listEntries(s.Secret.ObjectMeta.Annotations, s.ParamValue) {
 		key := pair
 		val := ""
 
-		if strings.Contains(pair, "=") {
-			kv := strings.SplitN(pair, "=", 2)
-			if len(kv) == 2 {
-				if kv[0] != "" {
-					key = kv[0]
-					val = kv[1]
-				}
-			}
+		// Split at the first delimiter only; the value may contain more
+		if i := strings.IndexAny(pair, delimiters); i > 0 {
+			_, n := utf8.DecodeRuneInString(pair[i:])
+			key = pair[:i]
+			val = pair[i+n:]
 		}
 		if key != "" {
 			values[key] = val
@@ -175,8 +418,147 @@ func (s *Secret) ParseStringList() (values map[string]string) {
 	return
 }
 
+// setListEntries sets a key per StringList entry, in StringData or (with
+// list-target) in Data, base64-decoding each value with ListTargetDataBase64.
+// Every entry that isn't valid base64 is reported, by key (never by value).
+func (s *Secret) setListEntries(target string) error {
+	values := s.ParseStringList()
+	if target == "" || target == anno.ListTargetStringData {
+		for k, v := range values {
+			s.Set(k, v)
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	invalid := []string{}
+	for _, k := range keys {
+		data := []byte(values[k])
+		if target == anno.ListTargetDataBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(values[k])
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("'%s' (%s)", k, err))
+				continue
+			}
+			data = decoded
+		}
+		s.SetBinary(k, data)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("Invalid base64 in StringList entries: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// The keys each type of Secret needs; the apiserver rejects the Secret otherwise
+var typeKeys = map[v1.SecretType][]string{
+	v1.SecretTypeTLS:              {v1.TLSCertKey, v1.TLSPrivateKeyKey},
+	v1.SecretTypeDockerConfigJson: {v1.DockerConfigJsonKey},
+	v1.SecretTypeDockercfg:        {v1.DockerConfigKey},
+	v1.SecretTypeSSHAuth:          {v1.SSHAuthPrivateKey},
+}
+
+// checkType returns an error if the Secret, as it would be written, doesn't
+// have the keys its type needs, or a docker config key isn't JSON. Opaque (and
+// custom) Secrets can have any keys.
+func (s *Secret) checkType() error {
+	value := func(k string) (string, bool) {
+		if v, ok := s.Secret.StringData[k]; ok {
+			return v, true
+		}
+		v, ok := s.Secret.Data[k]
+		return string(v), ok
+	}
+
+	missing := []string{}
+	for _, k := range typeKeys[s.Secret.Type] {
+		if _, ok := value(k); !ok {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Secret %s/%s is of type %s, which needs the keys %s, but is missing %s",
+			s.Namespace, s.Name, s.Secret.Type, strings.Join(typeKeys[s.Secret.Type], ", "), strings.Join(missing, ", "))
+	}
+
+	switch s.Secret.Type {
+	case v1.SecretTypeBasicAuth:
+		_, user := value(v1.BasicAuthUsernameKey)
+		_, password := value(v1.BasicAuthPasswordKey)
+		if !user && !password {
+			return fmt.Errorf("Secret %s/%s is of type %s, which needs the key %s or %s, but has neither",
+				s.Namespace, s.Name, s.Secret.Type, v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey)
+		}
+	case v1.SecretTypeDockerConfigJson, v1.SecretTypeDockercfg:
+		k := v1.DockerConfigJsonKey
+		if s.Secret.Type == v1.SecretTypeDockercfg {
+			k = v1.DockerConfigKey
+		}
+		v, _ := value(k)
+		if !json.Valid([]byte(v)) {
+			return fmt.Errorf("Secret %s/%s is of type %s, but its %s key isn't JSON", s.Namespace, s.Name, s.Secret.Type, k)
+		}
+	}
+	return nil
+}
+
+// setDockerConfig replaces the imported username, password and email keys with
+// a .dockerconfigjson key, which logs into registry with them
+func (s *Secret) setDockerConfig(registry string) error {
+	if s.Secret.Type != v1.SecretTypeDockerConfigJson {
+		return fmt.Errorf("%s only applies to Secrets of type %s, but %s/%s is of type %s",
+			anno.V1DockerRegistry, v1.SecretTypeDockerConfigJson, s.Namespace, s.Name, s.Secret.Type)
+	}
+	username, hasUser := s.Secret.StringData["username"]
+	password, hasPassword := s.Secret.StringData["password"]
+	if !hasUser || !hasPassword {
+		return fmt.Errorf("%s needs the parameter to have username and password keys; it has: %s",
+			anno.V1DockerRegistry, strings.Join(s.ManagedKeys(), ", "))
+	}
+
+	auth := map[string]string{
+		"username": username,
+		"password": password,
+		"auth":     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	if email, ok := s.Secret.StringData["email"]; ok {
+		auth["email"] = email
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{registry: auth},
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range []string{"username", "password", "email"} {
+		delete(s.Secret.StringData, k)
+		delete(s.keys, k)
+	}
+	return s.Set(v1.DockerConfigJsonKey, string(config))
+}
+
+// logger returns a logger with fields identifying the Secret and its parameter.
+// Values must never be logged.
+func (s *Secret) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"namespace": s.Namespace,
+		"name":      s.Name,
+		"paramName": s.ParamName,
+		"paramType": s.ParamType,
+	})
+}
+
+// Set sets key to val, refusing to overwrite a key of s.Data. It's safe for
+// concurrent use.
 func (s *Secret) Set(key string, val string) (err error) {
-	log.Debugf("Setting key=%s", key)
+	s.logger().Debugf("Setting key=%s", key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.Secret.StringData == nil {
 		s.Secret.StringData = make(map[string]string)
 	}
@@ -186,12 +568,876 @@ func (s *Secret) Set(key string, val string) (err error) {
 		return errors.New(fmt.Sprintf("Key '%s' already exists for Secret %s/%s", key, s.Namespace, s.Name))
 	}
 	s.Secret.StringData[key] = val
+	s.track(key)
+	return
+}
+
+// getParameterValue reads the value of the parameter, and transforms it (see
+// transform.Value). With allow-encrypted-fallback, a decrypt that's denied stores
+// the still-encrypted value instead, untransformed, which is recorded in the
+// encrypted-fallback annotation.
+func (s *Secret) getParameterValue(p provider.Provider, decrypt bool) (string, error) {
+	annotations := s.Secret.ObjectMeta.Annotations
+	value, err := getParameterValue(p, annotations, s.ParamName, decrypt)
+	delete(annotations, anno.V1DecryptError)
+	if err == nil {
+		delete(annotations, anno.V1EncryptedFallback)
+		return transform.Value(p, s.ParamName, value)
+	}
+	if !decrypt || !provider.IsAccessDenied(err) || !anno.Bool(annotations, anno.V1AllowEncryptedFallback, false) {
+		delete(annotations, anno.V1EncryptedFallback)
+		return "", err
+	}
+
+	encrypted, fallbackErr := p.GetParameterValue(s.ParamName, false)
+	if fallbackErr != nil {
+		s.logger().Debugf("Failed to read the encrypted value: %s", fallbackErr)
+		return "", err
+	}
+	s.logger().Warnf("Storing the encrypted value, since decrypting was denied: %s", err)
+	annotations[anno.V1EncryptedFallback] = "true"
+	return encrypted, nil
+}
+
+// decryptFailed records err in the decrypt-error annotation, if it's a failure to
+// decrypt and decrypt-failure-fatal is "false". The Secret is then synced without
+// any keys of the parameter, so nothing (not even the ciphertext) is written,
+// and keys from previous syncs are left as they were.
+func (s *Secret) decryptFailed(err error, decrypt bool) bool {
+	annotations := s.Secret.ObjectMeta.Annotations
+	if !decrypt || !provider.IsDecryptError(err) || anno.Bool(annotations, anno.V1DecryptFailureFatal, true) {
+		return false
+	}
+	s.logger().Warnf("Failed to decrypt the parameter; syncing without its keys: %s", err)
+	annotations[anno.V1DecryptError] = fmt.Sprintf("%s: %s", s.ParamType, err)
+	return true
+}
+
+// setParameterTier records the tier of a single parameter's value in the
+// param-tier annotation
+func (s *Secret) setParameterTier(value string) error {
+	if s.Secret.ObjectMeta.Annotations[anno.V1EncryptedFallback] == "true" {
+		// The size of the ciphertext says nothing of the tier
+		delete(s.Secret.ObjectMeta.Annotations, anno.V1ParamTier)
+		return nil
+	}
+	tier, err := provider.ParameterTier(s.ParamName, value)
+	if err != nil {
+		return err
+	}
+	if s.Secret.ObjectMeta.Annotations == nil {
+		s.Secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	s.Secret.ObjectMeta.Annotations[anno.V1ParamTier] = tier
+	return nil
+}
+
+// SetBinary sets a key in Data rather than StringData, for values that aren't valid strings
+func (s *Secret) SetBinary(key string, val []byte) (err error) {
+	s.logger().Debugf("Setting binary key=%s", key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Secret.Data == nil {
+		s.Secret.Data = make(map[string][]byte)
+	}
+	if _, ok := s.Data[key]; ok {
+		// Refuse to overwite existing keys
+		return errors.New(fmt.Sprintf("Key '%s' already exists for Secret %s/%s", key, s.Namespace, s.Name))
+	}
+	s.Secret.Data[key] = val
+	s.track(key)
 	return
 }
 
+func (s *Secret) track(key string) {
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	s.keys[key] = true
+}
+
+// ManagedKeys returns the keys set during this sync, sorted
+func (s *Secret) ManagedKeys() []string {
+	keys := []string{}
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PreserveKeys restores the original value of each key that was already in the
+// Secret but not set by a previous sync (i.e., owned by another tool), and records
+// the remaining keys in the managed-keys annotation. Returns the restored keys.
+func (s *Secret) PreserveKeys(original map[string][]byte) []string {
+	previous := make(map[string]bool)
+	for _, k := range anno.List(s.Secret.ObjectMeta.Annotations, anno.V1ManagedKeys) {
+		previous[k] = true
+	}
+
+	preserved := []string{}
+	for _, k := range s.ManagedKeys() {
+		v, ok := original[k]
+		if !ok || previous[k] {
+			continue
+		}
+		delete(s.Secret.StringData, k)
+		s.Secret.Data[k] = v
+		delete(s.keys, k)
+		s.skip(k)
+		preserved = append(preserved, k)
+	}
+
+	if s.Secret.ObjectMeta.Annotations == nil {
+		s.Secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	s.Secret.ObjectMeta.Annotations[anno.V1ManagedKeys] = strings.Join(s.ManagedKeys(), ",")
+	return preserved
+}
+
+// Size returns the number of bytes the apiserver counts against its size limit
+// (v1.MaxSecretSize) once StringData has been merged into Data
+func (s *Secret) Size() int {
+	size := 0
+	for k, v := range s.Secret.Data {
+		if _, ok := s.Secret.StringData[k]; !ok {
+			size += len(v)
+		}
+	}
+	for _, v := range s.Secret.StringData {
+		size += len(v)
+	}
+	return size
+}
+
 func (s *Secret) UpdateObject(cli kubernetes.Interface) (result *v1.Secret, err error) {
-	log.Info("Updating Kubernetes Secret...")
-	return cli.CoreV1().Secrets(s.Namespace).Update(&s.Secret)
+	if err := s.prepareWrite(); err != nil {
+		return nil, err
+	}
+
+	if anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1PatchChangedKeys, false) {
+		s.logUpdate("Patching")
+		result, err = s.patchChangedKeys(cli)
+	} else {
+		s.logUpdate("Updating")
+		s.reconcileData()
+		result, err = cli.CoreV1().Secrets(s.Namespace).Update(&s.Secret)
+	}
+	return s.createIfMissing(cli, result, err)
+}
+
+// PatchObject writes the Secret like UpdateObject, but with a JSON merge patch
+// of only the keys set during this sync, the controller's annotations and the
+// managed label (-update-strategy=patch), so it doesn't conflict with other
+// writers, whose keys and annotations are left alone. aws-ssm/patch-changed-keys
+// still narrows the patch to the changed keys.
+func (s *Secret) PatchObject(cli kubernetes.Interface) (result *v1.Secret, err error) {
+	if err := s.prepareWrite(); err != nil {
+		return nil, err
+	}
+
+	s.logUpdate("Patching")
+	if anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1PatchChangedKeys, false) {
+		result, err = s.patchChangedKeys(cli)
+	} else {
+		result, err = s.mergePatch(cli)
+	}
+	return s.createIfMissing(cli, result, err)
+}
+
+// types.ApplyPatchType, which this apimachinery predates
+const applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+// The metav1.CauseType of the conflicts of an apply with another field manager
+const fieldManagerConflict = "FieldManagerConflict"
+
+// ApplyObject writes the Secret with a server-side apply of the keys set during
+// this sync, the controller's annotations and the managed label
+// (-update-strategy=apply). The apiserver records them as owned by fieldManager
+// (-field-manager), and removes those its previous apply set that this one doesn't,
+// e.g. the key of a parameter deleted from a Directory. Keys and annotations
+// owned by other writers are left alone. Kubernetes < 1.16 doesn't support
+// server-side apply, so there (and with a client that can't send one, e.g. a
+// fake) it falls back to PatchObject, which leaves removed keys in place.
+func (s *Secret) ApplyObject(cli kubernetes.Interface, fieldManager string) (*v1.Secret, error) {
+	rc := cli.CoreV1().RESTClient()
+	if r, ok := rc.(*rest.RESTClient); ok && r == nil {
+		s.logger().Warn("Client can't send a server-side apply; patching instead")
+		return s.PatchObject(cli)
+	}
+	if err := s.prepareWrite(); err != nil {
+		return nil, err
+	}
+
+	body, err := s.applyConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	s.logUpdate("Applying")
+	req := rc.Patch(applyPatchType).
+		Namespace(s.Namespace).
+		Resource("secrets").
+		Name(s.Name).
+		Param("fieldManager", fieldManager)
+	if anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1ForceApply, false) {
+		req = req.Param("force", "true")
+	}
+	result := &v1.Secret{}
+	err = req.Body(body).Do().Into(result)
+	if isApplyConflict(err) {
+		return nil, fmt.Errorf("Keys or annotations are owned by another field manager; set aws-ssm/force-apply to take them over: %w", err)
+	}
+	if apierrors.IsUnsupportedMediaType(err) {
+		s.logger().Warn("The apiserver doesn't support server-side apply (Kubernetes < 1.16); patching instead")
+		return s.PatchObject(cli)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.clearLastError(cli, result)
+}
+
+// isApplyConflict returns true if err is the conflict of an apply (that isn't
+// forced) with the fields of another field manager
+func isApplyConflict(err error) bool {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return false
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == fieldManagerConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfiguration returns the server-side apply of ApplyObject. Unless
+// aws-ssm/create-if-missing is set, it has the resourceVersion the Secret was
+// read at, so an object deleted since then isn't recreated.
+func (s *Secret) applyConfiguration() ([]byte, error) {
+	metadata := map[string]interface{}{
+		"name":      s.Name,
+		"namespace": s.Namespace,
+		"labels":    map[string]string{anno.V1ManagedLabel: "true"},
+	}
+	if !anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1CreateIfMissing, false) {
+		metadata["resourceVersion"] = s.Secret.ObjectMeta.ResourceVersion
+	}
+	annotations := make(map[string]string)
+	for _, k := range controllerAnnotations {
+		if v, ok := s.Secret.ObjectMeta.Annotations[k]; ok {
+			annotations[k] = v
+		}
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata,
+		"data":       s.managedData(),
+	})
+}
+
+// clearLastError removes the last-error annotations from result, the Secret as
+// applied. They're written by another kind of request when a sync fails, so
+// they aren't owned by the controller's applies, which can't remove them.
+func (s *Secret) clearLastError(cli kubernetes.Interface, result *v1.Secret) (*v1.Secret, error) {
+	_, hasError := result.ObjectMeta.Annotations[anno.V1LastError]
+	_, hasTime := result.ObjectMeta.Annotations[anno.V1LastErrorTime]
+	if !hasError && !hasTime {
+		return result, nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{anno.V1LastError: nil, anno.V1LastErrorTime: nil},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cli.CoreV1().Secrets(s.Namespace).Patch(s.Name, types.MergePatchType, patch)
+}
+
+// prepareWrite checks the size and keys of the Secret and sets what the
+// controller records on every successful sync
+func (s *Secret) prepareWrite() error {
+	if size := s.Size(); size > v1.MaxSecretSize {
+		return fmt.Errorf("Secret %s/%s is too large: %d bytes exceeds the limit of %d bytes", s.Namespace, s.Name, size, v1.MaxSecretSize)
+	}
+	if err := s.checkType(); err != nil {
+		return err
+	}
+	// A successful sync clears any previous error
+	delete(s.Secret.ObjectMeta.Annotations, anno.V1LastError)
+	delete(s.Secret.ObjectMeta.Annotations, anno.V1LastErrorTime)
+	s.label()
+	if anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1ComputeChecksum, false) {
+		s.Secret.ObjectMeta.Annotations[anno.V1Checksum] = s.Checksum()
+	}
+	return nil
+}
+
+// createIfMissing creates the Secret if writing it failed because it doesn't
+// exist and it has aws-ssm/create-if-missing. Otherwise, it returns result and err.
+func (s *Secret) createIfMissing(cli kubernetes.Interface, result *v1.Secret, err error) (*v1.Secret, error) {
+	if apierrors.IsNotFound(err) && anno.Bool(s.Secret.ObjectMeta.Annotations, anno.V1CreateIfMissing, false) {
+		s.logger().Info("Secret not found; creating it")
+		s.Secret.ObjectMeta.ResourceVersion = ""
+		s.reconcileData()
+		return cli.CoreV1().Secrets(s.Namespace).Create(&s.Secret)
+	}
+	return result, err
+}
+
+// reconcileData removes the Data entry of each key that's also in StringData,
+// so the Secret is written with each key once. StringData holds the values
+// computed by this sync, so it takes precedence, as it does when the apiserver
+// merges the two. Returns the keys whose Data value was replaced by a different one.
+func (s *Secret) reconcileData() []string {
+	replaced := []string{}
+	for k, v := range s.Secret.StringData {
+		original, ok := s.Secret.Data[k]
+		if !ok {
+			continue
+		}
+		delete(s.Secret.Data, k)
+		if string(original) != v {
+			replaced = append(replaced, k)
+		}
+	}
+	sort.Strings(replaced)
+	if len(replaced) > 0 {
+		s.logger().Debugf("Replacing the Data values of keys also in StringData: %s", strings.Join(replaced, ", "))
+	}
+	return replaced
+}
+
+// Annotations written by the controller, which are patched along with the keys
+var controllerAnnotations = []string{
+	anno.V1ManagedKeys,
+	anno.V1ArchiveKeyCount,
+	anno.V1ParamTier,
+	anno.V1EncryptedFallback,
+	anno.V1DecryptError,
+	anno.V1Description,
+	anno.V1KMSKeyID,
+	anno.V1ARN,
+	anno.V1TruncatedKeys,
+	anno.V1Checksum,
+	anno.V1LastError,
+	anno.V1LastErrorTime,
+}
+
+// patchChangedKeys patches the keys whose values differ from the Secret as
+// it was read, and the controller's annotations. Keys and annotations written
+// by other controllers in the meantime are left alone, as are keys that are
+// no longer set from the parameter.
+func (s *Secret) patchChangedKeys(cli kubernetes.Interface) (*v1.Secret, error) {
+	data := make(map[string][]byte)
+	for k, v := range s.Secret.StringData {
+		if original, ok := s.Secret.Data[k]; !ok || string(original) != v {
+			data[k] = []byte(v)
+		}
+	}
+	patch, err := s.patch(data)
+	if err != nil {
+		return nil, err
+	}
+	s.logger().Debugf("Patching %d changed keys", len(data))
+	return cli.CoreV1().Secrets(s.Namespace).Patch(s.Name, types.StrategicMergePatchType, patch)
+}
+
+// mergePatch patches the keys set during this sync, whether or not they
+// changed, and the controller's annotations, with a JSON merge patch
+func (s *Secret) mergePatch(cli kubernetes.Interface) (*v1.Secret, error) {
+	data := s.managedData()
+	patch, err := s.patch(data)
+	if err != nil {
+		return nil, err
+	}
+	s.logger().Debugf("Patching %d keys", len(data))
+	return cli.CoreV1().Secrets(s.Namespace).Patch(s.Name, types.MergePatchType, patch)
+}
+
+// managedData returns the value of each key set during this sync
+func (s *Secret) managedData() map[string][]byte {
+	data := make(map[string][]byte)
+	for _, k := range s.ManagedKeys() {
+		if v, ok := s.Secret.StringData[k]; ok {
+			data[k] = []byte(v)
+		} else {
+			data[k] = s.Secret.Data[k]
+		}
+	}
+	return data
+}
+
+// patch returns a patch of data, the controller's annotations (removing those
+// it didn't set) and the managed label
+func (s *Secret) patch(data map[string][]byte) ([]byte, error) {
+	annotations := make(map[string]*string)
+	for _, k := range controllerAnnotations {
+		if v, ok := s.Secret.ObjectMeta.Annotations[k]; ok {
+			annotations[k] = &v
+		} else {
+			annotations[k] = nil
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+			"labels":      map[string]string{anno.V1ManagedLabel: "true"},
+		},
+		"data": data,
+	})
+}
+
+// changedKeys returns the keys set during this sync whose values differ from
+// the Secret as it was read, sorted
+func (s *Secret) changedKeys() []string {
+	added, changed := s.KeyChanges()
+	keys := append(added, changed...)
+	sort.Strings(keys)
+	return keys
+}
+
+// KeyChanges returns the keys set during this sync that weren't in the Secret
+// as it was read, and the ones whose values differ from it, sorted
+func (s *Secret) KeyChanges() (added []string, changed []string) {
+	added, changed = []string{}, []string{}
+	for _, k := range s.ManagedKeys() {
+		v, ok := s.Secret.StringData[k]
+		if !ok {
+			v = string(s.Secret.Data[k])
+		}
+		if original, ok := s.original[k]; !ok {
+			added = append(added, k)
+		} else if original != v {
+			changed = append(changed, k)
+		}
+	}
+	return added, changed
+}
+
+func (s *Secret) skip(key string) {
+	if s.skipped == nil {
+		s.skipped = make(map[string]bool)
+	}
+	s.skipped[key] = true
+}
+
+// SkippedKeys returns the keys left alone during this sync, because something
+// else set them (see PreserveKeys), sorted
+func (s *Secret) SkippedKeys() []string {
+	keys := []string{}
+	for k := range s.skipped {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SourceParams returns the full names of the parameters read, sorted: each
+// parameter of a Directory/DirectoryArchive, or else the parameter itself
+func (s *Secret) SourceParams() []string {
+	if s.sources == nil {
+		return []string{provider.Unversioned(s.ParamName)}
+	}
+	names := []string{}
+	for _, name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// logUpdate logs an update at info level when keys changed. Most resyncs change
+// nothing, so those are only logged at debug level.
+func (s *Secret) logUpdate(action string) {
+	changed := s.changedKeys()
+	entry := s.logger().WithFields(log.Fields{
+		"kind":        "Secret",
+		"changed":     len(changed) > 0,
+		"changedKeys": strings.Join(changed, ","),
+	})
+	if len(changed) > 0 {
+		entry.Infof("%s Kubernetes Secret...", action)
+	} else {
+		entry.Debugf("%s Kubernetes Secret (no keys changed)...", action)
+	}
+}
+
+// Checksum returns a hex SHA-256 of the keys set by the controller and their
+// values, in key order. Keys and values are length-prefixed, so moving bytes
+// between them changes the checksum.
+func (s *Secret) Checksum() string {
+	h := sha256.New()
+	for _, k := range s.ManagedKeys() {
+		v, ok := s.Secret.StringData[k]
+		if !ok {
+			// Binary
+			v = string(s.Secret.Data[k])
+		}
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// label sets the managed label, so synced objects can be listed with a selector.
+// It's set on every sync, which doesn't change an object that already has it,
+// and the controller doesn't watch labels, so syncs don't trigger each other.
+func (s *Secret) label() {
+	if s.Secret.ObjectMeta.Labels == nil {
+		s.Secret.ObjectMeta.Labels = make(map[string]string)
+	}
+	s.Secret.ObjectMeta.Labels[anno.V1ManagedLabel] = "true"
+}
+
+// importARNs records the ARNs of the parameters of each key (sources, as full
+// names) in the arn annotation, in key order. Only the first ARN is read; the
+// others are in the same region and account (see importFailed).
+func (s *Secret) importARNs(p provider.Provider, sources map[string]string) {
+	if s.Secret.ObjectMeta.Annotations == nil {
+		s.Secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	if len(sources) == 0 {
+		delete(s.Secret.ObjectMeta.Annotations, anno.V1ARN)
+		return
+	}
+
+	keys := make([]string, 0, len(sources))
+	for k := range sources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	arn, err := p.GetParameterARN(sources[keys[0]])
+	if s.importFailed("the ARN", err) {
+		return
+	}
+	arns := []string{arn}
+	for _, k := range keys[1:] {
+		arns = append(arns, provider.ARNFor(arn, sources[k]))
+	}
+	s.Secret.ObjectMeta.Annotations[anno.V1ARN] = strings.Join(arns, ",")
+}
+
+// setPreviousValues sets the key of each previous-value-<key> annotation to the
+// value of the Nth version before the current one, reading only as much of the
+// parameter's history as needed. A parameter without that many versions (yet)
+// leaves the key unset.
+func (s *Secret) setPreviousValues(p provider.Provider, annotations map[string]string, decrypt bool) error {
+	if s.ParamType != "String" && s.ParamType != "SecureString" && s.ParamType != "StringList" {
+		return nil
+	}
+	previous, err := anno.PreviousValues(annotations)
+	if err != nil || len(previous) == 0 {
+		return err
+	}
+
+	keys := make([]string, 0, len(previous))
+	limit := 0
+	for k, n := range previous {
+		keys = append(keys, k)
+		if n+1 > limit {
+			limit = n + 1
+		}
+	}
+	sort.Strings(keys)
+
+	name := provider.Unversioned(s.ParamName)
+	versions, err := provider.GetParameterHistory(p, name, decrypt, limit)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		n := previous[k]
+		if n >= len(versions) {
+			s.logger().Warnf("Not setting %s: the parameter has no version %d before the current one", k, n)
+			continue
+		}
+		value, err := transform.Value(p, name, versions[n].Value)
+		if err != nil {
+			return err
+		}
+		if err := s.Set(k, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFailed logs err and returns true if importing metadata (e.g., tags or
+// the description, rather than a value) failed. Metadata isn't what the Secret
+// is synced for, so it never fails the sync.
+func (s *Secret) importFailed(what string, err error) bool {
+	if err == nil {
+		return false
+	}
+	s.logger().Warnf("Failed to import %s: %s", what, err)
+	return true
+}
+
+// importDescription copies the parameter's description to the description
+// annotation (see importFailed)
+func (s *Secret) importDescription(p provider.Provider) {
+	description, err := p.GetParameterDescription(s.ParamName)
+	if s.importFailed("the description", err) {
+		return
+	}
+	if s.Secret.ObjectMeta.Annotations == nil {
+		s.Secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	if description == "" {
+		delete(s.Secret.ObjectMeta.Annotations, anno.V1Description)
+		return
+	}
+	s.Secret.ObjectMeta.Annotations[anno.V1Description] = description
+}
+
+// importKMSKeyID records the ID of the KMS key that encrypted the parameter,
+// which may differ from aws-param-key, in the kms-key-id annotation (for
+// auditing). Parameters that aren't encrypted have none (see importFailed).
+func (s *Secret) importKMSKeyID(p provider.Provider) {
+	keyID, err := provider.GetParameterKeyID(p, s.ParamName)
+	if s.importFailed("the KMS key ID", err) {
+		return
+	}
+	if s.Secret.ObjectMeta.Annotations == nil {
+		s.Secret.ObjectMeta.Annotations = make(map[string]string)
+	}
+	if keyID == "" {
+		delete(s.Secret.ObjectMeta.Annotations, anno.V1KMSKeyID)
+		return
+	}
+	s.Secret.ObjectMeta.Annotations[anno.V1KMSKeyID] = keyID
+}
+
+// setDirectoryMeta sets the key of aws-ssm/directory-meta, if any: "true", or
+// the directory's path. Fails if a parameter has the same key.
+func (s *Secret) setDirectoryMeta(annotations map[string]string) error {
+	switch annotations[anno.V1DirectoryMeta] {
+	case anno.DirectoryMetaTrue:
+	case anno.DirectoryMetaPath:
+		s.ParamValue = s.ParamName
+	default:
+		return nil
+	}
+	key := s.ParamType
+	if k := annotations[anno.V1DirectoryMetaKey]; k != "" {
+		key = k
+	}
+	// Only keys set in this sync: StringData may still have the meta key of the last one
+	if s.keys[key] {
+		return fmt.Errorf("The %s key '%s' is also a parameter's key; rename it with %s", anno.V1DirectoryMeta, key, anno.V1DirectoryMetaKey)
+	}
+	return s.Set(key, s.ParamValue)
+}
+
+// setExtraData sets the static keys of the extra-data annotation. Keys that
+// were already set from the parameter are kept.
+func (s *Secret) setExtraData(annotations map[string]string) error {
+	value, ok := annotations[anno.V1ExtraData]
+	if !ok {
+		return nil
+	}
+	extra := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &extra); err != nil {
+		return fmt.Errorf("Invalid %s annotation: %s", anno.V1ExtraData, err)
+	}
+	for k, v := range extra {
+		// Only the keys set in this sync
+		if s.keys[k] {
+			s.logger().Warnf("Ignoring extra-data key '%s': already set", k)
+			s.skip(k)
+			continue
+		}
+		s.Set(k, v)
+	}
+	return nil
+}
+
+// getParameterValue reads a parameter, passing any KMS grant tokens to the
+// decrypt call, which is made as the aws-ssm/decrypt-role-arn if it's set
+func getParameterValue(p provider.Provider, annotations map[string]string, name string, decrypt bool) (string, error) {
+	tokens := anno.List(annotations, anno.V1KMSGrantToken)
+	if role := annotations[anno.V1DecryptRoleARN]; decrypt && role != "" {
+		return provider.GetParameterValueDecryptedAs(p, name, role, tokens)
+	}
+	if decrypt && len(tokens) > 0 {
+		return p.GetParameterValueWithGrants(name, tokens)
+	}
+	return p.GetParameterValue(name, decrypt)
+}
+
+// listSeparator returns the separator of StringList entries in value. The
+// list-separator annotation (which may use "\n" and "\t" escapes) takes precedence.
+func listSeparator(annotations map[string]string, value string) string {
+	if sep := annotations[anno.V1ListSeparator]; sep != "" {
+		return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(sep)
+	}
+	if strings.Contains(value, "\n") && !strings.Contains(value, ",") {
+		return "\n"
+	}
+	return ","
+}
+
+// listPairDelimiters returns the characters that split a StringList entry into
+// its key and value: the list-pair-delimiters annotation, or "="
+func listPairDelimiters(annotations map[string]string) string {
+	if delimiters := annotations[anno.V1ListPairDelimiters]; delimiters != "" {
+		return delimiters
+	}
+	return "="
+}
+
+// listEntries splits a StringList value into its (trimmed, non-empty) entries
+func listEntries(annotations map[string]string, value string) []string {
+	entries := []string{}
+	sep := listSeparator(annotations, value)
+	for _, entry := range strings.Split(strings.TrimSpace(value), sep) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// directoryPath normalizes a Directory path so that equivalent spellings produce
+// identical keys: surrounding slashes are trimmed, then a single leading slash
+// is added ("app/db/", "/app/db/" and "//app/db" -> "/app/db")
+func directoryPath(ppath string) string {
+	return "/" + strings.Trim(ppath, "/")
+}
+
+// directoryData reads the params of each comma-separated Directory path and
+// maps them to their keys, trimming the strip-prefix annotation (parameters in
+// the key-map annotation are stored under their mapped keys instead). Every collision,
+// within a path or across paths, is checked before any key is set, so the error
+// names both parameters instead of just the key. Returns the normalized paths,
+// and the full name of the parameter of each key.
+func (s *Secret) directoryData(p provider.Provider, annotations map[string]string, ppaths string, decrypt bool) (string, map[string]string, map[string]string, error) {
+	prefix := annotations[anno.V1StripPrefix]
+	keyMap, err := anno.KeyMap(annotations)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	data := make(map[string]string)
+	// key -> the full name of the parameter it was read from
+	sources := make(map[string]string)
+	// Parameters left out with decrypt-failure-fatal: "false"
+	var failures []string
+	delete(annotations, anno.V1DecryptError)
+
+	parts := strings.Split(ppaths, ",")
+	paths := make([]string, 0, len(parts))
+	for _, ppath := range parts {
+		ppath = strings.TrimSpace(ppath)
+		if ppath == "" && len(parts) > 1 {
+			// A stray comma, not the root
+			continue
+		}
+		ppath = directoryPath(ppath)
+		paths = append(paths, ppath)
+
+		params, failed, err := s.directoryParams(p, annotations, ppath, decrypt)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		failures = append(failures, failed...)
+		if len(params) == 0 && anno.Bool(annotations, anno.V1FailOnEmptyDirectory, false) && !provider.Validating(p) {
+			return "", nil, nil, fmt.Errorf("Path '%s' has no parameters (%s)", ppath, anno.V1FailOnEmptyDirectory)
+		}
+		tiers, err := directoryTiers(p, annotations, ppath)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if tiers != nil && tiers[name] != annotations[anno.V1TierFilter] {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			key, ok := keyMap[name]
+			if !ok {
+				key = strings.TrimPrefix(safeKeyName(path.Base(name)), prefix)
+			}
+			if key == "" {
+				return "", nil, nil, fmt.Errorf("Parameter %s is empty after stripping prefix '%s'", name, prefix)
+			}
+			if other, ok := sources[key]; ok {
+				return "", nil, nil, fmt.Errorf("Parameters %s and %s both produce key '%s'", other, name, key)
+			}
+			if _, err := provider.ParameterTier(name, params[name]); err != nil {
+				return "", nil, nil, err
+			}
+			value, err := transform.Value(p, name, params[name])
+			if err != nil {
+				return "", nil, nil, err
+			}
+			sources[key] = name
+			data[key] = value
+		}
+	}
+	if len(failures) > 0 {
+		annotations[anno.V1DecryptError] = strings.Join(failures, "; ")
+	}
+	return strings.Join(paths, ","), data, sources, nil
+}
+
+// directoryParams reads the params under ppath, by full name. With directory-fetch:
+// each, they're listed, then read one at a time: a parameter that can't be decrypted
+// fails the sync, unless decrypt-failure-fatal is "false", in which case it's left
+// out (as if it weren't under ppath) and the failure is returned.
+func (s *Secret) directoryParams(p provider.Provider, annotations map[string]string, ppath string, decrypt bool) (map[string]string, []string, error) {
+	if annotations[anno.V1DirectoryFetch] != anno.DirectoryFetchEach {
+		params, err := p.GetParameterDataByPath(ppath, decrypt)
+		return params, nil, err
+	}
+	names, err := provider.ListParametersByPath(p, ppath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+
+	params := make(map[string]string, len(names))
+	var failures []string
+	for _, name := range names {
+		value, err := p.GetParameterValue(name, decrypt)
+		if err != nil {
+			if !decrypt || !provider.IsDecryptError(err) || anno.Bool(annotations, anno.V1DecryptFailureFatal, true) {
+				return nil, nil, fmt.Errorf("Failed to read %s: %w", name, err)
+			}
+			s.logger().WithField("paramName", name).Warnf("Failed to decrypt; syncing without it: %s", err)
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		params[name] = value
+	}
+	return params, failures, nil
+}
+
+// directoryTiers returns the tiers of the parameters under ppath if they're
+// filtered by the tier-filter annotation, or nil if they aren't. Parameters
+// of other tiers are skipped, as if they weren't under ppath.
+func directoryTiers(p provider.Provider, annotations map[string]string, ppath string) (map[string]string, error) {
+	if annotations[anno.V1TierFilter] == "" {
+		return nil, nil
+	}
+	tiers, err := provider.GetParameterTiersByPath(p, ppath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the tiers of %s (%s): %s", ppath, anno.V1TierFilter, err)
+	}
+	return tiers, nil
 }
 
 func safeKeyName(key string) string {
@@ -201,3 +1447,10 @@ func safeKeyName(key string) string {
 	}
 	return strings.Replace(key, "/", "_", -1)
 }
+
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// tagKeyName returns the key for a parameter tag: 'Cost Center' -> 'tag_Cost_Center'
+func tagKeyName(tag string) string {
+	return "tag_" + invalidKeyChars.ReplaceAllString(tag, "_")
+}
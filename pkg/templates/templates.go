@@ -0,0 +1,114 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/transform"
+)
+
+// Params parses the template-params annotation: "host=/db/host,port=/db/port"
+// maps the names used in templates to SSM parameter names.
+func Params(value string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid template parameter '%s' (expected name=/ssm/param)", item)
+		}
+		params[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return params, nil
+}
+
+// Keys returns the object keys that have a template annotation, sorted
+func Keys(annotations map[string]string) []string {
+	var keys []string
+	for k := range annotations {
+		if k == anno.V1TemplateParams || !strings.HasPrefix(k, anno.V1TemplatePrefix) {
+			continue
+		}
+		keys = append(keys, strings.TrimPrefix(k, anno.V1TemplatePrefix))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Render fetches the template parameters (decrypted, in one batch) and renders each
+// "aws-ssm/template-<key>" annotation into <key>. Errors never include
+// parameter values.
+func Render(annotations map[string]string, p provider.Provider) (map[string]string, error) {
+	keys := Keys(annotations)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	params, err := Params(annotations[anno.V1TemplateParams])
+	if err != nil {
+		return nil, err
+	}
+
+	// In as few calls as the provider allows, rather than one per parameter
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, param := range params {
+		if !seen[param] {
+			seen[param] = true
+			names = append(names, param)
+		}
+	}
+	sort.Strings(names)
+	fetched, err := provider.BatchGetParameterValues(p, names, true)
+	if err != nil {
+		return nil, fmt.Errorf("Template parameters: %s", err)
+	}
+
+	values := make(map[string]string)
+	for name, param := range params {
+		value, ok := fetched[param]
+		if !ok {
+			return nil, fmt.Errorf("Template parameter '%s': %s wasn't returned", name, param)
+		}
+		if values[name], err = transform.Value(p, param, value); err != nil {
+			return nil, fmt.Errorf("Template parameter '%s': %s", name, err)
+		}
+	}
+
+	rendered := make(map[string]string)
+	for _, key := range keys {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(annotations[anno.V1TemplatePrefix+key])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template for key '%s': %s", key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, values); err != nil {
+			return nil, fmt.Errorf("Failed to render template for key '%s': %s", key, err)
+		}
+		rendered[key] = buf.String()
+	}
+	return rendered, nil
+}
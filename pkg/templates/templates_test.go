@@ -0,0 +1,102 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package templates
+
+import (
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParams(t *testing.T) {
+	params, err := Params("host=/db/host, port = /db/port,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "/db/host", "port": "/db/port"}, params)
+
+	for _, value := range []string{"host", "=/db/host", "host="} {
+		_, err := Params(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := Keys(map[string]string{
+		"aws-ssm/template-params": "host=/db/host",
+		"aws-ssm/template-url":    "{{.host}}",
+		"aws-ssm/template-dsn":    "{{.host}}",
+		"aws-ssm/aws-param-name":  "foo",
+	})
+	assert.Equal(t, []string{"dsn", "url"}, keys)
+}
+
+func TestRender(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{
+		"/db/host": "db.internal",
+		"/db/port": "5432",
+		"/db/user": "app",
+	}}
+	rendered, err := Render(map[string]string{
+		"aws-ssm/template-params": "host=/db/host,port=/db/port,user=/db/user",
+		"aws-ssm/template-url":    "postgres://{{.user}}@{{.host}}:{{.port}}/app",
+	}, p)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"url": "postgres://app@db.internal:5432/app"}, rendered)
+}
+
+func TestRenderBatchesParameters(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{
+		"/db/host": "db.internal",
+		"/db/port": "5432",
+		"/db/user": "app",
+	}}
+	rendered, err := Render(map[string]string{
+		"aws-ssm/template-params": "host=/db/host,port=/db/port,user=/db/user,addr=/db/host",
+		"aws-ssm/template-url":    "postgres://{{.user}}@{{.addr}}:{{.port}}/app",
+	}, p)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"url": "postgres://app@db.internal:5432/app"}, rendered)
+	assert.Equal(t, [][]string{{"/db/host", "/db/port", "/db/user"}}, p.Batches)
+}
+
+func TestRenderNoTemplates(t *testing.T) {
+	p := &testutil.Provider{}
+	rendered, err := Render(map[string]string{"aws-ssm/template-params": "host=/db/host"}, p)
+	assert.NoError(t, err)
+	assert.Empty(t, rendered)
+	assert.Empty(t, p.Requested)
+}
+
+func TestRenderErrorsDoNotLeakValues(t *testing.T) {
+	p := &testutil.Provider{Values: map[string]string{"/db/password": "hunter2"}}
+	for _, tmpl := range []string{"{{.pasword}}", "{{.password", "{{.password.Field}}", "{{index .password 99}}"} {
+		_, err := Render(map[string]string{
+			"aws-ssm/template-params": "password=/db/password",
+			"aws-ssm/template-url":    tmpl,
+		}, p)
+		require.Error(t, err, tmpl)
+		assert.NotContains(t, err.Error(), "hunter2")
+	}
+}
+
+func TestRenderMissingParameter(t *testing.T) {
+	_, err := Render(map[string]string{
+		"aws-ssm/template-params": "host=/db/host",
+		"aws-ssm/template-url":    "{{.host}}",
+	}, &testutil.Provider{})
+	assert.Error(t, err)
+}
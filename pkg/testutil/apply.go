@@ -0,0 +1,338 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The content type of a server-side apply
+const applyContentType = "application/apply-patch+yaml"
+
+// FieldManagerConflict is the cause of the conflicts of an apply that isn't
+// forced, like the apiserver's
+const FieldManagerConflict = metav1.CauseType("FieldManagerConflict")
+
+// ApplyServer is a fake apiserver for the server-side applies of ConfigMaps
+// and Secrets. Like the apiserver, it records the data keys, annotations and
+// labels each field manager's apply sets, and removes those its previous apply
+// set that its next one doesn't, unless another manager set them too. An apply
+// that isn't forced fails with a conflict if it changes a field another
+// manager set; a forced one takes them over. It also takes gets and JSON merge
+// patches; other requests fail.
+type ApplyServer struct {
+	*httptest.Server
+	// Respond to applies like Kubernetes < 1.16, which doesn't support them
+	Unsupported bool
+	// Each request, e.g. "PATCH /api/v1/namespaces/ns/secrets/foo fieldManager=aws-ssm-controller"
+	Requests []string
+
+	mu      sync.Mutex
+	version int
+	// By path
+	objects map[string]map[string]interface{}
+	// By path, then field manager
+	owners map[string]map[string][]string
+}
+
+// NewApplyServer starts an ApplyServer seeded with ConfigMaps and Secrets,
+// whose fields aren't owned by any apply. Close it when done.
+func NewApplyServer(objects ...runtime.Object) *ApplyServer {
+	s := &ApplyServer{
+		objects: map[string]map[string]interface{}{},
+		owners:  map[string]map[string][]string{},
+	}
+	for _, obj := range objects {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			panic(err)
+		}
+		kind := strings.TrimPrefix(fmt.Sprintf("%T", obj), "*v1.")
+		u["apiVersion"], u["kind"] = "v1", kind
+		meta := u["metadata"].(map[string]interface{})
+		s.version += 1
+		meta["resourceVersion"] = strconv.Itoa(s.version)
+		path := fmt.Sprintf("/api/v1/namespaces/%s/%ss/%s", meta["namespace"], strings.ToLower(kind), meta["name"])
+		s.objects[path] = u
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client returns a client of the server
+func (s *ApplyServer) Client() kubernetes.Interface {
+	return kubernetes.NewForConfigOrDie(&rest.Config{Host: s.URL})
+}
+
+func (s *ApplyServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	manager := r.URL.Query().Get("fieldManager")
+	s.Requests = append(s.Requests, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, r.URL.RawQuery)))
+	obj, ok := s.objects[r.URL.Path]
+	body, _ := ioutil.ReadAll(r.Body)
+	patch := map[string]interface{}{}
+
+	switch {
+	case r.Method == http.MethodGet:
+	case r.Method != http.MethodPatch || json.Unmarshal(body, &patch) != nil:
+		s.fail(w, http.StatusBadRequest, metav1.StatusReasonBadRequest)
+		return
+	case r.Header.Get("Content-Type") == applyContentType:
+		if s.Unsupported {
+			s.fail(w, http.StatusUnsupportedMediaType, metav1.StatusReasonUnsupportedMediaType)
+			return
+		}
+		meta, _ := patch["metadata"].(map[string]interface{})
+		version, _ := meta["resourceVersion"].(string)
+		if !ok && version != "" {
+			s.fail(w, http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		if ok && version != "" && version != obj["metadata"].(map[string]interface{})["resourceVersion"] {
+			s.fail(w, http.StatusConflict, metav1.StatusReasonConflict)
+			return
+		}
+		if !ok {
+			obj = map[string]interface{}{"metadata": map[string]interface{}{}}
+			s.objects[r.URL.Path] = obj
+		}
+		if conflicts := s.conflicts(r.URL.Path, obj, manager, patch); len(conflicts) > 0 && r.URL.Query().Get("force") != "true" {
+			s.conflict(w, conflicts)
+			return
+		}
+		s.apply(r.URL.Path, obj, manager, patch)
+	case r.Header.Get("Content-Type") == "application/merge-patch+json" && ok:
+		mergePatch(obj, patch)
+	default:
+		s.fail(w, http.StatusNotFound, metav1.StatusReasonNotFound)
+		return
+	}
+	if !ok && r.Method == http.MethodGet {
+		s.fail(w, http.StatusNotFound, metav1.StatusReasonNotFound)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		s.version += 1
+		obj["metadata"].(map[string]interface{})["resourceVersion"] = strconv.Itoa(s.version)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+// apply sets the fields of patch in obj, owned by manager, and removes those
+// manager owned that patch doesn't set, unless another manager owns them too.
+// Fields it changes move from their other owners (as a forced apply); those it
+// sets to the same value are shared.
+func (s *ApplyServer) apply(path string, obj map[string]interface{}, manager string, patch map[string]interface{}) {
+	if s.owners[path] == nil {
+		s.owners[path] = map[string][]string{}
+	}
+	fields := applyFields(patch)
+	set := map[string]bool{}
+	for _, f := range fields {
+		set[f] = true
+	}
+	for _, f := range s.owners[path][manager] {
+		if !set[f] && !s.ownedByOthers(path, manager, f) {
+			parent, key := fieldParent(obj, f)
+			delete(parent, key)
+		}
+	}
+	changed := map[string]bool{}
+	for _, f := range fields {
+		changed[f] = fieldChanged(obj, patch, f)
+	}
+	for other, owned := range s.owners[path] {
+		kept := []string{}
+		for _, f := range owned {
+			if !changed[f] {
+				kept = append(kept, f)
+			}
+		}
+		s.owners[path][other] = kept
+	}
+	s.owners[path][manager] = fields
+
+	for k, v := range patch {
+		if k != "metadata" {
+			if _, ok := v.(map[string]interface{}); !ok {
+				obj[k] = v
+			}
+		}
+	}
+	for _, f := range fields {
+		parent, key := fieldParent(obj, f)
+		from, _ := fieldParent(patch, f)
+		parent[key] = from[key]
+	}
+	meta := obj["metadata"].(map[string]interface{})
+	applied, _ := patch["metadata"].(map[string]interface{})
+	meta["name"], meta["namespace"] = applied["name"], applied["namespace"]
+}
+
+// conflicts returns the fields of patch that change a field of obj owned by
+// another manager, by manager, as in the apiserver's message (e.g. ".data.host")
+func (s *ApplyServer) conflicts(path string, obj map[string]interface{}, manager string, patch map[string]interface{}) map[string][]string {
+	conflicts := map[string][]string{}
+	for _, f := range applyFields(patch) {
+		if !fieldChanged(obj, patch, f) {
+			continue
+		}
+		for other, owned := range s.owners[path] {
+			for _, o := range owned {
+				if other != manager && o == f {
+					parts := strings.SplitN(f, "\x00", 2)
+					conflicts[other] = append(conflicts[other], "."+parts[0]+"."+parts[1])
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// conflict fails an apply with the conflicts of conflicts, like the apiserver
+func (s *ApplyServer) conflict(w http.ResponseWriter, conflicts map[string][]string) {
+	managers := []string{}
+	for manager := range conflicts {
+		managers = append(managers, manager)
+	}
+	sort.Strings(managers)
+	messages := []string{}
+	causes := []metav1.StatusCause{}
+	for _, manager := range managers {
+		sort.Strings(conflicts[manager])
+		for _, field := range conflicts[manager] {
+			message := fmt.Sprintf("conflict with %q", manager)
+			messages = append(messages, message+": "+field)
+			causes = append(causes, metav1.StatusCause{Type: FieldManagerConflict, Message: message, Field: field})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  fmt.Sprintf("Apply failed with %d conflicts: %s", len(causes), strings.Join(messages, "\n")),
+		Reason:   metav1.StatusReasonConflict,
+		Details:  &metav1.StatusDetails{Causes: causes},
+		Code:     http.StatusConflict,
+	})
+}
+
+func (s *ApplyServer) ownedByOthers(path string, manager string, field string) bool {
+	for other, owned := range s.owners[path] {
+		for _, f := range owned {
+			if other != manager && f == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *ApplyServer) fail(w http.ResponseWriter, code int, reason metav1.StatusReason) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+// applyFields returns the data keys, annotations and labels of an apply, each
+// as the path of its map and its key, separated by a NUL (keys have dots), e.g.
+// "metadata.labels\x00aws-ssm/managed"
+func applyFields(patch map[string]interface{}) []string {
+	fields := []string{}
+	add := func(prefix string, m interface{}) {
+		values, _ := m.(map[string]interface{})
+		for k := range values {
+			fields = append(fields, prefix+"\x00"+k)
+		}
+	}
+	add("data", patch["data"])
+	if meta, ok := patch["metadata"].(map[string]interface{}); ok {
+		add("metadata.annotations", meta["annotations"])
+		add("metadata.labels", meta["labels"])
+	}
+	return fields
+}
+
+// fieldParent returns the map holding a field of applyFields in obj, creating
+// it if needed, and the field's key in it
+func fieldParent(obj map[string]interface{}, field string) (map[string]interface{}, string) {
+	parts := strings.SplitN(field, "\x00", 2)
+	parent := obj
+	for _, name := range strings.Split(parts[0], ".") {
+		child, ok := parent[name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			parent[name] = child
+		}
+		parent = child
+	}
+	return parent, parts[1]
+}
+
+// fieldChanged returns true if patch sets a field of applyFields to another
+// value than obj's (or obj doesn't have it)
+func fieldChanged(obj map[string]interface{}, patch map[string]interface{}, field string) bool {
+	parts := strings.SplitN(field, "\x00", 2)
+	current, applied := obj, patch
+	for _, name := range strings.Split(parts[0], ".") {
+		current, _ = current[name].(map[string]interface{})
+		applied, _ = applied[name].(map[string]interface{})
+	}
+	value, ok := current[parts[1]]
+	return !ok || !reflect.DeepEqual(value, applied[parts[1]])
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to obj
+func mergePatch(obj map[string]interface{}, patch map[string]interface{}) {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case map[string]interface{}:
+			child, ok := obj[k].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				obj[k] = child
+			}
+			mergePatch(child, v)
+		default:
+			obj[k] = v
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package testutil
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// NewKubeClient returns a fake apiserver client seeded with objects
+func NewKubeClient(objects ...runtime.Object) *fake.Clientset {
+	return fake.NewSimpleClientset(objects...)
+}
+
+// ClientGenerator always returns Client (see controller.ClientGenerator)
+type ClientGenerator struct {
+	Client kubernetes.Interface
+}
+
+func (cg ClientGenerator) KubeClient() (kubernetes.Interface, error) {
+	return cg.Client, nil
+}
+
+// ConfigMap returns an empty ConfigMap with the given annotations
+func ConfigMap(namespace string, name string, annotations map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+// Secret returns an empty Secret with the given annotations
+func Secret(namespace string, name string, annotations map[string]string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+// Annotations returns the annotations for a parameter of paramType
+func Annotations(paramName string, paramType string) map[string]string {
+	return map[string]string{
+		"aws-ssm/aws-param-name": paramName,
+		"aws-ssm/aws-param-type": paramType,
+	}
+}
@@ -0,0 +1,228 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package testutil provides in-memory fakes of AWS and Kubernetes for tests.
+// It must only be imported from _test.go files.
+package testutil
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+)
+
+// Provider returns values by parameter name and records each request
+type Provider struct {
+	Values map[string]string
+	// The parameters under each path, by name relative to the path ("db/host")
+	Directories map[string]map[string]string
+	Binaries    map[string][]byte
+	Tags        map[string]map[string]string
+	// Secret values by name and version stage (other than AWSCURRENT)
+	Stages map[string]map[string]string
+	// Parameter descriptions; an error if unset
+	Descriptions map[string]string
+	// KMS key IDs of SecureStrings; "" for other parameters in Values, an error otherwise
+	KeyIDs map[string]string
+	// Parameter ARNs; if unset, in us-west-2 of account 123456789012
+	ARNs map[string]string
+	// Tiers of the parameters in Directories, by full name; Standard if unset
+	Tiers map[string]string
+	// Parameter versions; 1 if unset
+	Versions map[string]int64
+	// The value of each version of a parameter, from version 1; if unset, its
+	// value is version 1
+	History   map[string][]string
+	Requested []string
+	// The role each parameter was decrypted as (GetParameterValueDecryptedAs)
+	DecryptRoles map[string]string
+	// Names passed to each BatchGetParameterValues call (also Requested)
+	Batches [][]string
+	// Grant tokens passed with each parameter name
+	GrantTokens map[string][]string
+	// Values read without decryption, by name (e.g., SecureString ciphertext);
+	// reading these with decryption fails with DecryptError
+	Encrypted    map[string]string
+	DecryptError error
+
+	mu sync.Mutex
+}
+
+func (tp *Provider) record(name string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.Requested = append(tp.Requested, name)
+}
+
+func (tp *Provider) GetParameterValue(name string, decrypt bool) (string, error) {
+	tp.record(name)
+	if v, ok := tp.Encrypted[name]; ok {
+		if decrypt {
+			return "", tp.DecryptError
+		}
+		return v, nil
+	}
+	if v, ok := tp.Values[name]; ok {
+		return v, nil
+	}
+	return "", errors.New("ParameterNotFound: " + name)
+}
+
+func (tp *Provider) BatchGetParameterValues(names []string, decrypt bool) (map[string]string, error) {
+	tp.mu.Lock()
+	tp.Batches = append(tp.Batches, names)
+	tp.mu.Unlock()
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		v, err := tp.GetParameterValue(name, decrypt)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func (tp *Provider) GetParameterValueWithGrants(name string, grantTokens []string) (string, error) {
+	tp.mu.Lock()
+	if tp.GrantTokens == nil {
+		tp.GrantTokens = make(map[string][]string)
+	}
+	tp.GrantTokens[name] = grantTokens
+	tp.mu.Unlock()
+	return tp.GetParameterValue(name, true)
+}
+
+func (tp *Provider) GetParameterValueDecryptedAs(name string, roleARN string, grantTokens []string) (string, error) {
+	tp.mu.Lock()
+	if tp.DecryptRoles == nil {
+		tp.DecryptRoles = make(map[string]string)
+	}
+	tp.DecryptRoles[name] = roleARN
+	tp.mu.Unlock()
+	return tp.GetParameterValueWithGrants(name, grantTokens)
+}
+
+// GetParameterDataByPath returns the parameters of the path in Directories, by full name
+func (tp *Provider) GetParameterDataByPath(path string, decrypt bool) (map[string]string, error) {
+	tp.record(path)
+	values := make(map[string]string)
+	for name, value := range tp.Directories[path] {
+		values[path+"/"+name] = value
+	}
+	return values, nil
+}
+
+// GetParameterTiersByPath returns the Tiers of the parameters of the path in Directories
+func (tp *Provider) GetParameterTiersByPath(path string) (map[string]string, error) {
+	tiers := make(map[string]string)
+	for name := range tp.Directories[path] {
+		tiers[path+"/"+name] = provider.StandardTier
+		if tier, ok := tp.Tiers[path+"/"+name]; ok {
+			tiers[path+"/"+name] = tier
+		}
+	}
+	return tiers, nil
+}
+
+// ListParametersByPath returns the full names of the parameters of the path in
+// Directories. Their values are read from Values (or Encrypted), not Directories.
+func (tp *Provider) ListParametersByPath(path string) ([]string, error) {
+	tp.record("list:" + path)
+	names := []string{}
+	for name := range tp.Directories[path] {
+		names = append(names, path+"/"+name)
+	}
+	return names, nil
+}
+
+// GetSecretValue reads Values (or Binaries) for the AWSCURRENT stage, and Stages otherwise
+func (tp *Provider) GetSecretValue(name string, versionStage string) (provider.SecretValue, error) {
+	if versionStage != "" && versionStage != "AWSCURRENT" {
+		tp.record(name)
+		if v, ok := tp.Stages[name][versionStage]; ok {
+			return provider.SecretValue{String: v}, nil
+		}
+		return provider.SecretValue{}, errors.New("ResourceNotFoundException: " + name + " has no version with stage " + versionStage)
+	}
+	if b, ok := tp.Binaries[name]; ok {
+		tp.record(name)
+		return provider.SecretValue{Binary: b}, nil
+	}
+	v, err := tp.GetParameterValue(name, true)
+	return provider.SecretValue{String: v}, err
+}
+
+func (tp *Provider) GetParameterTags(name string) (map[string]string, error) {
+	if tags, ok := tp.Tags[name]; ok {
+		return tags, nil
+	}
+	return nil, errors.New("AccessDeniedException")
+}
+
+func (tp *Provider) GetParameterDescription(name string) (string, error) {
+	if description, ok := tp.Descriptions[name]; ok {
+		return description, nil
+	}
+	return "", errors.New("AccessDeniedException")
+}
+
+func (tp *Provider) GetParameterKeyID(name string) (string, error) {
+	if keyID, ok := tp.KeyIDs[name]; ok {
+		return keyID, nil
+	}
+	if _, ok := tp.Values[name]; ok {
+		return "", nil
+	}
+	return "", errors.New("ParameterNotFound: " + name)
+}
+
+func (tp *Provider) GetParameterARN(name string) (string, error) {
+	tp.record(name)
+	if arn, ok := tp.ARNs[name]; ok {
+		return arn, nil
+	}
+	return provider.ARNFor("arn:aws:ssm:us-west-2:123456789012", name), nil
+}
+
+func (tp *Provider) GetParameterHistory(name string, decrypt bool, limit int) ([]provider.ParameterVersion, error) {
+	tp.record(name)
+	values, ok := tp.History[name]
+	if !ok {
+		v, ok := tp.Values[name]
+		if !ok {
+			return nil, errors.New("ParameterNotFound: " + name)
+		}
+		values = []string{v}
+	}
+	versions := []provider.ParameterVersion{}
+	for i := len(values) - 1; i >= 0 && (limit <= 0 || len(versions) < limit); i-- {
+		versions = append(versions, provider.ParameterVersion{Version: int64(i + 1), Value: values[i]})
+	}
+	return versions, nil
+}
+
+func (tp *Provider) GetParameterVersion(name string) (int64, error) {
+	tp.record(name)
+	if v, ok := tp.Versions[name]; ok {
+		return v, nil
+	}
+	if _, ok := tp.Values[name]; ok {
+		return 1, nil
+	}
+	return 0, errors.New("ParameterNotFound: " + name)
+}
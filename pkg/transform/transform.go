@@ -0,0 +1,122 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+)
+
+// ValueTransformer post-processes a value fetched from the provider before
+// it's stored (e.g., to decode a proprietary format)
+type ValueTransformer interface {
+	Transform(ctx context.Context, paramName string, value string) (string, error)
+}
+
+// Func adapts a function to a ValueTransformer
+type Func func(ctx context.Context, paramName string, value string) (string, error)
+
+func (f Func) Transform(ctx context.Context, paramName string, value string) (string, error) {
+	return f(ctx, paramName, value)
+}
+
+// Trim removes leading and trailing whitespace
+var Trim = Func(func(ctx context.Context, paramName string, value string) (string, error) {
+	return strings.TrimSpace(value), nil
+})
+
+// Base64Decode decodes standard base64
+var Base64Decode = Func(func(ctx context.Context, paramName string, value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("Failed to base64-decode '%s': %s", paramName, err)
+	}
+	return string(decoded), nil
+})
+
+// NormalizeNewlines converts Windows line endings (\r\n) to \n
+var NormalizeNewlines = Func(func(ctx context.Context, paramName string, value string) (string, error) {
+	return strings.Replace(value, "\r\n", "\n", -1), nil
+})
+
+// Builtin transformers, by the names used with -transforms
+var Builtin = map[string]ValueTransformer{
+	"trim":     Trim,
+	"base64":   Base64Decode,
+	"newlines": NormalizeNewlines,
+}
+
+// Parse returns the builtin transformers with the given names, in order
+func Parse(names []string) ([]ValueTransformer, error) {
+	chain := []ValueTransformer{}
+	for _, name := range names {
+		t, ok := Builtin[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown transform '%s'", name)
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
+
+// Apply runs value through each transformer in chain, in order
+func Apply(ctx context.Context, chain []ValueTransformer, paramName string, value string) (string, error) {
+	for _, t := range chain {
+		var err error
+		value, err = t.Transform(ctx, paramName, value)
+		if err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx with chain run after the transformers it already has
+func NewContext(ctx context.Context, chain ...ValueTransformer) context.Context {
+	if len(chain) == 0 {
+		return ctx
+	}
+	transformers := append(append([]ValueTransformer{}, FromContext(ctx)...), chain...)
+	return context.WithValue(ctx, contextKey{}, transformers)
+}
+
+// FromContext returns the transformers of ctx, in order
+func FromContext(ctx context.Context) []ValueTransformer {
+	chain, _ := ctx.Value(contextKey{}).([]ValueTransformer)
+	return chain
+}
+
+// WithTransformers returns p with chain added to the transformers of its
+// context (see provider.WithContext), which Value runs
+func WithTransformers(p provider.Provider, chain ...ValueTransformer) provider.Provider {
+	if len(chain) == 0 {
+		return p
+	}
+	return provider.WithContext(NewContext(provider.Context(p), chain...), p)
+}
+
+// Value runs value, read from the parameter name with p, through the
+// transformers of p's context (see WithTransformers), before it's stored
+func Value(p provider.Provider, name string, value string) (string, error) {
+	ctx := provider.Context(p)
+	return Apply(ctx, FromContext(ctx), name, value)
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package transform
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	chain, err := Parse([]string{"trim", "base64"})
+	require.NoError(t, err)
+	assert.Len(t, chain, 2)
+
+	_, err = Parse([]string{"rot13"})
+	assert.Error(t, err)
+}
+
+func TestApplyInOrder(t *testing.T) {
+	ctx := context.Background()
+
+	value, err := Apply(ctx, []ValueTransformer{Trim, Base64Decode}, "foo", " Zm9vYmFy\n")
+	require.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+
+	// Base64 first fails on the whitespace
+	_, err = Apply(ctx, []ValueTransformer{Base64Decode, Trim}, "foo", " Zm9vYmFy\n")
+	assert.Error(t, err)
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	value, err := Apply(context.Background(), []ValueTransformer{NormalizeNewlines}, "cert", "-----BEGIN CERTIFICATE-----\r\nMIIB\r\n-----END CERTIFICATE-----\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", value)
+
+	// A lone \r isn't a Windows line ending
+	value, err = Apply(context.Background(), []ValueTransformer{NormalizeNewlines}, "foo", "a\rb\n")
+	require.NoError(t, err)
+	assert.Equal(t, "a\rb\n", value)
+}
+
+func TestApplyStopsOnError(t *testing.T) {
+	called := false
+	fail := Func(func(ctx context.Context, paramName string, value string) (string, error) {
+		return "", errors.New("bad value")
+	})
+	record := Func(func(ctx context.Context, paramName string, value string) (string, error) {
+		called = true
+		return value, nil
+	})
+
+	_, err := Apply(context.Background(), []ValueTransformer{fail, record}, "foo", "bar")
+	assert.EqualError(t, err, "bad value")
+	assert.False(t, called)
+}
+
+func TestWithTransformers(t *testing.T) {
+	names := []string{}
+	record := Func(func(ctx context.Context, paramName string, value string) (string, error) {
+		names = append(names, paramName)
+		return value + "!", nil
+	})
+	p := provider.Provider(&testutil.Provider{})
+
+	// Nothing to run
+	assert.Equal(t, p, WithTransformers(p))
+	value, err := Value(p, "/app/host", "db.internal")
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", value)
+
+	// In order, with the chains of the providers wrapped
+	p = WithTransformers(WithTransformers(p, record), Trim)
+	value, err = Value(p, "/app/host", " db.internal")
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal!", value)
+	assert.Equal(t, []string{"/app/host"}, names)
+
+	// The context of the read is passed on
+	ctx, cancel := context.WithCancel(context.Background())
+	p = WithTransformers(provider.WithContext(ctx, &testutil.Provider{}), Func(func(ctx context.Context, paramName string, value string) (string, error) {
+		return value, ctx.Err()
+	}))
+	cancel()
+	_, err = Value(p, "/app/host", "db.internal")
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,176 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package validate
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	anno "github.com/cmattoon/aws-ssm/pkg/annotations"
+	"github.com/cmattoon/aws-ssm/pkg/configmap"
+	"github.com/cmattoon/aws-ssm/pkg/provider"
+	"github.com/cmattoon/aws-ssm/pkg/secret"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ParamTypes are the values accepted for aws-ssm/aws-param-type
+var ParamTypes = anno.ParamTypes
+
+// Result describes how the controller would interpret a single resource
+type Result struct {
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Recognized bool     `json:"recognized"`
+	ParamName  string   `json:"paramName,omitempty"`
+	ParamType  string   `json:"paramType,omitempty"`
+	ParamKey   string   `json:"paramKey,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Manifest parses every ConfigMap and Secret in a (multi-document) YAML or
+// JSON manifest. Annotations are interpreted exactly as the controller would,
+// but no values are fetched from AWS. Other kinds are ignored.
+func Manifest(r io.Reader) ([]Result, error) {
+	objects, err := Objects(r)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *v1.ConfigMap:
+			results = append(results, ConfigMap(*o))
+		case *v1.Secret:
+			results = append(results, Secret(*o))
+		}
+	}
+	return results, nil
+}
+
+// Objects decodes every ConfigMap and Secret (as a *v1.ConfigMap or *v1.Secret)
+// in a (multi-document) YAML or JSON manifest. Other kinds are ignored.
+func Objects(r io.Reader) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := yaml.ToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		if string(data) == "null" {
+			// Empty document
+			continue
+		}
+
+		meta := metav1.TypeMeta{}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+
+		switch meta.Kind {
+		case "ConfigMap":
+			cm := &v1.ConfigMap{}
+			if err := json.Unmarshal(data, cm); err != nil {
+				return nil, err
+			}
+			objects = append(objects, cm)
+		case "Secret":
+			sec := &v1.Secret{}
+			if err := json.Unmarshal(data, sec); err != nil {
+				return nil, err
+			}
+			objects = append(objects, sec)
+		}
+	}
+}
+
+// ConfigMap reports how the controller would interpret a v1.ConfigMap
+func ConfigMap(cm v1.ConfigMap) Result {
+	res := Result{
+		Kind:      "ConfigMap",
+		Namespace: cm.ObjectMeta.Namespace,
+		Name:      cm.ObjectMeta.Name,
+	}
+
+	obj, err := configmap.FromKubernetesConfigMap(provider.NullProvider{}, cm)
+	if err != nil {
+		if err.Error() != "Irrelevant ConfigMap" {
+			res.Recognized = true
+			res.Warnings, _ = anno.Validate("ConfigMap", cm.ObjectMeta.Annotations)
+			res.Error = err.Error()
+		}
+		return res
+	}
+
+	res.Recognized = true
+	res.ParamName = obj.ParamName
+	res.ParamType = obj.ParamType
+	res.ParamKey = obj.ParamKey
+	res.Warnings, _ = anno.Validate("ConfigMap", cm.ObjectMeta.Annotations)
+	return res
+}
+
+// Secret reports how the controller would interpret a v1.Secret
+func Secret(sec v1.Secret) Result {
+	res := Result{
+		Kind:      "Secret",
+		Namespace: sec.ObjectMeta.Namespace,
+		Name:      sec.ObjectMeta.Name,
+	}
+
+	obj, err := secret.FromKubernetesSecret(provider.NullProvider{}, sec)
+	if err != nil {
+		if err.Error() != "Irrelevant Secret" {
+			res.Recognized = true
+			res.Warnings, _ = anno.Validate("Secret", sec.ObjectMeta.Annotations)
+			res.Error = err.Error()
+		}
+		return res
+	}
+
+	res.Recognized = true
+	res.ParamName = obj.ParamName
+	res.ParamType = obj.ParamType
+	res.ParamKey = obj.ParamKey
+	res.Warnings, _ = anno.Validate("Secret", sec.ObjectMeta.Annotations)
+	return res
+}
+
+// Valid returns false if any result has an error
+func Valid(results []Result) bool {
+	for _, res := range results {
+		if res.Error != "" {
+			return false
+		}
+	}
+	return true
+}
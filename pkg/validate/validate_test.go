@@ -0,0 +1,206 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+const manifest = `
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: my-secret
+  namespace: default
+  annotations:
+    aws-ssm/aws-param-name: my-db-password
+    aws-ssm/aws-param-type: SecureString
+data: {}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-configmap
+  namespace: default
+  annotations:
+    aws-ssm/aws-param-name: /dev/db
+    aws-ssm/aws-param-type: Directory
+data: {}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: typo
+  namespace: default
+  annotations:
+    aws-ssm/aws-param-name: my-param
+    aws-ssm/aws-param-type: Strnig
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unannotated
+  namespace: default
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ignored
+`
+
+func TestManifest(t *testing.T) {
+	results, err := Manifest(strings.NewReader(manifest))
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, Result{
+		Kind:       "Secret",
+		Namespace:  "default",
+		Name:       "my-secret",
+		Recognized: true,
+		ParamName:  "my-db-password",
+		ParamType:  "SecureString",
+		ParamKey:   "alias/aws/ssm",
+	}, results[0])
+
+	assert.Equal(t, Result{
+		Kind:       "ConfigMap",
+		Namespace:  "default",
+		Name:       "my-configmap",
+		Recognized: true,
+		ParamName:  "/dev/db",
+		ParamType:  "Directory",
+	}, results[1])
+
+	assert.True(t, results[2].Recognized)
+	assert.Equal(t, "Unknown parameter type 'Strnig'", results[2].Error)
+
+	assert.False(t, results[3].Recognized)
+	assert.Equal(t, "", results[3].Error)
+
+	assert.False(t, Valid(results))
+	assert.True(t, Valid(results[:2]))
+}
+
+func TestManifestReportsAnnotationErrors(t *testing.T) {
+	results, err := Manifest(strings.NewReader(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: pinned
+  annotations:
+    aws-ssm/aws-param-name: my-param
+    aws-ssm/aws-param-type: String
+    aws-ssm/pin-version: latest
+`))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Recognized)
+	assert.Equal(t, "Invalid parameter version 'latest'", results[0].Error)
+}
+
+func TestManifestRejectsMalformedYAML(t *testing.T) {
+	_, err := Manifest(strings.NewReader("kind: [ConfigMap"))
+	assert.Error(t, err)
+}
+
+func TestManifestReportsWarnings(t *testing.T) {
+	results, err := Manifest(strings.NewReader(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: plaintext
+  annotations:
+    aws-ssm/aws-param-name: my-param
+    aws-ssm/aws-param-type: SecureString
+`))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "", results[0].Error)
+	require.Len(t, results[0].Warnings, 1)
+	assert.Contains(t, results[0].Warnings[0], "plaintext")
+	assert.True(t, Valid(results))
+}
+
+// Checks of the values read from AWS pass, since none are
+func TestManifestWithoutValues(t *testing.T) {
+	results, err := Manifest(strings.NewReader(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: min-version
+  annotations:
+    aws-ssm/aws-param-name: /app/password
+    aws-ssm/aws-param-type: SecureString
+    aws-ssm/min-version: "3"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: json-field
+  annotations:
+    aws-ssm/aws-param-name: my-secret#password
+    aws-ssm/aws-param-type: SecretsManager
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: secret-fields
+  annotations:
+    aws-ssm/aws-param-name: my-secret
+    aws-ssm/aws-param-type: SecretsManager
+    aws-ssm/secret-fields: username,password
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: fail-on-empty-directory
+  annotations:
+    aws-ssm/aws-param-name: /a/b
+    aws-ssm/aws-param-type: Directory
+    aws-ssm/fail-on-empty-directory: "true"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: directory-fetch
+  annotations:
+    aws-ssm/aws-param-name: /a/b
+    aws-ssm/aws-param-type: Directory
+    aws-ssm/directory-fetch: each
+`))
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	for _, res := range results {
+		assert.Equal(t, "", res.Error, res.Name)
+	}
+	assert.True(t, Valid(results))
+}
+
+func TestObjects(t *testing.T) {
+	objects, err := Objects(strings.NewReader(manifest))
+	require.NoError(t, err)
+	require.Len(t, objects, 4)
+	assert.IsType(t, &v1.Secret{}, objects[0])
+	assert.IsType(t, &v1.ConfigMap{}, objects[1])
+	assert.Equal(t, "my-configmap", objects[1].(*v1.ConfigMap).Name)
+}
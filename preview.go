@@ -0,0 +1,99 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/cmattoon/aws-ssm/pkg/validate"
+	"github.com/tdmalone/aws-ssm/pkg/controller"
+)
+
+// runPreview implements "aws-ssm preview [-show-secrets] [FLAGS] [FILE...]":
+// print the keys and values each ConfigMap/Secret in the manifests would be
+// synced with, as JSON, reading them from AWS as the controller would (with
+// the controller's flags) but writing nothing. Manifests are read from stdin
+// when no files are given. Returns the exit code.
+func runPreview(args []string) int {
+	showSecrets := flag.Bool("show-secrets", false, "Show the values of Secrets and decrypted params (preview only)")
+	os.Args = append([]string{os.Args[0]}, args...)
+
+	cfg := config.DefaultConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %s\n", err)
+		return 2
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	objects := []runtime.Object{}
+	for _, file := range files {
+		objs, err := previewFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			return 2
+		}
+		objects = append(objects, objs...)
+	}
+
+	ctrl := controller.NewController(cfg)
+	var cli kubernetes.Interface
+	if c, err := ctrl.KubeGen.KubeClient(); err != nil {
+		log.Warnf("Not connected to a cluster, so namespace defaults don't apply: %s", err)
+	} else {
+		cli = c
+	}
+
+	code := 0
+	previews := []*controller.Preview{}
+	for _, obj := range objects {
+		if res := ctrl.Preview(cli, obj, *showSecrets); res != nil {
+			if res.Error != "" {
+				code = 1
+			}
+			previews = append(previews, res)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(previews)
+	return code
+}
+
+func previewFile(file string) ([]runtime.Object, error) {
+	if file == "-" {
+		return validate.Objects(os.Stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return validate.Objects(f)
+}
@@ -0,0 +1,94 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/tdmalone/aws-ssm/pkg/controller"
+)
+
+// runPrune implements "aws-ssm prune [-dry-run] [-namespace NAMESPACE] [FLAGS]
+// configmap/NAME|secret/NAME...": remove the keys the controller set from each
+// object (see controller.Prune) and print what was removed, as JSON. Returns
+// the exit code.
+func runPrune(args []string) int {
+	dryRun := flag.Bool("dry-run", false, "Print the keys that would be removed without writing anything (prune only)")
+	namespace := flag.String("namespace", "default", "Namespace of the objects to prune (prune only)")
+	os.Args = append([]string{os.Args[0]}, args...)
+
+	cfg := config.DefaultConfig()
+	if err := cfg.ParseFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %s\n", err)
+		return 2
+	}
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: aws-ssm prune [-dry-run] [-namespace NAMESPACE] [FLAGS] configmap/NAME|secret/NAME...")
+		return 2
+	}
+
+	scg := &controller.SingletonClientGenerator{
+		KubeConfig:   cfg.KubeConfig,
+		KubeMaster:   cfg.KubeMaster,
+		FieldManager: cfg.FieldManager,
+	}
+	cli, err := scg.KubeClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error with kubernetes client: %s\n", err)
+		return 2
+	}
+
+	code := 0
+	results := []*controller.PruneResult{}
+	for _, arg := range flag.Args() {
+		kind, name, err := pruneTarget(arg)
+		if err == nil {
+			var res *controller.PruneResult
+			if res, err = controller.Prune(cli, kind, *namespace, name, *dryRun); err == nil {
+				results = append(results, res)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", arg, err)
+			code = 1
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(results)
+	return code
+}
+
+// pruneTarget splits "configmap/NAME" or "secret/NAME" (as kubectl accepts them)
+func pruneTarget(arg string) (kind string, name string, err error) {
+	parts := strings.SplitN(arg, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("Expected configmap/NAME or secret/NAME")
+	}
+	switch strings.ToLower(parts[0]) {
+	case "configmap", "configmaps", "cm":
+		return "ConfigMap", parts[1], nil
+	case "secret", "secrets":
+		return "Secret", parts[1], nil
+	}
+	return "", "", fmt.Errorf("Unsupported kind '%s' (configmap|secret)", parts[0])
+}
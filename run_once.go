@@ -0,0 +1,45 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cmattoon/aws-ssm/pkg/config"
+	"github.com/tdmalone/aws-ssm/pkg/controller"
+)
+
+// runOnce implements -run-once: sync every object, print a JSON summary to stdout
+// and return the exit code. 0: all synced, 1: any failed, 2: startup/connectivity errors.
+func runOnce(cfg *config.Config) int {
+	// Anything fatal from here on is a startup error
+	log.StandardLogger().ExitFunc = func(int) { os.Exit(2) }
+
+	ctrl := controller.NewController(cfg)
+	summary, err := ctrl.Sync()
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(summary)
+	return summary.ExitCode()
+}
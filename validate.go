@@ -0,0 +1,96 @@
+/**
+ * Copyright 2018 Curtis Mattoon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cmattoon/aws-ssm/pkg/validate"
+)
+
+// runValidate implements "aws-ssm validate [-output text|json] [FILE...]".
+// Manifests are read from stdin when no files are given. Returns the exit code.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	output := flags.String("output", "text", "Output format (text|json)")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	results := []validate.Result{}
+	for _, file := range files {
+		res, err := validateFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			return 2
+		}
+		results = append(results, res...)
+	}
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	case "text":
+		for _, res := range results {
+			printResult(os.Stdout, res)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format '%s'\n", *output)
+		return 2
+	}
+
+	if !validate.Valid(results) {
+		return 1
+	}
+	return 0
+}
+
+func validateFile(file string) ([]validate.Result, error) {
+	if file == "-" {
+		return validate.Manifest(os.Stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return validate.Manifest(f)
+}
+
+func printResult(w io.Writer, res validate.Result) {
+	id := fmt.Sprintf("%s %s/%s", res.Kind, res.Namespace, res.Name)
+	switch {
+	case res.Error != "":
+		fmt.Fprintf(w, "INVALID  %s: %s\n", id, res.Error)
+	case !res.Recognized:
+		fmt.Fprintf(w, "IGNORED  %s\n", id)
+	default:
+		fmt.Fprintf(w, "OK       %s: name=%s type=%s key=%s\n", id, res.ParamName, res.ParamType, res.ParamKey)
+	}
+	for _, warning := range res.Warnings {
+		fmt.Fprintf(w, "WARNING  %s: %s\n", id, warning)
+	}
+}